@@ -0,0 +1,57 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+// WriteBatch 一组写入和删除操作，通过 DB.Write 一起写入，只刷盘一次。
+// 批量中的 key 和 value 直接引用调用方的切片，写入之前不能修改
+type WriteBatch struct {
+	logRecords []*data.LogRecord
+}
+
+// Put 添加一条写入操作
+func (b *WriteBatch) Put(key []byte, value []byte) {
+	b.logRecords = append(b.logRecords, &data.LogRecord{Key: key, Value: value, Type: data.LogRecordNormal})
+}
+
+// Delete 添加一条删除操作
+func (b *WriteBatch) Delete(key []byte) {
+	b.logRecords = append(b.logRecords, &data.LogRecord{Key: key, Type: data.LogRecordDelete})
+}
+
+// Len 返回操作的数量
+func (b *WriteBatch) Len() int {
+	return len(b.logRecords)
+}
+
+// Reset 清空所有操作，之后可以重复使用
+func (b *WriteBatch) Reset() {
+	b.logRecords = b.logRecords[:0]
+}
+
+// ForEach 按添加的顺序遍历所有操作，删除操作的 value 为空
+func (b *WriteBatch) ForEach(fn func(key []byte, value []byte, isDelete bool)) {
+	for _, logRecord := range b.logRecords {
+		fn(logRecord.Key, logRecord.Value, logRecord.Type == data.LogRecordDelete)
+	}
+}
+
+// Write 按顺序执行批量中的操作。其他读写看到的是执行之前或之后的状态，
+// 写入或刷盘失败时截断已经写入的记录，所有操作都不生效。
+// 但批量不是事务：写入中途崩溃时，重启后可能只有一部分操作生效
+func (db *DB) Write(batch *WriteBatch) error {
+	for _, logRecord := range batch.logRecords {
+		if !utils.IsValidKey(logRecord.Key) {
+			return ErrKeyIsNilOrEmpty
+		}
+	}
+	if db.options.ReadOnly {
+		return ErrDBReadOnly
+	}
+	if len(batch.logRecords) == 0 {
+		return nil
+	}
+	return db.writeBatch(batch.logRecords)
+}
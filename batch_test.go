@@ -0,0 +1,122 @@
+package bitcask_go
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDB_WriteBatch(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-batch"
+	opts.FS = fio.NewMemFS()
+	opts.CacheSize = 1024 * 1024
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("old"), []byte("v")))
+	_, err = db.Get([]byte("old"))
+	assert.Nil(t, err)
+
+	batch := &WriteBatch{}
+	for i := 0; i < 100; i++ {
+		batch.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+	}
+	// 按顺序执行，后面的操作覆盖前面的操作
+	batch.Delete(utils.GetTestKey(0))
+	batch.Put(utils.GetTestKey(1), []byte("new"))
+	batch.Delete([]byte("old"))
+	batch.Delete([]byte("missing"))
+	assert.Equal(t, 104, batch.Len())
+	assert.Nil(t, db.Write(batch))
+
+	check := func(db *DB) {
+		_, err := db.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrReadKeyNotFound, err)
+		_, err = db.Get([]byte("old"))
+		assert.Equal(t, ErrReadKeyNotFound, err)
+		value, err := db.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new"), value)
+		value, err = db.Get(utils.GetTestKey(99))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(99), value)
+		assert.Equal(t, 99, len(db.ListKeys()))
+	}
+	check(db)
+
+	// 重启之后仍然生效
+	assert.Nil(t, db.Close())
+	db, err = Start(&opts)
+	assert.Nil(t, err)
+	check(db)
+
+	var ops []string
+	batch.Reset()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Delete([]byte("b"))
+	batch.ForEach(func(key []byte, value []byte, isDelete bool) {
+		if isDelete {
+			ops = append(ops, "delete "+string(key))
+		} else {
+			ops = append(ops, "put "+string(key)+" "+string(value))
+		}
+	})
+	assert.Equal(t, []string{"put a 1", "delete b"}, ops)
+
+	// key 无效时不执行任何操作
+	batch.Put(nil, []byte("v"))
+	assert.Equal(t, ErrKeyIsNilOrEmpty, db.Write(batch))
+	_, err = db.Get([]byte("a"))
+	assert.Equal(t, ErrReadKeyNotFound, err)
+	assert.Nil(t, db.Write(&WriteBatch{}))
+	assert.Nil(t, db.Close())
+}
+
+// 写入中途失败时回滚已经写入的记录，包括轮转产生的新文件
+func TestDB_WriteBatchFailed(t *testing.T) {
+	fs := fio.NewFaultFS(fio.NewMemFS(), fio.FaultConfig{})
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-batch-failed"
+	opts.FS = fs
+	opts.FileMaxSize = 4 * 1024
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("old"), []byte("v")))
+	end := db.LogEnd()
+
+	batch := &WriteBatch{}
+	for i := 0; i < 100; i++ {
+		batch.Put(utils.GetTestKey(i), utils.RandomValue(128))
+	}
+	batch.Delete([]byte("old"))
+	for _, failAt := range []int{1, 50, 101} {
+		fs.SetConfig(fio.FaultConfig{FailWriteAt: failAt})
+		assert.NotNil(t, db.Write(batch), failAt)
+		fs.SetConfig(fio.FaultConfig{})
+		assert.Equal(t, end, db.LogEnd(), failAt)
+		names, err := fs.ReadDir(opts.DBFileDir)
+		assert.Nil(t, err)
+		assert.Contains(t, names, "000000001.data")
+		assert.NotContains(t, names, "000000002.data")
+	}
+
+	check := func(db *DB) {
+		_, err := db.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrReadKeyNotFound, err)
+		value, err := db.Get([]byte("old"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), value)
+	}
+	check(db)
+	assert.Nil(t, db.Close())
+	db, err = Start(&opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// 回滚之后可以继续写入
+	assert.Nil(t, db.Write(batch))
+	_, err = db.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"bitcask-go/data"
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// 默认的分片数量
+const defaultShardNum = 16
+
+// 每个缓存项除 value 之外额外占用的内存，按估算值计入容量
+const entryOverhead = 64
+
+// Cache 按字节数限制容量的 LRU 缓存，以记录在数据文件中的位置为 key 缓存 value
+// 数据文件只追加写入，同一位置上的记录不会改变，因此缓存项不会过期，
+// key 被覆盖或删除后，旧位置的缓存项不会再被访问，调用方应当主动移除以释放空间。
+// 缓存按位置分片，每个分片有自己的锁和 LRU 链表，并发读取不会在同一把锁上排队
+type Cache struct {
+	shards []*shard
+	hits   uint64 // 命中次数，原子读写
+	misses uint64 // 未命中次数，原子读写
+}
+
+// Stats 缓存的统计信息
+type Stats struct {
+	Hits    uint64 // 命中次数
+	Misses  uint64 // 未命中次数
+	Entries int    // 缓存项数量
+	Bytes   int64  // 已经使用的容量
+}
+
+type shard struct {
+	lock     *sync.Mutex
+	capacity int64
+	size     int64
+	items    map[cacheKey]*list.Element
+	lru      *list.List
+}
+
+type cacheKey struct {
+	fid    uint32
+	offset uint64
+}
+
+type entry struct {
+	key   cacheKey
+	value []byte
+}
+
+// New 创建容量为 capacity 字节的缓存，容量平均分配给各个分片
+func New(capacity int64) *Cache {
+	shardNum := defaultShardNum
+	if capacity < int64(shardNum)*entryOverhead*16 {
+		shardNum = 1
+	}
+	c := &Cache{shards: make([]*shard, shardNum)}
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			lock:     new(sync.Mutex),
+			capacity: capacity / int64(shardNum),
+			items:    make(map[cacheKey]*list.Element),
+			lru:      list.New(),
+		}
+	}
+	return c
+}
+
+func (c *Cache) shard(key cacheKey) *shard {
+	hash := uint64(key.fid)*0x9e3779b97f4a7c15 ^ key.offset*0xff51afd7ed558ccd
+	return c.shards[(hash>>32)%uint64(len(c.shards))]
+}
+
+// Get 获取 pos 处记录的 value，返回的是拷贝，调用方可以随意修改
+func (c *Cache) Get(pos *data.LogRecordPos) ([]byte, bool) {
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	s := c.shard(key)
+	s.lock.Lock()
+	elem, ok := s.items[key]
+	if !ok {
+		s.lock.Unlock()
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	value := append([]byte{}, elem.Value.(*entry).value...)
+	s.lock.Unlock()
+	atomic.AddUint64(&c.hits, 1)
+	return value, true
+}
+
+// Put 缓存 pos 处记录的 value，保存的是拷贝。超过分片容量的 value 不缓存
+func (c *Cache) Put(pos *data.LogRecordPos, value []byte) {
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	cost := int64(len(value)) + entryOverhead
+	s := c.shard(key)
+	if cost > s.capacity {
+		return
+	}
+	value = append([]byte{}, value...)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if elem, ok := s.items[key]; ok {
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.items[key] = s.lru.PushFront(&entry{key: key, value: value})
+	s.size += cost
+	// 淘汰最久未访问的缓存项
+	for s.size > s.capacity {
+		s.removeElement(s.lru.Back())
+	}
+}
+
+// Remove 移除 pos 处记录的缓存
+func (c *Cache) Remove(pos *data.LogRecordPos) {
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	s := c.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if elem, ok := s.items[key]; ok {
+		s.removeElement(elem)
+	}
+}
+
+// Clear 清空缓存，数据文件被重写后位置失效时调用，统计的命中次数保留
+func (c *Cache) Clear() {
+	for _, s := range c.shards {
+		s.lock.Lock()
+		s.items = make(map[cacheKey]*list.Element)
+		s.lru.Init()
+		s.size = 0
+		s.lock.Unlock()
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	stats := Stats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+	for _, s := range c.shards {
+		s.lock.Lock()
+		stats.Entries += len(s.items)
+		stats.Bytes += s.size
+		s.lock.Unlock()
+	}
+	return stats
+}
+
+// 必须在加锁的条件下调用
+func (s *shard) removeElement(elem *list.Element) {
+	e := s.lru.Remove(elem).(*entry)
+	delete(s.items, e.key)
+	s.size -= int64(len(e.value)) + entryOverhead
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestCache_GetPut(t *testing.T) {
+	c := New(1024 * 1024)
+	pos := &data.LogRecordPos{Fid: 1, Offset: 10, Size: 20}
+
+	_, ok := c.Get(pos)
+	assert.False(t, ok)
+
+	value := []byte("value")
+	c.Put(pos, value)
+	got, ok := c.Get(pos)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), got)
+
+	// 缓存保存和返回的都是拷贝
+	value[0] = 'x'
+	got[1] = 'x'
+	got, _ = c.Get(pos)
+	assert.Equal(t, []byte("value"), got)
+
+	// 只按位置区分，与 Size 无关
+	_, ok = c.Get(&data.LogRecordPos{Fid: 1, Offset: 10})
+	assert.True(t, ok)
+	_, ok = c.Get(&data.LogRecordPos{Fid: 2, Offset: 10})
+	assert.False(t, ok)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, int64(len(value)+entryOverhead), stats.Bytes)
+}
+
+func TestCache_Evict(t *testing.T) {
+	// 容量较小时只有一个分片，便于验证淘汰顺序
+	c := New(3 * (100 + entryOverhead))
+	assert.Equal(t, 1, len(c.shards))
+	for i := 0; i < 3; i++ {
+		c.Put(&data.LogRecordPos{Fid: 1, Offset: uint64(i)}, utils.RandomValue(100))
+	}
+	// 访问 0 之后，最久未访问的是 1
+	_, ok := c.Get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+	c.Put(&data.LogRecordPos{Fid: 1, Offset: 3}, utils.RandomValue(100))
+
+	_, ok = c.Get(&data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.False(t, ok)
+	for _, offset := range []uint64{0, 2, 3} {
+		_, ok = c.Get(&data.LogRecordPos{Fid: 1, Offset: offset})
+		assert.True(t, ok)
+	}
+	assert.Equal(t, int64(3*(100+entryOverhead)), c.Stats().Bytes)
+
+	// 超过容量的 value 不缓存
+	c.Put(&data.LogRecordPos{Fid: 1, Offset: 4}, utils.RandomValue(1000))
+	_, ok = c.Get(&data.LogRecordPos{Fid: 1, Offset: 4})
+	assert.False(t, ok)
+	assert.Equal(t, 3, c.Stats().Entries)
+}
+
+func TestCache_RemoveClear(t *testing.T) {
+	c := New(1024 * 1024)
+	for i := 0; i < 100; i++ {
+		c.Put(&data.LogRecordPos{Fid: 1, Offset: uint64(i)}, utils.RandomValue(10))
+	}
+	c.Remove(&data.LogRecordPos{Fid: 1, Offset: 5})
+	c.Remove(&data.LogRecordPos{Fid: 1, Offset: 500})
+	_, ok := c.Get(&data.LogRecordPos{Fid: 1, Offset: 5})
+	assert.False(t, ok)
+	assert.Equal(t, 99, c.Stats().Entries)
+
+	c.Clear()
+	stats := c.Stats()
+	assert.Equal(t, 0, stats.Entries)
+	assert.Equal(t, int64(0), stats.Bytes)
+	assert.Equal(t, uint64(1), stats.Misses)
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := New(64 * 1024)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				pos := &data.LogRecordPos{Fid: uint32(w), Offset: uint64(i % 300)}
+				if value, ok := c.Get(pos); ok {
+					assert.Equal(t, utils.GetTestKey(i%300), value)
+				} else {
+					c.Put(pos, utils.GetTestKey(i%300))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	stats := c.Stats()
+	assert.Equal(t, uint64(8*2000), stats.Hits+stats.Misses)
+	assert.True(t, stats.Bytes <= 64*1024)
+}
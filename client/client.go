@@ -0,0 +1,351 @@
+// Package client 是 bitcask 二进制协议（见 wire 包）的客户端，方法和 bitcask_go.DB 一一对应。
+// 客户端维护一个连接池，每个连接上可以同时等待多个请求的应答，可以被多个协程并发使用
+package client
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/wire"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed       = errors.New("client: client closed")
+	ErrTimeout      = errors.New("client: request timeout")
+	ErrPoolSize     = errors.New("client: illegal pool size")
+	ErrMaxRetries   = errors.New("client: illegal max retries")
+	ErrTimeoutValue = errors.New("client: illegal timeout")
+)
+
+type Options struct {
+	// 网络类型，tcp 或 unix
+	Network string
+
+	// 连接池中的连接数量，请求轮流使用各个连接
+	PoolSize int
+
+	// 建立连接的超时时间，0 表示不限制
+	DialTimeout time.Duration
+
+	// 等待应答的超时时间，扫描时为等待每条数据的时间，0 表示不限制。
+	// Merge 和 Backup 耗时较长，不受此限制
+	RequestTimeout time.Duration
+
+	// 连接失败或者断开时的重试次数。超时和服务端返回的错误不重试，Merge 和 Backup 不重试
+	MaxRetries int
+
+	// 每次重试前等待的时间
+	RetryBackoff time.Duration
+}
+
+var DefaultOptions = &Options{
+	Network:        "tcp",
+	PoolSize:       4,
+	DialTimeout:    5 * time.Second,
+	RequestTimeout: 10 * time.Second,
+	MaxRetries:     2,
+	RetryBackoff:   100 * time.Millisecond,
+}
+
+// Client 连接到一个 bitcask 服务端
+type Client struct {
+	address string
+	options *Options
+
+	mu     sync.Mutex
+	conns  []*conn // 按需建立，断开的连接在下次使用时重新建立
+	next   int
+	closed bool
+}
+
+// New 创建客户端，连接在第一次请求时建立。options 为空时使用 DefaultOptions
+func New(address string, options *Options) (*Client, error) {
+	if options == nil {
+		options = DefaultOptions
+	}
+	if options.PoolSize <= 0 {
+		return nil, ErrPoolSize
+	}
+	if options.MaxRetries < 0 {
+		return nil, ErrMaxRetries
+	}
+	if options.DialTimeout < 0 || options.RequestTimeout < 0 || options.RetryBackoff < 0 {
+		return nil, ErrTimeoutValue
+	}
+	return &Client{
+		address: address,
+		options: options,
+		conns:   make([]*conn, options.PoolSize),
+	}, nil
+}
+
+// Close 关闭所有连接，正在等待应答的请求返回错误
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for _, cn := range c.conns {
+		if cn != nil {
+			cn.close()
+		}
+	}
+	return nil
+}
+
+// 轮流选择一个连接，连接不存在或者已经断开时重新建立
+func (c *Client) getConn() (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	slot := c.next
+	c.next = (c.next + 1) % len(c.conns)
+	if cn := c.conns[slot]; cn != nil && !cn.broken() {
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	network := c.options.Network
+	if network == "" {
+		network = "tcp"
+	}
+	cn, err := dial(network, c.address, c.options.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cn.close()
+		return nil, ErrClosed
+	}
+	// 其他协程可能已经重新建立了这个位置的连接
+	if old := c.conns[slot]; old != nil && !old.broken() {
+		cn.close()
+		return old, nil
+	}
+	c.conns[slot] = cn
+	return cn, nil
+}
+
+// 发送请求并等待 StatusOK 应答，连接出错时按 MaxRetries 重试
+func (c *Client) do(op byte, body []byte) ([]byte, error) {
+	retries, timeout := c.options.MaxRetries, c.options.RequestTimeout
+	if op == wire.OpMerge || op == wire.OpBackup {
+		retries, timeout = 0, 0
+	}
+	for attempt := 0; ; attempt++ {
+		reply, err := c.roundTrip(op, body, timeout)
+		if !c.retryable(err, attempt, retries) {
+			return reply, err
+		}
+	}
+}
+
+func (c *Client) roundTrip(op byte, body []byte, timeout time.Duration) ([]byte, error) {
+	cn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+	cl, id, err := cn.send(op, body, timeout)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := cn.receive(cl, timeout)
+	if err != nil {
+		cn.abandon(id, cl)
+		return nil, err
+	}
+	switch frame.Code {
+	case wire.StatusOK:
+		return frame.Body, nil
+	case wire.StatusError:
+		return nil, wire.DecodeError(frame.Body)
+	}
+	// 只有扫描会返回 StatusItem
+	cn.cancel(id, cl, timeout)
+	return nil, wire.ErrInvalidBody
+}
+
+// 判断请求是否可以重试，可以重试时等待 RetryBackoff
+func (c *Client) retryable(err error, attempt int, retries int) bool {
+	var connErr *connError
+	if !errors.As(err, &connErr) || attempt >= retries {
+		return false
+	}
+	time.Sleep(c.options.RetryBackoff)
+	return true
+}
+
+// Ping 检查服务端是否可用
+func (c *Client) Ping() error {
+	_, err := c.do(wire.OpPing, nil)
+	return err
+}
+
+// Get 读取 key 对应的 value，key 不存在时返回 bitcask_go.ErrReadKeyNotFound
+func (c *Client) Get(key []byte) ([]byte, error) {
+	// 应答的消息体就是 value
+	return c.do(wire.OpGet, (&wire.Encoder{}).Bytes(key).Body())
+}
+
+// Put 写入数据
+func (c *Client) Put(key []byte, value []byte) error {
+	_, err := c.do(wire.OpPut, (&wire.Encoder{}).Bytes(key).Bytes(value).Body())
+	return err
+}
+
+// Delete 删除数据
+func (c *Client) Delete(key []byte) error {
+	_, err := c.do(wire.OpDelete, (&wire.Encoder{}).Bytes(key).Body())
+	return err
+}
+
+// Write 在服务端通过 DB.Write 执行批量中的操作
+func (c *Client) Write(batch *bitcask_go.WriteBatch) error {
+	e := (&wire.Encoder{}).Uvarint(uint64(batch.Len()))
+	batch.ForEach(func(key []byte, value []byte, isDelete bool) {
+		if isDelete {
+			e.Byte(wire.BatchDelete).Bytes(key)
+		} else {
+			e.Byte(wire.BatchPut).Bytes(key).Bytes(value)
+		}
+	})
+	_, err := c.do(wire.OpWrite, e.Body())
+	return err
+}
+
+// Scan 按 key 的顺序遍历以 prefix 开头的数据，fn 返回 false 时结束遍历。
+// fn 处理得慢时服务端暂停这次扫描，不影响同一个连接上的其他请求
+func (c *Client) Scan(prefix []byte, fn func(key []byte, value []byte) bool) error {
+	return c.scan(0, prefix, func(d *wire.Decoder) bool {
+		key, value := d.Bytes(), d.Bytes()
+		return d.Finish() != nil || fn(key, value)
+	})
+}
+
+// Fold 按 key 的顺序遍历所有数据，fn 返回 false 时结束遍历
+func (c *Client) Fold(fn func(key []byte, value []byte) bool) error {
+	return c.Scan(nil, fn)
+}
+
+// ListKeys 按顺序返回所有 key
+func (c *Client) ListKeys() ([][]byte, error) {
+	var keys [][]byte
+	err := c.scan(wire.ScanKeysOnly, nil, func(d *wire.Decoder) bool {
+		key := d.Bytes()
+		if d.Finish() == nil {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys, err
+}
+
+// 执行扫描，每条数据调用一次 item，返回 false 时通知服务端结束扫描。
+// 连接出错时只有在还没有收到数据的情况下重试，避免重复调用 fn
+func (c *Client) scan(flags byte, prefix []byte, item func(d *wire.Decoder) bool) error {
+	body := (&wire.Encoder{}).Byte(flags).Bytes(prefix).Body()
+	timeout := c.options.RequestTimeout
+	for attempt := 0; ; attempt++ {
+		received, err := c.scanOnce(body, timeout, item)
+		if received || !c.retryable(err, attempt, c.options.MaxRetries) {
+			return err
+		}
+	}
+}
+
+func (c *Client) scanOnce(body []byte, timeout time.Duration, item func(d *wire.Decoder) bool) (bool, error) {
+	cn, err := c.getConn()
+	if err != nil {
+		return false, err
+	}
+	cl, id, err := cn.send(wire.OpScan, body, timeout)
+	if err != nil {
+		return false, err
+	}
+	received := false
+	unacked := 0
+	for {
+		frame, err := cn.receive(cl, timeout)
+		if err != nil {
+			cn.cancel(id, cl, timeout)
+			return received, err
+		}
+		switch frame.Code {
+		case wire.StatusOK:
+			return received, nil
+		case wire.StatusError:
+			return received, wire.DecodeError(frame.Body)
+		}
+		received = true
+		d := wire.NewDecoder(frame.Body)
+		if !item(d) {
+			cn.cancel(id, cl, timeout)
+			return received, nil
+		}
+		if err := d.Err(); err != nil {
+			cn.cancel(id, cl, timeout)
+			return received, err
+		}
+		// 处理完半个窗口之后确认，服务端不用等待
+		if unacked++; unacked >= wire.ScanWindow/2 {
+			if err := cn.ack(id, unacked, timeout); err != nil {
+				cn.abandon(id, cl)
+				return received, err
+			}
+			unacked = 0
+		}
+	}
+}
+
+// Stat 获取数据库的统计信息
+func (c *Client) Stat() (*bitcask_go.Stat, error) {
+	body, err := c.do(wire.OpStat, nil)
+	if err != nil {
+		return nil, err
+	}
+	d := wire.NewDecoder(body)
+	stat := &bitcask_go.Stat{
+		KeyNum:      int(d.Uvarint()),
+		DataFileNum: int(d.Uvarint()),
+		DiskSize:    d.Uvarint(),
+	}
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+// Sync 将服务端的数据刷盘
+func (c *Client) Sync() error {
+	_, err := c.do(wire.OpSync, nil)
+	return err
+}
+
+// Merge 在服务端执行 merge
+func (c *Client) Merge() error {
+	_, err := c.do(wire.OpMerge, nil)
+	return err
+}
+
+// Backup 将服务端的数据文件备份到服务端备份根目录下的 name 目录，name 为空时由服务端按当前时间命名，
+// 返回服务端上的备份目录。服务端没有配置备份根目录时返回错误
+func (c *Client) Backup(name string) (string, error) {
+	body, err := c.do(wire.OpBackup, (&wire.Encoder{}).Bytes([]byte(name)).Body())
+	if err != nil {
+		return "", err
+	}
+	d := wire.NewDecoder(body)
+	dir := d.Bytes()
+	if err := d.Finish(); err != nil {
+		return "", err
+	}
+	return string(dir), nil
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/fio"
+	"bitcask-go/server"
+	"bitcask-go/wire"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startServer(t *testing.T) (*bitcask_go.DB, string) {
+	opts := *bitcask_go.DefaultOptions
+	opts.DBFileDir = "/bitcask-go-client"
+	opts.FS = fio.NewMemFS()
+	db, err := bitcask_go.Start(&opts)
+	assert.Nil(t, err)
+	srv, err := server.New(db, &server.Options{Protocol: server.Binary, BackupDir: "/bitcask-go-client-backup"})
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+		_ = db.Close()
+	})
+	return db, l.Addr().String()
+}
+
+func newClient(t *testing.T, addr string, options *Options) *Client {
+	c, err := New(addr, options)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestClient(t *testing.T) {
+	db, addr := startServer(t)
+	c := newClient(t, addr, nil)
+
+	assert.Nil(t, c.Ping())
+	_, err := c.Get([]byte("k1"))
+	assert.Equal(t, bitcask_go.ErrReadKeyNotFound, err)
+	assert.Equal(t, bitcask_go.ErrKeyIsNilOrEmpty, c.Put(nil, []byte("v")))
+
+	assert.Nil(t, c.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, c.Put([]byte("k2"), nil))
+	value, err := c.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	value, err = c.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(value))
+	assert.Nil(t, c.Delete([]byte("k2")))
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, bitcask_go.ErrReadKeyNotFound, err)
+
+	batch := &bitcask_go.WriteBatch{}
+	batch.Put([]byte("a/1"), []byte("1"))
+	batch.Put([]byte("a/2"), []byte("2"))
+	batch.Put([]byte("b/1"), []byte("3"))
+	batch.Delete([]byte("k1"))
+	assert.Nil(t, c.Write(batch))
+
+	var got []string
+	assert.Nil(t, c.Scan([]byte("a/"), func(key []byte, value []byte) bool {
+		got = append(got, string(key)+"="+string(value))
+		return true
+	}))
+	assert.Equal(t, []string{"a/1=1", "a/2=2"}, got)
+	keys, err := c.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a/1"), []byte("a/2"), []byte("b/1")}, keys)
+
+	stat, err := c.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, stat.KeyNum)
+	assert.Equal(t, 1, stat.DataFileNum)
+	assert.Nil(t, c.Sync())
+	assert.Nil(t, c.Merge())
+
+	// 备份只能写到服务端配置的备份根目录下
+	dir, err := c.Backup("b1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/bitcask-go-client-backup/b1", dir)
+	_, err = c.Backup("b1")
+	assert.Equal(t, bitcask_go.ErrBackupDirNotEmpty.Error(), err.Error())
+	for _, name := range []string{"..", "../b2", "/tmp/b2"} {
+		_, err = c.Backup(name)
+		assert.Equal(t, server.ErrBackupName.Error(), err.Error(), name)
+	}
+	dir, err = c.Backup("")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(dir, "/bitcask-go-client-backup/backup-"), dir)
+
+	assert.Nil(t, c.Close())
+	assert.Equal(t, ErrClosed, c.Ping())
+}
+
+func TestClient_ScanStop(t *testing.T) {
+	db, addr := startServer(t)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("value")))
+	}
+	c := newClient(t, addr, &Options{PoolSize: 1})
+
+	// 提前结束的扫描不影响同一个连接上之后的请求
+	count := 0
+	assert.Nil(t, c.Fold(func(key []byte, value []byte) bool {
+		count++
+		return count < 10
+	}))
+	assert.Equal(t, 10, count)
+	value, err := c.Get([]byte("key-0999"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	keys, err := c.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(keys))
+}
+
+// 扫描的调用方处理得慢时，同一个连接上的其他请求仍然可以收到应答
+func TestClient_SlowScan(t *testing.T) {
+	db, addr := startServer(t)
+	for i := 0; i < 4*wire.ScanWindow; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("value")))
+	}
+	c := newClient(t, addr, &Options{PoolSize: 1, RequestTimeout: 5 * time.Second})
+
+	count := 0
+	assert.Nil(t, c.Fold(func(key []byte, value []byte) bool {
+		if count%wire.ScanWindow == 0 {
+			// 等待服务端发送完一个窗口的数据
+			time.Sleep(50 * time.Millisecond)
+			value, err := c.Get([]byte("key-0000"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("value"), value)
+		}
+		count++
+		return true
+	}))
+	assert.Equal(t, 4*wire.ScanWindow, count)
+}
+
+// 服务端最多发送一个窗口的没有确认的数据
+func TestClient_ScanWindow(t *testing.T) {
+	db, addr := startServer(t)
+	for i := 0; i < 2*wire.ScanWindow; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("value")))
+	}
+	netConn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer netConn.Close()
+	body := (&wire.Encoder{}).Byte(wire.ScanKeysOnly).Bytes(nil).Body()
+	assert.Nil(t, wire.WriteFrame(netConn, &wire.Frame{ID: 1, Code: wire.OpScan, Body: body}))
+	for i := 0; i < wire.ScanWindow; i++ {
+		frame, err := wire.ReadFrame(netConn)
+		assert.Nil(t, err)
+		assert.Equal(t, wire.StatusItem, frame.Code)
+	}
+	_ = netConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = wire.ReadFrame(netConn)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+
+	// 确认之后继续发送
+	_ = netConn.SetReadDeadline(time.Time{})
+	body = (&wire.Encoder{}).Uvarint(wire.ScanWindow).Body()
+	assert.Nil(t, wire.WriteFrame(netConn, &wire.Frame{ID: 1, Code: wire.OpAck, Body: body}))
+	for i := 0; i < wire.ScanWindow; i++ {
+		frame, err := wire.ReadFrame(netConn)
+		assert.Nil(t, err)
+		assert.Equal(t, wire.StatusItem, frame.Code)
+	}
+	frame, err := wire.ReadFrame(netConn)
+	assert.Nil(t, err)
+	assert.Equal(t, wire.StatusOK, frame.Code)
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	_, addr := startServer(t)
+	c := newClient(t, addr, &Options{PoolSize: 2, RequestTimeout: 10 * time.Second})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := []byte(fmt.Sprintf("%d-%d", i, j))
+				assert.Nil(t, c.Put(key, key))
+				value, err := c.Get(key)
+				assert.Nil(t, err)
+				assert.Equal(t, key, value)
+			}
+		}(i)
+	}
+	wg.Wait()
+	keys, err := c.ListKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 800, len(keys))
+}
+
+func TestClient_Retry(t *testing.T) {
+	db, addr := startServer(t)
+	c := newClient(t, addr, &Options{PoolSize: 1, MaxRetries: 1})
+	assert.Nil(t, c.Put([]byte("k1"), []byte("v1")))
+
+	// 连接断开后的请求在新的连接上重试
+	c.conns[0].close()
+	value, err := c.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	assert.Nil(t, db.Sync())
+
+	c2 := newClient(t, "127.0.0.1:1", &Options{PoolSize: 1, MaxRetries: 1, DialTimeout: time.Second})
+	err = c2.Ping()
+	var connErr *connError
+	assert.ErrorAs(t, err, &connErr)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	// 不回复的服务端
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					if _, err := wire.ReadFrame(conn); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	c := newClient(t, l.Addr().String(), &Options{PoolSize: 1, RequestTimeout: 50 * time.Millisecond})
+	assert.Equal(t, ErrTimeout, c.Ping())
+	assert.Equal(t, ErrTimeout, c.Ping())
+	assert.Equal(t, ErrTimeout, c.Scan(nil, func(key []byte, value []byte) bool { return true }))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("127.0.0.1:0", &Options{})
+	assert.Equal(t, ErrPoolSize, err)
+	_, err = New("127.0.0.1:0", &Options{PoolSize: 1, MaxRetries: -1})
+	assert.Equal(t, ErrMaxRetries, err)
+	_, err = New("127.0.0.1:0", &Options{PoolSize: 1, RequestTimeout: -1})
+	assert.Equal(t, ErrTimeoutValue, err)
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"bitcask-go/wire"
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errScanWindow = errors.New("client: server exceeded the scan window")
+
+// connError 连接建立失败或者断开，请求可能没有被服务端执行，可以重试
+type connError struct {
+	err error
+}
+
+func (e *connError) Error() string {
+	return "client: connection error: " + e.err.Error()
+}
+
+func (e *connError) Unwrap() error {
+	return e.err
+}
+
+// call 一个等待应答的请求
+type call struct {
+	frames chan *wire.Frame // 读取协程写入应答，连接断开或者服务端违反协议时关闭
+	done   chan struct{}    // 调用方放弃请求时关闭
+	err    error            // 连接正常时关闭 frames 的原因
+}
+
+// conn 一个连接，多个请求可以同时在连接上等待应答，由读取协程按 id 分发
+type conn struct {
+	netConn net.Conn
+
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+
+	mu     sync.Mutex
+	calls  map[uint32]*call
+	nextID uint32
+	err    error // 连接断开的原因，非空之后不能再使用
+}
+
+func dial(network, address string, timeout time.Duration) (*conn, error) {
+	netConn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, &connError{err: err}
+	}
+	c := &conn{
+		netConn: netConn,
+		writer:  bufio.NewWriter(netConn),
+		calls:   make(map[uint32]*call),
+	}
+	go c.readLoop(bufio.NewReader(netConn))
+	return c, nil
+}
+
+func (c *conn) broken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+func (c *conn) close() {
+	_ = c.netConn.Close()
+}
+
+// 读取应答并分发给对应的请求，连接断开后通知所有等待中的请求
+func (c *conn) readLoop(reader *bufio.Reader) {
+	for {
+		frame, err := wire.ReadFrame(reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		cl := c.calls[frame.ID]
+		if frame.Code != wire.StatusItem {
+			delete(c.calls, frame.ID)
+		}
+		c.mu.Unlock()
+		if cl == nil {
+			// 请求已经超时或者取消
+			continue
+		}
+		// 缓存可以容纳一个扫描窗口的数据和最后的应答，写入不会阻塞读取协程，
+		// 服务端发送了超出窗口的数据时结束这个请求
+		if frame.Code == wire.StatusItem && len(cl.frames) >= cap(cl.frames)-1 {
+			c.finish(frame.ID, cl, errScanWindow)
+			continue
+		}
+		cl.frames <- frame
+	}
+}
+
+// 结束一个请求，之后收到的应答被丢弃
+func (c *conn) finish(id uint32, cl *call, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls[id] != cl {
+		return
+	}
+	delete(c.calls, id)
+	cl.err = err
+	close(cl.frames)
+}
+
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = &connError{err: err}
+	for id, cl := range c.calls {
+		close(cl.frames)
+		delete(c.calls, id)
+	}
+	_ = c.netConn.Close()
+}
+
+// 发送请求，返回等待应答的 call 和请求的 id
+func (c *conn) send(op byte, body []byte, timeout time.Duration) (*call, uint32, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, 0, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	// 扫描之外的请求只有一个应答
+	size := 1
+	if op == wire.OpScan {
+		size = wire.ScanWindow + 1
+	}
+	cl := &call{frames: make(chan *wire.Frame, size), done: make(chan struct{})}
+	c.calls[id] = cl
+	c.mu.Unlock()
+
+	if err := c.writeFrame(&wire.Frame{ID: id, Code: op, Body: body}, timeout); err != nil {
+		c.abandon(id, cl)
+		return nil, 0, err
+	}
+	return cl, id, nil
+}
+
+func (c *conn) writeFrame(frame *wire.Frame, timeout time.Duration) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if timeout > 0 {
+		_ = c.netConn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	err := wire.WriteFrame(c.writer, frame)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil {
+		// 帧可能只写入了一部分，连接不能再使用
+		_ = c.netConn.Close()
+		return &connError{err: err}
+	}
+	return nil
+}
+
+// 等待下一个应答，超时返回 ErrTimeout
+func (c *conn) receive(cl *call, timeout time.Duration) (*wire.Frame, error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case frame, ok := <-cl.frames:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if cl.err != nil {
+				return nil, cl.err
+			}
+			return nil, c.err
+		}
+		return frame, nil
+	case <-timer:
+		return nil, ErrTimeout
+	}
+}
+
+// 放弃请求，之后收到的应答被丢弃
+func (c *conn) abandon(id uint32, cl *call) {
+	c.mu.Lock()
+	if c.calls[id] == cl {
+		delete(c.calls, id)
+	}
+	c.mu.Unlock()
+	close(cl.done)
+}
+
+// 确认扫描中已经处理的 n 条数据，服务端可以继续发送
+func (c *conn) ack(id uint32, n int, timeout time.Duration) error {
+	body := (&wire.Encoder{}).Uvarint(uint64(n)).Body()
+	return c.writeFrame(&wire.Frame{ID: id, Code: wire.OpAck, Body: body}, timeout)
+}
+
+// 结束服务端正在执行的扫描
+func (c *conn) cancel(id uint32, cl *call, timeout time.Duration) {
+	c.abandon(id, cl)
+	_ = c.writeFrame(&wire.Frame{ID: id, Code: wire.OpCancel}, timeout)
+}
@@ -0,0 +1,120 @@
+// bitcask-server 通过 RESP2 协议（Redis 协议）、memcached 文本协议或 bitcask 二进制协议对外提供 bitcask 数据库的读写服务
+//
+//	bitcask-server -dir <DBFileDir> [-protocol resp|memcached|binary] [-addr host:port] [-unix path] [-readonly]
+//
+// 收到 SIGINT 或 SIGTERM 时停止接受新连接，执行完已经收到的命令后关闭数据库
+package main
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/index"
+	"bitcask-go/server"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+)
+
+// 优雅关闭的最长等待时间，超时后强制断开剩余的连接
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	os.Exit(run(os.Args[1:], os.Stderr, signals))
+}
+
+// run 启动服务，stop 收到信号后关闭，返回进程的退出码
+func run(args []string, stderr io.Writer, stop <-chan os.Signal) int {
+	flags := flag.NewFlagSet("bitcask-server", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dir := flags.String("dir", "", "数据目录 DBFileDir")
+	protocol := flags.String("protocol", "resp", "客户端使用的协议：resp、memcached 或 binary")
+	addr := flags.String("addr", "127.0.0.1:6380", "TCP 监听地址，为空时不监听 TCP")
+	unixPath := flags.String("unix", "", "Unix socket 路径，为空时不监听 Unix socket")
+	readOnly := flags.Bool("readonly", false, "以只读模式打开数据库，写命令返回错误")
+	expireInterval := flags.Duration("expire-interval", server.DefaultOptions.ExpireInterval, "后台清理过期 key 的间隔，0 表示只在访问时删除")
+	backupDir := flags.String("backup-dir", "", "备份的根目录，二进制协议的备份请求在其下创建备份目录，为空时不允许备份")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *dir == "" || flags.NArg() > 0 || (*addr == "" && *unixPath == "") {
+		flags.Usage()
+		return 2
+	}
+	serverOpts := &server.Options{ExpireInterval: *expireInterval, BackupDir: *backupDir}
+	switch *protocol {
+	case "resp":
+		serverOpts.Protocol = server.RESP
+	case "memcached":
+		serverOpts.Protocol = server.Memcached
+	case "binary":
+		serverOpts.Protocol = server.Binary
+	default:
+		fmt.Fprintf(stderr, "bitcask-server: unknown protocol %q\n", *protocol)
+		return 2
+	}
+
+	opts := *bitcask_go.DefaultOptions
+	opts.DBFileDir = *dir
+	opts.ReadOnly = *readOnly
+	if _, err := os.Stat(path.Join(*dir, index.BPlusTreeFileName)); err == nil {
+		opts.DBIndex = index.BPTree
+	}
+	db, err := bitcask_go.Start(&opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "bitcask-server: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	srv, err := server.New(db, serverOpts)
+	if err != nil {
+		fmt.Fprintf(stderr, "bitcask-server: %v\n", err)
+		return 2
+	}
+
+	var listeners []net.Listener
+	for _, l := range []struct{ network, address string }{{"tcp", *addr}, {"unix", *unixPath}} {
+		if l.address == "" {
+			continue
+		}
+		listener, err := net.Listen(l.network, l.address)
+		if err != nil {
+			fmt.Fprintf(stderr, "bitcask-server: %v\n", err)
+			for _, listener := range listeners {
+				_ = listener.Close()
+			}
+			return 1
+		}
+		fmt.Fprintf(stderr, "bitcask-server: listening on %s %s\n", l.network, listener.Addr())
+		listeners = append(listeners, listener)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errs <- srv.Serve(listener)
+		}(listener)
+	}
+	code := 0
+	select {
+	case <-stop:
+	case err := <-errs:
+		fmt.Fprintf(stderr, "bitcask-server: %v\n", err)
+		code = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && err != server.ErrServerClosed {
+		fmt.Fprintf(stderr, "bitcask-server: shutdown: %v\n", err)
+		code = 1
+	}
+	return code
+}
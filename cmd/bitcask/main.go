@@ -0,0 +1,456 @@
+// bitcask 命令行工具，对 DBFileDir 目录下的数据库执行读写和运维操作
+//
+//	bitcask -dir <DBFileDir> [-readonly] [-format raw|hex|json] <command> [args]
+package main
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const usage = `usage: bitcask -dir <DBFileDir> [-readonly] [-format raw|hex|json] <command> [args]
+
+commands:
+  get <key>               读取 key 的值
+  put <key> <value>       写入数据，value 为 - 时从标准输入读取
+  delete <key>            删除 key
+  scan [-prefix <prefix>] 按 key 的顺序输出以 prefix 开头的数据
+  keys                    输出所有的 key
+  stat                    输出统计信息
+  merge                   合并数据文件，回收无效数据占用的空间
+  backup <dir>            将数据文件备份到 dir 目录，dir 必须不存在或者为空
+  verify                  校验所有数据文件中的记录
+  repair                  跳过损坏的记录重写数据文件，原文件添加 .corrupt 后缀保留
+  dump                    按文件顺序输出数据文件中的所有记录，包括被覆盖和删除的记录
+  export [-prefix <prefix>] [-binary]
+                          将数据导出到标准输出，默认为 JSON Lines 格式
+  import [-prefix <prefix>]
+                          从标准输入导入 export 导出的数据
+
+flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli 一次命令的执行环境
+type cli struct {
+	dir      string
+	readOnly bool
+	format   string
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+// run 执行命令，返回进程的退出码
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	flags := flag.NewFlagSet("bitcask", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&c.dir, "dir", "", "数据目录 DBFileDir")
+	flags.BoolVar(&c.readOnly, "readonly", false, "以只读模式打开，不锁定数据目录，可以和正在写入的进程同时使用")
+	flags.StringVar(&c.format, "format", "raw", "输出格式：raw 原样输出，hex 十六进制，json 每行一个 JSON 对象，二进制数据按 base64 编码")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if c.dir == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if c.format != "raw" && c.format != "hex" && c.format != "json" {
+		fmt.Fprintf(stderr, "bitcask: unknown format %q\n", c.format)
+		return 2
+	}
+
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	var err error
+	switch command {
+	case "get":
+		err = c.withArgs(commandArgs, 1, c.get)
+	case "put":
+		err = c.withArgs(commandArgs, 2, c.put)
+	case "delete":
+		err = c.withArgs(commandArgs, 1, c.delete)
+	case "scan":
+		err = c.scan(commandArgs)
+	case "keys":
+		err = c.withArgs(commandArgs, 0, c.keys)
+	case "stat":
+		err = c.withArgs(commandArgs, 0, c.stat)
+	case "merge":
+		err = c.withArgs(commandArgs, 0, c.merge)
+	case "backup":
+		err = c.withArgs(commandArgs, 1, c.backup)
+	case "verify":
+		err = c.withArgs(commandArgs, 0, c.verify)
+	case "repair":
+		err = c.withArgs(commandArgs, 0, c.repair)
+	case "dump":
+		err = c.withArgs(commandArgs, 0, c.dump)
+	case "export":
+		err = c.exportData(commandArgs)
+	case "import":
+		err = c.importData(commandArgs)
+	default:
+		fmt.Fprintf(stderr, "bitcask: unknown command %q\n", command)
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "bitcask %s: %v\n", command, err)
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("wrong number of arguments, see bitcask -h")
+
+func (c *cli) withArgs(args []string, n int, fn func(args []string) error) error {
+	if len(args) != n {
+		return errUsage
+	}
+	return fn(args)
+}
+
+// 打开数据库，目录下存在 B+树索引文件时使用 B+树索引，避免索引文件与数据文件不一致
+func (c *cli) open() (*bitcask_go.DB, error) {
+	opts := *bitcask_go.DefaultOptions
+	opts.DBFileDir = c.dir
+	opts.ReadOnly = c.readOnly
+	if _, err := os.Stat(path.Join(c.dir, index.BPlusTreeFileName)); err == nil {
+		opts.DBIndex = index.BPTree
+	}
+	return bitcask_go.Start(&opts)
+}
+
+// 打开数据库执行 fn，执行完成后关闭
+func (c *cli) withDB(fn func(db *bitcask_go.DB) error) error {
+	db, err := c.open()
+	if err != nil {
+		return err
+	}
+	if err := fn(db); err != nil {
+		_ = db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+func (c *cli) get(args []string) error {
+	return c.withDB(func(db *bitcask_go.DB) error {
+		value, err := db.Get([]byte(args[0]))
+		if err != nil {
+			return err
+		}
+		if c.format == "json" {
+			return c.printJSON(&kvJSON{Key: []byte(args[0]), Value: value})
+		}
+		return c.printLine(c.encode(value))
+	})
+}
+
+func (c *cli) put(args []string) error {
+	value := []byte(args[1])
+	if args[1] == "-" {
+		var err error
+		if value, err = io.ReadAll(c.stdin); err != nil {
+			return err
+		}
+	}
+	return c.withDB(func(db *bitcask_go.DB) error {
+		return db.Put([]byte(args[0]), value)
+	})
+}
+
+func (c *cli) delete(args []string) error {
+	return c.withDB(func(db *bitcask_go.DB) error {
+		return db.Delete([]byte(args[0]))
+	})
+}
+
+func (c *cli) scan(args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	prefix := flags.String("prefix", "", "只输出以 prefix 开头的 key")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	return c.withDB(func(db *bitcask_go.DB) error {
+		var printErr error
+		err := db.Scan([]byte(*prefix), func(key []byte, value []byte) bool {
+			if c.format == "json" {
+				printErr = c.printJSON(&kvJSON{Key: key, Value: value})
+			} else {
+				printErr = c.printLine(c.encode(key), c.encode(value))
+			}
+			return printErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return printErr
+	})
+}
+
+func (c *cli) keys([]string) error {
+	return c.withDB(func(db *bitcask_go.DB) error {
+		for _, key := range db.ListKeys() {
+			var err error
+			if c.format == "json" {
+				err = c.printJSON(&kvJSON{Key: key})
+			} else {
+				err = c.printLine(c.encode(key))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *cli) stat([]string) error {
+	return c.withDB(func(db *bitcask_go.DB) error {
+		stat, err := db.Stat()
+		if err != nil {
+			return err
+		}
+		if c.format == "json" {
+			return c.printJSON(&statJSON{KeyNum: stat.KeyNum, DataFileNum: stat.DataFileNum, DiskSize: stat.DiskSize})
+		}
+		return c.printLines(
+			[]string{"keys", strconv.Itoa(stat.KeyNum)},
+			[]string{"data_files", strconv.Itoa(stat.DataFileNum)},
+			[]string{"disk_size", strconv.FormatUint(stat.DiskSize, 10)},
+		)
+	})
+}
+
+func (c *cli) merge([]string) error {
+	return c.withDB(func(db *bitcask_go.DB) error {
+		return db.Merge()
+	})
+}
+
+func (c *cli) backup(args []string) error {
+	return c.withDB(func(db *bitcask_go.DB) error {
+		return db.Backup(args[0])
+	})
+}
+
+// verify 不打开数据库，数据库被其他进程使用时也可以执行，发现问题时返回错误
+func (c *cli) verify([]string) error {
+	report, err := bitcask_go.Verify(c.dir)
+	if err != nil {
+		return err
+	}
+	if c.format == "json" {
+		err = c.printJSON(newVerifyJSON(report))
+	} else {
+		err = c.printVerifyReport(report)
+	}
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return errors.New("the data directory is damaged")
+	}
+	return nil
+}
+
+// 每行输出一个文件或一个问题，字段之间以制表符分隔
+func (c *cli) printVerifyReport(report *bitcask_go.VerifyReport) error {
+	var lines [][]string
+	for _, file := range report.Files {
+		status := "ok"
+		if len(file.Damaged) > 0 {
+			status = "damaged"
+		}
+		lines = append(lines, []string{"file", path.Base(data.DataFileName(c.dir, file.Fid)),
+			strconv.Itoa(file.Records), strconv.FormatUint(file.Size, 10), status})
+		lines = append(lines, damagedLines(file)...)
+	}
+	for _, name := range report.InvalidNames {
+		lines = append(lines, []string{"invalid_name", name})
+	}
+	if index := report.Index; index != nil {
+		status := "ok"
+		if index.Err != nil {
+			status = index.Err.Error()
+		} else if index.InvalidCheckpoint {
+			status = "invalid checkpoint"
+		} else if len(index.Mismatches) > 0 {
+			status = "mismatched"
+		}
+		lines = append(lines, []string{"index", strconv.Itoa(index.Keys), status})
+		for _, mismatch := range index.Mismatches {
+			lines = append(lines, []string{"mismatch", c.encode(mismatch.Key), strconv.FormatUint(uint64(mismatch.Pos.Fid), 10),
+				strconv.FormatUint(mismatch.Pos.Offset, 10), mismatch.Err.Error()})
+		}
+	}
+	return c.printLines(lines...)
+}
+
+func damagedLines(file *bitcask_go.VerifyFileReport) [][]string {
+	var lines [][]string
+	for _, damaged := range file.Damaged {
+		lines = append(lines, []string{"damaged", strconv.FormatUint(uint64(damaged.Fid), 10),
+			strconv.FormatUint(damaged.Offset, 10), strconv.FormatUint(damaged.Size, 10), damaged.Err.Error()})
+	}
+	return lines
+}
+
+// repair 需要独占数据目录，输出被重写的文件和丢失的区间
+func (c *cli) repair([]string) error {
+	report, err := bitcask_go.Repair(c.dir)
+	if err != nil {
+		return err
+	}
+	if c.format == "json" {
+		return c.printJSON(newRepairJSON(report))
+	}
+	var lines [][]string
+	for _, file := range report.Files {
+		lines = append(lines, []string{"repaired", path.Base(data.DataFileName(c.dir, file.Fid)), strconv.Itoa(file.Records)})
+		lines = append(lines, damagedLines(file)...)
+	}
+	for _, name := range report.InvalidNames {
+		lines = append(lines, []string{"renamed", name, report.Renamed[name]})
+	}
+	if report.IndexRemoved {
+		lines = append(lines, []string{"index", "removed"})
+	}
+	lines = append(lines, []string{"lost", strconv.FormatUint(report.LostBytes, 10)})
+	return c.printLines(lines...)
+}
+
+// dump 不打开数据库，按文件顺序输出所有记录
+func (c *cli) dump([]string) error {
+	fids, err := dataFileIds(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fids {
+		var printErr error
+		err := scanDataFile(c.dir, fid, func(logRecord *data.LogRecord, pos *data.LogRecordPos) {
+			if printErr != nil {
+				return
+			}
+			recordType := "put"
+			if logRecord.Type == data.LogRecordDelete {
+				recordType = "delete"
+			}
+			if c.format == "json" {
+				printErr = c.printJSON(&recordJSON{
+					Fid: pos.Fid, Offset: pos.Offset, Size: pos.Size, Type: recordType,
+					Key: logRecord.Key, Value: logRecord.Value,
+				})
+			} else {
+				printErr = c.printLine(
+					strconv.FormatUint(uint64(pos.Fid), 10), strconv.FormatUint(pos.Offset, 10),
+					strconv.FormatUint(uint64(pos.Size), 10), recordType,
+					c.encode(logRecord.Key), c.encode(logRecord.Value),
+				)
+			}
+		})
+		if printErr != nil {
+			return printErr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path.Base(data.DataFileName(c.dir, fid)), err)
+		}
+	}
+	return nil
+}
+
+func (c *cli) exportData(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	prefix := flags.String("prefix", "", "只导出以 prefix 开头的 key")
+	binary := flags.Bool("binary", false, "导出为二进制格式")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+	format := bitcask_go.ExportJSON
+	if *binary {
+		format = bitcask_go.ExportBinary
+	}
+	return c.withDB(func(db *bitcask_go.DB) error {
+		_, err := db.Export(c.stdout, format, &bitcask_go.ExportOptions{Prefix: []byte(*prefix)})
+		return err
+	})
+}
+
+func (c *cli) importData(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	prefix := flags.String("prefix", "", "只导入以 prefix 开头的 key")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+	return c.withDB(func(db *bitcask_go.DB) error {
+		count, err := db.Import(c.stdin, &bitcask_go.ImportOptions{
+			Prefix: []byte(*prefix),
+			Progress: func(count int) {
+				fmt.Fprintf(c.stderr, "imported %d\n", count)
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("imported %d: %v", count, err)
+		}
+		return nil
+	})
+}
+
+// 数据目录下所有数据文件的编号，按编号排序
+func dataFileIds(dir string) ([]uint32, error) {
+	names, err := fio.DefaultVFS.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var fids []uint32
+	for _, name := range names {
+		if !strings.HasSuffix(name, data.DataFileSubffix) {
+			continue
+		}
+		fid, err := strconv.ParseUint(strings.TrimSuffix(name, data.DataFileSubffix), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data file name %q", name)
+		}
+		fids = append(fids, uint32(fid))
+	}
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+	return fids, nil
+}
+
+func scanDataFile(dir string, fid uint32, fn func(logRecord *data.LogRecord, pos *data.LogRecordPos)) error {
+	dataFile, err := data.OpenDataFileReadOnly(fio.DefaultVFS, dir, fid)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	return dataFile.Scan(func(logRecord *data.LogRecord, pos *data.LogRecordPos) bool {
+		fn(logRecord, pos)
+		return true
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// 执行命令，返回退出码和标准输出
+func runCommand(t *testing.T, stdin string, args ...string) (int, string) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	code := run(args, strings.NewReader(stdin), stdout, stderr)
+	if code != 0 {
+		t.Log(stderr.String())
+	}
+	return code, stdout.String()
+}
+
+func TestCLI_Commands(t *testing.T) {
+	dir := t.TempDir()
+
+	code, _ := runCommand(t, "", "-dir", dir, "put", "apple", "red")
+	assert.Equal(t, 0, code)
+	code, _ = runCommand(t, "", "-dir", dir, "put", "banana", "yellow")
+	assert.Equal(t, 0, code)
+	code, _ = runCommand(t, "from stdin", "-dir", dir, "put", "cherry", "-")
+	assert.Equal(t, 0, code)
+
+	code, out := runCommand(t, "", "-dir", dir, "get", "cherry")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "from stdin\n", out)
+	code, out = runCommand(t, "", "-dir", dir, "-format", "hex", "get", "apple")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "726564\n", out)
+	code, out = runCommand(t, "", "-dir", dir, "-format", "json", "get", "apple")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, `{"key":"YXBwbGU=","value":"cmVk"}`+"\n", out)
+
+	code, out = runCommand(t, "", "-dir", dir, "scan", "-prefix", "b")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "banana\tyellow\n", out)
+	code, out = runCommand(t, "", "-dir", dir, "-readonly", "keys")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "apple\nbanana\ncherry\n", out)
+
+	code, _ = runCommand(t, "", "-dir", dir, "delete", "banana")
+	assert.Equal(t, 0, code)
+	code, _ = runCommand(t, "", "-dir", dir, "get", "banana")
+	assert.Equal(t, 1, code)
+	code, _ = runCommand(t, "", "-dir", dir, "-readonly", "put", "a", "b")
+	assert.Equal(t, 1, code)
+
+	code, out = runCommand(t, "", "-dir", dir, "-format", "json", "stat")
+	assert.Equal(t, 0, code)
+	stat := &statJSON{}
+	assert.Nil(t, json.Unmarshal([]byte(out), stat))
+	assert.Equal(t, 2, stat.KeyNum)
+	assert.Equal(t, 1, stat.DataFileNum)
+
+	// dump 输出包括删除记录在内的所有记录
+	code, out = runCommand(t, "", "-dir", dir, "dump")
+	assert.Equal(t, 0, code)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, "1\t0\t15\tput\tapple\tred", lines[0])
+	assert.True(t, strings.HasSuffix(lines[3], "\tdelete\tbanana\t"))
+
+	code, _ = runCommand(t, "", "-dir", dir, "merge")
+	assert.Equal(t, 0, code)
+	code, out = runCommand(t, "", "-dir", dir, "dump")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, 2, len(strings.Split(strings.TrimSpace(out), "\n")))
+
+	backupDir := path.Join(t.TempDir(), "backup")
+	code, _ = runCommand(t, "", "-dir", dir, "backup", backupDir)
+	assert.Equal(t, 0, code)
+	code, out = runCommand(t, "", "-dir", backupDir, "keys")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "apple\ncherry\n", out)
+}
+
+func TestCLI_Verify(t *testing.T) {
+	dir := t.TempDir()
+	for _, key := range []string{"a", "b", "c"} {
+		code, _ := runCommand(t, "", "-dir", dir, "put", key, "value-"+key)
+		assert.Equal(t, 0, code)
+	}
+	code, out := runCommand(t, "", "-dir", dir, "verify")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "file\t000000001.data\t3\t45\tok\n", out)
+
+	// 截断最后一条记录
+	fileName := path.Join(dir, "000000001.data")
+	assert.Nil(t, os.Truncate(fileName, 40))
+	code, out = runCommand(t, "", "-dir", dir, "verify")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "file\t000000001.data\t2\t40\tdamaged\n"+"damaged\t1\t30\t10\tunexpected EOF\n", out)
+
+	code, out = runCommand(t, "", "-dir", dir, "-format", "json", "verify")
+	assert.Equal(t, 1, code)
+	result := &verifyJSON{}
+	assert.Nil(t, json.Unmarshal([]byte(out), result))
+	assert.False(t, result.OK)
+	assert.Equal(t, []*damagedJSON{{Offset: 30, Size: 10, Error: "unexpected EOF"}}, result.Files[0].Damaged)
+}
+
+func TestCLI_Repair(t *testing.T) {
+	dir := t.TempDir()
+	for _, key := range []string{"a", "b", "c"} {
+		code, _ := runCommand(t, "", "-dir", dir, "put", key, "value-"+key)
+		assert.Equal(t, 0, code)
+	}
+	fileName := path.Join(dir, "000000001.data")
+	assert.Nil(t, os.Truncate(fileName, 40))
+	code, out := runCommand(t, "", "-dir", dir, "repair")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "repaired\t000000001.data\t2\n"+"damaged\t1\t30\t10\tunexpected EOF\n"+"lost\t10\n", out)
+
+	code, _ = runCommand(t, "", "-dir", dir, "verify")
+	assert.Equal(t, 0, code)
+	code, out = runCommand(t, "", "-dir", dir, "keys")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "a\nb\n", out)
+
+	code, out = runCommand(t, "", "-dir", dir, "-format", "json", "repair")
+	assert.Equal(t, 0, code)
+	result := &repairJSON{}
+	assert.Nil(t, json.Unmarshal([]byte(out), result))
+	assert.Equal(t, 0, len(result.Files))
+}
+
+func TestCLI_Usage(t *testing.T) {
+	code, _ := runCommand(t, "")
+	assert.Equal(t, 2, code)
+	code, _ = runCommand(t, "", "-dir", t.TempDir(), "unknown")
+	assert.Equal(t, 2, code)
+	code, _ = runCommand(t, "", "-dir", t.TempDir(), "-format", "xml", "keys")
+	assert.Equal(t, 2, code)
+	code, _ = runCommand(t, "", "-dir", t.TempDir(), "get")
+	assert.Equal(t, 1, code)
+}
+
+func TestCLI_ExportImport(t *testing.T) {
+	dir := t.TempDir()
+	for _, key := range []string{"a1", "b1", "b2"} {
+		code, _ := runCommand(t, "", "-dir", dir, "put", key, "value-"+key)
+		assert.Equal(t, 0, code)
+	}
+	for _, args := range [][]string{{"export", "-prefix", "b"}, {"export", "-prefix", "b", "-binary"}} {
+		code, out := runCommand(t, "", append([]string{"-dir", dir, "-readonly"}, args...)...)
+		assert.Equal(t, 0, code)
+
+		importDir := t.TempDir()
+		code, _ = runCommand(t, out, "-dir", importDir, "import")
+		assert.Equal(t, 0, code)
+		code, out = runCommand(t, "", "-dir", importDir, "scan")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "b1\tvalue-b1\nb2\tvalue-b2\n", out)
+	}
+	code, _ := runCommand(t, "garbage", "-dir", dir, "import")
+	assert.Equal(t, 1, code)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	bitcask_go "bitcask-go"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+type kvJSON struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type statJSON struct {
+	KeyNum      int    `json:"keys"`
+	DataFileNum int    `json:"data_files"`
+	DiskSize    uint64 `json:"disk_size"`
+}
+
+type verifyJSON struct {
+	OK           bool             `json:"ok"`
+	Files        []*fileJSON      `json:"files"`
+	InvalidNames []string         `json:"invalid_names,omitempty"`
+	Index        *verifyIndexJSON `json:"index,omitempty"`
+}
+
+type fileJSON struct {
+	Fid     uint32         `json:"fid"`
+	Records int            `json:"records"`
+	Size    uint64         `json:"size"`
+	Padding uint64         `json:"padding"`
+	Damaged []*damagedJSON `json:"damaged,omitempty"`
+}
+
+type damagedJSON struct {
+	Offset uint64 `json:"offset"`
+	Size   uint64 `json:"size"`
+	Error  string `json:"error"`
+}
+
+type verifyIndexJSON struct {
+	Keys              int             `json:"keys"`
+	InvalidCheckpoint bool            `json:"invalid_checkpoint"`
+	Error             string          `json:"error,omitempty"`
+	Mismatches        []*mismatchJSON `json:"mismatches,omitempty"`
+}
+
+type mismatchJSON struct {
+	Key    []byte `json:"key"`
+	Fid    uint32 `json:"fid"`
+	Offset uint64 `json:"offset"`
+	Error  string `json:"error"`
+}
+
+func newVerifyJSON(report *bitcask_go.VerifyReport) *verifyJSON {
+	result := &verifyJSON{OK: report.OK(), Files: []*fileJSON{}, InvalidNames: report.InvalidNames}
+	for _, file := range report.Files {
+		result.Files = append(result.Files, newFileJSON(file))
+	}
+	if index := report.Index; index != nil {
+		result.Index = &verifyIndexJSON{Keys: index.Keys, InvalidCheckpoint: index.InvalidCheckpoint}
+		if index.Err != nil {
+			result.Index.Error = index.Err.Error()
+		}
+		for _, mismatch := range index.Mismatches {
+			result.Index.Mismatches = append(result.Index.Mismatches, &mismatchJSON{
+				Key: mismatch.Key, Fid: mismatch.Pos.Fid, Offset: mismatch.Pos.Offset, Error: mismatch.Err.Error(),
+			})
+		}
+	}
+	return result
+}
+
+func newFileJSON(file *bitcask_go.VerifyFileReport) *fileJSON {
+	f := &fileJSON{Fid: file.Fid, Records: file.Records, Size: file.Size, Padding: file.Padding}
+	for _, damaged := range file.Damaged {
+		f.Damaged = append(f.Damaged, &damagedJSON{Offset: damaged.Offset, Size: damaged.Size, Error: damaged.Err.Error()})
+	}
+	return f
+}
+
+type repairJSON struct {
+	Files        []*fileJSON       `json:"files"`
+	InvalidNames []string          `json:"invalid_names,omitempty"`
+	Renamed      map[string]string `json:"renamed,omitempty"`
+	LostBytes    uint64            `json:"lost_bytes"`
+	IndexRemoved bool              `json:"index_removed"`
+}
+
+func newRepairJSON(report *bitcask_go.RepairReport) *repairJSON {
+	result := &repairJSON{Files: []*fileJSON{}, InvalidNames: report.InvalidNames, Renamed: report.Renamed,
+		LostBytes: report.LostBytes, IndexRemoved: report.IndexRemoved}
+	for _, file := range report.Files {
+		result.Files = append(result.Files, newFileJSON(file))
+	}
+	return result
+}
+
+type recordJSON struct {
+	Fid    uint32 `json:"fid"`
+	Offset uint64 `json:"offset"`
+	Size   uint32 `json:"size"`
+	Type   string `json:"type"`
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+}
+
+// 按输出格式编码二进制数据，raw 原样输出，hex 输出十六进制
+func (c *cli) encode(b []byte) string {
+	if c.format == "hex" {
+		return hex.EncodeToString(b)
+	}
+	return string(b)
+}
+
+// 输出一行，字段之间以制表符分隔
+func (c *cli) printLine(fields ...string) error {
+	_, err := c.stdout.Write([]byte(strings.Join(fields, "\t") + "\n"))
+	return err
+}
+
+func (c *cli) printLines(lines ...[]string) error {
+	for _, fields := range lines {
+		if err := c.printLine(fields...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 输出 JSON 对象，独占一行
+func (c *cli) printJSON(v interface{}) error {
+	return json.NewEncoder(c.stdout).Encode(v)
+}
@@ -0,0 +1,80 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+// 记录的位置在 key 每次写入时都会改变，可以作为数据的版本，用于条件读写。
+// 合并会重写数据文件，之后所有 key 的位置都会改变
+
+// GetWithPosition 读取 key 的值和它当前记录的位置
+func (db *DB) GetWithPosition(key []byte) ([]byte, *data.LogRecordPos, error) {
+	if !utils.IsValidKey(key) {
+		return nil, nil, ErrKeyIsNilOrEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	value, pos, err := db.getLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	posCopy := *pos
+	return value, &posCopy, nil
+}
+
+// PutIf 在 cond 返回 true 时写入数据，返回新记录的位置，cond 为空时无条件写入。
+// cond 在写锁内执行，参数为 key 当前记录的位置，key 不存在时为空。cond 返回 false 时返回 ErrConditionFailed。
+// cond 中不能调用 DB 的方法
+func (db *DB) PutIf(key []byte, value []byte, cond func(pos *data.LogRecordPos) bool) (*data.LogRecordPos, error) {
+	if !utils.IsValidKey(key) {
+		return nil, ErrKeyIsNilOrEmpty
+	}
+	if db.options.ReadOnly {
+		return nil, ErrDBReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if cond != nil && !cond(db.currentPosition(key)) {
+		return nil, ErrConditionFailed
+	}
+	pos, err := db.putLocked(key, value)
+	if err != nil {
+		return nil, err
+	}
+	posCopy := *pos
+	return &posCopy, nil
+}
+
+// DeleteIf 在 cond 返回 true 时删除 key，cond 的含义和 PutIf 相同。key 不存在且 cond 返回 true 时不做任何操作
+func (db *DB) DeleteIf(key []byte, cond func(pos *data.LogRecordPos) bool) error {
+	if !utils.IsValidKey(key) {
+		return ErrKeyIsNilOrEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrDBReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	pos := db.currentPosition(key)
+	if cond != nil && !cond(pos) {
+		return ErrConditionFailed
+	}
+	if pos == nil {
+		return nil
+	}
+	return db.deleteLocked(key, pos)
+}
+
+// 返回 key 当前记录位置的副本，cond 修改参数不会影响索引
+// 该方法必须在加锁的条件下调用
+func (db *DB) currentPosition(key []byte) *data.LogRecordPos {
+	pos := db.index.Get(key)
+	if pos == nil {
+		return nil
+	}
+	posCopy := *pos
+	return &posCopy
+}
@@ -0,0 +1,85 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDB_Conditional(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-conditional"
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+
+	// key 不存在时条件的参数为空
+	notExist := func(pos *data.LogRecordPos) bool { return pos == nil }
+	pos1, err := db.PutIf([]byte("k"), []byte("v1"), notExist)
+	assert.Nil(t, err)
+	_, err = db.PutIf([]byte("k"), []byte("v2"), notExist)
+	assert.Equal(t, ErrConditionFailed, err)
+
+	value, pos, err := db.GetWithPosition([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	assert.Equal(t, pos1, pos)
+
+	// 按位置比较并写入，写入之后位置改变
+	samePos := func(expected *data.LogRecordPos) func(pos *data.LogRecordPos) bool {
+		return func(pos *data.LogRecordPos) bool {
+			return pos != nil && pos.Fid == expected.Fid && pos.Offset == expected.Offset
+		}
+	}
+	pos2, err := db.PutIf([]byte("k"), []byte("v2"), samePos(pos1))
+	assert.Nil(t, err)
+	assert.NotEqual(t, pos1.Offset, pos2.Offset)
+	_, err = db.PutIf([]byte("k"), []byte("v3"), samePos(pos1))
+	assert.Equal(t, ErrConditionFailed, err)
+	_, err = db.PutIf([]byte("other"), []byte("v"), nil)
+	assert.Nil(t, err)
+
+	// 修改返回的位置不影响索引
+	pos2.Offset = 0
+	_, pos, err = db.GetWithPosition([]byte("k"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, uint64(0), pos.Offset)
+
+	assert.Equal(t, ErrConditionFailed, db.DeleteIf([]byte("k"), samePos(pos1)))
+	assert.Nil(t, db.DeleteIf([]byte("k"), samePos(pos)))
+	_, _, err = db.GetWithPosition([]byte("k"))
+	assert.Equal(t, ErrReadKeyNotFound, err)
+	assert.Nil(t, db.DeleteIf([]byte("k"), notExist))
+	assert.Equal(t, ErrKeyIsNilOrEmpty, db.DeleteIf(nil, nil))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_ScanKeys(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-scan-keys"
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	for _, key := range []string{"apple", "banana", "band", "bank", "cat"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("value-"+key)))
+	}
+
+	scan := func(prefix, start string, limit int) []string {
+		var keys []string
+		db.ScanKeys([]byte(prefix), []byte(start), func(key []byte, pos *data.LogRecordPos) bool {
+			_, expected, err := db.GetWithPosition(key)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, pos)
+			keys = append(keys, string(key))
+			return len(keys) < limit
+		})
+		return keys
+	}
+	assert.Equal(t, []string{"banana", "band", "bank"}, scan("ban", "", 10))
+	assert.Equal(t, []string{"band", "bank"}, scan("ban", "banb", 10))
+	assert.Equal(t, []string{"banana", "band"}, scan("ban", "a", 2))
+	assert.Equal(t, []string{"bank", "cat"}, scan("", "bank", 10))
+	assert.Nil(t, scan("ban", "c", 10))
+	assert.Nil(t, db.Close())
+}
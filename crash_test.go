@@ -0,0 +1,145 @@
+package bitcask_go
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+// 崩溃测试中 key 的期望状态，value 为 nil 表示 key 不存在
+type crashModel struct {
+	committed map[string][]byte   // 已确认持久化的值
+	uncertain map[string][][]byte // 操作返回错误的 key，崩溃后可能是其中任意一个值
+}
+
+func (m *crashModel) apply(key string, value []byte, err error) {
+	if err == nil {
+		m.committed[key] = value
+		delete(m.uncertain, key)
+		return
+	}
+	if _, ok := m.uncertain[key]; !ok {
+		m.uncertain[key] = [][]byte{m.committed[key]}
+	}
+	m.uncertain[key] = append(m.uncertain[key], value)
+}
+
+// 校验重启后的数据，并将不确定的 key 收敛为实际读到的值
+func (m *crashModel) verify(t *testing.T, db *DB) {
+	for key, value := range m.committed {
+		if _, ok := m.uncertain[key]; ok {
+			continue
+		}
+		got, err := db.Get([]byte(key))
+		if value == nil {
+			assert.Equal(t, ErrReadKeyNotFound, err, key)
+		} else {
+			assert.Nil(t, err, key)
+			assert.Equal(t, value, got, key)
+		}
+	}
+	for key, candidates := range m.uncertain {
+		got, err := db.Get([]byte(key))
+		if err == ErrReadKeyNotFound {
+			got = nil
+		} else {
+			assert.Nil(t, err, key)
+		}
+		matched := false
+		for _, candidate := range candidates {
+			if (candidate == nil && got == nil) || (candidate != nil && got != nil && bytes.Equal(candidate, got)) {
+				matched = true
+				break
+			}
+		}
+		assert.True(t, matched, key)
+		m.committed[key] = got
+		delete(m.uncertain, key)
+	}
+}
+
+func TestDB_CrashConsistency(t *testing.T) {
+	testCrashConsistency(t, index.BTree)
+}
+
+// B+树索引在文件轮转和 Sync 时提交检查点，崩溃后从检查点恢复
+func TestDB_CrashConsistencyBPlusTree(t *testing.T) {
+	testCrashConsistency(t, index.BPTree)
+}
+
+func testCrashConsistency(t *testing.T, indexType index.DBIndexType) {
+	faultConfig := fio.FaultConfig{
+		Seed:           1,
+		WriteFailRate:  0.02,
+		ShortWriteRate: 0.02,
+		SyncFailRate:   0.05,
+		TornCrash:      true,
+	}
+	faultFS := fio.NewFaultFS(fio.NewMemFS(), faultConfig)
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-crash"
+	opts.FileMaxSize = 16 * 1024
+	opts.FS = faultFS
+	opts.DBIndex = indexType
+
+	rnd := rand.New(rand.NewSource(1))
+	model := &crashModel{
+		committed: make(map[string][]byte),
+		uncertain: make(map[string][][]byte),
+	}
+	for round := 0; round < 30; round++ {
+		// 启动过程中不注入故障
+		faultFS.SetConfig(fio.FaultConfig{Seed: int64(round)})
+		db, err := Start(&opts)
+		if !assert.Nil(t, err, "round %d", round) {
+			return
+		}
+		model.verify(t, db)
+
+		faultConfig.Seed = int64(round)
+		faultFS.SetConfig(faultConfig)
+		for i := 0; i < 200; i++ {
+			key := utils.GetTestKey(rnd.Intn(100))
+			if indexType == index.BPTree && rnd.Intn(50) == 0 {
+				_ = db.Sync()
+				continue
+			}
+			if rnd.Intn(4) == 0 {
+				model.apply(string(key), nil, db.Delete(key))
+			} else {
+				value := utils.RandomValue(rnd.Intn(256) + 1)
+				model.apply(string(key), value, db.Put(key, value))
+			}
+		}
+		assert.Nil(t, faultFS.Crash())
+	}
+}
+
+func TestDB_ReadBitFlip(t *testing.T) {
+	faultFS := fio.NewFaultFS(fio.NewMemFS(), fio.FaultConfig{})
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-flip"
+	opts.FS = faultFS
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 100; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+
+	// 读取时发生比特翻转，只能返回错误，不能返回错误的数据
+	faultFS.SetConfig(fio.FaultConfig{Seed: 1, ReadFlipRate: 0.5})
+	for i := 0; i < 100; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		if err == nil {
+			assert.Equal(t, values[i], value)
+		}
+	}
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"testing"
+)
+
+// 写入时丢弃数据的 IOManager，只统计编码本身的开销
+type discardIO struct {
+	fio.IOManager
+}
+
+func (d *discardIO) Write(bytes []byte) (int, error) {
+	return len(bytes), nil
+}
+
+func BenchmarkEncodeLogRecord(b *testing.B) {
+	codec := NewLogRecordCodec(&discardIO{})
+	logRecord := &LogRecord{Key: utils.GetTestKey(1), Value: utils.RandomValue(128), Type: LogRecordNormal}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.EncodeLogRecord(logRecord); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeLogRecordSize(b *testing.B) {
+	codec := NewLogRecordCodec(&discardIO{})
+	logRecord := &LogRecord{Key: utils.GetTestKey(1), Value: utils.RandomValue(128), Type: LogRecordNormal}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		codec.EncodeLogRecordSize(logRecord)
+	}
+}
+
+// 准备一个写满记录的内存文件，返回每条记录的位置
+func benchDataFile(b *testing.B, num int) (*DataFile, []*LogRecordPos) {
+	dataFile, err := OpenDataFile(fio.NewMemFS(), "/", 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	poses := make([]*LogRecordPos, num)
+	for i := 0; i < num; i++ {
+		offset := dataFile.WriteOff
+		size, err := dataFile.WriteLogRecord(&LogRecord{
+			Key:   utils.GetTestKey(i),
+			Value: utils.RandomValue(128),
+			Type:  LogRecordNormal,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		poses[i] = &LogRecordPos{Fid: 1, Offset: offset, Size: uint32(size)}
+	}
+	return dataFile, poses
+}
+
+func BenchmarkDataFile_ReadLogRecord(b *testing.B) {
+	dataFile, poses := benchDataFile(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := dataFile.ReadLogRecord(int64(poses[i%len(poses)].Offset)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDataFile_ReadLogRecordAt(b *testing.B) {
+	dataFile, poses := benchDataFile(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pos := poses[i%len(poses)]
+		if _, err := dataFile.ReadLogRecordAt(int64(pos.Offset), pos.Size); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"errors"
 	"hash/crc32"
 	"io"
+	"math"
+	"sync"
 )
 
+var ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
+
 type BinaryCodec struct {
 	ioManager fio.IOManager
 }
@@ -15,6 +19,30 @@ type BinaryCodec struct {
 // LogRecordHeaderMaxSize 日志记录头部最大长度
 const LogRecordHeaderMaxSize = 15
 
+// 超过该长度的缓冲区不放回池中，避免偶尔写入的大记录长期占用内存
+const maxPooledBufferSize = 1 << 20
+
+// 编码和读取头部时使用的缓冲区池
+// 缓冲区在 Write 返回后即被复用，IOManager 的实现不能持有传入的切片
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+func putBuffer(bufPtr *[]byte, buf []byte) {
+	if cap(buf) > maxPooledBufferSize {
+		return
+	}
+	*bufPtr = buf[:0]
+	bufferPool.Put(bufPtr)
+}
+
 // 日志记录头部，不对外暴露
 type logRecordHeader struct {
 	crc           uint32
@@ -34,30 +62,26 @@ func newBinaryCodec(io fio.IOManager) *BinaryCodec {
 // | crc校验值 | type类型 | key size | value size |    key   |   value  |
 // +----------+---------+----------+------------+----------+----------+
 //     4字节      1字节  变长(最大5字节) 变长(最大5字节)   变长       变长
+// 编码使用池中的缓冲区，按追加的方式写入各个字段，不产生额外的内存分配
 func (b *BinaryCodec) EncodeLogRecord(lr *LogRecord) (int, error) {
-	header := make([]byte, LogRecordHeaderMaxSize)
-	// 第五个字节存储type
-	header[4] = byte(lr.Type)
+	bufPtr := getBuffer()
+	// 前四个字节留给crc，第五个字节存储type
+	buf := append(*bufPtr, 0, 0, 0, 0, byte(lr.Type))
 
-	// 后面字节存储key size 和 value size
-	index := 5
-	index += binary.PutVarint(header[index:], int64(len(lr.Key)))
-	index += binary.PutVarint(header[index:], int64(len(lr.Value)))
-
-	size := index + len(lr.Key) + len(lr.Value)
-	encBytes := make([]byte, size)
-	// 拷贝header
-	copy(encBytes[:index], header[:index])
-	// 拷贝key和value
-	copy(encBytes[index:], lr.Key)
-	copy(encBytes[index+len(lr.Key):], lr.Value)
+	// 后面字节存储key size 和 value size，然后是key和value
+	buf = appendVarint(buf, int64(len(lr.Key)))
+	buf = appendVarint(buf, int64(len(lr.Value)))
+	buf = append(buf, lr.Key...)
+	buf = append(buf, lr.Value...)
 
 	// 对所有数据计算一个CRC冗余码
-	crc := crc32.ChecksumIEEE(encBytes[4:])
-	binary.LittleEndian.PutUint32(encBytes[:4], crc)
+	crc := crc32.ChecksumIEEE(buf[4:])
+	binary.LittleEndian.PutUint32(buf[:4], crc)
 
 	// 写入IO流
-	_, err := b.ioManager.Write(encBytes)
+	size := len(buf)
+	_, err := b.ioManager.Write(buf)
+	putBuffer(bufPtr, buf)
 	if err != nil {
 		return 0, err
 	}
@@ -67,16 +91,27 @@ func (b *BinaryCodec) EncodeLogRecord(lr *LogRecord) (int, error) {
 
 // EncodeLogRecordSize 获取编码后的长度
 func (b *BinaryCodec) EncodeLogRecordSize(lr *LogRecord) int {
+	// crc + type，key size 和 value size 是变长的
+	return 5 + varintSize(int64(len(lr.Key))) + varintSize(int64(len(lr.Value))) + len(lr.Key) + len(lr.Value)
+}
 
-	// 编码长度只有key size ，value size 是变长的
-	size := 5 // type + crc
-	// 计算key size ，value size 实际长度
-	bytes := make([]byte, 10)
-	size += binary.PutVarint(bytes, int64(len(lr.Key)))
-	size += binary.PutVarint(bytes, int64(len(lr.Value)))
+func appendVarint(buf []byte, x int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
 
-	size += len(lr.Key)
-	size += len(lr.Value)
+// 变长编码后的字节数，与 binary.PutVarint 一致
+func varintSize(x int64) int {
+	ux := uint64(x) << 1
+	if x < 0 {
+		ux = ^ux
+	}
+	size := 1
+	for ux >= 0x80 {
+		ux >>= 7
+		size++
+	}
 	return size
 }
 
@@ -97,54 +132,113 @@ func (b *BinaryCodec) DecodeLogRecord(offset int64) (*LogRecord, int, error) {
 	if currentLRMaxSize == 0 {
 		return nil, 0, io.EOF
 	}
-	// 读头部信息
-	header := make([]byte, currentLRMaxSize)
-	if _, err = b.ioManager.Read(header, offset); err != nil {
+	// 读头部信息，头部解析完之后不再引用缓冲区，可以放回池中
+	bufPtr := getBuffer()
+	headerBuf := (*bufPtr)[:currentLRMaxSize]
+	defer putBuffer(bufPtr, headerBuf)
+	if _, err = b.ioManager.Read(headerBuf, offset); err != nil {
 		return nil, 0, err
 	}
-
-	crc := binary.LittleEndian.Uint32(header)
-	lrType := header[4]
-
-	// 读key size 和 value size
-	index := 5
-	keySize, n := binary.Varint(header[index:])
-	index += n
-
-	valueSize, n := binary.Varint(header[index:])
-	index += n
-
-	// 读取key value
-	key := make([]byte, keySize)
-	n, err = b.ioManager.Read(key, offset+int64(index))
+	header, index, err := decodeLogRecordHeader(headerBuf)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	value := make([]byte, valueSize)
-	n, err = b.ioManager.Read(value, offset+int64(index)+keySize)
-	if err != nil {
+	// 记录超出了文件末尾(写入时崩溃导致记录不完整)
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	if offset+int64(index)+keySize+valueSize > size {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	// 一次读取key value
+	kv := make([]byte, keySize+valueSize)
+	if _, err = b.ioManager.Read(kv, offset+int64(index)); err != nil {
 		return nil, 0, err
 	}
 
 	logRecord := &LogRecord{
-		Type:  LogRecordType(lrType),
-		Key:   key,
-		Value: value,
+		Type:  header.logRecordType,
+		Key:   kv[:keySize:keySize],
+		Value: kv[keySize:],
+	}
+
+	crc := crc32.ChecksumIEEE(headerBuf[4:index])
+	if crc32.Update(crc, crc32.IEEETable, kv) != header.crc {
+		return nil, 0, ErrInvalidCRC
 	}
+	return logRecord, index + len(kv), nil
+}
 
-	if !b.checkLogRecordCRC(header[:index], logRecord, crc) {
-		return nil, 0, errors.New("the data file is damaged")
+// DecodeLogRecordAt 二进制解码，size 为记录编码后的长度
+// 只需要一次读取，key 和 value 直接引用读出的缓冲区，不再拷贝
+func (b *BinaryCodec) DecodeLogRecordAt(offset int64, size uint32) (*LogRecord, error) {
+	buf := make([]byte, size)
+	if _, err := b.ioManager.Read(buf, offset); err != nil {
+		return nil, err
+	}
+	header, index, err := decodeLogRecordHeader(buf)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	// 头部记录的长度与索引中的长度不一致
+	keyEnd := int64(index) + int64(header.keySize)
+	if keyEnd+int64(header.valueSize) != int64(size) {
+		return nil, ErrInvalidCRC
+	}
+	if crc32.ChecksumIEEE(buf[4:]) != header.crc {
+		return nil, ErrInvalidCRC
 	}
-	return logRecord, index + int(keySize+valueSize), nil
+	return &LogRecord{
+		Type:  header.logRecordType,
+		Key:   buf[index:keyEnd:keyEnd],
+		Value: buf[keyEnd:],
+	}, nil
 }
 
-// 校验LogRecord CRC码，防止文件已经被破坏
-func (b *BinaryCodec) checkLogRecordCRC(header []byte, lr *LogRecord, crc uint32) bool {
-	currentCRC := crc32.ChecksumIEEE(header[4:])
-	currentCRC = crc32.Update(currentCRC, crc32.IEEETable, lr.Key)
-	currentCRC = crc32.Update(currentCRC, crc32.IEEETable, lr.Value)
-	return currentCRC == crc
+// 解析日志记录头部，返回头部及其长度，头部按值返回，不产生内存分配
+// 数据不足一个完整的头部时返回 io.ErrUnexpectedEOF，全零的头部返回 io.EOF
+func decodeLogRecordHeader(buf []byte) (logRecordHeader, int, error) {
+	// 文件末尾只有不完整的头部
+	if len(buf) <= 5 {
+		return logRecordHeader{}, 0, io.ErrUnexpectedEOF
+	}
+
+	crc := binary.LittleEndian.Uint32(buf)
+	lrType := buf[4]
+
+	// 读key size 和 value size
+	index := 5
+	keySize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return logRecordHeader{}, 0, io.ErrUnexpectedEOF
+	}
+	index += n
+
+	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return logRecordHeader{}, 0, io.ErrUnexpectedEOF
+	}
+	index += n
+
+	// 全零的头部是预分配的空白空间，说明已经到了文件的逻辑末尾
+	if crc == 0 && lrType == 0 && keySize == 0 && valueSize == 0 {
+		return logRecordHeader{}, 0, io.EOF
+	}
+
+	// 长度非法
+	if keySize < 0 || valueSize < 0 || keySize > math.MaxUint32 || valueSize > math.MaxUint32 {
+		return logRecordHeader{}, 0, ErrInvalidCRC
+	}
+
+	return logRecordHeader{
+		crc:           crc,
+		logRecordType: LogRecordType(lrType),
+		keySize:       uint32(keySize),
+		valueSize:     uint32(valueSize),
+	}, index, nil
 }
 
 func (b *BinaryCodec) Sync() error {
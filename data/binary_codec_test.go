@@ -0,0 +1,47 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestVarintSize(t *testing.T) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, x := range []int64{0, 1, -1, 63, 64, -64, -65, 127, 128, 8191, 8192, 1 << 20, math.MaxInt32, math.MaxInt64, math.MinInt64} {
+		assert.Equal(t, binary.PutVarint(buf, x), varintSize(x), x)
+	}
+}
+
+func TestBinaryCodec_EncodeDecode(t *testing.T) {
+	ioManager, err := fio.NewMemFS().OpenFile("/000000001.data")
+	assert.Nil(t, err)
+	codec := NewLogRecordCodec(ioManager)
+
+	// 缓冲区复用后，之前写入的数据不受影响
+	records := []*LogRecord{
+		{Key: []byte("hello"), Value: []byte("world"), Type: LogRecordNormal},
+		{Key: []byte("big"), Value: make([]byte, 2*maxPooledBufferSize), Type: LogRecordNormal},
+		{Key: []byte("hello"), Type: LogRecordDelete},
+		{Key: []byte("k"), Value: []byte("v"), Type: LogRecordNormal},
+	}
+	var offset int64
+	for _, record := range records {
+		size, err := codec.EncodeLogRecord(record)
+		assert.Nil(t, err)
+		assert.Equal(t, codec.EncodeLogRecordSize(record), size)
+	}
+	for _, record := range records {
+		decoded, size, err := codec.DecodeLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, record.Key, decoded.Key)
+		assert.Equal(t, len(record.Value), len(decoded.Value))
+		assert.Equal(t, record.Type, decoded.Type)
+		offset += int64(size)
+	}
+	_, _, err = codec.DecodeLogRecord(offset)
+	assert.Equal(t, io.EOF, err)
+}
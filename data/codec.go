@@ -10,6 +10,8 @@ type LogRecordCodec interface {
 	EncodeLogRecordSize(lr *LogRecord) int
 	// DecodeLogRecord 从io流反序列化LogRecord
 	DecodeLogRecord(offset int64) (*LogRecord, int, error)
+	// DecodeLogRecordAt 已知记录编码后的长度时，一次读出整条记录并反序列化
+	DecodeLogRecordAt(offset int64, size uint32) (*LogRecord, error)
 	// Sync 刷盘
 	Sync() error
 	// Close 关闭流
@@ -3,6 +3,8 @@ package data
 import (
 	"bitcask-go/fio"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"path"
 )
 
@@ -11,36 +13,55 @@ const DataFileFormat = "%09d%s"
 
 // DataFile 数据日志文件实例
 type DataFile struct {
-	FileId   uint32         // 文件编号
-	WriteOff uint64         // 已经写入的数据长度
-	codec    LogRecordCodec // 编解码器，内部隐藏了文件操作细节
+	FileId       uint32         // 文件编号
+	WriteOff     uint64         // 已经写入的数据长度
+	Preallocated uint64         // 已经预分配的磁盘空间
+	codec        LogRecordCodec // 编解码器，内部隐藏了文件操作细节
+	ioManager    fio.IOManager  // 文件IO，用于截断等不经过编解码器的操作
 }
 
-// OpenDataFile 打开数据文件，封装dataFile对象
-func OpenDataFile(dirPath string, fid uint32) (*DataFile, error) {
+// OpenDataFile 通过 fs 打开数据文件，封装dataFile对象
+func OpenDataFile(fs fio.VFS, dirPath string, fid uint32) (*DataFile, error) {
 
-	fileName := path.Join(dirPath, fmt.Sprintf(DataFileFormat, fid, DataFileSubffix))
+	ioManager, err := fs.OpenFile(DataFileName(dirPath, fid))
+	if err != nil {
+		return nil, err
+	}
+	return newDataFile(fid, ioManager), nil
+}
 
-	ioManager, err := fio.NewIOManager(fileName)
+// OpenDataFileReadOnly 以只读方式打开已经存在的数据文件，不会创建文件
+func OpenDataFileReadOnly(fs fio.VFS, dirPath string, fid uint32) (*DataFile, error) {
+	ioManager, err := fs.OpenReadOnly(DataFileName(dirPath, fid))
 	if err != nil {
 		return nil, err
 	}
+	return newDataFile(fid, ioManager), nil
+}
 
-	dataFile := &DataFile{
-		FileId: fid,
-		codec:  NewLogRecordCodec(ioManager),
+func newDataFile(fid uint32, ioManager fio.IOManager) *DataFile {
+	return &DataFile{
+		FileId:    fid,
+		codec:     NewLogRecordCodec(ioManager),
+		ioManager: ioManager,
 	}
-	return dataFile, nil
 }
 
 func (file *DataFile) EncodeLogRecordSize(logRecord *LogRecord) int {
 	return file.codec.EncodeLogRecordSize(logRecord)
 }
 
+// DataFileName 获取数据文件的完整路径
+func DataFileName(dirPath string, fid uint32) string {
+	return path.Join(dirPath, fmt.Sprintf(DataFileFormat, fid, DataFileSubffix))
+}
+
 // WriteLogRecord  往文件中写入数据
 func (file *DataFile) WriteLogRecord(logRecord *LogRecord) (int, error) {
 	size, err := file.codec.EncodeLogRecord(logRecord)
 	if err != nil {
+		// 可能只写入了部分数据，截断到写入前的位置，保证后续写入的偏移量正确
+		_ = file.ioManager.Truncate(int64(file.WriteOff))
 		return 0, err
 	}
 	// 更新文件偏移量
@@ -53,10 +74,225 @@ func (file *DataFile) Sync() error {
 	return file.codec.Sync()
 }
 
+// Flush 将写缓冲区中的数据写入文件，不保证刷盘
+func (file *DataFile) Flush() error {
+	if flusher, ok := file.ioManager.(fio.Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
 func (file *DataFile) ReadLogRecord(offset int64) (*LogRecord, int, error) {
 	return file.codec.DecodeLogRecord(offset)
 }
 
+// ReadLogRecordAt 读取 offset 处编码后长度为 size 的记录，只需要一次读取
+func (file *DataFile) ReadLogRecordAt(offset int64, size uint32) (*LogRecord, error) {
+	return file.codec.DecodeLogRecordAt(offset, size)
+}
+
+// Scan 从文件开头顺序遍历所有记录，fn 返回 false 时终止遍历。
+// 正常读完返回 nil，文件末尾的记录不完整时返回 io.ErrUnexpectedEOF，
+// 记录损坏或者全零的区域之后还有数据时返回 ErrInvalidCRC
+func (file *DataFile) Scan(fn func(logRecord *LogRecord, pos *LogRecordPos) bool) error {
+	size, err := file.ioManager.Size()
+	if err != nil {
+		return err
+	}
+	reader := NewRecordReaderSize(io.NewSectionReader(file, 0, size), size)
+	for {
+		logRecord, offset, recordSize, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		pos := &LogRecordPos{Fid: file.FileId, Offset: uint64(offset), Size: uint32(recordSize)}
+		if !fn(logRecord, pos) {
+			return nil
+		}
+	}
+}
+
+// IsZeroTail 判断 offset 之后的数据是否全部为零，即文件末尾预分配的空白空间
+func (file *DataFile) IsZeroTail(offset uint64) (bool, error) {
+	size, err := file.Size()
+	if err != nil {
+		return false, err
+	}
+	buf := make([]byte, 4096)
+	for offset < size {
+		n := uint64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		if _, err := file.ReadAt(buf[:n], int64(offset)); err != nil {
+			return false, err
+		}
+		if !isZero(buf[:n]) {
+			return false, nil
+		}
+		offset += n
+	}
+	return true, nil
+}
+
+// 查找有效记录时每次读取的窗口大小
+const recoveryWindow = 64 * 1024
+
+// NextValidRecord 查找从 offset 开始、end 之前的下一条有效记录的位置，找不到时返回 end。
+// 按窗口读取数据，在内存中逐个位置解析头部并校验 CRC，记录超出窗口时才单独读取。
+// 随机数据恰好通过 CRC 校验的概率可以忽略
+func (file *DataFile) NextValidRecord(offset, end uint64) (uint64, error) {
+	size, err := file.Size()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, recoveryWindow)
+	for offset < end {
+		n := uint64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		if _, err := file.ReadAt(buf[:n], int64(offset)); err != nil && err != io.EOF {
+			return 0, err
+		}
+		// 窗口末尾不足一个头部的位置留给下一个窗口，读到文件末尾时除外
+		limit := n
+		if offset+n < size {
+			limit = n - LogRecordHeaderMaxSize
+		}
+		if offset+limit > end {
+			limit = end - offset
+		}
+		for i := uint64(0); i < limit; i++ {
+			valid, err := file.validRecordAt(buf[i:n], offset+i)
+			if err != nil {
+				return 0, err
+			}
+			if valid {
+				return offset + i, nil
+			}
+		}
+		offset += limit
+	}
+	return end, nil
+}
+
+// 判断 offset 处是否是一条有效记录，buf 为从 offset 开始读出的数据
+func (file *DataFile) validRecordAt(buf []byte, offset uint64) (bool, error) {
+	headerBuf := buf
+	if len(headerBuf) > LogRecordHeaderMaxSize {
+		headerBuf = headerBuf[:LogRecordHeaderMaxSize]
+	}
+	header, index, err := decodeLogRecordHeader(headerBuf)
+	if err != nil {
+		return false, nil
+	}
+	size := uint64(index) + uint64(header.keySize) + uint64(header.valueSize)
+	if size <= uint64(len(buf)) {
+		return crc32.ChecksumIEEE(buf[4:size]) == header.crc, nil
+	}
+	_, _, err = file.ReadLogRecord(int64(offset))
+	switch err {
+	case nil:
+		return true, nil
+	case io.EOF, io.ErrUnexpectedEOF, ErrInvalidCRC:
+		return false, nil
+	}
+	return false, err
+}
+
+// IsTornTail 判断 offset 处解码失败的记录之后是否还有有效记录，没有时说明是崩溃时没有写完的最后一条记录。
+// 末尾预分配的空间全部为零，只需要查找到最后一个非零字节为止
+func (file *DataFile) IsTornTail(offset uint64) (bool, error) {
+	end, err := file.nonZeroEnd(offset)
+	if err != nil {
+		return false, err
+	}
+	next, err := file.NextValidRecord(offset+1, end)
+	if err != nil {
+		return false, err
+	}
+	return next >= end, nil
+}
+
+// 返回 offset 之后最后一个非零字节之后的位置，全部为零时返回 offset
+func (file *DataFile) nonZeroEnd(offset uint64) (uint64, error) {
+	size, err := file.Size()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 4096)
+	for size > offset {
+		n := uint64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		if _, err := file.ReadAt(buf[:n], int64(size-n)); err != nil {
+			return 0, err
+		}
+		for i := int(n) - 1; i >= 0; i-- {
+			if buf[i] != 0 {
+				return size - n + uint64(i) + 1, nil
+			}
+		}
+		size -= n
+	}
+	return offset, nil
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadAt 读取文件 off 处的原始数据，实现 io.ReaderAt
+func (file *DataFile) ReadAt(b []byte, off int64) (int, error) {
+	return file.ioManager.Read(b, off)
+}
+
+// Preallocate 为文件预分配 size 字节的磁盘空间，不改变文件长度
+func (file *DataFile) Preallocate(size uint64) error {
+	if size <= file.Preallocated {
+		return nil
+	}
+	if preallocator, ok := file.ioManager.(fio.Preallocator); ok {
+		if err := preallocator.Preallocate(int64(size)); err != nil {
+			return err
+		}
+	}
+	file.Preallocated = size
+	return nil
+}
+
+// Size 获取文件的实际长度
+func (file *DataFile) Size() (uint64, error) {
+	size, err := file.ioManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(size), nil
+}
+
+// Truncate 截断文件，丢弃 size 之后的数据
+func (file *DataFile) Truncate(size uint64) error {
+	if err := file.ioManager.Truncate(int64(size)); err != nil {
+		return err
+	}
+	file.WriteOff = size
+	// 截断会丢弃预分配的空间
+	if file.Preallocated > size {
+		file.Preallocated = size
+	}
+	return nil
+}
+
 func (file *DataFile) Close() error {
 	return file.codec.Close()
 }
@@ -1,18 +1,20 @@
 package data
 
 import (
+	"bitcask-go/fio"
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile, err := OpenDataFile("./", 1)
+	dataFile, err := OpenDataFile(fio.DefaultVFS, "./", 1)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 }
 
 func TestDataFile_EncodeLogRecordSize(t *testing.T) {
-	dataFile, err := OpenDataFile("./", 1)
+	dataFile, err := OpenDataFile(fio.DefaultVFS, "./", 1)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -27,7 +29,7 @@ func TestDataFile_EncodeLogRecordSize(t *testing.T) {
 }
 
 func TestDataFile_WriteLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile("./", 1)
+	dataFile, err := OpenDataFile(fio.DefaultVFS, "./", 1)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -44,7 +46,7 @@ func TestDataFile_WriteLogRecord(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile("./", 1)
+	dataFile, err := OpenDataFile(fio.DefaultVFS, "./", 1)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -61,3 +63,134 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, logRecord.Key, readLogRecord.Key)
 	assert.Equal(t, logRecord.Value, readLogRecord.Value)
 }
+
+// 统计读取和获取文件长度的次数
+type countingIO struct {
+	fio.IOManager
+	reads int
+	sizes int
+}
+
+func (c *countingIO) Read(bytes []byte, off int64) (int, error) {
+	c.reads++
+	return c.IOManager.Read(bytes, off)
+}
+
+func (c *countingIO) Size() (int64, error) {
+	c.sizes++
+	return c.IOManager.Size()
+}
+
+func TestDataFile_ReadLogRecordAt(t *testing.T) {
+	ioManager, err := fio.NewMemFS().OpenFile("/000000001.data")
+	assert.Nil(t, err)
+	counter := &countingIO{IOManager: ioManager}
+	dataFile := &DataFile{FileId: 1, codec: NewLogRecordCodec(counter), ioManager: counter}
+
+	logRecord1 := &LogRecord{Key: []byte("hello"), Value: []byte("world"), Type: LogRecordNormal}
+	size1, err := dataFile.WriteLogRecord(logRecord1)
+	assert.Nil(t, err)
+	logRecord2 := &LogRecord{Key: []byte("hello"), Type: LogRecordDelete}
+	size2, err := dataFile.WriteLogRecord(logRecord2)
+	assert.Nil(t, err)
+
+	// 只读取一次，不获取文件长度
+	counter.reads, counter.sizes = 0, 0
+	readLogRecord, err := dataFile.ReadLogRecordAt(0, uint32(size1))
+	assert.Nil(t, err)
+	assert.Equal(t, logRecord1, readLogRecord)
+	assert.Equal(t, 1, counter.reads)
+	assert.Equal(t, 0, counter.sizes)
+
+	readLogRecord, err = dataFile.ReadLogRecordAt(int64(size1), uint32(size2))
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordDelete, readLogRecord.Type)
+	assert.Equal(t, 0, len(readLogRecord.Value))
+
+	// 长度与头部不一致
+	_, err = dataFile.ReadLogRecordAt(0, uint32(size1-1))
+	assert.Equal(t, ErrInvalidCRC, err)
+	_, err = dataFile.ReadLogRecordAt(0, uint32(size1+size2))
+	assert.Equal(t, ErrInvalidCRC, err)
+	// 超出文件末尾
+	_, err = dataFile.ReadLogRecordAt(int64(size1), uint32(size2+1))
+	assert.NotNil(t, err)
+}
+
+func TestDataFile_ReadLogRecordAtCorrupted(t *testing.T) {
+	ioManager, err := fio.NewMemFS().OpenFile("/000000001.data")
+	assert.Nil(t, err)
+	dataFile := &DataFile{FileId: 1, codec: NewLogRecordCodec(ioManager), ioManager: ioManager}
+	size, err := dataFile.WriteLogRecord(&LogRecord{Key: []byte("hello"), Value: []byte("world"), Type: LogRecordNormal})
+	assert.Nil(t, err)
+
+	// 修改 value 的最后一个字节
+	buf := make([]byte, size)
+	_, err = ioManager.Read(buf, 0)
+	assert.Nil(t, err)
+	buf[size-1] ^= 0xff
+	assert.Nil(t, ioManager.Truncate(0))
+	_, err = ioManager.Write(buf)
+	assert.Nil(t, err)
+
+	_, err = dataFile.ReadLogRecordAt(0, uint32(size))
+	assert.Equal(t, ErrInvalidCRC, err)
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
+
+func TestDataFile_NextValidRecord(t *testing.T) {
+	ioManager, err := fio.NewMemFS().OpenFile("/000000001.data")
+	assert.Nil(t, err)
+	counting := &countingIO{IOManager: ioManager}
+	dataFile := &DataFile{FileId: 1, codec: NewLogRecordCodec(counting), ioManager: counting}
+
+	// 损坏的数据跨过一个窗口，之后的记录比窗口还大
+	garbage := uint64(recoveryWindow + 7)
+	_, err = ioManager.Write(bytes.Repeat([]byte{0xff}, int(garbage)))
+	assert.Nil(t, err)
+	var offsets []uint64
+	offset := garbage
+	for _, value := range [][]byte{[]byte("a"), make([]byte, 3*recoveryWindow), []byte("c")} {
+		offsets = append(offsets, offset)
+		size, err := dataFile.WriteLogRecord(&LogRecord{Key: []byte("key"), Value: value, Type: LogRecordNormal})
+		assert.Nil(t, err)
+		offset += uint64(size)
+	}
+	size, err := dataFile.Size()
+	assert.Nil(t, err)
+
+	// 按窗口读取，不会每个位置读取一次
+	counting.reads = 0
+	next, err := dataFile.NextValidRecord(0, size)
+	assert.Nil(t, err)
+	assert.Equal(t, offsets[0], next)
+	assert.True(t, counting.reads < 10, counting.reads)
+
+	for i := 1; i < len(offsets); i++ {
+		next, err = dataFile.NextValidRecord(offsets[i-1]+1, size)
+		assert.Nil(t, err)
+		assert.Equal(t, offsets[i], next)
+	}
+	next, err = dataFile.NextValidRecord(offsets[2]+1, size)
+	assert.Nil(t, err)
+	assert.Equal(t, size, next)
+	// 只查找 end 之前开始的记录
+	next, err = dataFile.NextValidRecord(0, offsets[0])
+	assert.Nil(t, err)
+	assert.Equal(t, offsets[0], next)
+
+	// 最后一条记录没有写完，之后是预分配的空间
+	assert.False(t, mustTornTail(t, dataFile, 0))
+	assert.Nil(t, ioManager.Truncate(int64(size-2)))
+	_, err = ioManager.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.True(t, mustTornTail(t, dataFile, offsets[2]))
+	assert.False(t, mustTornTail(t, dataFile, offsets[0]))
+}
+
+func mustTornTail(t *testing.T, dataFile *DataFile, offset uint64) bool {
+	torn, err := dataFile.IsTornTail(offset)
+	assert.Nil(t, err)
+	return torn
+}
@@ -13,6 +13,8 @@ type LogRecordPos struct {
 	Fid uint32
 	// Offset 在文件中的偏移量
 	Offset uint64
+	// Size 记录编码后的长度，读取时一次读出整条记录，为 0 表示长度未知
+	Size uint32
 }
 
 // LogRecord 存储在文件中的数据日志记录
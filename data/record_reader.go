@@ -0,0 +1,123 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"hash/crc32"
+	"io"
+)
+
+// 不知道流的长度时，超过该长度的 key 和 value 随着数据的到达逐步分配内存，
+// 避免损坏的头部声明一个很大的长度时直接分配大块内存
+const recordChunkSize = 64 * 1024
+
+// RecordReader 从任意 io.Reader 中顺序读取日志记录，不需要随机读取，可以用于管道和网络流
+type RecordReader struct {
+	reader *bufio.Reader
+	offset int64 // 下一条记录在流中的偏移量
+	size   int64 // 流的总长度，小于 0 表示未知
+}
+
+// NewRecordReader 创建顺序读取器，r 的起始位置必须是一条记录的开头
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{reader: bufio.NewReader(r), size: -1}
+}
+
+// NewRecordReaderSize 创建顺序读取器，r 中只有 size 个字节，
+// 头部声明的长度超出剩余的数据时直接返回 io.ErrUnexpectedEOF，不分配内存
+func NewRecordReaderSize(r io.Reader, size int64) *RecordReader {
+	return &RecordReader{reader: bufio.NewReader(r), size: size}
+}
+
+// Next 读取下一条记录，返回记录、记录的偏移量和编码后的长度。
+// 数据正常读完或者之后只有全零的数据时返回 io.EOF，末尾的记录不完整时返回 io.ErrUnexpectedEOF，
+// 记录损坏时返回 ErrInvalidCRC。返回的记录不会被之后的读取复用
+func (r *RecordReader) Next() (*LogRecord, int64, int, error) {
+	offset := r.offset
+	headerBuf, err := r.reader.Peek(LogRecordHeaderMaxSize)
+	if err != nil && err != io.EOF {
+		return nil, offset, 0, err
+	}
+	if len(headerBuf) == 0 {
+		return nil, offset, 0, io.EOF
+	}
+	// 全零的头部是预分配的空白空间，之后的数据全部为零时同样视为正常结束，
+	// 否则是文件中间的数据被清零，不能丢弃之后的记录
+	header, index, err := decodeLogRecordHeader(headerBuf)
+	if err == io.EOF {
+		return nil, offset, 0, r.zeroTail()
+	}
+	if err != nil {
+		return nil, offset, 0, err
+	}
+	crc := crc32.ChecksumIEEE(headerBuf[4:index])
+	if _, err = r.reader.Discard(index); err != nil {
+		return nil, offset, 0, err
+	}
+
+	keySize := int(header.keySize)
+	kv, err := r.readPayload(offset+int64(index), int64(keySize)+int64(header.valueSize))
+	if err != nil {
+		return nil, offset, 0, err
+	}
+	if crc32.Update(crc, crc32.IEEETable, kv) != header.crc {
+		return nil, offset, 0, ErrInvalidCRC
+	}
+
+	size := index + len(kv)
+	r.offset += int64(size)
+	return &LogRecord{
+		Type:  header.logRecordType,
+		Key:   kv[:keySize:keySize],
+		Value: kv[keySize:],
+	}, offset, size, nil
+}
+
+// 读取从 start 开始的 n 个字节的 key 和 value
+func (r *RecordReader) readPayload(start int64, n int64) ([]byte, error) {
+	if r.size >= 0 && n > r.size-start {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if r.size >= 0 || n <= recordChunkSize {
+		kv := make([]byte, n)
+		if _, err := io.ReadFull(r.reader, kv); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return kv, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(recordChunkSize)
+	copied, err := io.CopyN(&buf, r.reader, n)
+	if copied < n {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 读完剩余的数据，全部为零时返回 io.EOF，否则返回 ErrInvalidCRC
+func (r *RecordReader) zeroTail() error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.reader.Read(buf)
+		if !isZero(buf[:n]) {
+			return ErrInvalidCRC
+		}
+		if err == io.EOF {
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Offset 下一条记录在流中的偏移量，读取出错时为出错记录的偏移量
+func (r *RecordReader) Offset() int64 {
+	return r.offset
+}
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// bufferIO 将写入的数据保存在内存中
+type bufferIO struct {
+	fio.IOManager
+	buf bytes.Buffer
+}
+
+func (b *bufferIO) Write(bytes []byte) (int, error) {
+	return b.buf.Write(bytes)
+}
+
+func encodeTestRecords(t *testing.T, n int) ([]byte, []*LogRecord) {
+	bufIO := &bufferIO{}
+	codec := NewLogRecordCodec(bufIO)
+	var records []*LogRecord
+	for i := 0; i < n; i++ {
+		record := &LogRecord{Key: utils.GetTestKey(i), Value: utils.RandomValue(i * 10), Type: LogRecordNormal}
+		if i%5 == 4 {
+			record.Type = LogRecordDelete
+			record.Value = nil
+		}
+		_, err := codec.EncodeLogRecord(record)
+		assert.Nil(t, err)
+		records = append(records, record)
+	}
+	return bufIO.buf.Bytes(), records
+}
+
+func TestRecordReader_Next(t *testing.T) {
+	encoded, records := encodeTestRecords(t, 100)
+
+	// 通过管道读取，读取方不知道数据的总长度
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < len(encoded); i += 7 {
+			end := i + 7
+			if end > len(encoded) {
+				end = len(encoded)
+			}
+			_, _ = pw.Write(encoded[i:end])
+		}
+		_ = pw.Close()
+	}()
+
+	reader := NewRecordReader(pr)
+	var offset int64
+	for _, expected := range records {
+		record, recordOffset, size, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, offset, recordOffset)
+		assert.Equal(t, expected.Key, record.Key)
+		assert.Equal(t, len(expected.Value), len(record.Value))
+		assert.Equal(t, expected.Type, record.Type)
+		offset += int64(size)
+	}
+	_, _, _, err := reader.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, int64(len(encoded)), reader.Offset())
+}
+
+func TestRecordReader_TruncatedTail(t *testing.T) {
+	encoded, _ := encodeTestRecords(t, 3)
+	lastSize := 5 + 1 + 1 + len(utils.GetTestKey(2)) + 20
+	lastOffset := int64(len(encoded) - lastSize)
+
+	// 截断在头部或数据中间都返回 io.ErrUnexpectedEOF
+	for _, cut := range []int{1, 3, lastSize - 1} {
+		reader := NewRecordReader(bytes.NewReader(encoded[:len(encoded)-cut]))
+		var err error
+		for err == nil {
+			_, _, _, err = reader.Next()
+		}
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+		assert.Equal(t, lastOffset, reader.Offset())
+	}
+
+	// 记录损坏
+	corrupted := append([]byte{}, encoded...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	reader := NewRecordReader(bytes.NewReader(corrupted))
+	var err error
+	for err == nil {
+		_, _, _, err = reader.Next()
+	}
+	assert.Equal(t, ErrInvalidCRC, err)
+
+	// 末尾预分配的空白空间视为正常结束
+	padded := append(append([]byte{}, encoded...), make([]byte, 64)...)
+	reader = NewRecordReader(bytes.NewReader(padded))
+	n := 0
+	for err = nil; err == nil; n++ {
+		_, _, _, err = reader.Next()
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 4, n)
+
+	// 中间一段数据被清零，之后还有记录，不能视为正常结束
+	zeroed := append(append(append([]byte{}, encoded...), make([]byte, 64)...), encoded...)
+	reader = NewRecordReader(bytes.NewReader(zeroed))
+	for err = nil; err == nil; {
+		_, _, _, err = reader.Next()
+	}
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Equal(t, int64(len(encoded)), reader.Offset())
+}
+
+func TestRecordReader_HugeHeader(t *testing.T) {
+	// 头部声明 1GB 的 value，之后只有几个字节
+	huge := []byte{1, 2, 3, 4, byte(LogRecordNormal)}
+	huge = appendVarint(huge, 1)
+	huge = appendVarint(huge, 1<<30)
+	huge = append(huge, "kv"...)
+
+	allocated := func(fn func()) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		fn()
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	// 知道长度时不分配内存，长度未知时随着数据的到达分配
+	for _, reader := range []*RecordReader{
+		NewRecordReaderSize(bytes.NewReader(huge), int64(len(huge))),
+		NewRecordReader(bytes.NewReader(huge)),
+	} {
+		var err error
+		assert.True(t, allocated(func() { _, _, _, err = reader.Next() }) < 1024*1024)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	}
+
+	// 数据完整时长度超过分块大小的记录仍然可以读出
+	encoded := appendVarint(appendVarint([]byte{0, 0, 0, 0, byte(LogRecordNormal)}, 1), 3*recordChunkSize)
+	encoded = append(encoded, 'k')
+	encoded = append(encoded, make([]byte, 3*recordChunkSize)...)
+	binary.LittleEndian.PutUint32(encoded, crc32.ChecksumIEEE(encoded[4:]))
+	record, _, size, err := NewRecordReader(bytes.NewReader(encoded)).Next()
+	assert.Nil(t, err)
+	assert.Equal(t, len(encoded), size)
+	assert.Equal(t, []byte("k"), record.Key)
+	assert.Equal(t, 3*recordChunkSize, len(record.Value))
+}
+
+func TestDataFile_Scan(t *testing.T) {
+	fs := fio.NewMemFS()
+	dataFile, err := OpenDataFile(fs, "/", 1)
+	assert.Nil(t, err)
+	for i := 0; i < 50; i++ {
+		_, err := dataFile.WriteLogRecord(&LogRecord{Key: utils.GetTestKey(i), Value: utils.RandomValue(64), Type: LogRecordNormal})
+		assert.Nil(t, err)
+	}
+
+	i := 0
+	err = dataFile.Scan(func(logRecord *LogRecord, pos *LogRecordPos) bool {
+		assert.Equal(t, utils.GetTestKey(i), logRecord.Key)
+		readRecord, err := dataFile.ReadLogRecordAt(int64(pos.Offset), pos.Size)
+		assert.Nil(t, err)
+		assert.Equal(t, logRecord, readRecord)
+		assert.Equal(t, uint32(1), pos.Fid)
+		i++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 50, i)
+
+	// fn 返回 false 时终止
+	i = 0
+	err = dataFile.Scan(func(logRecord *LogRecord, pos *LogRecordPos) bool {
+		i++
+		return i < 10
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, i)
+
+	// 末尾记录不完整
+	assert.Nil(t, dataFile.Truncate(dataFile.WriteOff-3))
+	i = 0
+	err = dataFile.Scan(func(logRecord *LogRecord, pos *LogRecordPos) bool {
+		i++
+		return true
+	})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 49, i)
+	assert.Nil(t, dataFile.Close())
+}
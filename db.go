@@ -1,12 +1,15 @@
 package bitcask_go
 
 import (
+	"bitcask-go/cache"
 	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bytes"
 	"io"
-	"io/ioutil"
-	"os"
+	"path"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,8 +22,16 @@ type DB struct {
 	activityDataFile *data.DataFile            // 当前活跃的数据文件，可读写
 	oldDataFiles     map[uint32]*data.DataFile // 旧的数据文件，只读
 	options          *Options                  // 用户配置选项
-	index            index.Indexer             // 内存索引
+	index            index.Indexer             // 索引
 	fids             []int                     // 保存db数据文件序号的数组，有序
+	fs               fio.VFS                   // 文件系统，所有文件操作都通过它完成
+	fileLock         io.Closer                 // 数据目录锁，保证同一时刻只有一个实例使用该目录
+	cache            *cache.Cache              // value 缓存，未启用时为 nil
+	merging          bool                      // 是否正在合并
+	mergeEnd         LogPosition               // 最近一次合并开始时的日志结束位置，之前的文件已经被重写
+	generation       uint32                    // 当前的合并代数，和 mergeEnd 一起更新，原子读取
+	logRetention     func() []LogPosition      // 返回合并时需要保留的日志位置，为空时不限制
+	logWaiter        chan struct{}             // 等待新记录的调用方，有新记录写入时关闭
 }
 
 func Start(options *Options) (*DB, error) {
@@ -29,11 +40,12 @@ func Start(options *Options) (*DB, error) {
 		return nil, err
 	}
 
-	// 校验数据目录是否存在，不存在则创建
-	if _, err := os.Stat(options.DBFileDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(options.DBFileDir, os.ModeDir); err != nil {
-			return nil, err
-		}
+	fs := options.FS
+	if fs == nil {
+		fs = fio.DefaultVFS
+	}
+	if options.WriteBufferSize > 0 {
+		fs = fio.NewBufferedFS(fs, options.WriteBufferSize)
 	}
 
 	// 创建db
@@ -41,16 +53,57 @@ func Start(options *Options) (*DB, error) {
 		oldDataFiles: make(map[uint32]*data.DataFile),
 		options:      options,
 		mu:           new(sync.RWMutex),
-		index:        index.NewIndexer(options.DBIndex),
+		fs:           fs,
+	}
+	if options.CacheSize > 0 {
+		db.cache = cache.New(options.CacheSize)
+	}
+
+	// 只读模式不创建数据目录，也不锁定数据目录，可以和正在写入的实例同时打开
+	if !options.ReadOnly {
+		// 数据目录不存在则创建
+		if err := fs.MkdirAll(options.DBFileDir); err != nil {
+			return nil, err
+		}
+
+		// 锁定数据目录，防止多个实例同时写入
+		fileLock, err := fs.Lock(path.Join(options.DBFileDir, fio.FileLockName))
+		if err != nil {
+			if err == fio.ErrFileLocked {
+				return nil, ErrDatabaseIsUsing
+			}
+			return nil, err
+		}
+		db.fileLock = fileLock
+	}
+
+	// 处理上次未完成的合并
+	if err := db.recoverMerge(); err != nil {
+		_ = db.closeFiles()
+		return nil, err
+	}
+
+	// 读取最近一次合并的位置
+	if err := db.loadMergeEnd(); err != nil {
+		_ = db.closeFiles()
+		return nil, err
 	}
 
 	// 加载数据文件
 	if err := db.loadDataFiles(); err != nil {
+		_ = db.closeFiles()
+		return nil, err
+	}
+
+	// 创建索引
+	if err := db.openIndex(); err != nil {
+		_ = db.closeFiles()
 		return nil, err
 	}
 
 	// 加载内存索引
 	if err := db.loadIndexFromDataFiles(); err != nil {
+		_ = db.closeFiles()
 		return nil, err
 	}
 
@@ -65,8 +118,22 @@ func (db *DB) Put(key []byte, value []byte) error {
 		return ErrKeyIsNilOrEmpty
 	}
 
+	if db.options.ReadOnly {
+		return ErrDBReadOnly
+	}
+
 	// 这里不需要判断key是否存在,如果put已存在的key,相当于更新数据
 
+	// 写入数据和更新索引在同一把锁内完成，持久化索引提交检查点时两者一致
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, err := db.putLocked(key, value)
+	return err
+}
+
+// 写入数据并更新索引，返回新记录的位置
+// 该方法必须在加锁的条件下调用
+func (db *DB) putLocked(key []byte, value []byte) (*data.LogRecordPos, error) {
 	logRecord := &data.LogRecord{
 		Key:   key,
 		Value: value,
@@ -76,21 +143,33 @@ func (db *DB) Put(key []byte, value []byte) error {
 	// 写入数据
 	recordPos, err := db.appendLogRecord(logRecord)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// 旧的记录不会再被读取，移除它的缓存
+	if db.cache != nil {
+		if oldPos := db.index.Get(key); oldPos != nil {
+			db.cache.Remove(oldPos)
+		}
 	}
 
 	// 更新内存索引下标
 	if ok := db.index.Put(key, recordPos); !ok {
-		return ErrDBAppendFailed
+		return nil, ErrDBAppendFailed
 	}
-	return nil
+	return recordPos, nil
 }
 
-// 追加日志记录
+// 追加日志记录，按照刷盘策略刷盘
+// 该方法必须在加锁的条件下调用
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	return db.writeLogRecord(logRecord, db.options.DBSync == Always)
+}
+
+// 追加日志记录，sync 为 true 时写入后立即刷盘
+// 该方法必须在加锁的条件下调用
+func (db *DB) writeLogRecord(logRecord *data.LogRecord, sync bool) (*data.LogRecordPos, error) {
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
 	// 初始化活跃文件
 	if db.activityDataFile == nil {
 		if err := db.setActivityDataFile(); err != nil {
@@ -103,19 +182,14 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 
 	// 判断当前活跃文件是否达到阈值,达到阈值需要打开新的活跃文件
 	if db.activityDataFile.WriteOff+uint64(size) >= db.options.FileMaxSize {
-
-		// 持久化数据文件
-		if err := db.activityDataFile.Sync(); err != nil {
+		if err := db.rotateActivityDataFile(); err != nil {
 			return nil, err
 		}
+	}
 
-		// 保存当前活跃文件到旧文件中
-		db.oldDataFiles[db.activityDataFile.FileId] = db.activityDataFile
-
-		// 更新活跃文件
-		if err := db.setActivityDataFile(); err != nil {
-			return nil, err
-		}
+	// 写入位置超出了预分配的空间，继续预分配
+	if err := db.preallocateActivityDataFile(db.activityDataFile.WriteOff + uint64(size)); err != nil {
+		return nil, err
 	}
 
 	// 写入数据
@@ -123,20 +197,45 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		return nil, err
 	}
 
-	// 每次写入数据立马刷盘
-	if db.options.DBSync == Always {
+	// 写入数据后立马刷盘
+	if sync {
 		if err := db.activityDataFile.Sync(); err != nil {
+			// 刷盘失败，回滚本次写入，保证文件内容与内存索引一致
+			_ = db.activityDataFile.Truncate(db.activityDataFile.WriteOff - uint64(size))
 			return nil, err
 		}
 	}
 
+	db.notifyLog()
 	return &data.LogRecordPos{
-		db.activityDataFile.FileId,
-		db.activityDataFile.WriteOff - uint64(size),
+		Fid:    db.activityDataFile.FileId,
+		Offset: db.activityDataFile.WriteOff - uint64(size),
+		Size:   uint32(size),
 	}, nil
 
 }
 
+// 当前活跃文件刷盘后转为旧文件，并打开新的活跃文件
+// 该方法必须在加锁的条件下调用
+func (db *DB) rotateActivityDataFile() error {
+
+	// 持久化数据文件
+	if err := db.activityDataFile.Sync(); err != nil {
+		return err
+	}
+
+	// 保存当前活跃文件到旧文件中
+	db.oldDataFiles[db.activityDataFile.FileId] = db.activityDataFile
+
+	// 更新活跃文件
+	if err := db.setActivityDataFile(); err != nil {
+		return err
+	}
+
+	// 旧文件已经刷盘，提交持久化索引的检查点
+	return db.commitIndex()
+}
+
 // 初始化活跃文件或打新的活跃文件
 // 该方法必须在加锁的条件下调用
 func (db *DB) setActivityDataFile() error {
@@ -145,12 +244,29 @@ func (db *DB) setActivityDataFile() error {
 	if db.activityDataFile != nil {
 		initailFid = db.activityDataFile.FileId + 1
 	}
-	dataFile, err := data.OpenDataFile(db.options.DBFileDir, initailFid)
+	dataFile, err := data.OpenDataFile(db.fs, db.options.DBFileDir, initailFid)
 	if err != nil {
 		return err
 	}
 	db.activityDataFile = dataFile
-	return nil
+	return db.preallocateActivityDataFile(0)
+}
+
+// 为活跃文件按块预分配磁盘空间，保证 end 之前的空间都已经分配，减少文件碎片
+// 该方法必须在加锁的条件下调用
+func (db *DB) preallocateActivityDataFile(end uint64) error {
+	chunk := db.options.PreallocateSize
+	if chunk == 0 || (end < db.activityDataFile.Preallocated) {
+		return nil
+	}
+	size := (end/chunk + 1) * chunk
+	if size > db.options.FileMaxSize {
+		size = db.options.FileMaxSize
+	}
+	if size < end {
+		size = end
+	}
+	return db.activityDataFile.Preallocate(size)
 }
 
 // Get 读数据
@@ -160,25 +276,43 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	if !utils.IsValidKey(key) {
 		return nil, ErrKeyIsNilOrEmpty
 	}
+
+	// 查询索引和读取数据在同一把读锁内完成，合并替换数据文件时不会读到失效的位置
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	value, _, err := db.getLocked(key)
+	return value, err
+}
+
+// 查询索引并读取数据，返回数据和记录的位置
+// 该方法必须在加锁的条件下调用
+func (db *DB) getLocked(key []byte) ([]byte, *data.LogRecordPos, error) {
 	// 查询内存索引
 	logRecordPos := db.index.Get(key)
 	if logRecordPos == nil {
-		return nil, ErrReadKeyNotFound
+		return nil, nil, ErrReadKeyNotFound
+	}
+	// 优先从缓存中读取
+	if db.cache != nil {
+		if value, ok := db.cache.Get(logRecordPos); ok {
+			return value, logRecordPos, nil
+		}
 	}
 	// 文件中查询
 	logRecord, err := db.getLogRecordByPosition(logRecordPos)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return logRecord.Value, nil
+	if db.cache != nil {
+		db.cache.Put(logRecordPos, logRecord.Value)
+	}
+	return logRecord.Value, logRecordPos, nil
 }
 
 // 根据文件索引读数据
-// 该方法类会对db加锁
+// 该方法必须在加锁的条件下调用
 func (db *DB) getLogRecordByPosition(pos *data.LogRecordPos) (*data.LogRecord, error) {
 
-	db.mu.RLock()
-	db.mu.RUnlock()
 	// 查询读数据所在文件
 	belongFile := db.activityDataFile
 	if belongFile == nil || belongFile.FileId != pos.Fid {
@@ -190,7 +324,14 @@ func (db *DB) getLogRecordByPosition(pos *data.LogRecordPos) (*data.LogRecord, e
 		return nil, ErrDataFileNotFound
 	}
 
-	logRecord, _, err := belongFile.ReadLogRecord(int64(pos.Offset))
+	// 索引中保存了记录长度时一次读出整条记录，否则先读头部再读数据
+	var logRecord *data.LogRecord
+	var err error
+	if pos.Size > 0 {
+		logRecord, err = belongFile.ReadLogRecordAt(int64(pos.Offset), pos.Size)
+	} else {
+		logRecord, _, err = belongFile.ReadLogRecord(int64(pos.Offset))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -203,6 +344,7 @@ func (db *DB) getLogRecordByPosition(pos *data.LogRecordPos) (*data.LogRecord, e
 	return logRecord, nil
 }
 
+// 该方法必须在加锁的条件下调用
 func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 	logRecord, err := db.getLogRecordByPosition(pos)
 	if err != nil {
@@ -221,20 +363,35 @@ func checkOptions(options *Options) error {
 	if options.FileMaxSize <= 0 {
 		return ErrDBFileMaxSize
 	}
+
+	if options.WriteBufferSize < 0 {
+		return ErrDBWriteBufferSize
+	}
+
+	if options.IndexShards < 0 {
+		return ErrDBIndexShards
+	}
+
+	if options.CacheSize < 0 {
+		return ErrDBCacheSize
+	}
+
+	if options.LoadConcurrency < 0 {
+		return ErrDBLoadConcurrency
+	}
 	return nil
 }
 
 // 加载数据文件
 func (db *DB) loadDataFiles() error {
-	fileInfos, err := ioutil.ReadDir(db.options.DBFileDir)
+	fileNames, err := db.fs.ReadDir(db.options.DBFileDir)
 	if err != nil {
 		return err
 	}
 
 	// 遍历所有文件，获取DB数据文件的编号
 	fids := make([]int, 0)
-	for _, fileInfo := range fileInfos {
-		fileName := fileInfo.Name()
+	for _, fileName := range fileNames {
 		if strings.HasSuffix(fileName, data.DataFileSubffix) {
 			fid, err := strconv.Atoi(strings.Split(fileName, ".")[0])
 			// 文件损坏
@@ -251,7 +408,12 @@ func (db *DB) loadDataFiles() error {
 	db.fids = fids
 	// 打开所有DB数据文件
 	for i, fid := range fids {
-		dataFile, err := data.OpenDataFile(db.options.DBFileDir, uint32(fid))
+		var dataFile *data.DataFile
+		if db.options.ReadOnly {
+			dataFile, err = data.OpenDataFileReadOnly(db.fs, db.options.DBFileDir, uint32(fid))
+		} else {
+			dataFile, err = data.OpenDataFile(db.fs, db.options.DBFileDir, uint32(fid))
+		}
 		if err != nil {
 			return err
 		}
@@ -264,51 +426,311 @@ func (db *DB) loadDataFiles() error {
 	return nil
 }
 
+// 创建索引，B+树索引保存在数据目录中
+func (db *DB) openIndex() error {
+	// 只读模式不能修改索引文件，B+树索引改为从数据文件重建内存索引
+	if db.options.ReadOnly && db.options.DBIndex == index.BPTree {
+		db.index = index.NewIndexer(index.BTree)
+		return nil
+	}
+	if db.options.DBIndex != index.BPTree {
+		if db.options.IndexShards > 1 {
+			db.index = index.NewShardedIndex(db.options.IndexShards, func() index.Indexer {
+				return index.NewIndexer(db.options.DBIndex)
+			})
+		} else {
+			db.index = index.NewIndexer(db.options.DBIndex)
+		}
+		return nil
+	}
+	bpt, err := index.NewBPlusTree(db.fs, db.options.DBFileDir, db.options.IndexCacheSize)
+	if err != nil {
+		return err
+	}
+	db.index = bpt
+	return nil
+}
+
+// 持久化索引的检查点与数据文件不一致时，删除索引文件，从数据文件重建
+func (db *DB) resetIndex() error {
+	if persistent, ok := db.index.(index.PersistentIndexer); ok {
+		if err := persistent.Close(); err != nil {
+			return err
+		}
+	}
+	db.index = nil
+	if err := db.fs.Remove(path.Join(db.options.DBFileDir, index.BPlusTreeFileName)); err != nil {
+		return err
+	}
+	return db.openIndex()
+}
+
+// 提交持久化索引的检查点，活跃文件写入位置之前的数据必须已经刷盘
+// 该方法必须在加锁的条件下调用
+func (db *DB) commitIndex() error {
+	persistent, ok := db.index.(index.PersistentIndexer)
+	if !ok || db.activityDataFile == nil {
+		return nil
+	}
+	return persistent.Commit(&data.LogRecordPos{
+		Fid:    db.activityDataFile.FileId,
+		Offset: db.activityDataFile.WriteOff,
+	})
+}
+
+// 检查点所在的数据文件存在且长度足够时，检查点才有效
+func (db *DB) checkpointValid(checkpoint *data.LogRecordPos) bool {
+	dataFile := db.oldDataFiles[checkpoint.Fid]
+	if db.activityDataFile != nil && db.activityDataFile.FileId == checkpoint.Fid {
+		dataFile = db.activityDataFile
+	}
+	if dataFile == nil {
+		return false
+	}
+	size, err := dataFile.Size()
+	return err == nil && size >= checkpoint.Offset
+}
+
 // 从数据文件中加载内存索引
+// 持久化索引只需要加载检查点之后的数据
 func (db *DB) loadIndexFromDataFiles() error {
 
+	var checkpoint *data.LogRecordPos
+	if persistent, ok := db.index.(index.PersistentIndexer); ok {
+		checkpoint = persistent.Checkpoint()
+		if checkpoint != nil && !db.checkpointValid(checkpoint) {
+			if err := db.resetIndex(); err != nil {
+				return err
+			}
+			checkpoint = nil
+		}
+	}
+
+	// 检查点之前的数据文件已经全部包含在持久化索引中
+	var dataFiles []*data.DataFile
+	var offsets []uint64
 	for i, fid := range db.fids {
-		var dataFile *data.DataFile
+		var offset uint64 = 0
+		if checkpoint != nil {
+			if uint32(fid) < checkpoint.Fid {
+				continue
+			}
+			if uint32(fid) == checkpoint.Fid {
+				offset = checkpoint.Offset
+			}
+		}
 		if i != len(db.fids)-1 { // 当前是旧数据文件
-			dataFile = db.oldDataFiles[uint32(fid)]
+			dataFiles = append(dataFiles, db.oldDataFiles[uint32(fid)])
 		} else {
-			dataFile = db.activityDataFile
+			dataFiles = append(dataFiles, db.activityDataFile)
 		}
+		offsets = append(offsets, offset)
+	}
+	if len(dataFiles) == 0 {
+		return nil
+	}
 
-		var offset uint64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(int64(offset))
+	concurrency := db.options.LoadConcurrency
+	if concurrency == 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	// 多个数据文件并行解码，解码结果按文件顺序依次更新索引，保证后写入的记录覆盖先写入的记录。
+	// 解码完成但还没有更新到索引的文件最多 concurrency 个，限制缓存的解码结果占用的内存
+	results := make([]chan *loadResult, len(dataFiles))
+	for i := range results {
+		results[i] = make(chan *loadResult, 1)
+	}
+	tokens := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, dataFile := range dataFiles {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, dataFile *data.DataFile) {
+				results[i] <- decodeDataFile(dataFile, offsets[i], i == len(dataFiles)-1)
+			}(i, dataFile)
+		}
+	}()
+
+	for i, dataFile := range dataFiles {
+		result := <-results[i]
+		if result.err != nil {
+			return result.err
+		}
+		// 更新内存索引
+		for _, record := range result.records {
+			if record.typ == data.LogRecordNormal {
+				db.index.Put(record.key, record.pos)
+			} else if record.typ == data.LogRecordDelete {
+				db.index.Delete(record.key)
+			}
+		}
+		<-tokens
+
+		// 如果当前是活跃文件，更新写入偏移量
+		if i == len(dataFiles)-1 {
+			dataFile.WriteOff = result.end
+			// 文件末尾可能残留预分配的空白数据或崩溃时写入的不完整记录，截断后才能从正确的位置追加写入
+			fileSize, err := dataFile.Size()
 			if err != nil {
-				// 读到文件末尾了
-				if err == io.EOF {
-					break
-				}
 				return err
 			}
-
-			logRecordPos := &data.LogRecordPos{
-				Fid:    uint32(fid),
-				Offset: offset,
+			if fileSize > result.end && !db.options.ReadOnly {
+				if err := dataFile.Truncate(result.end); err != nil {
+					return err
+				}
 			}
+		}
+	}
+	return nil
+}
 
-			// 更新内存索引
-			if logRecord.Type == data.LogRecordNormal {
-				db.index.Put(logRecord.Key, logRecordPos)
-			} else if logRecord.Type == data.LogRecordDelete {
-				db.index.Delete(logRecord.Key)
+// loadResult 一个数据文件的解码结果
+type loadResult struct {
+	records []loadRecord
+	end     uint64 // 最后一条有效记录的结束位置
+	err     error
+}
+
+type loadRecord struct {
+	key []byte
+	typ data.LogRecordType
+	pos *data.LogRecordPos
+}
+
+// 从 offset 开始顺序解码数据文件中的记录，只保留更新索引需要的信息
+// 活跃文件末尾的记录不完整或已损坏，并且之后没有有效的记录，说明上次写入时发生了崩溃，
+// 这部分数据从未被确认持久化，解码在此结束，由调用方截断。
+// 之后还有有效记录时是文件中间的数据损坏，截断会丢失已经确认的写入，返回 ErrDataFileDamaged
+func decodeDataFile(dataFile *data.DataFile, offset uint64, active bool) *loadResult {
+	result := &loadResult{}
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(int64(offset))
+		if err != nil {
+			// 旧文件读到全零的头部，之后全部为零时是文件末尾，否则是文件中间的数据被清零
+			if err == io.EOF && !active {
+				zero, err := dataFile.IsZeroTail(offset)
+				if err != nil {
+					result.err = err
+					return result
+				}
+				if !zero {
+					result.err = ErrDataFileDamaged
+					return result
+				}
+				break
+			}
+			if !active || (err != io.EOF && err != io.ErrUnexpectedEOF && err != data.ErrInvalidCRC) {
+				result.err = err
+				return result
 			}
+			torn, err := dataFile.IsTornTail(offset)
+			if err != nil {
+				result.err = err
+				return result
+			}
+			if !torn {
+				result.err = ErrDataFileDamaged
+				return result
+			}
+			break
+		}
+
+		// key 与 value 共用同一块内存，拷贝 key 之后 value 可以被回收
+		result.records = append(result.records, loadRecord{
+			key: append([]byte(nil), logRecord.Key...),
+			typ: logRecord.Type,
+			pos: &data.LogRecordPos{
+				Fid:    dataFile.FileId,
+				Offset: offset,
+				Size:   uint32(size),
+			},
+		})
+
+		// 更新偏移量
+		offset += uint64(size)
+	}
+	result.end = offset
+	return result
+}
+
+// 批量写入数据和删除记录，所有记录写入之后只刷盘一次，然后统一更新索引
+// 写入或刷盘失败时返回错误，索引不更新，已经写入文件的记录全部截断
+func (db *DB) writeBatch(logRecords []*data.LogRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
-			// 更新偏移量
-			offset += uint64(size)
+	// 记录批量开始时的写入位置，失败时回滚
+	startFile := db.activityDataFile
+	var startOff uint64
+	if startFile != nil {
+		startOff = startFile.WriteOff
+	}
+	positions := make([]*data.LogRecordPos, len(logRecords))
+	for i, logRecord := range logRecords {
+		pos, err := db.writeLogRecord(logRecord, false)
+		if err != nil {
+			db.rollbackWrite(startFile, startOff)
+			return err
 		}
-		// 如果当前是活跃文件，更新写入偏移量
-		if fid == len(db.fids) {
-			dataFile.WriteOff = offset
+		positions[i] = pos
+	}
+	// 文件轮转时旧文件已经刷盘，这里只需要刷新活跃文件
+	if db.options.DBSync == Always && db.activityDataFile != nil {
+		if err := db.activityDataFile.Sync(); err != nil {
+			db.rollbackWrite(startFile, startOff)
+			return err
+		}
+	}
+
+	for i, logRecord := range logRecords {
+		if db.cache != nil {
+			if oldPos := db.index.Get(logRecord.Key); oldPos != nil {
+				db.cache.Remove(oldPos)
+			}
+		}
+		if logRecord.Type == data.LogRecordDelete {
+			db.index.Delete(logRecord.Key)
+			continue
+		}
+		if ok := db.index.Put(logRecord.Key, positions[i]); !ok {
+			return ErrIndexUpdateFailed
 		}
 	}
 	return nil
 }
 
+// 回滚写入，删除写入期间轮转产生的数据文件，并把 startFile 截断到 startOff，
+// startFile 为空表示写入之前还没有数据文件。与单条写入的回滚一样，回滚失败时忽略错误
+// 该方法必须在加锁的条件下调用
+func (db *DB) rollbackWrite(startFile *data.DataFile, startOff uint64) {
+	rotated := false
+	for db.activityDataFile != nil && db.activityDataFile != startFile {
+		dataFile := db.activityDataFile
+		_ = dataFile.Close()
+		_ = db.fs.Remove(data.DataFileName(db.options.DBFileDir, dataFile.FileId))
+		// 轮转时文件编号依次加一，上一个文件就是之前的活跃文件
+		db.activityDataFile = db.oldDataFiles[dataFile.FileId-1]
+		delete(db.oldDataFiles, dataFile.FileId-1)
+		rotated = true
+	}
+	if startFile == nil {
+		return
+	}
+	// 轮转在打开新文件时失败，旧文件已经放入 oldDataFiles
+	delete(db.oldDataFiles, startFile.FileId)
+	_ = startFile.Truncate(startOff)
+	// 轮转提交的检查点指向已经删除的文件，重新提交
+	if rotated {
+		_ = db.commitIndex()
+	}
+}
+
 // Delete 删除key-value
 func (db *DB) Delete(key []byte) error {
 
@@ -316,11 +738,23 @@ func (db *DB) Delete(key []byte) error {
 	if !utils.IsValidKey(key) {
 		return ErrKeyIsNilOrEmpty
 	}
+	if db.options.ReadOnly {
+		return ErrDBReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	// 在内存索引中查询数据位置
 	lrPos := db.index.Get(key)
 	if lrPos == nil {
 		return nil
 	}
+	return db.deleteLocked(key, lrPos)
+}
+
+// 写入删除记录并删除索引，lrPos 为 key 当前记录的位置
+// 该方法必须在加锁的条件下调用
+func (db *DB) deleteLocked(key []byte, lrPos *data.LogRecordPos) error {
 	// 构建删除后的数据
 	logRecord := &data.LogRecord{
 		Key:  key,
@@ -336,6 +770,9 @@ func (db *DB) Delete(key []byte) error {
 	if ok := db.index.Delete(key); !ok {
 		return ErrIndexUpdateFailed
 	}
+	if db.cache != nil {
+		db.cache.Remove(lrPos)
+	}
 	return nil
 }
 
@@ -343,31 +780,74 @@ func (db *DB) Close() error {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	if db.options.ReadOnly {
+		return db.closeFiles()
+	}
 	// 刷新活跃文件
 	if db.activityDataFile != nil {
 		if err := db.activityDataFile.Sync(); err != nil {
 			return err
 		}
 	}
-	// 关闭所有文件
-	if err := db.activityDataFile.Close(); err != nil {
+	if err := db.commitIndex(); err != nil {
 		return err
 	}
+	return db.closeFiles()
+}
+
+// 关闭所有数据文件、持久化索引并释放目录锁
+func (db *DB) closeFiles() error {
+	if persistent, ok := db.index.(index.PersistentIndexer); ok {
+		if err := persistent.Close(); err != nil {
+			return err
+		}
+	}
+	if db.activityDataFile != nil {
+		if err := db.activityDataFile.Close(); err != nil {
+			return err
+		}
+	}
 	for _, file := range db.oldDataFiles {
 		if err := file.Close(); err != nil {
 			return err
 		}
 	}
+	if db.fileLock != nil {
+		if err := db.fileLock.Close(); err != nil {
+			return err
+		}
+		db.fileLock = nil
+	}
 	return nil
 }
 
 // Fold 遍历所有数据，并执行用户指定的操作fn，fn返回错误时终止
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.Scan(nil, fn)
+}
+
+// Scan 按 key 的顺序遍历以 prefix 开头的数据，fn 返回 false 时终止，prefix 为空时遍历所有数据
+// 迭代期间被删除的 key 会被跳过，被覆盖的 key 读到的是最新的值
+func (db *DB) Scan(prefix []byte, fn func(key []byte, value []byte) bool) error {
 	it := db.index.Iterator(false)
 	defer it.Close()
-	for it.Rewind(); it.Valid(); it.Next() {
-		// 该方法加锁了，这里不用加
-		value, err := db.getValueByPosition(it.Value())
+	if len(prefix) > 0 {
+		it.Seek(prefix)
+	}
+	for ; it.Valid() && bytes.HasPrefix(it.Key(), prefix); it.Next() {
+		// 迭代器中的位置可能已经因为合并失效，加锁后重新查询索引
+		db.mu.RLock()
+		var value []byte
+		var err error
+		if pos := db.index.Get(it.Key()); pos != nil {
+			value, err = db.getValueByPosition(pos)
+		} else {
+			err = ErrReadKeyNotFound
+		}
+		db.mu.RUnlock()
+		if err == ErrReadKeyNotFound {
+			continue
+		}
 		if err != nil {
 			return err
 		}
@@ -378,6 +858,30 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	return nil
 }
 
+// ScanKeys 按 key 的顺序遍历以 prefix 开头、不小于 start 的 key 和记录的位置，不读取数据，fn 返回 false 时终止
+func (db *DB) ScanKeys(prefix []byte, start []byte, fn func(key []byte, pos *data.LogRecordPos) bool) {
+	it := db.index.Iterator(false)
+	defer it.Close()
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	if len(start) > 0 {
+		it.Seek(start)
+	}
+	for ; it.Valid() && bytes.HasPrefix(it.Key(), prefix); it.Next() {
+		// 和 Scan 一样重新查询索引，跳过迭代期间被删除的 key
+		db.mu.RLock()
+		pos := db.currentPosition(it.Key())
+		db.mu.RUnlock()
+		if pos == nil {
+			continue
+		}
+		if !fn(it.Key(), pos) {
+			break
+		}
+	}
+}
+
 // ListKeys 获取所有的key
 func (db *DB) ListKeys() (rnt [][]byte) {
 	it := db.index.Iterator(false)
@@ -387,12 +891,135 @@ func (db *DB) ListKeys() (rnt [][]byte) {
 	return
 }
 
-// Sync 将内存数据刷入磁盘
+// Sync 将内存数据刷入磁盘，并提交持久化索引的检查点
 func (db *DB) Sync() error {
 	db.mu.Lock()
-	db.mu.Unlock()
+	defer db.mu.Unlock()
+	if db.options.ReadOnly {
+		return nil
+	}
 	if db.activityDataFile != nil {
-		return db.activityDataFile.Sync()
+		if err := db.activityDataFile.Sync(); err != nil {
+			return err
+		}
+	}
+	return db.commitIndex()
+}
+
+// Flush 将写缓冲区中的数据写入文件，但不刷盘，未开启写缓冲区时不做任何操作
+func (db *DB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.activityDataFile != nil {
+		return db.activityDataFile.Flush()
 	}
 	return nil
 }
+
+// CacheStats 获取 value 缓存的统计信息，未启用缓存时返回零值
+func (db *DB) CacheStats() cache.Stats {
+	if db.cache == nil {
+		return cache.Stats{}
+	}
+	return db.cache.Stats()
+}
+
+// Stat 数据库的统计信息
+type Stat struct {
+	KeyNum      int    // key 的数量
+	DataFileNum int    // 数据文件的数量
+	DiskSize    uint64 // 数据文件占用的空间
+}
+
+// Stat 获取数据库的统计信息
+func (db *DB) Stat() (*Stat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	stat := &Stat{KeyNum: db.index.Size()}
+	for _, dataFile := range db.dataFiles() {
+		size, err := db.dataFileSize(dataFile)
+		if err != nil {
+			return nil, err
+		}
+		stat.DataFileNum++
+		stat.DiskSize += size
+	}
+	return stat, nil
+}
+
+// Backup 将所有数据文件拷贝到 dir 目录，dir 不存在时创建，已经存在时必须为空，
+// 否则旧备份中多出来的数据文件会在启动时覆盖备份的数据，返回 ErrBackupDirNotEmpty。
+// 拷贝期间阻塞写入，持久化索引不拷贝，从备份启动时从数据文件重建
+func (db *DB) Backup(dir string) error {
+	if path.Clean(dir) == path.Clean(db.options.DBFileDir) {
+		return ErrDBBackupDir
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := db.fs.MkdirAll(dir); err != nil {
+		return err
+	}
+	names, err := db.fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return ErrBackupDirNotEmpty
+	}
+	for _, dataFile := range db.dataFiles() {
+		size, err := db.dataFileSize(dataFile)
+		if err != nil {
+			return err
+		}
+		if err := db.copyDataFile(dataFile, size, data.DataFileName(dir, dataFile.FileId)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 将数据文件的前 size 个字节拷贝到 fileName
+func (db *DB) copyDataFile(dataFile *data.DataFile, size uint64, fileName string) error {
+	dst, err := db.fs.OpenFile(fileName)
+	if err != nil {
+		return err
+	}
+	if err := dst.Truncate(0); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if _, err := io.Copy(dst, io.NewSectionReader(dataFile, 0, int64(size))); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// 按文件编号排序的所有数据文件，活跃文件在最后
+// 该方法必须在加锁的条件下调用
+func (db *DB) dataFiles() []*data.DataFile {
+	dataFiles := make([]*data.DataFile, 0, len(db.oldDataFiles)+1)
+	for _, dataFile := range db.oldDataFiles {
+		dataFiles = append(dataFiles, dataFile)
+	}
+	sort.Slice(dataFiles, func(i, j int) bool {
+		return dataFiles[i].FileId < dataFiles[j].FileId
+	})
+	if db.activityDataFile != nil {
+		dataFiles = append(dataFiles, db.activityDataFile)
+	}
+	return dataFiles
+}
+
+// 数据文件中有效数据的长度，活跃文件末尾可能有预分配的空间，以写入位置为准
+// 该方法必须在加锁的条件下调用
+func (db *DB) dataFileSize(dataFile *data.DataFile) (uint64, error) {
+	if dataFile == db.activityDataFile {
+		return dataFile.WriteOff, nil
+	}
+	return dataFile.Size()
+}
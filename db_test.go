@@ -1,9 +1,14 @@
 package bitcask_go
 
 import (
+	"bitcask-go/cache"
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
 	"bitcask-go/utils"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"testing"
 )
@@ -318,3 +323,653 @@ func TestDB_Sync(t *testing.T) {
 	err = db.Sync()
 	assert.Nil(t, err)
 }
+
+func TestDB_MemFS(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-mem"
+	opts.FileMaxSize = 64 * 1024
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 同一目录不能被打开两次
+	_, err = Start(&opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.oldDataFiles) > 0)
+	err = db.Delete(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+
+	// 磁盘上不应该有任何文件
+	_, err = os.Stat(opts.DBFileDir)
+	assert.True(t, os.IsNotExist(err))
+
+	// 重启后数据仍然可以读到
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrReadKeyNotFound, err)
+	val2, err := db2.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val2)
+	assert.Equal(t, 999, len(db2.ListKeys()))
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_WriteBuffer(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-buffer"
+	opts.FileMaxSize = 64 * 1024
+	opts.FS = fio.NewMemFS()
+	opts.DBSync = Never
+	opts.WriteBufferSize = 4 * 1024
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 缓冲区中尚未写入文件的数据也能读到
+	values := make([][]byte, 1000)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	assert.True(t, len(db.oldDataFiles) > 0)
+	assert.Nil(t, db.Flush())
+
+	// 关闭时写入缓冲区，重启后数据完整
+	assert.Nil(t, db.Close())
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_Preallocate(t *testing.T) {
+	opts := *DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-prealloc")
+	opts.DBFileDir = dir
+	opts.FileMaxSize = 64 * 1024
+	opts.PreallocateSize = 16 * 1024
+	db, err := Start(&opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.True(t, len(db.oldDataFiles) > 0)
+	assert.True(t, db.activityDataFile.Preallocated >= db.activityDataFile.WriteOff)
+
+	// 重启后从文件的逻辑末尾继续写入
+	assert.Nil(t, db.Close())
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Put(utils.GetTestKey(1000), []byte("value")))
+	val, err := db2.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Equal(t, 1001, len(db2.ListKeys()))
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_PreallocatedZeroTail(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-zero-tail"
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	writeOff := db.activityDataFile.WriteOff
+	assert.Nil(t, db.Close())
+
+	// 模拟文件系统不支持保持长度的预分配，文件末尾留下空白数据
+	file, err := opts.FS.OpenFile(data.DataFileName(opts.DBFileDir, 1))
+	assert.Nil(t, err)
+	_, err = file.Write(make([]byte, 4096))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, db2.activityDataFile.WriteOff)
+	assert.Nil(t, db2.Put(utils.GetTestKey(10), []byte("value")))
+	assert.Nil(t, db2.Close())
+
+	db3, err := Start(&opts)
+	assert.Nil(t, err)
+	val, err := db3.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Equal(t, 11, len(db3.ListKeys()))
+	assert.Nil(t, db3.Close())
+}
+
+// 旧文件中间的一段数据被清零，不能当成文件末尾丢弃之后的记录
+func TestDB_ZeroedSealedFile(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-zeroed-sealed"
+	opts.FileMaxSize = 4 * 1024
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	pos := db.index.Get(utils.GetTestKey(3))
+	assert.Equal(t, uint32(1), pos.Fid)
+	assert.True(t, db.activityDataFile.FileId > 1)
+	assert.Nil(t, db.Close())
+
+	file, err := opts.FS.OpenFile(data.DataFileName(opts.DBFileDir, 1))
+	assert.Nil(t, err)
+	size, err := file.Size()
+	assert.Nil(t, err)
+	buf := make([]byte, size)
+	_, err = file.Read(buf, 0)
+	assert.Nil(t, err)
+	copy(buf[pos.Offset:], make([]byte, 2*pos.Size))
+	assert.Nil(t, file.Truncate(0))
+	_, err = file.Write(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	_, err = Start(&opts)
+	assert.Equal(t, ErrDataFileDamaged, err)
+}
+
+func TestDB_DamagedActiveFile(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-damaged-active"
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	pos := db.index.Get(utils.GetTestKey(5))
+	writeOff := db.activityDataFile.WriteOff
+	assert.Nil(t, db.Close())
+
+	readFile := func() []byte {
+		file, err := opts.FS.OpenFile(data.DataFileName(opts.DBFileDir, 1))
+		assert.Nil(t, err)
+		defer file.Close()
+		size, err := file.Size()
+		assert.Nil(t, err)
+		buf := make([]byte, size)
+		_, err = file.Read(buf, 0)
+		assert.Nil(t, err)
+		return buf
+	}
+	writeFile := func(buf []byte) {
+		file, err := opts.FS.OpenFile(data.DataFileName(opts.DBFileDir, 1))
+		assert.Nil(t, err)
+		assert.Nil(t, file.Truncate(0))
+		_, err = file.Write(buf)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+	}
+	original := readFile()
+
+	// 文件中间的记录损坏，之后还有已经确认的记录，不能截断
+	damaged := append([]byte(nil), original...)
+	damaged[pos.Offset+uint64(pos.Size)-1] ^= 0xff
+	writeFile(damaged)
+	_, err = Start(&opts)
+	assert.Equal(t, ErrDataFileDamaged, err)
+
+	// 末尾不完整的记录后面只有预分配的空白数据，截断之后正常启动
+	torn := append(append([]byte(nil), original...), original[:pos.Size-3]...)
+	writeFile(append(torn, make([]byte, 4096)...))
+	db, err = Start(&opts)
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, db.activityDataFile.WriteOff)
+	assert.Equal(t, 10, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_IndexTypes(t *testing.T) {
+	indexTypes := map[string]index.DBIndexType{
+		"BTree":    index.BTree,
+		"ART":      index.ART,
+		"SkipList": index.SkipList,
+		"Hash":     index.Hash,
+		"CowBTree": index.CowBTree,
+		"BPTree":   index.BPTree,
+	}
+	for name, indexType := range indexTypes {
+		t.Run(name, func(t *testing.T) {
+			opts := *DefaultOptions
+			opts.DBFileDir = "/bitcask-go-index"
+			opts.FileMaxSize = 64 * 1024
+			opts.FS = fio.NewMemFS()
+			opts.DBIndex = indexType
+			db, err := Start(&opts)
+			assert.Nil(t, err)
+
+			for i := 0; i < 1000; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+			}
+			for i := 0; i < 1000; i += 2 {
+				assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			}
+			assert.Nil(t, db.Close())
+
+			// 重启后重建索引
+			db2, err := Start(&opts)
+			assert.Nil(t, err)
+			keys := db2.ListKeys()
+			assert.Equal(t, 500, len(keys))
+			for i, key := range keys {
+				assert.Equal(t, utils.GetTestKey(2*i+1), key)
+				val, err := db2.Get(key)
+				assert.Nil(t, err)
+				assert.Equal(t, key, val)
+			}
+			assert.Nil(t, db2.Close())
+		})
+	}
+}
+
+func TestDB_ShardedIndex(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-sharded"
+	opts.FileMaxSize = 64 * 1024
+	opts.FS = fio.NewMemFS()
+	opts.IndexShards = 8
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	_, ok := db.index.(*index.ShardedIndex)
+	assert.True(t, ok)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	keys := db.ListKeys()
+	assert.Equal(t, 999, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, utils.GetTestKey(i+1), key)
+	}
+	assert.Nil(t, db.Close())
+
+	opts.IndexShards = -1
+	_, err = Start(&opts)
+	assert.Equal(t, ErrDBIndexShards, err)
+}
+
+func TestDB_BPlusTreeIndex(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-bptree"
+	opts.FileMaxSize = 16 * 1024
+	opts.FS = fio.NewMemFS()
+	opts.DBIndex = index.BPTree
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	// 文件轮转时提交检查点
+	assert.True(t, len(db.oldDataFiles) > 0)
+	checkpoint := db.index.(index.PersistentIndexer).Checkpoint()
+	assert.NotNil(t, checkpoint)
+	assert.Equal(t, db.activityDataFile.FileId, checkpoint.Fid)
+
+	// Sync 时提交检查点
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, db.Sync())
+	checkpoint = db.index.(index.PersistentIndexer).Checkpoint()
+	assert.Equal(t, &data.LogRecordPos{Fid: db.activityDataFile.FileId, Offset: db.activityDataFile.WriteOff}, checkpoint)
+
+	// 检查点之后的写入在重启时从数据文件加载
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("value")))
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	fs := opts.FS
+	db.index = index.NewIndexer(index.BTree) // 跳过 Close 时的提交，模拟崩溃
+	assert.Nil(t, db.Close())
+
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Equal(t, checkpoint, db2.index.(index.PersistentIndexer).Checkpoint())
+	val, err := db2.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrReadKeyNotFound, err)
+	assert.Equal(t, 999, len(db2.ListKeys()))
+	assert.Nil(t, db2.Close())
+
+	// 检查点指向的数据文件不存在时，从数据文件重建索引
+	assert.Nil(t, fs.Remove(data.DataFileName(opts.DBFileDir, checkpoint.Fid)))
+	db3, err := Start(&opts)
+	assert.Nil(t, err)
+	_, err = db3.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = db3.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrReadKeyNotFound, err)
+	assert.Nil(t, db3.Close())
+}
+
+func TestDB_IndexRecordSize(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-record-size"
+	opts.FS = fio.NewMemFS()
+	opts.DBIndex = index.BPTree
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("hello"), []byte("world")))
+	assert.Nil(t, db.Put([]byte("bitcask"), utils.RandomValue(1024)))
+	pos := db.index.Get([]byte("hello"))
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 0, Size: 17}, pos)
+	assert.Nil(t, db.Close())
+
+	// 记录长度随索引一起持久化，也可以在加载数据文件时重建
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Equal(t, pos, db2.index.Get([]byte("hello")))
+	val, err := db2.Get([]byte("bitcask"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1024, len(val))
+	bpt := db2.index
+	db2.index = index.NewIndexer(index.BTree)
+	assert.Nil(t, db2.loadIndexFromDataFiles())
+	assert.Equal(t, pos, db2.index.Get([]byte("hello")))
+	db2.index = bpt
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-cache"
+	opts.FS = fio.NewMemFS()
+	opts.CacheSize = 1024 * 1024
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("hello"), []byte("world")))
+	for i := 0; i < 3; i++ {
+		val, err := db.Get([]byte("hello"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("world"), val)
+		// 修改返回值不影响缓存
+		val[0] = 'x'
+	}
+	stats := db.CacheStats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 1, stats.Entries)
+
+	// 覆盖写入后读到新的值，旧值的缓存被移除
+	assert.Nil(t, db.Put([]byte("hello"), []byte("bitcask")))
+	assert.Equal(t, 0, db.CacheStats().Entries)
+	val, err := db.Get([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), val)
+
+	// 删除后读不到，缓存被移除
+	assert.Nil(t, db.Delete([]byte("hello")))
+	_, err = db.Get([]byte("hello"))
+	assert.Equal(t, ErrReadKeyNotFound, err)
+	stats = db.CacheStats()
+	assert.Equal(t, 0, stats.Entries)
+	assert.Equal(t, int64(0), stats.Bytes)
+	assert.Nil(t, db.Close())
+
+	opts.CacheSize = 0
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.cache)
+	assert.Equal(t, cache.Stats{}, db2.CacheStats())
+	assert.Nil(t, db2.Close())
+
+	opts.CacheSize = -1
+	_, err = Start(&opts)
+	assert.Equal(t, ErrDBCacheSize, err)
+}
+
+func TestDB_ParallelLoad(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-parallel-load"
+	opts.FileMaxSize = 4 * 1024
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+
+	// 覆盖写入和删除分布在不同的数据文件中
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 300; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d-%d", round, i))))
+		}
+		for i := round; i < 300; i += 7 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+	}
+	assert.True(t, len(db.oldDataFiles) > 5)
+	expected := make(map[string]*data.LogRecordPos)
+	iter := db.index.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		expected[string(iter.Key())] = iter.Value()
+	}
+	assert.Nil(t, db.Close())
+
+	for _, concurrency := range []int{1, 4, 64} {
+		opts.LoadConcurrency = concurrency
+		db2, err := Start(&opts)
+		assert.Nil(t, err)
+		keys := db2.ListKeys()
+		assert.Equal(t, len(expected), len(keys))
+		for _, key := range keys {
+			assert.Equal(t, expected[string(key)], db2.index.Get(key))
+		}
+		val, err := db2.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value-2-1"), val)
+		_, err = db2.Get(utils.GetTestKey(2))
+		assert.Equal(t, ErrReadKeyNotFound, err)
+		assert.Nil(t, db2.Close())
+	}
+
+	opts.LoadConcurrency = -1
+	_, err = Start(&opts)
+	assert.Equal(t, ErrDBLoadConcurrency, err)
+}
+
+func TestDB_Scan(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-scan"
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+
+	for _, key := range []string{"apple", "banana", "band", "bank", "cat"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("value-"+key)))
+	}
+	assert.Nil(t, db.Delete([]byte("band")))
+
+	var keys []string
+	err = db.Scan([]byte("ban"), func(key []byte, value []byte) bool {
+		assert.Equal(t, "value-"+string(key), string(value))
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"banana", "bank"}, keys)
+
+	// fn 返回 false 时终止，遍历期间删除的 key 被跳过
+	keys = nil
+	err = db.Scan(nil, func(key []byte, value []byte) bool {
+		keys = append(keys, string(key))
+		if string(key) == "apple" {
+			assert.Nil(t, db.Delete([]byte("banana")))
+		}
+		return len(keys) < 3
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"apple", "bank", "cat"}, keys)
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_Stat(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-stat"
+	opts.FileMaxSize = 4 * 1024
+	opts.PreallocateSize = 1024
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, &Stat{}, stat)
+
+	var size uint64
+	for i := 0; i < 200; i++ {
+		record := &data.LogRecord{Key: utils.GetTestKey(i % 100), Value: utils.GetTestKey(i), Type: data.LogRecordNormal}
+		assert.Nil(t, db.Put(record.Key, record.Value))
+		size += uint64(db.activityDataFile.EncodeLogRecordSize(record))
+	}
+	stat, err = db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, 100, stat.KeyNum)
+	assert.Equal(t, len(db.oldDataFiles)+1, stat.DataFileNum)
+	assert.Equal(t, size, stat.DiskSize)
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-readonly"
+	opts.FS = fio.NewMemFS()
+	opts.DBIndex = index.BPTree
+
+	// 数据目录不存在
+	opts.ReadOnly = true
+	_, err := Start(&opts)
+	assert.NotNil(t, err)
+	opts.ReadOnly = false
+
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("hello"), []byte("world")))
+	assert.Nil(t, db.Sync())
+
+	// 写入的实例仍然持有目录锁时，也可以只读打开
+	readOnlyOpts := opts
+	readOnlyOpts.ReadOnly = true
+	db2, err := Start(&readOnlyOpts)
+	assert.Nil(t, err)
+	val, err := db2.Get([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("world"), val)
+	assert.Equal(t, ErrDBReadOnly, db2.Put([]byte("a"), []byte("b")))
+	assert.Equal(t, ErrDBReadOnly, db2.Delete([]byte("hello")))
+	assert.Equal(t, ErrDBReadOnly, db2.Merge())
+	assert.Nil(t, db2.Sync())
+	assert.Nil(t, db2.Close())
+
+	// 只读实例没有修改 B+树索引文件
+	assert.Nil(t, db.Put([]byte("bitcask"), []byte("go")))
+	assert.Nil(t, db.Close())
+	db3, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(db3.ListKeys()))
+	assert.Nil(t, db3.Merge())
+	assert.Nil(t, db3.Close())
+
+	// 只读的介质上不能创建或修改文件，只读打开和校验仍然可以读取
+	readOnlyOpts.FS = &readOnlyFS{VFS: opts.FS}
+	db4, err := Start(&readOnlyOpts)
+	assert.Nil(t, err)
+	val, err = db4.Get([]byte("bitcask"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("go"), val)
+	assert.Nil(t, db4.Close())
+	report, err := verify(readOnlyOpts.FS, opts.DBFileDir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+}
+
+// readOnlyFS 模拟只读的介质，所有修改文件系统的操作都返回权限错误
+type readOnlyFS struct {
+	fio.VFS
+}
+
+func (fs *readOnlyFS) OpenFile(name string) (fio.IOManager, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+}
+
+func (fs *readOnlyFS) MkdirAll(dir string) error {
+	return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrPermission}
+}
+
+func (fs *readOnlyFS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+}
+
+func (fs *readOnlyFS) Rename(oldName, newName string) error {
+	return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrPermission}
+}
+
+func (fs *readOnlyFS) Lock(name string) (io.Closer, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+}
+
+func TestDB_Backup(t *testing.T) {
+	opts := *DefaultOptions
+	opts.DBFileDir = "/bitcask-go-backup"
+	opts.FileMaxSize = 4 * 1024
+	opts.PreallocateSize = 1024
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	assert.Equal(t, ErrDBBackupDir, db.Backup(opts.DBFileDir+"/"))
+	assert.Nil(t, db.Backup("/bitcask-go-backup-copy"))
+	// 备份之后的写入不影响备份
+	assert.Nil(t, db.Put([]byte("hello"), []byte("world")))
+
+	// 合并之后不能备份到已有的备份中，旧备份中编号更大的文件会覆盖合并后的数据
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, ErrBackupDirNotEmpty, db.Backup("/bitcask-go-backup-copy"))
+	assert.Nil(t, db.Close())
+
+	backupOpts := opts
+	backupOpts.DBFileDir = "/bitcask-go-backup-copy"
+	db2, err := Start(&backupOpts)
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 299, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, utils.GetTestKey(i+1), key)
+		val, err := db2.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, key, val)
+	}
+	_, err = db2.Get([]byte("hello"))
+	assert.Equal(t, ErrReadKeyNotFound, err)
+	assert.Nil(t, db2.Close())
+}
@@ -9,7 +9,23 @@ var (
 	ErrReadKeyNotFound   = errors.New("read key is not found")
 	ErrDataFileNotFound  = errors.New("data file is not found")
 
-	ErrDBDirEmpty      = errors.New("config error: empty db directory path")
-	ErrDBFileMaxSize   = errors.New("config error: illegal file max size")
-	ErrDataFileDamaged = errors.New("the data file is damaged")
+	ErrDBDirEmpty        = errors.New("config error: empty db directory path")
+	ErrDBFileMaxSize     = errors.New("config error: illegal file max size")
+	ErrDBWriteBufferSize = errors.New("config error: illegal write buffer size")
+	ErrDBIndexShards     = errors.New("config error: illegal index shard number")
+	ErrDBCacheSize       = errors.New("config error: illegal cache size")
+	ErrDBLoadConcurrency = errors.New("config error: illegal load concurrency")
+	ErrDataFileDamaged   = errors.New("the data file is damaged")
+	ErrDatabaseIsUsing   = errors.New("the database directory is used by another process")
+	ErrDBReadOnly        = errors.New("the database is opened in read-only mode")
+	ErrDBBackupDir       = errors.New("the backup directory is the database directory")
+	ErrBackupDirNotEmpty = errors.New("the backup directory is not empty")
+	ErrMergeIsProgress   = errors.New("merge is in progress, try again later")
+	ErrMergeUnfinished   = errors.New("the last merge is unfinished, open the database in read-write mode first")
+	ErrExportFormat      = errors.New("unknown export format")
+	ErrInvalidExport     = errors.New("the export stream is invalid or truncated")
+	ErrIndexMismatch     = errors.New("the index entry does not point to a record of the key")
+	ErrConditionFailed   = errors.New("the condition of the write is not satisfied")
+
+	ErrLogPositionUnavailable = errors.New("the log position is unavailable, the data file may have been merged")
 )
@@ -0,0 +1,224 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+type ExportFormat byte
+
+const (
+	ExportJSON   ExportFormat = iota // JSON Lines，每行一个 {"key": ..., "value": ...} 对象，key 和 value 按 base64 编码
+	ExportBinary                     // 二进制流，以魔数开头，每条数据为 key 长度、value 长度(uvarint)、key、value，以数据条数结尾
+)
+
+// 二进制导出流的魔数，最后一个字节为格式版本
+var exportMagic = []byte("BITCASK\x01")
+
+const (
+	// 导出时每导出多少条数据调用一次进度回调
+	exportProgressInterval = 1024
+	// 导入时默认每批写入的数据条数
+	defaultImportBatchSize = 1024
+)
+
+// ExportOptions 导出选项
+type ExportOptions struct {
+	Prefix   []byte          // 只导出以 Prefix 开头的 key，为空时导出所有数据
+	Progress func(count int) // 进度回调，参数为已经导出的数据条数，结束时会再调用一次
+}
+
+// ImportOptions 导入选项
+type ImportOptions struct {
+	Prefix    []byte          // 只导入以 Prefix 开头的 key，为空时导入所有数据
+	BatchSize int             // 每批写入的数据条数，一批数据写入后只刷盘一次，0 表示使用默认值
+	Progress  func(count int) // 进度回调，每写入一批调用一次，参数为已经导入的数据条数
+}
+
+type exportRecord struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Export 将数据按 key 的顺序导出到 w，返回导出的数据条数
+// 导出期间不阻塞读写，导出的不是某一时刻的快照，期间被删除的 key 不会导出，被覆盖的 key 导出最新的值
+func (db *DB) Export(w io.Writer, format ExportFormat, opts *ExportOptions) (int, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	if format != ExportJSON && format != ExportBinary {
+		return 0, ErrExportFormat
+	}
+
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	if format == ExportBinary {
+		if _, err := writer.Write(exportMagic); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	var writeErr error
+	var lenBuf [2 * binary.MaxVarintLen64]byte
+	err := db.Scan(opts.Prefix, func(key []byte, value []byte) bool {
+		if format == ExportJSON {
+			writeErr = encoder.Encode(&exportRecord{Key: key, Value: value})
+		} else {
+			n := binary.PutUvarint(lenBuf[:], uint64(len(key)))
+			n += binary.PutUvarint(lenBuf[n:], uint64(len(value)))
+			if _, writeErr = writer.Write(lenBuf[:n]); writeErr == nil {
+				if _, writeErr = writer.Write(key); writeErr == nil {
+					_, writeErr = writer.Write(value)
+				}
+			}
+		}
+		if writeErr != nil {
+			return false
+		}
+		count++
+		if opts.Progress != nil && count%exportProgressInterval == 0 {
+			opts.Progress(count)
+		}
+		return true
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return count, err
+	}
+
+	// 二进制流以长度为 0 的 key 和数据条数结尾，导入时可以发现被截断的流
+	if format == ExportBinary {
+		n := binary.PutUvarint(lenBuf[:], 0)
+		n += binary.PutUvarint(lenBuf[n:], uint64(count))
+		if _, err := writer.Write(lenBuf[:n]); err != nil {
+			return count, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return count, err
+	}
+	if opts.Progress != nil {
+		opts.Progress(count)
+	}
+	return count, nil
+}
+
+// Import 从 r 中导入 Export 导出的数据，根据开头的魔数自动识别格式，返回导入的数据条数
+// 已经存在的 key 会被覆盖。导入出错时，之前的批次已经写入，出错的批次可能部分写入
+func (db *DB) Import(r io.Reader, opts *ImportOptions) (int, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	if db.options.ReadOnly {
+		return 0, ErrDBReadOnly
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+
+	reader := bufio.NewReader(r)
+	var next func() (*exportRecord, error)
+	if magic, _ := reader.Peek(len(exportMagic)); bytes.Equal(magic, exportMagic) {
+		_, _ = reader.Discard(len(exportMagic))
+		next = newBinaryImportReader(reader)
+	} else {
+		decoder := json.NewDecoder(reader)
+		next = func() (*exportRecord, error) {
+			record := &exportRecord{}
+			if err := decoder.Decode(record); err != nil {
+				if err != io.EOF {
+					err = ErrInvalidExport
+				}
+				return nil, err
+			}
+			return record, nil
+		}
+	}
+
+	count := 0
+	batch := make([]*data.LogRecord, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := db.writeBatch(batch); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(count)
+		}
+		return nil
+	}
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		if len(record.Key) == 0 {
+			return count, ErrKeyIsNilOrEmpty
+		}
+		if !bytes.HasPrefix(record.Key, opts.Prefix) {
+			continue
+		}
+		batch = append(batch, &data.LogRecord{Key: record.Key, Value: record.Value, Type: data.LogRecordNormal})
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, flush()
+}
+
+// 导入二进制流时每次最多预先分配的内存大小
+const importChunkSize = 64 * 1024
+
+// 逐条读取二进制导出流，读到结尾标记并且数据条数一致时返回 io.EOF
+func newBinaryImportReader(reader *bufio.Reader) func() (*exportRecord, error) {
+	var count uint64
+	return func() (*exportRecord, error) {
+		keySize, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, ErrInvalidExport
+		}
+		if keySize == 0 {
+			total, err := binary.ReadUvarint(reader)
+			if err != nil || total != count {
+				return nil, ErrInvalidExport
+			}
+			return nil, io.EOF
+		}
+		valueSize, err := binary.ReadUvarint(reader)
+		if err != nil || keySize > math.MaxUint32 || valueSize > math.MaxUint32 {
+			return nil, ErrInvalidExport
+		}
+		// 长度来自输入流，不能直接按声明的长度分配内存，随着数据的到达逐步增长
+		var buf bytes.Buffer
+		n := int64(keySize + valueSize)
+		if n < importChunkSize {
+			buf.Grow(int(n))
+		} else {
+			buf.Grow(importChunkSize)
+		}
+		if copied, _ := io.CopyN(&buf, reader, n); copied != n {
+			return nil, ErrInvalidExport
+		}
+		kv := buf.Bytes()
+		count++
+		return &exportRecord{Key: kv[:keySize:keySize], Value: kv[keySize:]}, nil
+	}
+}
@@ -0,0 +1,136 @@
+package bitcask_go
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func newExportTestDB(t *testing.T, dir string) *DB {
+	opts := *DefaultOptions
+	opts.DBFileDir = dir
+	opts.FS = fio.NewMemFS()
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestDB_ExportImport(t *testing.T) {
+	for _, format := range []ExportFormat{ExportJSON, ExportBinary} {
+		db := newExportTestDB(t, "/bitcask-go-export")
+		for i := 0; i < 3000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(i%100)))
+		}
+		// 二进制的 key 和空的 value
+		assert.Nil(t, db.Put([]byte{0, 0xff, '\n'}, []byte{}))
+		assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+		buf := new(bytes.Buffer)
+		var progress []int
+		count, err := db.Export(buf, format, &ExportOptions{Progress: func(n int) { progress = append(progress, n) }})
+		assert.Nil(t, err)
+		assert.Equal(t, 3000, count)
+		assert.Equal(t, []int{1024, 2048, 3000}, progress)
+
+		db2 := newExportTestDB(t, "/bitcask-go-import")
+		assert.Nil(t, db2.Put(utils.GetTestKey(1), []byte("old")))
+		progress = nil
+		count, err = db2.Import(bytes.NewReader(buf.Bytes()), &ImportOptions{BatchSize: 1000, Progress: func(n int) { progress = append(progress, n) }})
+		assert.Nil(t, err)
+		assert.Equal(t, 3000, count)
+		assert.Equal(t, []int{1000, 2000, 3000}, progress)
+
+		assert.Equal(t, db.ListKeys(), db2.ListKeys())
+		err = db.Fold(func(key []byte, value []byte) bool {
+			val, err := db2.Get(key)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(value, val))
+			return true
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, db.Close())
+		assert.Nil(t, db2.Close())
+	}
+}
+
+func TestDB_ExportPrefix(t *testing.T) {
+	db := newExportTestDB(t, "/bitcask-go-export-prefix")
+	for _, key := range []string{"a1", "b1", "b2", "c1"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("v"+key)))
+	}
+
+	buf := new(bytes.Buffer)
+	count, err := db.Export(buf, ExportJSON, &ExportOptions{Prefix: []byte("b")})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, `{"key":"YjE=","value":"dmIx"}`+"\n"+`{"key":"YjI=","value":"dmIy"}`+"\n", buf.String())
+
+	// 导入时也可以按前缀过滤
+	full := new(bytes.Buffer)
+	_, err = db.Export(full, ExportBinary, nil)
+	assert.Nil(t, err)
+	db2 := newExportTestDB(t, "/bitcask-go-import-prefix")
+	count, err = db2.Import(full, &ImportOptions{Prefix: []byte("c")})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, [][]byte{[]byte("c1")}, db2.ListKeys())
+
+	_, err = db.Export(buf, ExportFormat(9), nil)
+	assert.Equal(t, ErrExportFormat, err)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_ImportInvalid(t *testing.T) {
+	db := newExportTestDB(t, "/bitcask-go-export-invalid")
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	buf := new(bytes.Buffer)
+	_, err := db.Export(buf, ExportBinary, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// 截断的二进制流，包括只缺少结尾标记的情况
+	encoded := buf.Bytes()
+	for _, cut := range []int{1, 2, 5} {
+		db2 := newExportTestDB(t, "/bitcask-go-import-invalid")
+		count, err := db2.Import(bytes.NewReader(encoded[:len(encoded)-cut]), &ImportOptions{BatchSize: 4})
+		assert.Equal(t, ErrInvalidExport, err)
+		assert.True(t, count <= 10)
+		assert.Nil(t, db2.Close())
+	}
+
+	// 声明了很大长度的记录不会按声明的长度分配内存
+	var lenBuf [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], math.MaxUint32)
+	n += binary.PutUvarint(lenBuf[n:], math.MaxUint32)
+	huge := append(append(append([]byte{}, exportMagic...), lenBuf[:n]...), "key"...)
+	db4 := newExportTestDB(t, "/bitcask-go-import-huge")
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	count, err := db4.Import(bytes.NewReader(huge), nil)
+	runtime.ReadMemStats(&after)
+	assert.Equal(t, ErrInvalidExport, err)
+	assert.Equal(t, 0, count)
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 16*1024*1024)
+	assert.Nil(t, db4.Close())
+
+	db3 := newExportTestDB(t, "/bitcask-go-import-json")
+	count, err = db3.Import(strings.NewReader(`{"key":"YQ==","value":"Yg=="}`+"\n"+`{"key":`), nil)
+	assert.Equal(t, ErrInvalidExport, err)
+	assert.Equal(t, 0, count)
+	_, err = db3.Import(strings.NewReader(`{"key":"","value":"Yg=="}`), nil)
+	assert.Equal(t, ErrKeyIsNilOrEmpty, err)
+	count, err = db3.Import(strings.NewReader(""), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	assert.Nil(t, db3.Close())
+}
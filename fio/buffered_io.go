@@ -0,0 +1,192 @@
+package fio
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Flusher 带有用户态缓冲区的 IOManager 实现该接口
+type Flusher interface {
+	// Flush 将缓冲区中的数据写入底层文件，不保证刷盘
+	Flush() error
+}
+
+// BufferedIO 带写缓冲的 IOManager，追加写入先收集在用户态缓冲区中，
+// 缓冲区写满、Sync、Flush 或 Close 时才写入底层文件，以减少系统调用次数。
+// 只读取已经写入文件的数据时不加锁，读取之间以及读取和写入之间都不互相等待
+type BufferedIO struct {
+	flushed   int64 // 底层文件的长度，在加锁的条件下原子地修改，放在开头保证 64 位对齐
+	ioManager IOManager
+	mu        sync.RWMutex
+	buf       []byte // 尚未写入底层文件的数据
+}
+
+func NewBufferedIO(ioManager IOManager, bufferSize int) (*BufferedIO, error) {
+	size, err := ioManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &BufferedIO{
+		ioManager: ioManager,
+		buf:       make([]byte, 0, bufferSize),
+		flushed:   size,
+	}, nil
+}
+
+// Read 读取数据，缓冲区中尚未写入文件的数据同样可见
+func (b *BufferedIO) Read(bytes []byte, off int64) (int, error) {
+	if off+int64(len(bytes)) <= atomic.LoadInt64(&b.flushed) {
+		return b.ioManager.Read(bytes, off)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := 0
+	flushed := atomic.LoadInt64(&b.flushed)
+	if off < flushed {
+		end := len(bytes)
+		if off+int64(end) > flushed {
+			end = int(flushed - off)
+		}
+		read, err := b.ioManager.Read(bytes[:end], off)
+		n += read
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if read < end {
+			return n, io.EOF
+		}
+	}
+	if n < len(bytes) {
+		bufOff := off + int64(n) - flushed
+		if bufOff >= int64(len(b.buf)) {
+			return n, io.EOF
+		}
+		n += copy(bytes[n:], b.buf[bufOff:])
+		if n < len(bytes) {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (b *BufferedIO) Write(bytes []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.buf)+len(bytes) > cap(b.buf) {
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+		// 数据比缓冲区还大，直接写入文件
+		if len(bytes) > cap(b.buf) {
+			n, err := b.ioManager.Write(bytes)
+			atomic.AddInt64(&b.flushed, int64(n))
+			return n, err
+		}
+	}
+	b.buf = append(b.buf, bytes...)
+	return len(bytes), nil
+}
+
+func (b *BufferedIO) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+// 将缓冲区写入底层文件，必须在加锁的条件下调用
+func (b *BufferedIO) flush() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	n, err := b.ioManager.Write(b.buf)
+	atomic.AddInt64(&b.flushed, int64(n))
+	if err != nil {
+		// 保留未写入的部分，下次继续写
+		b.buf = b.buf[:copy(b.buf, b.buf[n:])]
+		return err
+	}
+	b.buf = b.buf[:0]
+	return nil
+}
+
+func (b *BufferedIO) Sync() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.flush(); err != nil {
+		return err
+	}
+	return b.ioManager.Sync()
+}
+
+func (b *BufferedIO) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.flush(); err != nil {
+		return err
+	}
+	return b.ioManager.Close()
+}
+
+func (b *BufferedIO) Size() (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.flushed + int64(len(b.buf)), nil
+}
+
+func (b *BufferedIO) Truncate(size int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size >= b.flushed {
+		if size-b.flushed <= int64(len(b.buf)) {
+			b.buf = b.buf[:size-b.flushed]
+			return nil
+		}
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	b.buf = b.buf[:0]
+	if err := b.ioManager.Truncate(size); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&b.flushed, size)
+	return nil
+}
+
+func (b *BufferedIO) Preallocate(size int64) error {
+	if preallocator, ok := b.ioManager.(Preallocator); ok {
+		return preallocator.Preallocate(size)
+	}
+	return nil
+}
+
+// BufferedFS 为打开的每个文件加上写缓冲区的 VFS
+type BufferedFS struct {
+	VFS
+	bufferSize int
+}
+
+func NewBufferedFS(fs VFS, bufferSize int) *BufferedFS {
+	return &BufferedFS{VFS: fs, bufferSize: bufferSize}
+}
+
+func (fs *BufferedFS) OpenFile(name string) (IOManager, error) {
+	ioManager, err := fs.VFS.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	bufferedIO, err := NewBufferedIO(ioManager, fs.bufferSize)
+	if err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	return bufferedIO, nil
+}
+
+// OpenReadOnly 只读的文件不会写入，不需要写缓冲区
+func (fs *BufferedFS) OpenReadOnly(name string) (IOManager, error) {
+	return fs.VFS.OpenReadOnly(name)
+}
@@ -0,0 +1,161 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestBufferedIO(t *testing.T, bufferSize int) (*BufferedIO, IOManager) {
+	fs := NewMemFS()
+	assert.Nil(t, fs.MkdirAll("/db"))
+	ioManager, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	// 另外打开一个句柄，用于观察底层文件的内容
+	raw, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	bufferedIO, err := NewBufferedIO(ioManager, bufferSize)
+	assert.Nil(t, err)
+	return bufferedIO, raw
+}
+
+func TestBufferedIO_Write(t *testing.T) {
+	bufferedIO, raw := newTestBufferedIO(t, 16)
+
+	_, err := bufferedIO.Write([]byte("hello "))
+	assert.Nil(t, err)
+	size, _ := raw.Size()
+	assert.Equal(t, int64(0), size)
+	size, _ = bufferedIO.Size()
+	assert.Equal(t, int64(6), size)
+
+	// 缓冲区写满后写入文件
+	_, err = bufferedIO.Write([]byte("world, bitcask"))
+	assert.Nil(t, err)
+	size, _ = raw.Size()
+	assert.Equal(t, int64(6), size)
+
+	// 比缓冲区大的数据直接写入
+	_, err = bufferedIO.Write([]byte("0123456789abcdefg"))
+	assert.Nil(t, err)
+	size, _ = raw.Size()
+	assert.Equal(t, int64(37), size)
+	size, _ = bufferedIO.Size()
+	assert.Equal(t, int64(37), size)
+}
+
+func TestBufferedIO_Read(t *testing.T) {
+	bufferedIO, _ := newTestBufferedIO(t, 8)
+
+	_, err := bufferedIO.Write([]byte("hello"))
+	assert.Nil(t, err)
+	_, err = bufferedIO.Write([]byte("world"))
+	assert.Nil(t, err)
+
+	// "hello" 在文件中，"world" 在缓冲区中
+	b := make([]byte, 10)
+	_, err = bufferedIO.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("helloworld"), b)
+
+	b = make([]byte, 4)
+	_, err = bufferedIO.Read(b, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("lowo"), b)
+
+	n, err := bufferedIO.Read(b, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+}
+
+// 读取已经写入文件的数据时不等待写入
+func TestBufferedIO_ReadFlushed(t *testing.T) {
+	bufferedIO, _ := newTestBufferedIO(t, 8)
+	_, err := bufferedIO.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, bufferedIO.Flush())
+	_, err = bufferedIO.Write([]byte("world"))
+	assert.Nil(t, err)
+
+	// 模拟正在执行的写入
+	bufferedIO.mu.Lock()
+	done := make(chan []byte)
+	go func() {
+		b := make([]byte, 5)
+		_, err := bufferedIO.Read(b, 0)
+		assert.Nil(t, err)
+		done <- b
+	}()
+	select {
+	case b := <-done:
+		assert.Equal(t, []byte("hello"), b)
+	case <-time.After(time.Second):
+		t.Fatal("read blocked by write")
+	}
+	bufferedIO.mu.Unlock()
+
+	// 并发的读取和写入
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := make([]byte, 10)
+			for j := 0; j < 100; j++ {
+				_, err := bufferedIO.Read(b, 0)
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("helloworld"), b)
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		_, err := bufferedIO.Write([]byte("!"))
+		assert.Nil(t, err)
+	}
+	wg.Wait()
+}
+
+func TestBufferedIO_SyncAndClose(t *testing.T) {
+	bufferedIO, raw := newTestBufferedIO(t, 64)
+
+	_, err := bufferedIO.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, bufferedIO.Sync())
+	size, _ := raw.Size()
+	assert.Equal(t, int64(5), size)
+
+	_, err = bufferedIO.Write([]byte("world"))
+	assert.Nil(t, err)
+	assert.Nil(t, bufferedIO.Flush())
+	size, _ = raw.Size()
+	assert.Equal(t, int64(10), size)
+
+	_, err = bufferedIO.Write([]byte("!"))
+	assert.Nil(t, err)
+	assert.Nil(t, bufferedIO.Close())
+	size, _ = raw.Size()
+	assert.Equal(t, int64(11), size)
+}
+
+func TestBufferedIO_Truncate(t *testing.T) {
+	bufferedIO, raw := newTestBufferedIO(t, 8)
+
+	_, err := bufferedIO.Write([]byte("hello"))
+	assert.Nil(t, err)
+	_, err = bufferedIO.Write([]byte("world"))
+	assert.Nil(t, err)
+
+	// 只截断缓冲区
+	assert.Nil(t, bufferedIO.Truncate(7))
+	size, _ := bufferedIO.Size()
+	assert.Equal(t, int64(7), size)
+
+	// 截断到已写入文件的部分
+	assert.Nil(t, bufferedIO.Truncate(3))
+	size, _ = bufferedIO.Size()
+	assert.Equal(t, int64(3), size)
+	size, _ = raw.Size()
+	assert.Equal(t, int64(3), size)
+}
@@ -0,0 +1,324 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	ErrInjectedFault = errors.New("injected io fault")
+	ErrCrashed       = errors.New("the file system is crashed")
+)
+
+// FaultConfig 故障注入配置，概率的取值范围为 [0, 1]
+type FaultConfig struct {
+	Seed           int64   // 随机数种子，便于复现
+	FailWriteAt    int     // 第 N 次写入失败（从 1 开始计数），0 表示不启用
+	WriteFailRate  float64 // 写入直接失败、不写入任何数据的概率
+	ShortWriteRate float64 // 只写入部分数据并返回错误的概率
+	SyncFailRate   float64 // Sync 失败的概率，失败时数据仍未持久化
+	ReadFlipRate   float64 // 读取时随机翻转一个比特的概率
+	TornCrash      bool    // 崩溃时保留未刷盘数据的随机前缀（撕裂写），否则全部丢弃
+}
+
+// FaultFS 故障注入文件系统，包装另一个 VFS，用于测试崩溃恢复
+// 它记录每个文件已经持久化的长度，Crash 时丢弃未刷盘的数据
+type FaultFS struct {
+	fs      VFS
+	mu      sync.Mutex
+	config  FaultConfig
+	rand    *rand.Rand
+	writes  int                   // 已经发生的写入次数
+	files   map[string]*faultFile // 文件名 -> 持久化状态
+	handles []*FaultIO            // 崩溃前打开的所有句柄
+	locks   []io.Closer           // 崩溃前持有的所有锁
+}
+
+// 文件的持久化状态
+type faultFile struct {
+	synced int64 // 已经刷盘的长度，崩溃后只保证这部分数据存在
+}
+
+func NewFaultFS(fs VFS, config FaultConfig) *FaultFS {
+	return &FaultFS{
+		fs:     fs,
+		config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)),
+		files:  make(map[string]*faultFile),
+	}
+}
+
+// SetConfig 更新故障注入配置，写入计数会被重置
+func (fs *FaultFS) SetConfig(config FaultConfig) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.config = config
+	fs.writes = 0
+}
+
+func (fs *FaultFS) OpenFile(name string) (IOManager, error) {
+	name = filepath.Clean(name)
+	ioManager, err := fs.fs.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.newHandle(name, ioManager)
+}
+
+func (fs *FaultFS) OpenReadOnly(name string) (IOManager, error) {
+	name = filepath.Clean(name)
+	ioManager, err := fs.fs.OpenReadOnly(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.newHandle(name, ioManager)
+}
+
+// 包装打开的文件，记录文件的持久化状态和句柄
+func (fs *FaultFS) newHandle(name string, ioManager IOManager) (IOManager, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	file, ok := fs.files[name]
+	if !ok {
+		// 已经存在的数据视为已持久化
+		size, err := ioManager.Size()
+		if err != nil {
+			_ = ioManager.Close()
+			return nil, err
+		}
+		file = &faultFile{synced: size}
+		fs.files[name] = file
+	}
+	handle := &FaultIO{fs: fs, file: file, ioManager: ioManager}
+	fs.handles = append(fs.handles, handle)
+	return handle, nil
+}
+
+func (fs *FaultFS) ReadDir(dir string) ([]string, error) {
+	return fs.fs.ReadDir(dir)
+}
+
+func (fs *FaultFS) MkdirAll(dir string) error {
+	return fs.fs.MkdirAll(dir)
+}
+
+func (fs *FaultFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	if err := fs.fs.Remove(name); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	delete(fs.files, name)
+	fs.mu.Unlock()
+	return nil
+}
+
+func (fs *FaultFS) Rename(oldName, newName string) error {
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	if err := fs.fs.Rename(oldName, newName); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if file, ok := fs.files[oldName]; ok {
+		delete(fs.files, oldName)
+		fs.files[newName] = file
+	}
+	return nil
+}
+
+func (fs *FaultFS) Lock(name string) (io.Closer, error) {
+	lock, err := fs.fs.Lock(name)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	fs.locks = append(fs.locks, lock)
+	fs.mu.Unlock()
+	return lock, nil
+}
+
+// Crash 模拟进程崩溃或掉电：所有句柄失效，锁被释放，未刷盘的数据被丢弃
+// 开启 TornCrash 时，未刷盘的数据会保留一个随机长度的前缀
+func (fs *FaultFS) Crash() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, handle := range fs.handles {
+		handle.mu.Lock()
+		if !handle.crashed {
+			handle.crashed = true
+			_ = handle.ioManager.Close()
+		}
+		handle.mu.Unlock()
+	}
+	fs.handles = nil
+
+	for _, lock := range fs.locks {
+		_ = lock.Close()
+	}
+	fs.locks = nil
+
+	for name, file := range fs.files {
+		ioManager, err := fs.fs.OpenFile(name)
+		if err != nil {
+			return err
+		}
+		size, err := ioManager.Size()
+		if err != nil {
+			_ = ioManager.Close()
+			return err
+		}
+		keep := file.synced
+		if fs.config.TornCrash && size > keep {
+			keep += fs.rand.Int63n(size - keep + 1)
+		}
+		if keep < size {
+			if err := ioManager.Truncate(keep); err != nil {
+				_ = ioManager.Close()
+				return err
+			}
+		}
+		if err := ioManager.Close(); err != nil {
+			return err
+		}
+	}
+	// 崩溃后磁盘上剩下的数据都视为已持久化
+	fs.files = make(map[string]*faultFile)
+	return nil
+}
+
+// 判断本次写入注入何种故障，必须在加锁的条件下调用
+func (fs *FaultFS) writeFault(n int) (written int, err error) {
+	fs.writes++
+	if fs.config.FailWriteAt > 0 && fs.writes == fs.config.FailWriteAt {
+		return 0, ErrInjectedFault
+	}
+	if fs.hit(fs.config.WriteFailRate) {
+		return 0, ErrInjectedFault
+	}
+	if n > 0 && fs.hit(fs.config.ShortWriteRate) {
+		return fs.rand.Intn(n), io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (fs *FaultFS) hit(rate float64) bool {
+	return rate > 0 && fs.rand.Float64() < rate
+}
+
+// FaultIO FaultFS 中打开的文件句柄
+type FaultIO struct {
+	fs        *FaultFS
+	file      *faultFile
+	ioManager IOManager
+	mu        sync.Mutex
+	crashed   bool
+}
+
+func (f *FaultIO) Read(bytes []byte, off int64) (int, error) {
+	if f.isCrashed() {
+		return 0, ErrCrashed
+	}
+	n, err := f.ioManager.Read(bytes, off)
+	if n > 0 {
+		f.fs.mu.Lock()
+		if f.fs.hit(f.fs.config.ReadFlipRate) {
+			bit := f.fs.rand.Intn(n * 8)
+			bytes[bit/8] ^= 1 << (bit % 8)
+		}
+		f.fs.mu.Unlock()
+	}
+	return n, err
+}
+
+func (f *FaultIO) Write(bytes []byte) (int, error) {
+	if f.isCrashed() {
+		return 0, ErrCrashed
+	}
+	f.fs.mu.Lock()
+	n, faultErr := f.fs.writeFault(len(bytes))
+	f.fs.mu.Unlock()
+
+	if n > 0 {
+		written, err := f.ioManager.Write(bytes[:n])
+		if err != nil {
+			return written, err
+		}
+	}
+	return n, faultErr
+}
+
+func (f *FaultIO) Sync() error {
+	if f.isCrashed() {
+		return ErrCrashed
+	}
+	f.fs.mu.Lock()
+	fail := f.fs.hit(f.fs.config.SyncFailRate)
+	f.fs.mu.Unlock()
+	if fail {
+		return ErrInjectedFault
+	}
+
+	if err := f.ioManager.Sync(); err != nil {
+		return err
+	}
+	size, err := f.ioManager.Size()
+	if err != nil {
+		return err
+	}
+	f.fs.mu.Lock()
+	f.file.synced = size
+	f.fs.mu.Unlock()
+	return nil
+}
+
+func (f *FaultIO) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return ErrCrashed
+	}
+	return f.ioManager.Close()
+}
+
+func (f *FaultIO) Size() (int64, error) {
+	if f.isCrashed() {
+		return 0, ErrCrashed
+	}
+	return f.ioManager.Size()
+}
+
+func (f *FaultIO) Truncate(size int64) error {
+	if f.isCrashed() {
+		return ErrCrashed
+	}
+	if err := f.ioManager.Truncate(size); err != nil {
+		return err
+	}
+	f.fs.mu.Lock()
+	if size < f.file.synced {
+		f.file.synced = size
+	}
+	f.fs.mu.Unlock()
+	return nil
+}
+
+func (f *FaultIO) Preallocate(size int64) error {
+	if f.isCrashed() {
+		return ErrCrashed
+	}
+	if preallocator, ok := f.ioManager.(Preallocator); ok {
+		return preallocator.Preallocate(size)
+	}
+	return nil
+}
+
+func (f *FaultIO) isCrashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashed
+}
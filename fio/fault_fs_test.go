@@ -0,0 +1,126 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestFaultFS_FailWriteAt(t *testing.T) {
+	fs := NewFaultFS(NewMemFS(), FaultConfig{FailWriteAt: 2})
+	assert.Nil(t, fs.MkdirAll("/db"))
+	file, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+
+	_, err = file.Write([]byte("aaa"))
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("bbb"))
+	assert.Equal(t, ErrInjectedFault, err)
+	_, err = file.Write([]byte("ccc"))
+	assert.Nil(t, err)
+
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+}
+
+func TestFaultFS_ShortWrite(t *testing.T) {
+	fs := NewFaultFS(NewMemFS(), FaultConfig{ShortWriteRate: 1})
+	assert.Nil(t, fs.MkdirAll("/db"))
+	file, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+
+	n, err := file.Write([]byte("hello world"))
+	assert.Equal(t, io.ErrShortWrite, err)
+	assert.True(t, n < 11)
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(n), size)
+}
+
+func TestFaultFS_SyncFail(t *testing.T) {
+	fs := NewFaultFS(NewMemFS(), FaultConfig{SyncFailRate: 1})
+	assert.Nil(t, fs.MkdirAll("/db"))
+	file, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInjectedFault, file.Sync())
+
+	// 刷盘失败的数据在崩溃后丢失
+	assert.Nil(t, fs.Crash())
+	file2, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	size, err := file2.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+}
+
+func TestFaultFS_Crash(t *testing.T) {
+	fs := NewFaultFS(NewMemFS(), FaultConfig{})
+	assert.Nil(t, fs.MkdirAll("/db"))
+	lock, err := fs.Lock("/db/flock")
+	assert.Nil(t, err)
+	assert.NotNil(t, lock)
+	file, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+
+	_, err = file.Write([]byte("synced"))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Sync())
+	_, err = file.Write([]byte("unsynced"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, fs.Crash())
+
+	// 崩溃后旧句柄失效，锁被释放
+	_, err = file.Write([]byte("x"))
+	assert.Equal(t, ErrCrashed, err)
+	lock2, err := fs.Lock("/db/flock")
+	assert.Nil(t, err)
+	assert.Nil(t, lock2.Close())
+
+	// 只有刷盘的数据保留下来
+	file2, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	b := make([]byte, 6)
+	_, err = file2.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("synced"), b)
+	size, err := file2.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+}
+
+func TestFaultFS_TornCrash(t *testing.T) {
+	fs := NewFaultFS(NewMemFS(), FaultConfig{TornCrash: true, Seed: 7})
+	assert.Nil(t, fs.MkdirAll("/db"))
+	file, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("synced"))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Sync())
+	_, err = file.Write([]byte("unsynced"))
+	assert.Nil(t, err)
+	assert.Nil(t, fs.Crash())
+
+	file2, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	size, err := file2.Size()
+	assert.Nil(t, err)
+	assert.True(t, size >= 6 && size <= 14)
+}
+
+func TestFaultFS_ReadFlip(t *testing.T) {
+	fs := NewFaultFS(NewMemFS(), FaultConfig{ReadFlipRate: 1})
+	assert.Nil(t, fs.MkdirAll("/db"))
+	file, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("hello world"))
+	assert.Nil(t, err)
+
+	b := make([]byte, 11)
+	_, err = file.Read(b, 0)
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("hello world"), b)
+}
@@ -20,6 +20,15 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 	return &FileIO{fd: fd}, nil
 }
 
+// NewFileIOManagerReadOnly 以只读方式打开已经存在的文件，写入返回错误
+func NewFileIOManagerReadOnly(fileName string) (*FileIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd}, nil
+}
+
 func (f *FileIO) Read(bytes []byte, off int64) (int, error) {
 	return f.fd.ReadAt(bytes, off)
 }
@@ -43,3 +52,11 @@ func (f *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+func (f *FileIO) Truncate(size int64) error {
+	return f.fd.Truncate(size)
+}
+
+func (f *FileIO) Preallocate(size int64) error {
+	return preallocate(f.fd, size)
+}
@@ -3,6 +3,7 @@ package fio
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -50,3 +51,29 @@ func TestFileIO_Sync(t *testing.T) {
 	err = fio.Sync()
 	assert.Nil(t, err)
 }
+
+func TestNewFileIOManagerReadOnly(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.data")
+
+	// 文件不存在时不创建
+	_, err := NewFileIOManagerReadOnly(fileName)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(fileName)
+	assert.True(t, os.IsNotExist(err))
+
+	fio, err := NewFileIOManager(fileName)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Close())
+
+	readOnly, err := NewFileIOManagerReadOnly(fileName)
+	assert.Nil(t, err)
+	b := make([]byte, 5)
+	_, err = readOnly.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), b)
+	_, err = readOnly.Write([]byte("world"))
+	assert.NotNil(t, err)
+	assert.Nil(t, readOnly.Close())
+}
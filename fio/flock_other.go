@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package fio
+
+import "os"
+
+type fileLock struct {
+	fd *os.File
+}
+
+// lockFile 当前平台不支持 flock，只创建锁文件，不提供进程间互斥
+func lockFile(name string) (*fileLock, error) {
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, FileDataPerm)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{fd: fd}, nil
+}
+
+func (l *fileLock) Close() error {
+	return l.fd.Close()
+}
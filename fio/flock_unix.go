@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+type fileLock struct {
+	fd *os.File
+}
+
+// lockFile 使用 flock 对文件加排他锁，进程退出时由操作系统自动释放
+func lockFile(name string) (*fileLock, error) {
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, FileDataPerm)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = fd.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrFileLocked
+		}
+		return nil, err
+	}
+	return &fileLock{fd: fd}, nil
+}
+
+func (l *fileLock) Close() error {
+	if err := syscall.Flock(int(l.fd.Fd()), syscall.LOCK_UN); err != nil {
+		return err
+	}
+	return l.fd.Close()
+}
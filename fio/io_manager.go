@@ -2,7 +2,7 @@ package fio
 
 const FileDataPerm = 0644
 
-// IOManager 抽象的IO管理接口，目前只支持标准文件IO
+// IOManager 抽象的IO管理接口，具体实现由 VFS 决定
 type IOManager interface {
 	Read([]byte, int64) (int, error)
 	Write([]byte) (int, error)
@@ -10,9 +10,17 @@ type IOManager interface {
 	Sync() error
 	Close() error
 	Size() (int64, error)
+	// Truncate 将文件截断到指定长度
+	Truncate(size int64) error
 }
 
-// NewIOManager 创建IO管理对象，目前只支持fileio
+// Preallocator 支持预分配磁盘空间的 IOManager 实现该接口
+type Preallocator interface {
+	// Preallocate 为文件的前 size 个字节预分配磁盘空间，不改变文件长度
+	Preallocate(size int64) error
+}
+
+// NewIOManager 创建IO管理对象，使用标准文件IO
 func NewIOManager(fileName string) (IOManager, error) {
 	return NewFileIOManager(fileName)
 }
@@ -0,0 +1,285 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrFileClosed   = errors.New("the file is already closed")
+	ErrFileReadOnly = errors.New("the file is opened in read-only mode")
+)
+
+// MemFS 纯内存的 VFS 实现，数据不落盘，可用于测试或作为临时缓存
+type MemFS struct {
+	mu    sync.Mutex
+	dirs  map[string]struct{}
+	files map[string]*memFile
+	locks map[string]struct{}
+}
+
+// 内存中的文件内容，被删除后已打开的句柄仍然可以读写
+type memFile struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		dirs:  map[string]struct{}{"/": {}, ".": {}},
+		files: make(map[string]*memFile),
+		locks: make(map[string]struct{}),
+	}
+}
+
+func (fs *MemFS) OpenFile(name string) (IOManager, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.dirs[name]; ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+	if _, ok := fs.dirs[filepath.Dir(name)]; !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	file, ok := fs.files[name]
+	if !ok {
+		file = &memFile{}
+		fs.files[name] = file
+	}
+	return &MemFileIO{file: file}, nil
+}
+
+func (fs *MemFS) OpenReadOnly(name string) (IOManager, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &MemFileIO{file: file, readOnly: true}, nil
+}
+
+func (fs *MemFS) ReadDir(dir string) ([]string, error) {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.dirs[dir]; !ok {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: os.ErrNotExist}
+	}
+	names := make([]string, 0)
+	for name := range fs.files {
+		if filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	for name := range fs.dirs {
+		if name != dir && filepath.Dir(name) == dir {
+			names = append(names, filepath.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *MemFS) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for {
+		if _, ok := fs.files[dir]; ok {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrExist}
+		}
+		fs.dirs[dir] = struct{}{}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+func (fs *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.files[name]; ok {
+		delete(fs.files, name)
+		return nil
+	}
+	if _, ok := fs.dirs[name]; ok {
+		// 目录下还有文件或者子目录时不能删除，否则删除之后这些条目仍然留在 MemFS 中，
+		// 重新创建同名目录时会再次出现
+		for other := range fs.files {
+			if inDir(other, name) {
+				return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			}
+		}
+		for other := range fs.dirs {
+			if inDir(other, name) {
+				return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			}
+		}
+		delete(fs.dirs, name)
+		return nil
+	}
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+}
+
+// 判断 name 是否位于 dir 或者 dir 的子目录中
+func inDir(name, dir string) bool {
+	for {
+		parent := filepath.Dir(name)
+		if parent == dir {
+			return true
+		}
+		if parent == name {
+			return false
+		}
+		name = parent
+	}
+}
+
+func (fs *MemFS) Rename(oldName, newName string) error {
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, ok := fs.files[oldName]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	if _, ok := fs.dirs[filepath.Dir(newName)]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldName)
+	fs.files[newName] = file
+	return nil
+}
+
+func (fs *MemFS) Lock(name string) (io.Closer, error) {
+	name = filepath.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.locks[name]; ok {
+		return nil, ErrFileLocked
+	}
+	if _, ok := fs.dirs[filepath.Dir(name)]; !ok {
+		return nil, &os.PathError{Op: "lock", Path: name, Err: os.ErrNotExist}
+	}
+	if _, ok := fs.files[name]; !ok {
+		fs.files[name] = &memFile{}
+	}
+	fs.locks[name] = struct{}{}
+	return &memLock{fs: fs, name: name}, nil
+}
+
+type memLock struct {
+	fs   *MemFS
+	name string
+	once sync.Once
+}
+
+func (l *memLock) Close() error {
+	l.once.Do(func() {
+		l.fs.mu.Lock()
+		delete(l.fs.locks, l.name)
+		l.fs.mu.Unlock()
+	})
+	return nil
+}
+
+// MemFileIO MemFS 中打开的文件句柄，closed 和文件内容一样由 memFile 的锁保护
+type MemFileIO struct {
+	file     *memFile
+	closed   bool
+	readOnly bool
+}
+
+func (m *MemFileIO) Read(bytes []byte, off int64) (int, error) {
+	m.file.mu.RLock()
+	defer m.file.mu.RUnlock()
+	if m.closed {
+		return 0, ErrFileClosed
+	}
+	if len(bytes) == 0 {
+		return 0, nil
+	}
+	if off >= int64(len(m.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(bytes, m.file.data[off:])
+	if n < len(bytes) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *MemFileIO) Write(bytes []byte) (int, error) {
+	m.file.mu.Lock()
+	defer m.file.mu.Unlock()
+	if m.closed {
+		return 0, ErrFileClosed
+	}
+	if m.readOnly {
+		return 0, ErrFileReadOnly
+	}
+	m.file.data = append(m.file.data, bytes...)
+	return len(bytes), nil
+}
+
+func (m *MemFileIO) Sync() error {
+	m.file.mu.RLock()
+	defer m.file.mu.RUnlock()
+	if m.closed {
+		return ErrFileClosed
+	}
+	return nil
+}
+
+func (m *MemFileIO) Close() error {
+	m.file.mu.Lock()
+	defer m.file.mu.Unlock()
+	if m.closed {
+		return ErrFileClosed
+	}
+	m.closed = true
+	return nil
+}
+
+func (m *MemFileIO) Size() (int64, error) {
+	m.file.mu.RLock()
+	defer m.file.mu.RUnlock()
+	if m.closed {
+		return 0, ErrFileClosed
+	}
+	return int64(len(m.file.data)), nil
+}
+
+func (m *MemFileIO) Truncate(size int64) error {
+	m.file.mu.Lock()
+	defer m.file.mu.Unlock()
+	if m.closed {
+		return ErrFileClosed
+	}
+	if m.readOnly {
+		return ErrFileReadOnly
+	}
+	if size < int64(len(m.file.data)) {
+		m.file.data = m.file.data[:size]
+	} else {
+		m.file.data = append(m.file.data, make([]byte, size-int64(len(m.file.data)))...)
+	}
+	return nil
+}
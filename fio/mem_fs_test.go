@@ -0,0 +1,175 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestMemFS_OpenFile(t *testing.T) {
+	fs := NewMemFS()
+
+	// 父目录不存在
+	_, err := fs.OpenFile("/db/000000001.data")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, fs.MkdirAll("/db"))
+	file, err := fs.OpenFile("/db/000000001.data")
+	assert.Nil(t, err)
+	assert.NotNil(t, file)
+
+	n, err := file.Write([]byte("hello world"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+
+	// 重新打开后数据仍然存在
+	file2, err := fs.OpenFile("/db/000000001.data")
+	assert.Nil(t, err)
+	size, err := file2.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), size)
+
+	b := make([]byte, 5)
+	_, err = file2.Read(b, 6)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("world"), b)
+
+	// 读取越过文件末尾
+	n, err = file2.Read(b, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 3, n)
+
+	assert.Nil(t, file2.Close())
+	_, err = file2.Read(b, 0)
+	assert.Equal(t, ErrFileClosed, err)
+}
+
+func TestMemFS_OpenReadOnly(t *testing.T) {
+	fs := NewMemFS()
+	assert.Nil(t, fs.MkdirAll("/db"))
+
+	// 文件不存在时不创建
+	_, err := fs.OpenReadOnly("/db/000000001.data")
+	assert.True(t, os.IsNotExist(err))
+	names, err := fs.ReadDir("/db")
+	assert.Nil(t, err)
+	assert.Empty(t, names)
+
+	file, err := fs.OpenFile("/db/000000001.data")
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	readOnly, err := fs.OpenReadOnly("/db/000000001.data")
+	assert.Nil(t, err)
+	b := make([]byte, 5)
+	_, err = readOnly.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), b)
+	_, err = readOnly.Write([]byte("world"))
+	assert.Equal(t, ErrFileReadOnly, err)
+	assert.Equal(t, ErrFileReadOnly, readOnly.Truncate(0))
+	assert.Nil(t, readOnly.Close())
+	assert.Nil(t, file.Close())
+}
+
+func TestMemFS_ReadDir(t *testing.T) {
+	fs := NewMemFS()
+	_, err := fs.ReadDir("/db")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, fs.MkdirAll("/db/sub"))
+	_, err = fs.OpenFile("/db/000000002.data")
+	assert.Nil(t, err)
+	_, err = fs.OpenFile("/db/000000001.data")
+	assert.Nil(t, err)
+
+	names, err := fs.ReadDir("/db")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"000000001.data", "000000002.data", "sub"}, names)
+}
+
+func TestMemFS_RemoveAndRename(t *testing.T) {
+	fs := NewMemFS()
+	assert.Nil(t, fs.MkdirAll("/db"))
+	file, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("aaa"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, fs.Rename("/db/a", "/db/b"))
+	names, err := fs.ReadDir("/db")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b"}, names)
+
+	file2, err := fs.OpenFile("/db/b")
+	assert.Nil(t, err)
+	size, err := file2.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), size)
+
+	assert.Nil(t, fs.Remove("/db/b"))
+	assert.True(t, os.IsNotExist(fs.Remove("/db/b")))
+	names, err = fs.ReadDir("/db")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(names))
+
+	// 目录下有子目录时不能删除，删除之后重新创建的目录是空的
+	assert.Nil(t, fs.MkdirAll("/db/merge/sub"))
+	_, err = fs.OpenFile("/db/merge/sub/000000001.data")
+	assert.Nil(t, err)
+	assert.NotNil(t, fs.Remove("/db/merge"))
+	assert.NotNil(t, fs.Remove("/db/merge/sub"))
+	assert.Nil(t, fs.Remove("/db/merge/sub/000000001.data"))
+	assert.NotNil(t, fs.Remove("/db/merge"))
+	assert.Nil(t, fs.Remove("/db/merge/sub"))
+	assert.Nil(t, fs.Remove("/db/merge"))
+	assert.Nil(t, fs.MkdirAll("/db/merge"))
+	names, err = fs.ReadDir("/db/merge")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(names))
+}
+
+// 关闭句柄和读写同时进行
+func TestMemFS_ConcurrentClose(t *testing.T) {
+	fs := NewMemFS()
+	assert.Nil(t, fs.MkdirAll("/db"))
+	file, err := fs.OpenFile("/db/a")
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b := make([]byte, 5)
+		for {
+			if _, err := file.Read(b, 0); err != nil {
+				assert.Equal(t, ErrFileClosed, err)
+				return
+			}
+		}
+	}()
+	assert.Nil(t, file.Close())
+	wg.Wait()
+	assert.Equal(t, ErrFileClosed, file.Close())
+}
+
+func TestMemFS_Lock(t *testing.T) {
+	fs := NewMemFS()
+	assert.Nil(t, fs.MkdirAll("/db"))
+
+	lock, err := fs.Lock("/db/flock")
+	assert.Nil(t, err)
+
+	_, err = fs.Lock("/db/flock")
+	assert.Equal(t, ErrFileLocked, err)
+
+	assert.Nil(t, lock.Close())
+	lock2, err := fs.Lock("/db/flock")
+	assert.Nil(t, err)
+	assert.Nil(t, lock2.Close())
+}
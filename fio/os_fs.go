@@ -0,0 +1,51 @@
+package fio
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+// OSFS 基于操作系统文件系统的 VFS 实现
+type OSFS struct{}
+
+func NewOSFS() *OSFS {
+	return &OSFS{}
+}
+
+func (fs *OSFS) OpenFile(name string) (IOManager, error) {
+	return NewFileIOManager(name)
+}
+
+func (fs *OSFS) OpenReadOnly(name string) (IOManager, error) {
+	return NewFileIOManagerReadOnly(name)
+}
+
+func (fs *OSFS) ReadDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *OSFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, DirPerm)
+}
+
+func (fs *OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (fs *OSFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (fs *OSFS) Lock(name string) (io.Closer, error) {
+	return lockFile(name)
+}
@@ -0,0 +1,23 @@
+package fio
+
+import (
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_KEEP_SIZE 预分配磁盘空间，但不改变文件长度
+const fallocFlKeepSize = 0x01
+
+func preallocate(fd *os.File, size int64) error {
+	for {
+		err := syscall.Fallocate(int(fd.Fd()), fallocFlKeepSize, 0, size)
+		if err == syscall.EINTR {
+			continue
+		}
+		// 文件系统不支持 fallocate 时忽略，不影响正确性
+		if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,40 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileIO_Preallocate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bitcask-go-fio")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fio, err := NewFileIOManager(filepath.Join(dir, "a.data"))
+	assert.Nil(t, err)
+	defer fio.Close()
+
+	_, err = fio.Write([]byte("hello world"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Preallocate(1024*1024))
+
+	// 预分配不改变文件长度，追加写入的位置不受影响
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), size)
+
+	// 文件系统不支持 fallocate 时 Preallocate 不做任何操作
+	probe, err := os.Create(filepath.Join(dir, "probe"))
+	assert.Nil(t, err)
+	defer probe.Close()
+	if err := syscall.Fallocate(int(probe.Fd()), fallocFlKeepSize, 0, 4096); err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		t.Skip("fallocate is not supported by the file system")
+	}
+
+	var stat syscall.Stat_t
+	assert.Nil(t, syscall.Fstat(int(fio.fd.Fd()), &stat))
+	assert.True(t, stat.Blocks*512 >= 1024*1024)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// 其他平台不支持保持文件长度的预分配，不做任何操作
+func preallocate(fd *os.File, size int64) error {
+	return nil
+}
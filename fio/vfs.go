@@ -0,0 +1,35 @@
+package fio
+
+import (
+	"errors"
+	"io"
+)
+
+// FileLockName 数据目录锁文件名称，防止多个进程同时使用同一个目录
+const FileLockName = "flock"
+
+// DirPerm 创建目录时使用的权限
+const DirPerm = 0755
+
+var ErrFileLocked = errors.New("the file is locked by another process")
+
+// VFS 虚拟文件系统抽象，DB 对文件系统的所有操作都通过它完成
+type VFS interface {
+	// OpenFile 打开文件，文件不存在时创建
+	OpenFile(name string) (IOManager, error)
+	// OpenReadOnly 以只读方式打开已经存在的文件，不会创建文件，只读的介质上也可以使用
+	OpenReadOnly(name string) (IOManager, error)
+	// ReadDir 读取目录下所有条目的名称，按名称排序
+	ReadDir(dir string) ([]string, error)
+	// MkdirAll 递归创建目录，目录已存在时不做任何操作
+	MkdirAll(dir string) error
+	// Remove 删除文件
+	Remove(name string) error
+	// Rename 重命名文件，目标文件存在时覆盖
+	Rename(oldName, newName string) error
+	// Lock 对文件加排他锁，已被锁定时返回 ErrFileLocked，关闭返回值即释放锁
+	Lock(name string) (io.Closer, error)
+}
+
+// DefaultVFS 默认的文件系统，直接操作磁盘
+var DefaultVFS VFS = NewOSFS()
@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type statJSON struct {
+	KeyNum      int       `json:"key_num"`
+	DataFileNum int       `json:"data_file_num"`
+	DiskSize    uint64    `json:"disk_size"`
+	Cache       cacheJSON `json:"cache"`
+}
+
+type cacheJSON struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+}
+
+func (h *Handler) stat(w http.ResponseWriter) {
+	stat, err := h.db.Stat()
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	cacheStats := h.db.CacheStats()
+	writeJSON(w, http.StatusOK, &statJSON{
+		KeyNum:      stat.KeyNum,
+		DataFileNum: stat.DataFileNum,
+		DiskSize:    stat.DiskSize,
+		Cache: cacheJSON{
+			Hits:    cacheStats.Hits,
+			Misses:  cacheStats.Misses,
+			Entries: cacheStats.Entries,
+			Bytes:   cacheStats.Bytes,
+		},
+	})
+}
+
+func (h *Handler) merge(w http.ResponseWriter) {
+	if err := h.db.Merge(); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) sync(w http.ResponseWriter) {
+	if err := h.db.Sync(); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type backupJSON struct {
+	Dir string `json:"dir"`
+}
+
+// 备份到 BackupDir 下的 name 目录，name 为空时使用当前时间。目录已经存在时返回 409，避免和旧的备份混在一起
+func (h *Handler) backup(w http.ResponseWriter, r *http.Request) {
+	if h.options.BackupDir == "" {
+		writeError(w, http.StatusForbidden, errors.New("backup is disabled"))
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "backup-" + time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		writeError(w, http.StatusBadRequest, errors.New("invalid backup name"))
+		return
+	}
+	dir := filepath.Join(h.options.BackupDir, name)
+	if _, err := os.Lstat(dir); err == nil {
+		writeError(w, http.StatusConflict, errors.New("backup already exists"))
+		return
+	}
+	if err := h.db.Backup(dir); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, &backupJSON{Dir: dir})
+}
@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ETag 由合并代数和记录所在的文件、偏移量生成，key 每次写入都会改变。
+// 合并会复用文件编号，只有位置时合并之后的记录可能和之前的 ETag 相同，因此带上合并代数。
+// 合并之后 ETag 全部改变，此时条件请求会失败，客户端需要重新读取
+func formatETag(generation uint32, pos *data.LogRecordPos) string {
+	return fmt.Sprintf(`"%x-%x-%x"`, generation, pos.Fid, pos.Offset)
+}
+
+// checkPreconditions 按 RFC 7232 检查 If-Match 和 If-None-Match，pos 为 key 当前记录的位置，不存在时为空。
+// 条件满足时返回 0，否则返回应答的状态码
+func checkPreconditions(r *http.Request, generation uint32, pos *data.LogRecordPos) int {
+	etag := ""
+	if pos != nil {
+		etag = formatETag(generation, pos)
+	}
+	if header := r.Header.Get("If-Match"); header != "" {
+		if pos == nil || !matchETag(header, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if pos != nil && matchETag(header, etag, true) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	}
+	return 0
+}
+
+// header 为 * 或逗号分隔的 ETag 列表。弱比较时忽略 W/ 前缀，强比较时弱 ETag 不匹配
+func matchETag(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,334 @@
+// Package httpapi 通过 HTTP/JSON 接口对外提供 bitcask 数据库的读写和运维操作，可以嵌入到其他服务中
+//
+//	GET/HEAD/PUT/DELETE /kv/{key}          读写单个 key
+//	GET /kv?prefix=&cursor=&limit=         按 key 的顺序分页列出 key
+//	GET /admin/stat                        统计信息
+//	POST /admin/merge、/admin/sync         合并数据文件、刷盘
+//	POST /admin/backup?name=               备份到 Options.BackupDir 下的 name 目录
+//
+// value 默认以 application/octet-stream 原样传输；请求的 Content-Type 或 Accept 为 application/json 时，
+// 使用 {"value": "<base64>"} 格式。ETag 由 key 当前记录的位置生成，支持 If-Match 和 If-None-Match 条件请求
+package httpapi
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/data"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 列表默认和最多返回的 key 数量
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type Options struct {
+	// PUT 请求体的最大长度，0 表示使用默认值
+	MaxValueSize int64
+
+	// 备份的根目录，/admin/backup 在其下创建备份目录，为空时不允许备份
+	BackupDir string
+}
+
+var DefaultOptions = &Options{
+	MaxValueSize: 64 * 1024 * 1024,
+}
+
+// Handler 将 HTTP 请求映射到一个 DB 上，DB 的打开和关闭由调用方负责
+type Handler struct {
+	db      *bitcask_go.DB
+	options Options
+}
+
+// NewHandler 创建 Handler，options 为空时使用 DefaultOptions
+func NewHandler(db *bitcask_go.DB, options *Options) *Handler {
+	if options == nil {
+		options = DefaultOptions
+	}
+	h := &Handler{db: db, options: *options}
+	if h.options.MaxValueSize <= 0 {
+		h.options.MaxValueSize = DefaultOptions.MaxValueSize
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch p := r.URL.Path; {
+	case p == "/kv":
+		if allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			h.list(w, r)
+		}
+	case strings.HasPrefix(p, "/kv/"):
+		key := []byte(strings.TrimPrefix(p, "/kv/"))
+		if len(key) == 0 {
+			writeError(w, http.StatusBadRequest, bitcask_go.ErrKeyIsNilOrEmpty)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.get(w, r, key)
+		case http.MethodPut:
+			h.put(w, r, key)
+		case http.MethodDelete:
+			h.delete(w, r, key)
+		default:
+			allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+		}
+	case p == "/admin/stat":
+		if allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			h.stat(w)
+		}
+	case p == "/admin/merge":
+		if allowMethods(w, r, http.MethodPost) {
+			h.merge(w)
+		}
+	case p == "/admin/sync":
+		if allowMethods(w, r, http.MethodPost) {
+			h.sync(w)
+		}
+	case p == "/admin/backup":
+		if allowMethods(w, r, http.MethodPost) {
+			h.backup(w, r)
+		}
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+type valueJSON struct {
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value"`
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, key []byte) {
+	// 先读取合并代数，读取期间发生合并时 ETag 只会失效，不会和之后的记录相同
+	generation := h.db.MergeGeneration()
+	value, pos, err := h.db.GetWithPosition(key)
+	if err != nil && err != bitcask_go.ErrReadKeyNotFound {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if status := checkPreconditions(r, generation, pos); status != 0 {
+		if pos != nil {
+			w.Header().Set("ETag", formatETag(generation, pos))
+		}
+		writeStatus(w, status)
+		return
+	}
+	if pos == nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("ETag", formatETag(generation, pos))
+	if acceptsJSON(r) {
+		writeJSON(w, http.StatusOK, &valueJSON{Key: key, Value: value})
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(value)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, key []byte) {
+	// 多读一个字节判断请求体是否超过限制
+	value, err := io.ReadAll(io.LimitReader(r.Body, h.options.MaxValueSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if int64(len(value)) > h.options.MaxValueSize {
+		writeError(w, http.StatusRequestEntityTooLarge, errors.New("request body too large"))
+		return
+	}
+	if isJSON(r.Header.Get("Content-Type")) {
+		body := &valueJSON{}
+		if err := json.Unmarshal(value, body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		value = body.Value
+	}
+
+	var status int
+	var generation uint32
+	created := false
+	pos, err := h.db.PutIf(key, value, func(pos *data.LogRecordPos) bool {
+		// cond 在写锁内执行，合并代数和位置一致，写入之后也不会改变
+		generation = h.db.MergeGeneration()
+		created = pos == nil
+		status = checkPreconditions(r, generation, pos)
+		return status == 0
+	})
+	if err == bitcask_go.ErrConditionFailed {
+		writeStatus(w, status)
+		return
+	}
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.Header().Set("ETag", formatETag(generation, pos))
+	if created {
+		writeStatus(w, http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, key []byte) {
+	var status int
+	found := false
+	err := h.db.DeleteIf(key, func(pos *data.LogRecordPos) bool {
+		found = pos != nil
+		status = checkPreconditions(r, h.db.MergeGeneration(), pos)
+		return status == 0
+	})
+	if err == bitcask_go.ErrConditionFailed {
+		writeStatus(w, status)
+		return
+	}
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, bitcask_go.ErrReadKeyNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listJSON struct {
+	Items      []*itemJSON `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+type itemJSON struct {
+	Key  []byte `json:"key"`
+	ETag string `json:"etag"`
+}
+
+// 列出以 prefix 开头的 key，cursor 为上一页返回的 next_cursor，没有 next_cursor 时说明已经是最后一页
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := []byte(query.Get("prefix"))
+	var start []byte
+	if cursor := query.Get("cursor"); cursor != "" {
+		last, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid cursor"))
+			return
+		}
+		// 从上一页最后一个 key 之后开始
+		start = append(last, 0)
+	}
+	limit := defaultListLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxListLimit {
+			writeError(w, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(maxListLimit)))
+			return
+		}
+		limit = n
+	}
+
+	result := &listJSON{Items: []*itemJSON{}}
+	more := false
+	generation := h.db.MergeGeneration()
+	h.db.ScanKeys(prefix, start, func(key []byte, pos *data.LogRecordPos) bool {
+		if len(result.Items) == limit {
+			more = true
+			return false
+		}
+		result.Items = append(result.Items, &itemJSON{Key: append([]byte(nil), key...), ETag: formatETag(generation, pos)})
+		return true
+	})
+	if more {
+		result.NextCursor = base64.RawURLEncoding.EncodeToString(result.Items[limit-1].Key)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// 检查请求方法，不允许时返回 405
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// Accept 中 application/json 出现在 application/octet-stream 之前时返回 JSON
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+			switch mediaType {
+			case "application/json":
+				return true
+			case "application/octet-stream":
+				return false
+			}
+		}
+	}
+	return false
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+func statusOf(err error) int {
+	switch err {
+	case bitcask_go.ErrKeyIsNilOrEmpty:
+		return http.StatusBadRequest
+	case bitcask_go.ErrReadKeyNotFound:
+		return http.StatusNotFound
+	case bitcask_go.ErrDBReadOnly:
+		return http.StatusForbidden
+	case bitcask_go.ErrMergeIsProgress, bitcask_go.ErrBackupDirNotEmpty:
+		return http.StatusConflict
+	case bitcask_go.ErrConditionFailed:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorJSON{Error: err.Error()})
+}
+
+// 写入没有数据的状态码，304 不能带响应体，其余返回状态描述
+func writeStatus(w http.ResponseWriter, status int) {
+	if status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return
+	}
+	writeError(w, status, errors.New(strings.ToLower(http.StatusText(status))))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
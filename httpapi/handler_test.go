@@ -0,0 +1,269 @@
+package httpapi
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/fio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func startHandler(t *testing.T, options *Options) (*bitcask_go.DB, *httptest.Server) {
+	opts := *bitcask_go.DefaultOptions
+	opts.DBFileDir = "/bitcask-go-http"
+	opts.FS = fio.NewMemFS()
+	db, err := bitcask_go.Start(&opts)
+	assert.Nil(t, err)
+	ts := httptest.NewServer(NewHandler(db, options))
+	t.Cleanup(func() {
+		ts.Close()
+		_ = db.Close()
+	})
+	return db, ts
+}
+
+func doRequest(t *testing.T, method, url string, body []byte, headers ...string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	assert.Nil(t, err)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp, data
+}
+
+func TestHandler_KV(t *testing.T) {
+	db, ts := startHandler(t, nil)
+
+	resp, _ := doRequest(t, http.MethodGet, ts.URL+"/kv/missing", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// 二进制数据原样写入和读出
+	value := []byte{0, 1, 2, 0xff, '\n'}
+	resp, _ = doRequest(t, http.MethodPut, ts.URL+"/kv/a%2Fb", value, "Content-Type", "application/octet-stream")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+	stored, err := db.Get([]byte("a/b"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, stored)
+
+	resp, body := doRequest(t, http.MethodGet, ts.URL+"/kv/a%2Fb", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Equal(t, value, body)
+
+	// JSON 格式的 value 按 base64 编码
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/kv/a%2Fb", nil, "Accept", "application/json")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := &valueJSON{}
+	assert.Nil(t, json.Unmarshal(body, result))
+	assert.Equal(t, []byte("a/b"), result.Key)
+	assert.Equal(t, value, result.Value)
+
+	jsonBody := fmt.Sprintf(`{"value": %q}`, base64.StdEncoding.EncodeToString([]byte("new")))
+	resp, _ = doRequest(t, http.MethodPut, ts.URL+"/kv/a%2Fb", []byte(jsonBody), "Content-Type", "application/json; charset=utf-8")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/kv/a%2Fb", nil, "Accept", "application/octet-stream, application/json")
+	assert.Equal(t, []byte("new"), body)
+	resp, _ = doRequest(t, http.MethodPut, ts.URL+"/kv/a%2Fb", []byte("{"), "Content-Type", "application/json")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = doRequest(t, http.MethodHead, ts.URL+"/kv/a%2Fb", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("Content-Length"))
+	assert.Equal(t, 0, len(body))
+
+	resp, _ = doRequest(t, http.MethodDelete, ts.URL+"/kv/a%2Fb", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodDelete, ts.URL+"/kv/a%2Fb", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodPut, ts.URL+"/kv/", []byte("v"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/kv/k", []byte("v"))
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD, PUT, DELETE", resp.Header.Get("Allow"))
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandler_Conditional(t *testing.T) {
+	_, ts := startHandler(t, nil)
+	url := ts.URL + "/kv/k"
+
+	// If-None-Match: * 只在 key 不存在时写入
+	resp, _ := doRequest(t, http.MethodPut, url, []byte("v1"), "If-None-Match", "*")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	etag1 := resp.Header.Get("ETag")
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v2"), "If-None-Match", "*")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, body := doRequest(t, http.MethodGet, url, nil, "If-None-Match", `"other", W/`+etag1)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag1, resp.Header.Get("ETag"))
+	assert.Equal(t, 0, len(body))
+	resp, _ = doRequest(t, http.MethodGet, url, nil, "If-None-Match", `"other"`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// If-Match 只在 ETag 匹配时写入，弱 ETag 不匹配
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v2"), "If-Match", "W/"+etag1)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v2"), "If-Match", etag1)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	etag2 := resp.Header.Get("ETag")
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v3"), "If-Match", etag1)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodGet, url, nil, "If-Match", etag1)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = doRequest(t, http.MethodDelete, url, nil, "If-Match", etag1)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodDelete, url, nil, "If-Match", etag2)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v"), "If-Match", "*")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+}
+
+func TestHandler_ConditionalAfterMerge(t *testing.T) {
+	db, ts := startHandler(t, nil)
+	url := ts.URL + "/kv/k"
+
+	resp, _ := doRequest(t, http.MethodPut, url, []byte("v1"))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Nil(t, db.Merge())
+	resp, _ = doRequest(t, http.MethodGet, url, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	_, before, err := db.GetWithPosition([]byte("k"))
+	assert.Nil(t, err)
+
+	// 读取之后 key 被覆盖，合并把新的记录写到了旧记录原来的位置
+	assert.Nil(t, db.Put([]byte("k"), []byte("v2")))
+	assert.Nil(t, db.Merge())
+	_, after, err := db.GetWithPosition([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, before.Fid, after.Fid)
+	assert.Equal(t, before.Offset, after.Offset)
+
+	resp, _ = doRequest(t, http.MethodPut, url, []byte("v3"), "If-Match", etag)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodGet, url, nil, "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	value, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+}
+
+func TestHandler_List(t *testing.T) {
+	db, ts := startHandler(t, nil)
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("user:%02d", i)), []byte("v")))
+	}
+	assert.Nil(t, db.Put([]byte("other"), []byte("v")))
+
+	var keys []string
+	cursor := ""
+	for pages := 1; ; pages++ {
+		resp, body := doRequest(t, http.MethodGet, ts.URL+"/kv?prefix=user:&limit=10&cursor="+cursor, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		result := &listJSON{}
+		assert.Nil(t, json.Unmarshal(body, result))
+		for _, item := range result.Items {
+			keys = append(keys, string(item.Key))
+			_, pos, err := db.GetWithPosition(item.Key)
+			assert.Nil(t, err)
+			assert.Equal(t, formatETag(db.MergeGeneration(), pos), item.ETag)
+		}
+		if result.NextCursor == "" {
+			assert.Equal(t, 3, pages)
+			break
+		}
+		cursor = result.NextCursor
+	}
+	assert.Equal(t, 25, len(keys))
+	assert.Equal(t, "user:00", keys[0])
+	assert.Equal(t, "user:24", keys[24])
+
+	resp, body := doRequest(t, http.MethodGet, ts.URL+"/kv?prefix=none", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "{\"items\":[]}\n", string(body))
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/kv?limit=0", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/kv?cursor=!!", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHandler_Admin(t *testing.T) {
+	opts := *bitcask_go.DefaultOptions
+	opts.DBFileDir = t.TempDir()
+	db, err := bitcask_go.Start(&opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	backupDir := t.TempDir()
+	ts := httptest.NewServer(NewHandler(db, &Options{BackupDir: backupDir, MaxValueSize: 8}))
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, _ := doRequest(t, http.MethodPut, ts.URL+"/kv/k", []byte("value"))
+		assert.True(t, resp.StatusCode < 300)
+	}
+	resp, _ := doRequest(t, http.MethodPut, ts.URL+"/kv/big", []byte("too large value"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp, body := doRequest(t, http.MethodGet, ts.URL+"/admin/stat", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	stat := &statJSON{}
+	assert.Nil(t, json.Unmarshal(body, stat))
+	assert.Equal(t, 1, stat.KeyNum)
+	diskSize := stat.DiskSize
+
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/admin/merge", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/admin/merge", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, body = doRequest(t, http.MethodGet, ts.URL+"/admin/stat", nil)
+	assert.Nil(t, json.Unmarshal(body, stat))
+	assert.True(t, stat.DiskSize < diskSize)
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/admin/sync", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/admin/backup?name=b1", nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	backup := &backupJSON{}
+	assert.Nil(t, json.Unmarshal(body, backup))
+	assert.Equal(t, filepath.Join(backupDir, "b1"), backup.Dir)
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/admin/backup?name=b1", nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodPost, ts.URL+"/admin/backup?name=..", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	backupOpts := *bitcask_go.DefaultOptions
+	backupOpts.DBFileDir = backup.Dir
+	backupOpts.ReadOnly = true
+	backupDB, err := bitcask_go.Start(&backupOpts)
+	assert.Nil(t, err)
+	value, err := backupDB.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	assert.Nil(t, backupDB.Close())
+
+	// 没有配置备份目录时不允许备份
+	ts2 := httptest.NewServer(NewHandler(db, nil))
+	defer ts2.Close()
+	resp, _ = doRequest(t, http.MethodPost, ts2.URL+"/admin/backup", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
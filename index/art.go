@@ -0,0 +1,540 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// AdaptiveRadixTree 自适应基数树(Adaptive Radix Tree)索引
+// 相同前缀只存储一次(路径压缩)，内部节点根据子节点数量在 4/16/48/256 四种规格间切换，
+// 适合 key 有较长公共前缀的场景
+type AdaptiveRadixTree struct {
+	root *artNode
+	size int
+	lock *sync.RWMutex
+}
+
+const (
+	artLeaf uint8 = iota
+	artNode4
+	artNode16
+	artNode48
+	artNode256
+)
+
+// artNode 树节点，叶子节点保存完整的 key 和位置，内部节点保存压缩后的前缀和子节点
+type artNode struct {
+	kind uint8
+
+	// 叶子节点
+	key []byte
+	pos *data.LogRecordPos
+
+	// 内部节点
+	prefix      []byte     // 压缩的公共前缀
+	term        *artNode   // 恰好在该节点结束的 key 对应的叶子，它比所有子节点都小
+	numChildren int        // 子节点数量
+	keys        []byte     // node4/node16: 有序的子节点字节；node48: 字节 -> 子节点下标+1，0 表示不存在
+	children    []*artNode // 子节点
+}
+
+func NewART() *AdaptiveRadixTree {
+	return &AdaptiveRadixTree{
+		lock: new(sync.RWMutex),
+	}
+}
+
+func (a *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if artInsert(&a.root, key, pos, 0) {
+		a.size++
+	}
+	return true
+}
+
+func (a *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	leaf := a.search(key)
+	if leaf == nil {
+		return nil
+	}
+	return leaf.pos
+}
+
+func (a *AdaptiveRadixTree) Delete(key []byte) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !artDelete(&a.root, key, 0) {
+		return false
+	}
+	a.size--
+	return true
+}
+
+// IsExist 判断key是否已经存在
+func (a *AdaptiveRadixTree) IsExist(key []byte) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.search(key) != nil
+}
+
+func (a *AdaptiveRadixTree) Size() int {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.size
+}
+
+func (a *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	if a == nil {
+		return nil
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return newARTIterator(a.root, a.size, reverse)
+}
+
+func (a *AdaptiveRadixTree) search(key []byte) *artNode {
+	n := a.root
+	depth := 0
+	for n != nil {
+		if n.kind == artLeaf {
+			if bytes.Equal(n.key, key) {
+				return n
+			}
+			return nil
+		}
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return nil
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			return n.term
+		}
+		child := n.findChild(key[depth])
+		if child == nil {
+			return nil
+		}
+		n = *child
+		depth++
+	}
+	return nil
+}
+
+func newARTLeaf(key []byte, pos *data.LogRecordPos) *artNode {
+	return &artNode{kind: artLeaf, key: key, pos: pos}
+}
+
+func newARTNode4() *artNode {
+	return &artNode{
+		kind:     artNode4,
+		keys:     make([]byte, 0, 4),
+		children: make([]*artNode, 0, 4),
+	}
+}
+
+// 插入 key，返回是否新增了 key
+func artInsert(ref **artNode, key []byte, pos *data.LogRecordPos, depth int) bool {
+	n := *ref
+	if n == nil {
+		*ref = newARTLeaf(key, pos)
+		return true
+	}
+
+	if n.kind == artLeaf {
+		// 叶子节点不可变，迭代器可以在不加锁的情况下读取
+		if bytes.Equal(n.key, key) {
+			*ref = newARTLeaf(key, pos)
+			return false
+		}
+		// 叶子节点分裂为内部节点，公共部分作为前缀
+		common := longestCommonPrefix(n.key[depth:], key[depth:])
+		node := newARTNode4()
+		node.prefix = append([]byte(nil), key[depth:depth+common]...)
+		depth += common
+		node.addLeaf(n, depth)
+		node.addLeaf(newARTLeaf(key, pos), depth)
+		*ref = node
+		return true
+	}
+
+	// 前缀不匹配，在不匹配的位置拆分出新的内部节点
+	common := longestCommonPrefix(n.prefix, key[depth:])
+	if common < len(n.prefix) {
+		node := newARTNode4()
+		node.prefix = append([]byte(nil), n.prefix[:common]...)
+		c := n.prefix[common]
+		n.prefix = append([]byte(nil), n.prefix[common+1:]...)
+		node.addChild(c, n)
+		node.addLeaf(newARTLeaf(key, pos), depth+common)
+		*ref = node
+		return true
+	}
+
+	depth += len(n.prefix)
+	if depth == len(key) {
+		exist := n.term != nil
+		n.term = newARTLeaf(key, pos)
+		return !exist
+	}
+
+	if child := n.findChild(key[depth]); child != nil {
+		return artInsert(child, key, pos, depth+1)
+	}
+	n.addChild(key[depth], newARTLeaf(key, pos))
+	return true
+}
+
+// 删除 key，返回 key 是否存在
+func artDelete(ref **artNode, key []byte, depth int) bool {
+	n := *ref
+	if n == nil {
+		return false
+	}
+	if n.kind == artLeaf {
+		if !bytes.Equal(n.key, key) {
+			return false
+		}
+		*ref = nil
+		return true
+	}
+
+	if !bytes.HasPrefix(key[depth:], n.prefix) {
+		return false
+	}
+	depth += len(n.prefix)
+	if depth == len(key) {
+		if n.term == nil {
+			return false
+		}
+		n.term = nil
+	} else {
+		child := n.findChild(key[depth])
+		if child == nil || !artDelete(child, key, depth+1) {
+			return false
+		}
+		if *child == nil {
+			n.removeChild(key[depth])
+		}
+	}
+	*ref = n.compact()
+	return true
+}
+
+// 删除之后压缩节点：没有子节点时退化为叶子，只有一个子节点时与子节点合并
+func (n *artNode) compact() *artNode {
+	switch {
+	case n.numChildren == 0:
+		return n.term
+	case n.numChildren == 1 && n.term == nil:
+		var c byte
+		var child *artNode
+		n.ascendChildren(func(b byte, node *artNode) bool {
+			c, child = b, node
+			return false
+		})
+		if child.kind == artLeaf {
+			return child
+		}
+		prefix := make([]byte, 0, len(n.prefix)+1+len(child.prefix))
+		prefix = append(prefix, n.prefix...)
+		prefix = append(prefix, c)
+		child.prefix = append(prefix, child.prefix...)
+		return child
+	}
+	n.shrink()
+	return n
+}
+
+// 将叶子挂到内部节点下，depth 为该内部节点前缀之后的位置
+func (n *artNode) addLeaf(leaf *artNode, depth int) {
+	if len(leaf.key) == depth {
+		n.term = leaf
+	} else {
+		n.addChild(leaf.key[depth], leaf)
+	}
+}
+
+// 查找子节点，返回子节点所在的槽位，不存在返回 nil
+func (n *artNode) findChild(c byte) **artNode {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i, k := range n.keys {
+			if k == c {
+				return &n.children[i]
+			}
+		}
+	case artNode48:
+		if idx := n.keys[c]; idx > 0 {
+			return &n.children[idx-1]
+		}
+	case artNode256:
+		if n.children[c] != nil {
+			return &n.children[c]
+		}
+	}
+	return nil
+}
+
+func (n *artNode) addChild(c byte, child *artNode) {
+	switch n.kind {
+	case artNode4, artNode16:
+		if len(n.keys) == cap(n.keys) {
+			n.grow()
+			n.addChild(c, child)
+			return
+		}
+		i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i] > c })
+		n.keys = append(n.keys, 0)
+		n.children = append(n.children, nil)
+		copy(n.keys[i+1:], n.keys[i:])
+		copy(n.children[i+1:], n.children[i:])
+		n.keys[i] = c
+		n.children[i] = child
+	case artNode48:
+		if n.numChildren == 48 {
+			n.grow()
+			n.addChild(c, child)
+			return
+		}
+		for i, slot := range n.children {
+			if slot == nil {
+				n.children[i] = child
+				n.keys[c] = byte(i + 1)
+				break
+			}
+		}
+	case artNode256:
+		n.children[c] = child
+	}
+	n.numChildren++
+}
+
+func (n *artNode) removeChild(c byte) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i, k := range n.keys {
+			if k == c {
+				n.keys = append(n.keys[:i], n.keys[i+1:]...)
+				n.children = append(n.children[:i], n.children[i+1:]...)
+				n.children[len(n.children):cap(n.children)][0] = nil
+				break
+			}
+		}
+	case artNode48:
+		n.children[n.keys[c]-1] = nil
+		n.keys[c] = 0
+	case artNode256:
+		n.children[c] = nil
+	}
+	n.numChildren--
+}
+
+// 节点已满，升级为更大规格的节点
+func (n *artNode) grow() {
+	switch n.kind {
+	case artNode4:
+		keys := make([]byte, len(n.keys), 16)
+		children := make([]*artNode, len(n.children), 16)
+		copy(keys, n.keys)
+		copy(children, n.children)
+		n.kind, n.keys, n.children = artNode16, keys, children
+	case artNode16:
+		keys := make([]byte, 256)
+		children := make([]*artNode, 48)
+		for i, c := range n.keys {
+			keys[c] = byte(i + 1)
+			children[i] = n.children[i]
+		}
+		n.kind, n.keys, n.children = artNode48, keys, children
+	case artNode48:
+		children := make([]*artNode, 256)
+		for c, idx := range n.keys {
+			if idx > 0 {
+				children[c] = n.children[idx-1]
+			}
+		}
+		n.kind, n.keys, n.children = artNode256, nil, children
+	}
+}
+
+// 子节点数量过少时降级为更小规格的节点，节省内存
+func (n *artNode) shrink() {
+	var kind uint8
+	var size int
+	switch {
+	case n.kind == artNode256 && n.numChildren <= 37:
+		kind = artNode48
+	case n.kind == artNode48 && n.numChildren <= 12:
+		kind, size = artNode16, 16
+	case n.kind == artNode16 && n.numChildren <= 3:
+		kind, size = artNode4, 4
+	default:
+		return
+	}
+
+	if kind == artNode48 {
+		keys := make([]byte, 256)
+		children := make([]*artNode, 48)
+		i := 0
+		n.ascendChildren(func(c byte, child *artNode) bool {
+			keys[c] = byte(i + 1)
+			children[i] = child
+			i++
+			return true
+		})
+		n.kind, n.keys, n.children = kind, keys, children
+		return
+	}
+	keys := make([]byte, 0, size)
+	children := make([]*artNode, 0, size)
+	n.ascendChildren(func(c byte, child *artNode) bool {
+		keys = append(keys, c)
+		children = append(children, child)
+		return true
+	})
+	n.kind, n.keys, n.children = kind, keys, children
+}
+
+// 按字节升序遍历子节点，fn 返回 false 时终止
+func (n *artNode) ascendChildren(fn func(c byte, child *artNode) bool) bool {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i, c := range n.keys {
+			if !fn(c, n.children[i]) {
+				return false
+			}
+		}
+	case artNode48:
+		for c, idx := range n.keys {
+			if idx > 0 && !fn(byte(c), n.children[idx-1]) {
+				return false
+			}
+		}
+	case artNode256:
+		for c, child := range n.children {
+			if child != nil && !fn(byte(c), child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 按字节降序遍历子节点，fn 返回 false 时终止
+func (n *artNode) descendChildren(fn func(c byte, child *artNode) bool) bool {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := len(n.keys) - 1; i >= 0; i-- {
+			if !fn(n.keys[i], n.children[i]) {
+				return false
+			}
+		}
+	case artNode48:
+		for c := 255; c >= 0; c-- {
+			if idx := n.keys[c]; idx > 0 && !fn(byte(c), n.children[idx-1]) {
+				return false
+			}
+		}
+	case artNode256:
+		for c := 255; c >= 0; c-- {
+			if child := n.children[c]; child != nil && !fn(byte(c), child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 按 key 的顺序遍历所有叶子
+func (n *artNode) walk(reverse bool, fn func(leaf *artNode)) {
+	if n == nil {
+		return
+	}
+	if n.kind == artLeaf {
+		fn(n)
+		return
+	}
+	visit := func(c byte, child *artNode) bool {
+		child.walk(reverse, fn)
+		return true
+	}
+	if !reverse {
+		if n.term != nil {
+			fn(n.term)
+		}
+		n.ascendChildren(visit)
+	} else {
+		n.descendChildren(visit)
+		if n.term != nil {
+			fn(n.term)
+		}
+	}
+}
+
+func longestCommonPrefix(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// ARTIterator ART 索引迭代器，创建时按序保存所有的叶子节点
+type ARTIterator struct {
+	reverse bool       // 是否反向遍历
+	index   int        // 当前遍历到的位置
+	leaves  []*artNode // 有序结果集
+}
+
+func newARTIterator(root *artNode, size int, reverse bool) *ARTIterator {
+	leaves := make([]*artNode, 0, size)
+	root.walk(reverse, func(leaf *artNode) {
+		leaves = append(leaves, leaf)
+	})
+	return &ARTIterator{
+		reverse: reverse,
+		leaves:  leaves,
+	}
+}
+
+func (it *ARTIterator) Rewind() {
+	it.index = 0
+}
+
+// Seek 二分查找第一个大于(反向时为小于)等于 key 的位置，
+// 由于相同前缀的 key 相邻，Seek 到前缀后顺序遍历即可得到该前缀下的所有 key
+func (it *ARTIterator) Seek(key []byte) {
+	if !it.reverse {
+		it.index = sort.Search(len(it.leaves), func(i int) bool {
+			return bytes.Compare(it.leaves[i].key, key) >= 0
+		})
+	} else {
+		it.index = sort.Search(len(it.leaves), func(i int) bool {
+			return bytes.Compare(it.leaves[i].key, key) <= 0
+		})
+	}
+}
+
+func (it *ARTIterator) Next() {
+	it.index++
+}
+
+func (it *ARTIterator) Valid() bool {
+	return it.index < len(it.leaves)
+}
+
+func (it *ARTIterator) Key() []byte {
+	return it.leaves[it.index].key
+}
+
+func (it *ARTIterator) Value() *data.LogRecordPos {
+	return it.leaves[it.index].pos
+}
+
+func (it *ARTIterator) Close() {
+	it.leaves = nil
+}
@@ -0,0 +1,146 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestART_Put(t *testing.T) {
+	art := NewART()
+
+	res := art.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	assert.True(t, res)
+
+	res = art.Put([]byte("iii"), &data.LogRecordPos{Fid: 2, Offset: 22})
+	assert.True(t, res)
+}
+
+func TestART_Get(t *testing.T) {
+	art := NewART()
+
+	art.Put([]byte("bitcask"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	art.Put([]byte("bitcask-go"), &data.LogRecordPos{Fid: 2, Offset: 22})
+	art.Put([]byte("bit"), &data.LogRecordPos{Fid: 3, Offset: 33})
+
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 11}, art.Get([]byte("bitcask")))
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 22}, art.Get([]byte("bitcask-go")))
+	assert.Equal(t, &data.LogRecordPos{Fid: 3, Offset: 33}, art.Get([]byte("bit")))
+	assert.Nil(t, art.Get([]byte("bitc")))
+	assert.Nil(t, art.Get([]byte("bitcask-")))
+	assert.Nil(t, art.Get([]byte("unknown")))
+
+	// 覆盖已存在的 key
+	art.Put([]byte("bitcask"), &data.LogRecordPos{Fid: 4, Offset: 44})
+	assert.Equal(t, &data.LogRecordPos{Fid: 4, Offset: 44}, art.Get([]byte("bitcask")))
+	assert.Equal(t, 3, art.size)
+}
+
+func TestART_Delete(t *testing.T) {
+	art := NewART()
+
+	art.Put([]byte("bitcask"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	art.Put([]byte("bitcask-go"), &data.LogRecordPos{Fid: 2, Offset: 22})
+
+	assert.False(t, art.Delete([]byte("bit")))
+	assert.True(t, art.Delete([]byte("bitcask")))
+	assert.False(t, art.Delete([]byte("bitcask")))
+	assert.Nil(t, art.Get([]byte("bitcask")))
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 22}, art.Get([]byte("bitcask-go")))
+
+	assert.True(t, art.Delete([]byte("bitcask-go")))
+	assert.Nil(t, art.root)
+	assert.Equal(t, 0, art.size)
+}
+
+// 与排序后的 map 对比，覆盖节点升级、降级和路径压缩
+func TestART_Random(t *testing.T) {
+	art := NewART()
+	expected := make(map[string]*data.LogRecordPos)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := utils.GetTestKey(rnd.Intn(5000))
+		if rnd.Intn(3) == 0 {
+			key = key[:rnd.Intn(len(key))+1]
+		}
+		if rnd.Intn(4) == 0 {
+			_, ok := expected[string(key)]
+			assert.Equal(t, ok, art.Delete(key))
+			delete(expected, string(key))
+		} else {
+			pos := &data.LogRecordPos{Fid: uint32(i), Offset: uint64(i)}
+			art.Put(key, pos)
+			expected[string(key)] = pos
+		}
+	}
+	assert.Equal(t, len(expected), art.size)
+
+	keys := make([]string, 0, len(expected))
+	for key, pos := range expected {
+		keys = append(keys, key)
+		assert.Equal(t, pos, art.Get([]byte(key)))
+	}
+	sort.Strings(keys)
+
+	iter := art.Iterator(false)
+	i := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, keys[i], string(iter.Key()))
+		i++
+	}
+	assert.Equal(t, len(keys), i)
+
+	iter = art.Iterator(true)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		i--
+		assert.Equal(t, keys[i], string(iter.Key()))
+	}
+}
+
+func TestART_Iterator(t *testing.T) {
+	art := NewART()
+	// 1.ART 为空的情况
+	iter1 := art.Iterator(false)
+	assert.Equal(t, false, iter1.Valid())
+
+	// 2.有多条数据
+	art.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("acee"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("eede"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("bbcd"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Put([]byte("bb"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	var keys [][]byte
+	iter2 := art.Iterator(false)
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		keys = append(keys, iter2.Key())
+	}
+	assert.True(t, sort.SliceIsSorted(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	}))
+	assert.Equal(t, 5, len(keys))
+
+	// 3.测试 seek
+	iter3 := art.Iterator(false)
+	iter3.Seek([]byte("bb"))
+	assert.Equal(t, []byte("bb"), iter3.Key())
+	iter3.Next()
+	assert.Equal(t, []byte("bbcd"), iter3.Key())
+	iter3.Seek([]byte("cc"))
+	assert.Equal(t, []byte("ccde"), iter3.Key())
+	iter3.Seek([]byte("zz"))
+	assert.False(t, iter3.Valid())
+
+	// 4.反向遍历的 seek
+	iter4 := art.Iterator(true)
+	iter4.Seek([]byte("cc"))
+	assert.Equal(t, []byte("bbcd"), iter4.Key())
+	iter4.Seek([]byte("zz"))
+	assert.Equal(t, []byte("eede"), iter4.Key())
+	iter4.Seek([]byte("a"))
+	assert.False(t, iter4.Valid())
+}
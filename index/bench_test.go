@@ -0,0 +1,198 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"testing"
+)
+
+// 索引对比基准测试，key 具有较长的公共前缀，例如 bitcask-go-000000001
+
+const benchKeyNum = 100000
+
+// 基于内存文件系统的 B+树索引，缓存只能容纳部分节点
+func newBenchBPlusTree(b *testing.B) *BPlusTree {
+	fs := fio.NewMemFS()
+	if err := fs.MkdirAll("/bench"); err != nil {
+		b.Fatal(err)
+	}
+	tree, err := NewBPlusTree(fs, "/bench", 256)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return tree
+}
+
+func benchmarkIndexPut(b *testing.B, indexer Indexer) {
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexer.Put(utils.GetTestKey(i%benchKeyNum), pos)
+	}
+}
+
+func benchmarkIndexGet(b *testing.B, indexer Indexer) {
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+	keys := make([][]byte, benchKeyNum)
+	for i := 0; i < benchKeyNum; i++ {
+		keys[i] = utils.GetTestKey(i)
+		indexer.Put(keys[i], pos)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		indexer.Get(keys[i%benchKeyNum])
+	}
+}
+
+func benchmarkIndexIterate(b *testing.B, indexer Indexer) {
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+	for i := 0; i < benchKeyNum; i++ {
+		indexer.Put(utils.GetTestKey(i), pos)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it := indexer.Iterator(false)
+		for it.Seek(utils.GetTestKey(i % benchKeyNum)); it.Valid(); it.Next() {
+			break
+		}
+		it.Close()
+	}
+}
+
+func BenchmarkBTree_Put(b *testing.B) {
+	benchmarkIndexPut(b, NewBTree())
+}
+
+func BenchmarkART_Put(b *testing.B) {
+	benchmarkIndexPut(b, NewART())
+}
+
+func BenchmarkSkipList_Put(b *testing.B) {
+	benchmarkIndexPut(b, NewSkipList())
+}
+
+func BenchmarkHashMap_Put(b *testing.B) {
+	benchmarkIndexPut(b, NewHashMap())
+}
+
+func BenchmarkBPlusTree_Put(b *testing.B) {
+	benchmarkIndexPut(b, newBenchBPlusTree(b))
+}
+
+func BenchmarkCowBTree_Put(b *testing.B) {
+	benchmarkIndexPut(b, NewCowBTree())
+}
+
+func BenchmarkBTree_Get(b *testing.B) {
+	benchmarkIndexGet(b, NewBTree())
+}
+
+func BenchmarkART_Get(b *testing.B) {
+	benchmarkIndexGet(b, NewART())
+}
+
+func BenchmarkSkipList_Get(b *testing.B) {
+	benchmarkIndexGet(b, NewSkipList())
+}
+
+func BenchmarkHashMap_Get(b *testing.B) {
+	benchmarkIndexGet(b, NewHashMap())
+}
+
+func BenchmarkBPlusTree_Get(b *testing.B) {
+	benchmarkIndexGet(b, newBenchBPlusTree(b))
+}
+
+func BenchmarkCowBTree_Get(b *testing.B) {
+	benchmarkIndexGet(b, NewCowBTree())
+}
+
+func BenchmarkBTree_Iterator(b *testing.B) {
+	benchmarkIndexIterate(b, NewBTree())
+}
+
+func BenchmarkART_Iterator(b *testing.B) {
+	benchmarkIndexIterate(b, NewART())
+}
+
+func BenchmarkSkipList_Iterator(b *testing.B) {
+	benchmarkIndexIterate(b, NewSkipList())
+}
+
+// 读写混合的并发负载，每 10 次操作中有 1 次写
+func BenchmarkBPlusTree_Iterator(b *testing.B) {
+	benchmarkIndexIterate(b, newBenchBPlusTree(b))
+}
+
+func benchmarkIndexMixed(b *testing.B, indexer Indexer) {
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+	for i := 0; i < benchKeyNum; i++ {
+		indexer.Put(utils.GetTestKey(i), pos)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := utils.GetTestKey(i % benchKeyNum)
+			if i%10 == 0 {
+				indexer.Put(key, pos)
+			} else {
+				indexer.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkBTree_Mixed(b *testing.B) {
+	benchmarkIndexMixed(b, NewBTree())
+}
+
+func BenchmarkSkipList_Mixed(b *testing.B) {
+	benchmarkIndexMixed(b, NewSkipList())
+}
+
+func BenchmarkHashMap_Mixed(b *testing.B) {
+	benchmarkIndexMixed(b, NewHashMap())
+}
+
+// 多个 goroutine 并发读写，对比全局锁和分片锁
+func benchmarkIndexParallel(b *testing.B, indexer Indexer) {
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+	keys := make([][]byte, benchKeyNum)
+	for i := 0; i < benchKeyNum; i++ {
+		keys[i] = utils.GetTestKey(i)
+		indexer.Put(keys[i], pos)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%benchKeyNum]
+			if i%4 == 0 {
+				indexer.Put(key, pos)
+			} else {
+				indexer.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkBTree_Parallel(b *testing.B) {
+	benchmarkIndexParallel(b, NewBTree())
+}
+
+func BenchmarkCowBTree_Parallel(b *testing.B) {
+	benchmarkIndexParallel(b, NewCowBTree())
+}
+
+func BenchmarkShardedBTree_Parallel(b *testing.B) {
+	benchmarkIndexParallel(b, NewShardedIndex(32, func() Indexer { return NewBTree() }))
+}
@@ -0,0 +1,1038 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"path"
+	"sort"
+	"sync"
+)
+
+const (
+	BPlusTreeFileName = "bptree.index" // B+树索引文件名
+
+	bptPageSize              = 4096       // 页大小，节点和元数据都按页对齐
+	bptMagic          uint32 = 0x42505431 // 文件头和元数据页的魔数 "BPT1"
+	bptNodeHeaderSize        = 9          // crc(4) + 页类型(1) + 节点长度(4)
+	bptMetaSize              = 54         // 元数据页中有效数据的长度
+
+	bptDefaultCacheSize = 4096    // 默认缓存的节点数量
+	bptMaxDirtyNodes    = 1024    // 修改过的节点超过该数量时写入文件（不提交）
+	bptIteratorBatch    = 256     // 迭代器每次加载的 key 数量
+	bptCompactMinPages  = 1024    // 文件小于该页数时不压缩
+	bptCompactRatio     = 2       // 文件页数超过存活页数的倍数时压缩
+	bptCompactBuffer    = 1 << 20 // 压缩时写缓冲区的大小
+)
+
+// 页类型
+const (
+	bptPageHeader byte = iota + 1
+	bptPageLeaf
+	bptPageInternal
+	bptPageMeta
+)
+
+var (
+	ErrBPlusTreeCorrupted = errors.New("the b+tree index file is corrupted")
+	ErrBPlusTreeReadOnly  = errors.New("the b+tree index is opened in read-only mode")
+)
+
+// BPlusTree 基于磁盘的 B+树索引，key 的数量不受内存大小限制
+//
+// 文件只追加写入：修改节点时先复制一份（写时复制），修改过的节点攒在内存中，
+// 数量达到阈值或 Commit 时才追加到文件末尾，旧的页不会被覆盖。
+// Commit 在节点刷盘之后再追加一个元数据页，记录根节点位置和检查点。
+// 打开文件时从末尾向前查找最近一次完整的元数据页，之后的数据都是崩溃时未提交的，直接截断，
+// 因此任何时刻崩溃，索引都能恢复到最近一次提交的状态。
+// 文件中失效的页越来越多时，Commit 会把整棵树重写到新文件中并原子替换旧文件。
+//
+// 读取文件出错时，Get 返回 nil，Put 和 Delete 返回 false，迭代器提前结束
+type BPlusTree struct {
+	fs         fio.VFS
+	fileName   string
+	file       fio.IOManager
+	lock       *sync.RWMutex
+	cache      *bptCache
+	root       bptRef
+	count      uint64             // key 的数量
+	live       uint64             // 当前树在文件中占用的页数，用于判断是否需要压缩
+	dirty      int                // 上次写入文件之后修改过的节点数量
+	checkpoint *data.LogRecordPos // 最近一次提交时索引对应的数据文件位置
+	readOnly   bool               // 只读模式不修改索引文件
+}
+
+type bptNode struct {
+	leaf     bool
+	keys     [][]byte
+	poses    []*data.LogRecordPos // 叶子节点中 key 对应的位置
+	children []bptRef             // 内部节点的子节点，children[i] 中的 key 位于 [keys[i-1], keys[i])
+	pages    uint64               // 节点在文件中占用的页数
+}
+
+// bptRef 指向子节点，node 不为空表示节点修改过，尚未写入文件
+type bptRef struct {
+	page uint64
+	node *bptNode
+}
+
+type bptSplit struct {
+	key   []byte // 右兄弟节点中最小的 key
+	right bptRef
+}
+
+type bptMeta struct {
+	root       uint64
+	count      uint64
+	live       uint64
+	checkpoint *data.LogRecordPos
+}
+
+// NewBPlusTree 打开 dirPath 目录下的 B+树索引文件，不存在则创建
+// cacheSize 为缓存的节点数量，小于等于 0 时使用默认值
+func NewBPlusTree(fs fio.VFS, dirPath string, cacheSize int) (*BPlusTree, error) {
+	return openBPlusTree(fs, dirPath, cacheSize, false)
+}
+
+// NewBPlusTreeReadOnly 以只读模式打开已经存在的 B+树索引文件，只能读取最近一次提交的版本，
+// 不会截断文件末尾未提交的数据，Put 和 Delete 返回 false，Commit 返回 ErrBPlusTreeReadOnly
+func NewBPlusTreeReadOnly(fs fio.VFS, dirPath string, cacheSize int) (*BPlusTree, error) {
+	return openBPlusTree(fs, dirPath, cacheSize, true)
+}
+
+func openBPlusTree(fs fio.VFS, dirPath string, cacheSize int, readOnly bool) (*BPlusTree, error) {
+	if cacheSize <= 0 {
+		cacheSize = bptDefaultCacheSize
+	}
+	fileName := path.Join(dirPath, BPlusTreeFileName)
+	var file fio.IOManager
+	var err error
+	if readOnly {
+		file, err = fs.OpenReadOnly(fileName)
+	} else {
+		file, err = fs.OpenFile(fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tree := &BPlusTree{
+		fs:       fs,
+		fileName: fileName,
+		file:     file,
+		lock:     new(sync.RWMutex),
+		cache:    newBPTCache(cacheSize),
+		readOnly: readOnly,
+	}
+	if err := tree.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
+// 校验文件头，从文件末尾向前查找最近一次提交的元数据页
+func (t *BPlusTree) load() error {
+	size, err := t.file.Size()
+	if err != nil {
+		return err
+	}
+	// 新文件，或者创建时文件头没有写完整
+	if size < bptPageSize {
+		if t.readOnly {
+			return ErrBPlusTreeCorrupted
+		}
+		if err := t.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := t.file.Write(encodeBPTHeader()); err != nil {
+			return err
+		}
+		return t.file.Sync()
+	}
+
+	buf := make([]byte, bptPageSize)
+	if _, err := t.file.Read(buf, 0); err != nil {
+		return err
+	}
+	if !checkBPTHeader(buf) {
+		return ErrBPlusTreeCorrupted
+	}
+
+	end := int64(bptPageSize)
+	for page := uint64(size/bptPageSize) - 1; page > 0; page-- {
+		if _, err := t.file.Read(buf, int64(page*bptPageSize)); err != nil {
+			return err
+		}
+		if meta := decodeBPTMeta(buf, page); meta != nil {
+			t.root = bptRef{page: meta.root}
+			t.count, t.live, t.checkpoint = meta.count, meta.live, meta.checkpoint
+			end = int64(page+1) * bptPageSize
+			break
+		}
+	}
+	// 丢弃最近一次提交之后写入的数据
+	if size > end && !t.readOnly {
+		return t.file.Truncate(end)
+	}
+	return nil
+}
+
+func (t *BPlusTree) Put(key []byte, pos *data.LogRecordPos) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.readOnly {
+		return false
+	}
+
+	key = append([]byte(nil), key...)
+	if t.root.page == 0 && t.root.node == nil {
+		t.root.node = &bptNode{leaf: true}
+		t.dirty++
+	}
+	split, inserted, err := t.insert(&t.root, key, pos)
+	if err != nil {
+		return false
+	}
+	// 根节点分裂，树高加一
+	if split != nil {
+		t.root = bptRef{node: &bptNode{
+			keys:     [][]byte{split.key},
+			children: []bptRef{t.root, split.right},
+		}}
+		t.dirty++
+	}
+	if inserted {
+		t.count++
+	}
+	// 写入失败时节点仍然保留在内存中，下次再写
+	if t.dirty >= bptMaxDirtyNodes {
+		_ = t.flush()
+	}
+	return true
+}
+
+func (t *BPlusTree) Get(key []byte) *data.LogRecordPos {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	pos, err := t.get(key)
+	if err != nil {
+		return nil
+	}
+	return pos
+}
+
+func (t *BPlusTree) Delete(key []byte) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.readOnly {
+		return false
+	}
+
+	if pos, err := t.get(key); err != nil || pos == nil {
+		return false
+	}
+	empty, err := t.remove(&t.root, key)
+	if err != nil {
+		return false
+	}
+	t.count--
+	if empty {
+		t.root = bptRef{}
+	}
+	// 根节点只剩一个子节点时降低树高
+	for t.root.node != nil && !t.root.node.leaf && len(t.root.node.children) == 1 {
+		t.root = t.root.node.children[0]
+	}
+	return true
+}
+
+// IsExist 判断key是否已经存在
+func (t *BPlusTree) IsExist(key []byte) bool {
+	return t.Get(key) != nil
+}
+
+func (t *BPlusTree) Size() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return int(t.count)
+}
+
+func (t *BPlusTree) Iterator(reverse bool) Iterator {
+	if t == nil {
+		return nil
+	}
+	it := &BPlusTreeIterator{tree: t, reverse: reverse}
+	it.Rewind()
+	return it
+}
+
+// Checkpoint 最近一次提交时索引对应的数据文件位置，从未提交过时返回 nil
+func (t *BPlusTree) Checkpoint() *data.LogRecordPos {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.checkpoint
+}
+
+// Commit 将修改过的节点和元数据页写入文件并刷盘，checkpoint 为此刻索引对应的数据文件位置
+func (t *BPlusTree) Commit(checkpoint *data.LogRecordPos) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.readOnly {
+		return ErrBPlusTreeReadOnly
+	}
+
+	// 先保证节点刷盘，再写元数据页，元数据页不会指向不完整的节点
+	if err := t.flush(); err != nil {
+		return err
+	}
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+
+	size, err := t.file.Size()
+	if err != nil {
+		return err
+	}
+	if pages := uint64(size / bptPageSize); pages >= bptCompactMinPages && pages > bptCompactRatio*(t.live+1) {
+		return t.compact(checkpoint)
+	}
+
+	page, err := t.alignedEnd()
+	if err != nil {
+		return err
+	}
+	meta := &bptMeta{root: t.root.page, count: t.count, live: t.live, checkpoint: checkpoint}
+	if _, err := t.file.Write(encodeBPTMeta(meta, page)); err != nil {
+		_ = t.file.Truncate(int64(page * bptPageSize))
+		return err
+	}
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+	t.checkpoint = checkpoint
+	return nil
+}
+
+// Close 关闭索引文件，未提交的修改会丢失
+func (t *BPlusTree) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.file.Close()
+}
+
+func (t *BPlusTree) get(key []byte) (*data.LogRecordPos, error) {
+	ref := t.root
+	for ref.page != 0 || ref.node != nil {
+		node, err := t.loadNode(ref)
+		if err != nil {
+			return nil, err
+		}
+		if node.leaf {
+			i := node.searchKey(key)
+			if i < len(node.keys) && bytes.Equal(node.keys[i], key) {
+				return node.poses[i], nil
+			}
+			return nil, nil
+		}
+		ref = node.children[node.childIndex(key)]
+	}
+	return nil, nil
+}
+
+// 插入 key，返回分裂出的右兄弟节点，以及是否新增了 key
+func (t *BPlusTree) insert(ref *bptRef, key []byte, pos *data.LogRecordPos) (*bptSplit, bool, error) {
+	node, err := t.mutableNode(ref)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if node.leaf {
+		i := node.searchKey(key)
+		if i < len(node.keys) && bytes.Equal(node.keys[i], key) {
+			node.poses[i] = pos
+			return nil, false, nil
+		}
+		node.keys = append(node.keys, nil)
+		copy(node.keys[i+1:], node.keys[i:])
+		node.keys[i] = key
+		node.poses = append(node.poses, nil)
+		copy(node.poses[i+1:], node.poses[i:])
+		node.poses[i] = pos
+		return t.split(node), true, nil
+	}
+
+	i := node.childIndex(key)
+	split, inserted, err := t.insert(&node.children[i], key, pos)
+	if err != nil || split == nil {
+		return nil, inserted, err
+	}
+	node.keys = append(node.keys, nil)
+	copy(node.keys[i+1:], node.keys[i:])
+	node.keys[i] = split.key
+	node.children = append(node.children, bptRef{})
+	copy(node.children[i+2:], node.children[i+1:])
+	node.children[i+1] = split.right
+	return t.split(node), inserted, nil
+}
+
+// 节点超过一页时从中间分裂，返回新的右兄弟节点，单个 key 过大时允许节点跨越多页
+func (t *BPlusTree) split(node *bptNode) *bptSplit {
+	if len(node.keys) < 2 || node.encodedSize() <= bptPageSize {
+		return nil
+	}
+	mid := len(node.keys) / 2
+	right := &bptNode{leaf: node.leaf}
+	var key []byte
+	if node.leaf {
+		right.keys = append([][]byte(nil), node.keys[mid:]...)
+		right.poses = append([]*data.LogRecordPos(nil), node.poses[mid:]...)
+		node.keys, node.poses = node.keys[:mid], node.poses[:mid]
+		key = right.keys[0]
+	} else {
+		key = node.keys[mid]
+		right.keys = append([][]byte(nil), node.keys[mid+1:]...)
+		right.children = append([]bptRef(nil), node.children[mid+1:]...)
+		node.keys, node.children = node.keys[:mid], node.children[:mid+1]
+	}
+	t.dirty++
+	return &bptSplit{key: key, right: bptRef{node: right}}
+}
+
+// 删除 key，返回节点是否已经为空。节点变空时直接从父节点中摘除，不做合并
+func (t *BPlusTree) remove(ref *bptRef, key []byte) (bool, error) {
+	node, err := t.mutableNode(ref)
+	if err != nil {
+		return false, err
+	}
+
+	if node.leaf {
+		i := node.searchKey(key)
+		if i < len(node.keys) && bytes.Equal(node.keys[i], key) {
+			node.keys = append(node.keys[:i], node.keys[i+1:]...)
+			node.poses = append(node.poses[:i], node.poses[i+1:]...)
+		}
+		return len(node.keys) == 0, nil
+	}
+
+	i := node.childIndex(key)
+	empty, err := t.remove(&node.children[i], key)
+	if err != nil || !empty {
+		return false, err
+	}
+	node.children = append(node.children[:i], node.children[i+1:]...)
+	if i > 0 {
+		node.keys = append(node.keys[:i-1], node.keys[i:]...)
+	} else if len(node.keys) > 0 {
+		node.keys = node.keys[1:]
+	}
+	return len(node.children) == 0, nil
+}
+
+// 从 start 开始按顺序遍历，start 为 nil 时从头(反向时从尾)开始，inclusive 表示是否包含 start 本身
+// fn 返回 false 时停止，返回值表示是否已经遍历到末尾
+func (t *BPlusTree) scan(ref bptRef, start []byte, inclusive, reverse bool,
+	fn func(key []byte, pos *data.LogRecordPos) bool) (bool, error) {
+	if ref.page == 0 && ref.node == nil {
+		return true, nil
+	}
+	node, err := t.loadNode(ref)
+	if err != nil {
+		return false, err
+	}
+
+	if node.leaf {
+		if !reverse {
+			i := 0
+			if start != nil {
+				i = sort.Search(len(node.keys), func(i int) bool {
+					cmp := bytes.Compare(node.keys[i], start)
+					return cmp > 0 || (cmp == 0 && inclusive)
+				})
+			}
+			for ; i < len(node.keys); i++ {
+				if !fn(node.keys[i], node.poses[i]) {
+					return false, nil
+				}
+			}
+		} else {
+			i := len(node.keys) - 1
+			if start != nil {
+				i = sort.Search(len(node.keys), func(i int) bool {
+					cmp := bytes.Compare(node.keys[i], start)
+					return cmp > 0 || (cmp == 0 && !inclusive)
+				}) - 1
+			}
+			for ; i >= 0; i-- {
+				if !fn(node.keys[i], node.poses[i]) {
+					return false, nil
+				}
+			}
+		}
+		return true, nil
+	}
+
+	i := 0
+	if reverse {
+		i = len(node.children) - 1
+	}
+	if start != nil {
+		i = node.childIndex(start)
+	}
+	for i >= 0 && i < len(node.children) {
+		end, err := t.scan(node.children[i], start, inclusive, reverse, fn)
+		if err != nil || !end {
+			return false, err
+		}
+		if !reverse {
+			i++
+		} else {
+			i--
+		}
+	}
+	return true, nil
+}
+
+func (t *BPlusTree) loadNode(ref bptRef) (*bptNode, error) {
+	if ref.node != nil {
+		return ref.node, nil
+	}
+	return t.readNode(ref.page)
+}
+
+// 获取可以修改的节点，已经写入文件的节点先复制一份，原来的页随之失效
+func (t *BPlusTree) mutableNode(ref *bptRef) (*bptNode, error) {
+	if ref.node != nil {
+		return ref.node, nil
+	}
+	node, err := t.readNode(ref.page)
+	if err != nil {
+		return nil, err
+	}
+	t.live -= node.pages
+	ref.node = node.clone()
+	ref.page = 0
+	t.dirty++
+	return ref.node, nil
+}
+
+// 读取 page 处的节点，优先从缓存中获取
+func (t *BPlusTree) readNode(page uint64) (*bptNode, error) {
+	if node := t.cache.get(page); node != nil {
+		return node, nil
+	}
+	buf := make([]byte, bptPageSize)
+	if _, err := t.file.Read(buf, int64(page*bptPageSize)); err != nil {
+		return nil, err
+	}
+	if buf[4] != bptPageLeaf && buf[4] != bptPageInternal {
+		return nil, ErrBPlusTreeCorrupted
+	}
+	// 节点跨越多页，继续读取剩余的部分
+	length := int(binary.LittleEndian.Uint32(buf[5:9]))
+	if length > bptPageSize {
+		full := make([]byte, length)
+		copy(full, buf)
+		if _, err := t.file.Read(full[bptPageSize:], int64(page+1)*bptPageSize); err != nil {
+			return nil, err
+		}
+		buf = full
+	}
+	node, err := decodeBPTNode(buf)
+	if err != nil {
+		return nil, err
+	}
+	t.cache.put(page, node)
+	return node, nil
+}
+
+// 将修改过的节点追加写入文件，不写元数据页，崩溃后这部分数据会在打开时被丢弃
+// 所有节点一次写入，失败时截断到写入前的位置，节点仍然保留在内存中
+func (t *BPlusTree) flush() error {
+	if t.root.node == nil {
+		t.dirty = 0
+		return nil
+	}
+	end, err := t.alignedEnd()
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		ref  *bptRef
+		page uint64
+	}
+	var (
+		buf      []byte
+		written  []pending
+		nextPage = end
+	)
+	// 先写子节点，父节点中记录子节点的页号
+	var write func(ref *bptRef) uint64
+	write = func(ref *bptRef) uint64 {
+		if ref.node == nil {
+			return ref.page
+		}
+		var childPages []uint64
+		if !ref.node.leaf {
+			childPages = make([]uint64, len(ref.node.children))
+			for i := range ref.node.children {
+				childPages[i] = write(&ref.node.children[i])
+			}
+		}
+		encoded := ref.node.encode(childPages)
+		page := nextPage
+		nextPage += uint64(len(encoded) / bptPageSize)
+		buf = append(buf, encoded...)
+		written = append(written, pending{ref: ref, page: page})
+		return page
+	}
+	write(&t.root)
+
+	if _, err := t.file.Write(buf); err != nil {
+		_ = t.file.Truncate(int64(end * bptPageSize))
+		return err
+	}
+	for _, w := range written {
+		node := w.ref.node
+		w.ref.page, w.ref.node = w.page, nil
+		t.live += node.pages
+		t.cache.put(w.page, node)
+	}
+	t.dirty = 0
+	return nil
+}
+
+// 获取文件末尾的页号，文件末尾有不完整的页时补齐
+func (t *BPlusTree) alignedEnd() (uint64, error) {
+	size, err := t.file.Size()
+	if err != nil {
+		return 0, err
+	}
+	if rem := size % bptPageSize; rem != 0 {
+		if _, err := t.file.Write(make([]byte, bptPageSize-rem)); err != nil {
+			return 0, err
+		}
+		size += bptPageSize - rem
+	}
+	return uint64(size / bptPageSize), nil
+}
+
+// 将整棵树重写到新文件中，丢弃失效的页，写完并刷盘后通过重命名原子替换旧文件
+// 调用前所有节点必须已经写入文件
+func (t *BPlusTree) compact(checkpoint *data.LogRecordPos) error {
+	tmpName := t.fileName + ".compact"
+	_ = t.fs.Remove(tmpName)
+	file, err := t.fs.OpenFile(tmpName)
+	if err != nil {
+		return err
+	}
+
+	buf := encodeBPTHeader()
+	nextPage := uint64(1)
+	var copyNode func(page uint64) (uint64, error)
+	copyNode = func(page uint64) (uint64, error) {
+		node, err := t.readNode(page)
+		if err != nil {
+			return 0, err
+		}
+		var childPages []uint64
+		if !node.leaf {
+			childPages = make([]uint64, len(node.children))
+			for i, child := range node.children {
+				if childPages[i], err = copyNode(child.page); err != nil {
+					return 0, err
+				}
+			}
+		}
+		encoded := node.encode(childPages)
+		newPage := nextPage
+		nextPage += uint64(len(encoded) / bptPageSize)
+		buf = append(buf, encoded...)
+		if len(buf) >= bptCompactBuffer {
+			if _, err := file.Write(buf); err != nil {
+				return 0, err
+			}
+			buf = buf[:0]
+		}
+		return newPage, nil
+	}
+
+	var root uint64
+	if t.root.page != 0 {
+		if root, err = copyNode(t.root.page); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	meta := &bptMeta{root: root, count: t.count, live: nextPage - 1, checkpoint: checkpoint}
+	buf = append(buf, encodeBPTMeta(meta, nextPage)...)
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := t.fs.Rename(tmpName, t.fileName); err != nil {
+		return err
+	}
+	newFile, err := t.fs.OpenFile(t.fileName)
+	if err != nil {
+		return err
+	}
+	_ = t.file.Close()
+	t.file = newFile
+	t.root = bptRef{page: root}
+	t.live = meta.live
+	t.checkpoint = checkpoint
+	// 页号已经改变，缓存全部失效
+	t.cache.clear()
+	return nil
+}
+
+// 第一个大于等于 key 的位置
+func (n *bptNode) searchKey(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+}
+
+// key 所在的子节点
+func (n *bptNode) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	})
+}
+
+func (n *bptNode) clone() *bptNode {
+	return &bptNode{
+		leaf:     n.leaf,
+		keys:     append([][]byte(nil), n.keys...),
+		poses:    append([]*data.LogRecordPos(nil), n.poses...),
+		children: append([]bptRef(nil), n.children...),
+	}
+}
+
+// 编码后的长度，内部节点子节点的页号按最大长度估算
+func (n *bptNode) encodedSize() int {
+	size := bptNodeHeaderSize + uvarintSize(uint64(len(n.keys)))
+	for i, key := range n.keys {
+		size += uvarintSize(uint64(len(key))) + len(key)
+		if n.leaf {
+			pos := n.poses[i]
+			size += uvarintSize(uint64(pos.Fid)) + uvarintSize(pos.Offset) + uvarintSize(uint64(pos.Size))
+		}
+	}
+	return size + len(n.children)*binary.MaxVarintLen64
+}
+
+// 编码节点，childPages 为内部节点每个子节点的页号，结果补齐到页的整数倍
+//
+//	+-------+--------+--------+-----------+---------------------------------------+
+//	| crc 4 | type 1 | length 4 | count 变长 | 叶子: (klen key fid offset size) * count |
+//	|       |        |          |           | 内部: page * (count+1), (klen key) * count |
+//	+-------+--------+--------+-----------+---------------------------------------+
+func (n *bptNode) encode(childPages []uint64) []byte {
+	buf := make([]byte, bptNodeHeaderSize, bptPageSize)
+	buf = appendUvarint(buf, uint64(len(n.keys)))
+	if n.leaf {
+		buf[4] = bptPageLeaf
+		for i, key := range n.keys {
+			buf = appendUvarint(buf, uint64(len(key)))
+			buf = append(buf, key...)
+			buf = appendUvarint(buf, uint64(n.poses[i].Fid))
+			buf = appendUvarint(buf, n.poses[i].Offset)
+			buf = appendUvarint(buf, uint64(n.poses[i].Size))
+		}
+	} else {
+		buf[4] = bptPageInternal
+		for _, page := range childPages {
+			buf = appendUvarint(buf, page)
+		}
+		for _, key := range n.keys {
+			buf = appendUvarint(buf, uint64(len(key)))
+			buf = append(buf, key...)
+		}
+	}
+	length := len(buf)
+	binary.LittleEndian.PutUint32(buf[5:9], uint32(length))
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	pages := (length + bptPageSize - 1) / bptPageSize
+	n.pages = uint64(pages)
+	return append(buf, make([]byte, pages*bptPageSize-length)...)
+}
+
+func decodeBPTNode(buf []byte) (*bptNode, error) {
+	if len(buf) < bptNodeHeaderSize {
+		return nil, ErrBPlusTreeCorrupted
+	}
+	length := int(binary.LittleEndian.Uint32(buf[5:9]))
+	if length < bptNodeHeaderSize || length > len(buf) {
+		return nil, ErrBPlusTreeCorrupted
+	}
+	if crc32.ChecksumIEEE(buf[4:length]) != binary.LittleEndian.Uint32(buf[0:4]) {
+		return nil, ErrBPlusTreeCorrupted
+	}
+
+	d := &bptDecoder{buf: buf[bptNodeHeaderSize:length]}
+	count := d.uvarint()
+	if count > uint64(length) {
+		return nil, ErrBPlusTreeCorrupted
+	}
+	node := &bptNode{
+		leaf:  buf[4] == bptPageLeaf,
+		keys:  make([][]byte, count),
+		pages: uint64((length + bptPageSize - 1) / bptPageSize),
+	}
+	if node.leaf {
+		node.poses = make([]*data.LogRecordPos, count)
+		for i := range node.keys {
+			node.keys[i] = d.bytes()
+			node.poses[i] = &data.LogRecordPos{
+				Fid:    uint32(d.uvarint()),
+				Offset: d.uvarint(),
+				Size:   uint32(d.uvarint()),
+			}
+		}
+	} else {
+		node.children = make([]bptRef, count+1)
+		for i := range node.children {
+			node.children[i].page = d.uvarint()
+		}
+		for i := range node.keys {
+			node.keys[i] = d.bytes()
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return node, nil
+}
+
+// bptDecoder 依次读取变长整数和字节数组，出错后后续读取都返回零值
+type bptDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *bptDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrBPlusTreeCorrupted
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return x
+}
+
+func (d *bptDecoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = ErrBPlusTreeCorrupted
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func encodeBPTHeader() []byte {
+	buf := make([]byte, bptPageSize)
+	buf[4] = bptPageHeader
+	binary.LittleEndian.PutUint32(buf[5:9], bptMagic)
+	binary.LittleEndian.PutUint32(buf[9:13], bptPageSize)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:13]))
+	return buf
+}
+
+func checkBPTHeader(buf []byte) bool {
+	return buf[4] == bptPageHeader &&
+		binary.LittleEndian.Uint32(buf[5:9]) == bptMagic &&
+		binary.LittleEndian.Uint32(buf[9:13]) == bptPageSize &&
+		binary.LittleEndian.Uint32(buf[0:4]) == crc32.ChecksumIEEE(buf[4:13])
+}
+
+// 编码元数据页，page 为元数据页自身的页号
+//
+//	crc 4 | type 1 | magic 4 | page 8 | root 8 | count 8 | live 8 | 是否有检查点 1 | fid 4 | offset 8
+func encodeBPTMeta(meta *bptMeta, page uint64) []byte {
+	buf := make([]byte, bptPageSize)
+	buf[4] = bptPageMeta
+	binary.LittleEndian.PutUint32(buf[5:9], bptMagic)
+	binary.LittleEndian.PutUint64(buf[9:17], page)
+	binary.LittleEndian.PutUint64(buf[17:25], meta.root)
+	binary.LittleEndian.PutUint64(buf[25:33], meta.count)
+	binary.LittleEndian.PutUint64(buf[33:41], meta.live)
+	if meta.checkpoint != nil {
+		buf[41] = 1
+		binary.LittleEndian.PutUint32(buf[42:46], meta.checkpoint.Fid)
+		binary.LittleEndian.PutUint64(buf[46:54], meta.checkpoint.Offset)
+	}
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:bptMetaSize]))
+	return buf
+}
+
+// 解码元数据页，不是 page 处完整的元数据页时返回 nil
+func decodeBPTMeta(buf []byte, page uint64) *bptMeta {
+	if buf[4] != bptPageMeta ||
+		binary.LittleEndian.Uint32(buf[5:9]) != bptMagic ||
+		binary.LittleEndian.Uint64(buf[9:17]) != page ||
+		binary.LittleEndian.Uint32(buf[0:4]) != crc32.ChecksumIEEE(buf[4:bptMetaSize]) {
+		return nil
+	}
+	meta := &bptMeta{
+		root:  binary.LittleEndian.Uint64(buf[17:25]),
+		count: binary.LittleEndian.Uint64(buf[25:33]),
+		live:  binary.LittleEndian.Uint64(buf[33:41]),
+	}
+	if meta.root >= page {
+		return nil
+	}
+	if buf[41] == 1 {
+		meta.checkpoint = &data.LogRecordPos{
+			Fid:    binary.LittleEndian.Uint32(buf[42:46]),
+			Offset: binary.LittleEndian.Uint64(buf[46:54]),
+		}
+	}
+	return meta
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+func uvarintSize(x uint64) int {
+	size := 1
+	for x >= 0x80 {
+		x >>= 7
+		size++
+	}
+	return size
+}
+
+// BPlusTreeIterator B+树索引迭代器，每次在读锁下加载一批 key，用完后从最后一个 key 之后继续加载，
+// 因此不会长时间阻塞写入，遍历期间的并发修改可能可见
+type BPlusTreeIterator struct {
+	tree    *BPlusTree
+	reverse bool    // 是否反向遍历
+	index   int     // 当前遍历到的位置
+	items   []*Item // 当前批次的数据
+	end     bool    // 当前批次之后没有更多数据
+}
+
+func (it *BPlusTreeIterator) Rewind() {
+	it.load(nil, true)
+}
+
+// Seek 从第一个大于(反向时为小于)等于 key 的位置开始遍历，时间复杂度 O(log n)
+func (it *BPlusTreeIterator) Seek(key []byte) {
+	it.load(key, true)
+}
+
+func (it *BPlusTreeIterator) Next() {
+	it.index++
+	if it.index >= len(it.items) && !it.end && len(it.items) > 0 {
+		it.load(it.items[len(it.items)-1].Key, false)
+	}
+}
+
+func (it *BPlusTreeIterator) load(start []byte, inclusive bool) {
+	it.items, it.index = nil, 0
+	it.tree.lock.RLock()
+	defer it.tree.lock.RUnlock()
+	end, err := it.tree.scan(it.tree.root, start, inclusive, it.reverse, func(key []byte, pos *data.LogRecordPos) bool {
+		it.items = append(it.items, &Item{Key: key, Pos: pos})
+		return len(it.items) < bptIteratorBatch
+	})
+	it.end = end || err != nil
+}
+
+func (it *BPlusTreeIterator) Valid() bool {
+	return it.index < len(it.items)
+}
+
+func (it *BPlusTreeIterator) Key() []byte {
+	return it.items[it.index].Key
+}
+
+func (it *BPlusTreeIterator) Value() *data.LogRecordPos {
+	return it.items[it.index].Pos
+}
+
+func (it *BPlusTreeIterator) Close() {
+	it.items = nil
+}
+
+// bptCache 节点缓存，按 LRU 策略淘汰，缓存的都是已经写入文件、不会再修改的节点
+type bptCache struct {
+	lock     *sync.Mutex
+	capacity int
+	items    map[uint64]*list.Element
+	lru      *list.List
+}
+
+type bptCacheEntry struct {
+	page uint64
+	node *bptNode
+}
+
+func newBPTCache(capacity int) *bptCache {
+	return &bptCache{
+		lock:     new(sync.Mutex),
+		capacity: capacity,
+		items:    make(map[uint64]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *bptCache) get(page uint64) *bptNode {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[page]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*bptCacheEntry).node
+}
+
+func (c *bptCache) put(page uint64, node *bptNode) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[page]; ok {
+		elem.Value.(*bptCacheEntry).node = node
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[page] = c.lru.PushFront(&bptCacheEntry{page: page, node: node})
+	for c.lru.Len() > c.capacity {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.items, elem.Value.(*bptCacheEntry).page)
+	}
+}
+
+func (c *bptCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items = make(map[uint64]*list.Element)
+	c.lru.Init()
+}
@@ -0,0 +1,302 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+const bptTestDir = "/bitcask-go-bptree"
+
+func openTestBPlusTree(t *testing.T, fs fio.VFS) *BPlusTree {
+	assert.Nil(t, fs.MkdirAll(bptTestDir))
+	tree, err := NewBPlusTree(fs, bptTestDir, 64)
+	assert.Nil(t, err)
+	return tree
+}
+
+func TestBPlusTree_Put(t *testing.T) {
+	tree := openTestBPlusTree(t, fio.NewMemFS())
+	defer tree.Close()
+
+	res := tree.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	assert.True(t, res)
+	res = tree.Put([]byte("aaa"), &data.LogRecordPos{Fid: 2, Offset: 22})
+	assert.True(t, res)
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 22}, tree.Get([]byte("aaa")))
+	assert.Nil(t, tree.Get([]byte("bbb")))
+}
+
+func TestBPlusTree_Split(t *testing.T) {
+	tree := openTestBPlusTree(t, fio.NewMemFS())
+	defer tree.Close()
+
+	// 足够多的 key 使节点分裂成多层，并触发修改过的节点写入文件
+	for i := 0; i < 20000; i++ {
+		assert.True(t, tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)}))
+	}
+	assert.Equal(t, uint64(20000), tree.count)
+	assert.False(t, tree.root.node != nil && tree.root.node.leaf)
+	for i := 0; i < 20000; i++ {
+		assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: uint64(i)}, tree.Get(utils.GetTestKey(i)))
+	}
+
+	// 过大的 key 单独占用多页
+	bigKey := utils.RandomValue(3 * bptPageSize)
+	assert.True(t, tree.Put(bigKey, &data.LogRecordPos{Fid: 2, Offset: 2}))
+	assert.Nil(t, tree.Commit(nil))
+	tree.cache.clear()
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 2}, tree.Get(bigKey))
+}
+
+func TestBPlusTree_Delete(t *testing.T) {
+	tree := openTestBPlusTree(t, fio.NewMemFS())
+	defer tree.Close()
+
+	assert.False(t, tree.Delete([]byte("aaa")))
+	for i := 0; i < 5000; i++ {
+		tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	for i := 0; i < 5000; i += 2 {
+		assert.True(t, tree.Delete(utils.GetTestKey(i)))
+	}
+	assert.False(t, tree.Delete(utils.GetTestKey(0)))
+	for i := 0; i < 5000; i++ {
+		assert.Equal(t, i%2 == 1, tree.IsExist(utils.GetTestKey(i)))
+	}
+
+	// 全部删除后树为空
+	for i := 1; i < 5000; i += 2 {
+		assert.True(t, tree.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, uint64(0), tree.count)
+	assert.Equal(t, bptRef{}, tree.root)
+	assert.False(t, tree.Iterator(false).Valid())
+}
+
+func TestBPlusTree_Iterator(t *testing.T) {
+	tree := openTestBPlusTree(t, fio.NewMemFS())
+	defer tree.Close()
+
+	iter1 := tree.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	for i := 0; i < 1000; i++ {
+		tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+
+	// 跨越多个批次正向遍历
+	i := 0
+	iter2 := tree.Iterator(false)
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, utils.GetTestKey(i), iter2.Key())
+		assert.Equal(t, uint64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 1000, i)
+
+	i = 999
+	iter3 := tree.Iterator(true)
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		assert.Equal(t, utils.GetTestKey(i), iter3.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+
+	iter4 := tree.Iterator(false)
+	iter4.Seek(utils.GetTestKey(500))
+	assert.Equal(t, utils.GetTestKey(500), iter4.Key())
+	iter4.Seek([]byte("bitcask-go-0000005000"))
+	assert.Equal(t, utils.GetTestKey(501), iter4.Key())
+
+	iter5 := tree.Iterator(true)
+	iter5.Seek([]byte("bitcask-go-0000005000"))
+	assert.Equal(t, utils.GetTestKey(500), iter5.Key())
+	iter5.Seek([]byte("a"))
+	assert.False(t, iter5.Valid())
+}
+
+func TestBPlusTree_Commit(t *testing.T) {
+	fs := fio.NewMemFS()
+	tree := openTestBPlusTree(t, fs)
+	assert.Nil(t, tree.Checkpoint())
+
+	for i := 0; i < 3000; i++ {
+		tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	checkpoint := &data.LogRecordPos{Fid: 1, Offset: 3000}
+	assert.Nil(t, tree.Commit(checkpoint))
+
+	// 提交之后的修改在重新打开后丢失
+	for i := 3000; i < 4000; i++ {
+		tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	tree.Delete(utils.GetTestKey(0))
+	assert.Nil(t, tree.Close())
+
+	tree2 := openTestBPlusTree(t, fs)
+	defer tree2.Close()
+	assert.Equal(t, checkpoint, tree2.Checkpoint())
+	assert.Equal(t, uint64(3000), tree2.count)
+	assert.NotNil(t, tree2.Get(utils.GetTestKey(0)))
+	assert.Nil(t, tree2.Get(utils.GetTestKey(3000)))
+
+	count := 0
+	iter := tree2.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 3000, count)
+}
+
+func TestBPlusTree_TornTail(t *testing.T) {
+	fs := fio.NewMemFS()
+	tree := openTestBPlusTree(t, fs)
+	for i := 0; i < 100; i++ {
+		tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	assert.Nil(t, tree.Commit(&data.LogRecordPos{Fid: 1, Offset: 100}))
+	for i := 100; i < 200; i++ {
+		tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	assert.Nil(t, tree.Commit(&data.LogRecordPos{Fid: 1, Offset: 200}))
+	assert.Nil(t, tree.Close())
+
+	// 模拟最后一次提交的元数据页只写了一部分
+	file, err := fs.OpenFile(path.Join(bptTestDir, BPlusTreeFileName))
+	assert.Nil(t, err)
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Nil(t, file.Truncate(size-100))
+	assert.Nil(t, file.Close())
+
+	tree2 := openTestBPlusTree(t, fs)
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 100}, tree2.Checkpoint())
+	assert.Equal(t, uint64(100), tree2.count)
+	assert.NotNil(t, tree2.Get(utils.GetTestKey(99)))
+	assert.Nil(t, tree2.Get(utils.GetTestKey(100)))
+
+	// 截断之后可以继续写入
+	tree2.Put(utils.GetTestKey(100), &data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.Nil(t, tree2.Commit(&data.LogRecordPos{Fid: 2, Offset: 10}))
+	assert.Nil(t, tree2.Close())
+
+	tree3 := openTestBPlusTree(t, fs)
+	defer tree3.Close()
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 0}, tree3.Get(utils.GetTestKey(100)))
+	assert.Equal(t, uint64(101), tree3.count)
+}
+
+func TestBPlusTree_ReadOnly(t *testing.T) {
+	fs := fio.NewMemFS()
+	assert.Nil(t, fs.MkdirAll(bptTestDir))
+	// 只读打开不会创建索引文件
+	_, err := NewBPlusTreeReadOnly(fs, bptTestDir, 0)
+	assert.True(t, os.IsNotExist(err))
+
+	tree := openTestBPlusTree(t, fs)
+	for i := 0; i < 100; i++ {
+		tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	assert.Nil(t, tree.Commit(&data.LogRecordPos{Fid: 1, Offset: 100}))
+	// 提交之后的修改写入文件但没有元数据页
+	tree.Put(utils.GetTestKey(100), &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.Nil(t, tree.flush())
+
+	file, err := fs.OpenFile(path.Join(bptTestDir, BPlusTreeFileName))
+	assert.Nil(t, err)
+	size, err := file.Size()
+	assert.Nil(t, err)
+
+	readOnly, err := NewBPlusTreeReadOnly(fs, bptTestDir, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 100}, readOnly.Checkpoint())
+	assert.Equal(t, 100, readOnly.Size())
+	assert.NotNil(t, readOnly.Get(utils.GetTestKey(99)))
+	assert.Nil(t, readOnly.Get(utils.GetTestKey(100)))
+	assert.False(t, readOnly.Put(utils.GetTestKey(200), &data.LogRecordPos{Fid: 1}))
+	assert.False(t, readOnly.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrBPlusTreeReadOnly, readOnly.Commit(nil))
+	assert.Nil(t, readOnly.Close())
+
+	// 文件没有被修改
+	newSize, err := file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, size, newSize)
+	assert.Nil(t, file.Close())
+	assert.Nil(t, tree.Close())
+}
+
+func TestBPlusTree_Corrupted(t *testing.T) {
+	fs := fio.NewMemFS()
+	tree := openTestBPlusTree(t, fs)
+	tree.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.Nil(t, tree.Commit(nil))
+	assert.Nil(t, tree.Close())
+
+	// 文件头损坏
+	file, err := fs.OpenFile(path.Join(bptTestDir, BPlusTreeFileName))
+	assert.Nil(t, err)
+	buf := make([]byte, 2*bptPageSize)
+	_, err = file.Read(buf, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Truncate(0))
+	buf[7] ^= 0xff
+	_, err = file.Write(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	_, err = NewBPlusTree(fs, bptTestDir, 0)
+	assert.Equal(t, ErrBPlusTreeCorrupted, err)
+
+	// 节点损坏，读取时返回错误而不是错误的数据
+	buf[7] ^= 0xff
+	buf[bptPageSize+bptNodeHeaderSize+2] ^= 0xff
+	assert.Nil(t, fs.Remove(path.Join(bptTestDir, BPlusTreeFileName)))
+	file, err = fs.OpenFile(path.Join(bptTestDir, BPlusTreeFileName))
+	assert.Nil(t, err)
+	_, err = file.Write(buf)
+	assert.Nil(t, err)
+	meta := encodeBPTMeta(&bptMeta{root: 1, count: 1, live: 1}, 2)
+	_, err = file.Write(meta)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	tree2 := openTestBPlusTree(t, fs)
+	defer tree2.Close()
+	assert.Nil(t, tree2.Get([]byte("aaa")))
+	_, err = tree2.get([]byte("aaa"))
+	assert.Equal(t, ErrBPlusTreeCorrupted, err)
+}
+
+func TestBPlusTree_Compact(t *testing.T) {
+	fs := fio.NewMemFS()
+	tree := openTestBPlusTree(t, fs)
+
+	// 反复覆盖同一批 key，文件中产生大量失效的页
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 5000; i++ {
+			tree.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: uint32(round), Offset: uint64(i)})
+		}
+		assert.Nil(t, tree.Commit(&data.LogRecordPos{Fid: uint32(round), Offset: 5000}))
+	}
+	file, err := fs.OpenFile(path.Join(bptTestDir, BPlusTreeFileName))
+	assert.Nil(t, err)
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	// 不压缩时文件中有 50 轮写入的页
+	assert.True(t, uint64(size/bptPageSize) < 20*tree.live)
+	assert.Nil(t, tree.Close())
+
+	tree2 := openTestBPlusTree(t, fs)
+	defer tree2.Close()
+	assert.Equal(t, &data.LogRecordPos{Fid: 49, Offset: 5000}, tree2.Checkpoint())
+	for i := 0; i < 5000; i++ {
+		assert.Equal(t, &data.LogRecordPos{Fid: 49, Offset: uint64(i)}, tree2.Get(utils.GetTestKey(i)))
+	}
+}
@@ -2,6 +2,7 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
 	"github.com/google/btree"
 	"sync"
 )
@@ -60,77 +61,99 @@ func (b *Btree) IsExist(key []byte) bool {
 	return b.tree.Has(it)
 }
 
+func (b *Btree) Size() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.tree.Len()
+}
+
 func (b *Btree) Iterator(reverse bool) Iterator {
 	if b == nil {
 		return nil
 	}
-	b.lock.RLock()
-	defer b.lock.RUnlock()
-	return newBTreeIterator(b.tree, reverse)
+	// Clone 不能与写操作并发执行，需要加写锁，之后快照与原树可以各自独立读写
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return newBTreeIterator(b.tree.Clone(), reverse)
 }
 
+// BTreeIterator BTree 迭代器，遍历的是创建时刻的写时复制快照，不拷贝数据，
+// 每次移动都从快照中查找下一个 key，时间复杂度 O(log n)
 type BTreeIterator struct {
-	reverse bool    // 是否反向遍历
-	index   int     // 当前遍历到的位置
-	items   []*Item // 有序结果集
+	tree    *btree.BTree // 创建迭代器时的快照
+	reverse bool         // 是否反向遍历
+	current *Item        // 当前位置，为 nil 表示遍历结束
 }
 
 func newBTreeIterator(tree *btree.BTree, reverse bool) *BTreeIterator {
-
 	iterator := &BTreeIterator{
+		tree:    tree,
 		reverse: reverse,
-		index:   0,
-		items:   make([]*Item, 0),
-	}
-	saveValue := func(item btree.Item) bool {
-		iterator.items = append(iterator.items, item.(*Item))
-		return true
-	}
-
-	if !reverse {
-		tree.Ascend(saveValue)
-	} else {
-		tree.Descend(saveValue)
 	}
+	iterator.Rewind()
 	return iterator
 }
 
 func (it *BTreeIterator) Rewind() {
-	it.index = 0
+	var item btree.Item
+	if !it.reverse {
+		item = it.tree.Min()
+	} else {
+		item = it.tree.Max()
+	}
+	it.current = nil
+	if item != nil {
+		it.current = item.(*Item)
+	}
 }
 
+// Seek 从第一个大于(反向时为小于)等于 key 的位置开始遍历
 func (it *BTreeIterator) Seek(key []byte) {
+	it.current = nil
+	saveFirst := func(item btree.Item) bool {
+		it.current = item.(*Item)
+		return false
+	}
 	if !it.reverse {
-		for i, item := range it.items {
-			if !item.Less(&Item{Key: key}) {
-				it.index = i
-			}
-		}
+		it.tree.AscendGreaterOrEqual(&Item{Key: key}, saveFirst)
 	} else {
-		for i, item := range it.items {
-			if item.Less(&Item{Key: key}) {
-				it.index = i
-			}
-		}
+		it.tree.DescendLessOrEqual(&Item{Key: key}, saveFirst)
 	}
 }
 
 func (it *BTreeIterator) Next() {
-	it.index++
+	if it.current == nil {
+		return
+	}
+	current := it.current
+	it.current = nil
+	// 跳过当前 key 本身
+	saveNext := func(item btree.Item) bool {
+		if bytes.Equal(item.(*Item).Key, current.Key) {
+			return true
+		}
+		it.current = item.(*Item)
+		return false
+	}
+	if !it.reverse {
+		it.tree.AscendGreaterOrEqual(current, saveNext)
+	} else {
+		it.tree.DescendLessOrEqual(current, saveNext)
+	}
 }
 
 func (it *BTreeIterator) Valid() bool {
-	return it.index < len(it.items)
+	return it.current != nil
 }
 
 func (it *BTreeIterator) Key() []byte {
-	return it.items[it.index].Key
+	return it.current.Key
 }
 
 func (it *BTreeIterator) Value() *data.LogRecordPos {
-	return it.items[it.index].Pos
+	return it.current.Pos
 }
 
 func (it *BTreeIterator) Close() {
-	it.items = nil
+	it.tree, it.current = nil, nil
 }
@@ -9,45 +9,45 @@ import (
 func TestBtree_Put(t *testing.T) {
 	btree := NewBTree()
 
-	res := btree.Put(nil, &data.LogRecordPos{1, 11})
+	res := btree.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 11})
 	assert.True(t, res)
 
-	res = btree.Put([]byte("iii"), &data.LogRecordPos{2, 22})
+	res = btree.Put([]byte("iii"), &data.LogRecordPos{Fid: 2, Offset: 22})
 	assert.True(t, res)
 }
 
 func TestBtree_Get(t *testing.T) {
 	btree := NewBTree()
 
-	res := btree.Put(nil, &data.LogRecordPos{1, 11})
+	res := btree.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 11})
 	assert.True(t, res)
 
-	res = btree.Put([]byte("iii"), &data.LogRecordPos{2, 22})
+	res = btree.Put([]byte("iii"), &data.LogRecordPos{Fid: 2, Offset: 22})
 	assert.True(t, res)
 
 	value := btree.Get(nil)
-	assert.Equal(t, &data.LogRecordPos{1, 11}, value)
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 11}, value)
 
-	btree.Put(nil, &data.LogRecordPos{2, 22})
+	btree.Put(nil, &data.LogRecordPos{Fid: 2, Offset: 22})
 	value = btree.Get(nil)
-	assert.Equal(t, &data.LogRecordPos{2, 22}, value)
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 22}, value)
 
 	value = btree.Get([]byte("iii"))
-	assert.Equal(t, &data.LogRecordPos{2, 22}, value)
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 22}, value)
 
-	btree.Put([]byte("iii"), &data.LogRecordPos{3, 33})
+	btree.Put([]byte("iii"), &data.LogRecordPos{Fid: 3, Offset: 33})
 	value = btree.Get([]byte("iii"))
-	assert.Equal(t, &data.LogRecordPos{3, 33}, value)
+	assert.Equal(t, &data.LogRecordPos{Fid: 3, Offset: 33}, value)
 
 }
 
 func TestBtree_Delete(t *testing.T) {
 	btree := NewBTree()
 
-	res := btree.Put(nil, &data.LogRecordPos{1, 11})
+	res := btree.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 11})
 	assert.True(t, res)
 
-	res = btree.Put([]byte("iii"), &data.LogRecordPos{2, 22})
+	res = btree.Put([]byte("iii"), &data.LogRecordPos{Fid: 2, Offset: 22})
 	assert.True(t, res)
 
 	res = btree.Delete(nil)
@@ -88,13 +88,46 @@ func TestBTree_Iterator(t *testing.T) {
 
 	// 4.测试 seek
 	iter5 := bt1.Iterator(false)
+	var keys []string
 	for iter5.Seek([]byte("cc")); iter5.Valid(); iter5.Next() {
-		assert.NotNil(t, iter5.Key())
+		keys = append(keys, string(iter5.Key()))
 	}
+	assert.Equal(t, []string{"ccde", "eede"}, keys)
 
 	// 5.反向遍历的 seek
 	iter6 := bt1.Iterator(true)
+	keys = nil
 	for iter6.Seek([]byte("zz")); iter6.Valid(); iter6.Next() {
-		assert.NotNil(t, iter6.Key())
+		keys = append(keys, string(iter6.Key()))
 	}
+	assert.Equal(t, []string{"eede", "ccde", "bbcd", "acee"}, keys)
+	iter6.Seek([]byte("ccde"))
+	assert.Equal(t, []byte("ccde"), iter6.Key())
+	iter6.Seek([]byte("a"))
+	assert.False(t, iter6.Valid())
+}
+
+func TestBTree_IteratorSnapshot(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("bbb"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	// 创建迭代器之后的修改对迭代器不可见
+	iter := bt.Iterator(false)
+	bt.Put([]byte("ccc"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	bt.Put([]byte("aaa"), &data.LogRecordPos{Fid: 2, Offset: 10})
+	bt.Delete([]byte("bbb"))
+
+	var keys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"aaa", "bbb"}, keys)
+	iter.Rewind()
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 10}, iter.Value())
+	iter.Close()
+	assert.False(t, iter.Valid())
+
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 10}, bt.Get([]byte("aaa")))
+	assert.Nil(t, bt.Get([]byte("bbb")))
 }
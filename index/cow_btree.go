@@ -0,0 +1,97 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"github.com/google/btree"
+	"sync"
+	"sync/atomic"
+)
+
+// CowBtree 写时复制的 BTree 索引
+// 写操作之间通过互斥锁串行执行，修改只作用于写者私有的树，完成后通过原子操作发布一个新的只读版本。
+// btree.Clone 只复制根节点，之后写者修改节点前会先复制该节点，已发布的版本永远不会被修改，
+// 因此 Get 和创建迭代器完全不加锁，迭代器直接遍历创建时刻发布的版本，不需要任何拷贝。
+// 代价是每次写入都要复制从根到叶子路径上的节点
+type CowBtree struct {
+	tree     *btree.BTree // 写者私有的树，只在持有写锁时访问
+	snapshot atomic.Value // *btree.BTree，最近一次发布的只读版本
+	lock     *sync.Mutex
+}
+
+func NewCowBTree() *CowBtree {
+	b := &CowBtree{
+		tree: btree.New(32),
+		lock: new(sync.Mutex),
+	}
+	b.snapshot.Store(b.tree.Clone())
+	return b
+}
+
+func (b *CowBtree) Put(key []byte, pos *data.LogRecordPos) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tree.ReplaceOrInsert(&Item{Key: key, Pos: pos})
+	b.snapshot.Store(b.tree.Clone())
+	return true
+}
+
+func (b *CowBtree) Get(key []byte) *data.LogRecordPos {
+	btreeItem := b.load().Get(&Item{Key: key})
+	if btreeItem == nil {
+		return nil
+	}
+	return btreeItem.(*Item).Pos
+}
+
+func (b *CowBtree) Delete(key []byte) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.tree.Delete(&Item{Key: key}) == nil {
+		return false
+	}
+	b.snapshot.Store(b.tree.Clone())
+	return true
+}
+
+// IsExist 判断key是否已经存在
+func (b *CowBtree) IsExist(key []byte) bool {
+	return b.load().Has(&Item{Key: key})
+}
+
+func (b *CowBtree) Size() int {
+	return b.load().Len()
+}
+
+// Iterator 遍历当前发布的版本，不加锁
+func (b *CowBtree) Iterator(reverse bool) Iterator {
+	if b == nil {
+		return nil
+	}
+	return newBTreeIterator(b.load(), reverse)
+}
+
+// Snapshot 获取当前版本的只读视图，之后的修改对它不可见
+func (b *CowBtree) Snapshot() *CowBtreeSnapshot {
+	return &CowBtreeSnapshot{tree: b.load()}
+}
+
+func (b *CowBtree) load() *btree.BTree {
+	return b.snapshot.Load().(*btree.BTree)
+}
+
+// CowBtreeSnapshot CowBtree 某一时刻的只读版本
+type CowBtreeSnapshot struct {
+	tree *btree.BTree
+}
+
+func (s *CowBtreeSnapshot) Get(key []byte) *data.LogRecordPos {
+	btreeItem := s.tree.Get(&Item{Key: key})
+	if btreeItem == nil {
+		return nil
+	}
+	return btreeItem.(*Item).Pos
+}
+
+func (s *CowBtreeSnapshot) Iterator(reverse bool) Iterator {
+	return newBTreeIterator(s.tree, reverse)
+}
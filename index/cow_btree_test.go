@@ -0,0 +1,91 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestCowBtree_PutGetDelete(t *testing.T) {
+	b := NewCowBTree()
+
+	assert.True(t, b.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11}))
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 11}, b.Get([]byte("aaa")))
+	assert.True(t, b.Put([]byte("aaa"), &data.LogRecordPos{Fid: 2, Offset: 22}))
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 22}, b.Get([]byte("aaa")))
+	assert.Nil(t, b.Get([]byte("bbb")))
+
+	assert.True(t, b.IsExist([]byte("aaa")))
+	assert.True(t, b.Delete([]byte("aaa")))
+	assert.False(t, b.Delete([]byte("aaa")))
+	assert.False(t, b.IsExist([]byte("aaa")))
+}
+
+func TestCowBtree_Snapshot(t *testing.T) {
+	b := NewCowBTree()
+	for i := 0; i < 1000; i++ {
+		b.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+
+	// 快照和迭代器看到的是创建时刻的版本
+	snapshot := b.Snapshot()
+	iter := b.Iterator(false)
+	for i := 0; i < 1000; i++ {
+		b.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 2, Offset: uint64(i)})
+	}
+	b.Delete(utils.GetTestKey(0))
+	b.Put(utils.GetTestKey(1000), &data.LogRecordPos{Fid: 2, Offset: 1000})
+
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 0}, snapshot.Get(utils.GetTestKey(0)))
+	assert.Nil(t, snapshot.Get(utils.GetTestKey(1000)))
+	assert.Nil(t, b.Get(utils.GetTestKey(0)))
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 1}, b.Get(utils.GetTestKey(1)))
+
+	i := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(i), iter.Key())
+		assert.Equal(t, uint32(1), iter.Value().Fid)
+		i++
+	}
+	assert.Equal(t, 1000, i)
+
+	iter2 := snapshot.Iterator(true)
+	iter2.Seek(utils.GetTestKey(500))
+	assert.Equal(t, utils.GetTestKey(500), iter2.Key())
+}
+
+func TestCowBtree_Concurrent(t *testing.T) {
+	b := NewCowBTree()
+	wg := new(sync.WaitGroup)
+
+	// 写者不断覆盖，读者读到的版本始终完整
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 1; round <= 5; round++ {
+			for i := 0; i < 500; i++ {
+				b.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: uint32(round), Offset: uint64(i)})
+			}
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				prev := -1
+				iter := b.Iterator(false)
+				for iter.Rewind(); iter.Valid(); iter.Next() {
+					offset := int(iter.Value().Offset)
+					assert.Equal(t, prev+1, offset)
+					prev = offset
+				}
+				b.Get(utils.GetTestKey(n))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, &data.LogRecordPos{Fid: 5, Offset: 499}, b.Get(utils.GetTestKey(499)))
+}
@@ -0,0 +1,155 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// hashShardNum 哈希索引的分片数量
+const hashShardNum = 64
+
+// HashMap 分片哈希索引，Get/Put/Delete 的时间复杂度为 O(1)，适合只有点查、没有范围扫描的场景
+// key 按哈希值分布到多个分片，每个分片有自己的锁，避免全局锁竞争
+//
+// 迭代顺序：哈希表本身是无序的，创建迭代器时会拷贝所有的 key 并排序，
+// 因此迭代器仍然按 key 的字典序(反向时为逆序)遍历，Seek 语义与 BTree 相同。
+// 代价是每次创建迭代器需要 O(n log n) 的时间和 O(n) 的内存，且只能看到创建时刻的快照，
+// 需要频繁扫描的场景应使用有序索引
+type HashMap struct {
+	shards []*hashShard
+}
+
+type hashShard struct {
+	items map[string]*data.LogRecordPos
+	lock  *sync.RWMutex
+}
+
+func NewHashMap() *HashMap {
+	shards := make([]*hashShard, hashShardNum)
+	for i := range shards {
+		shards[i] = &hashShard{
+			items: make(map[string]*data.LogRecordPos),
+			lock:  new(sync.RWMutex),
+		}
+	}
+	return &HashMap{shards: shards}
+}
+
+func (h *HashMap) shard(key []byte) *hashShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write(key)
+	return h.shards[hash.Sum32()%uint32(len(h.shards))]
+}
+
+func (h *HashMap) Put(key []byte, pos *data.LogRecordPos) bool {
+	shard := h.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	shard.items[string(key)] = pos
+	return true
+}
+
+func (h *HashMap) Get(key []byte) *data.LogRecordPos {
+	shard := h.shard(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	return shard.items[string(key)]
+}
+
+func (h *HashMap) Delete(key []byte) bool {
+	shard := h.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if _, ok := shard.items[string(key)]; !ok {
+		return false
+	}
+	delete(shard.items, string(key))
+	return true
+}
+
+// IsExist 判断key是否已经存在
+func (h *HashMap) IsExist(key []byte) bool {
+	return h.Get(key) != nil
+}
+
+func (h *HashMap) Size() int {
+	size := 0
+	for _, shard := range h.shards {
+		shard.lock.RLock()
+		size += len(shard.items)
+		shard.lock.RUnlock()
+	}
+	return size
+}
+
+// Iterator 拷贝所有的 key 并排序，按字典序遍历
+func (h *HashMap) Iterator(reverse bool) Iterator {
+	if h == nil {
+		return nil
+	}
+	items := make([]*Item, 0)
+	for _, shard := range h.shards {
+		shard.lock.RLock()
+		for key, pos := range shard.items {
+			items = append(items, &Item{Key: []byte(key), Pos: pos})
+		}
+		shard.lock.RUnlock()
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(items[i].Key, items[j].Key) > 0
+		}
+		return bytes.Compare(items[i].Key, items[j].Key) < 0
+	})
+	return &HashMapIterator{
+		reverse: reverse,
+		items:   items,
+	}
+}
+
+// HashMapIterator 哈希索引迭代器，保存创建时刻排好序的所有 key
+type HashMapIterator struct {
+	reverse bool    // 是否反向遍历
+	index   int     // 当前遍历到的位置
+	items   []*Item // 有序结果集
+}
+
+func (it *HashMapIterator) Rewind() {
+	it.index = 0
+}
+
+// Seek 二分查找第一个大于(反向时为小于)等于 key 的位置
+func (it *HashMapIterator) Seek(key []byte) {
+	if !it.reverse {
+		it.index = sort.Search(len(it.items), func(i int) bool {
+			return bytes.Compare(it.items[i].Key, key) >= 0
+		})
+	} else {
+		it.index = sort.Search(len(it.items), func(i int) bool {
+			return bytes.Compare(it.items[i].Key, key) <= 0
+		})
+	}
+}
+
+func (it *HashMapIterator) Next() {
+	it.index++
+}
+
+func (it *HashMapIterator) Valid() bool {
+	return it.index < len(it.items)
+}
+
+func (it *HashMapIterator) Key() []byte {
+	return it.items[it.index].Key
+}
+
+func (it *HashMapIterator) Value() *data.LogRecordPos {
+	return it.items[it.index].Pos
+}
+
+func (it *HashMapIterator) Close() {
+	it.items = nil
+}
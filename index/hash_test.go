@@ -0,0 +1,93 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestHashMap_Put(t *testing.T) {
+	hm := NewHashMap()
+
+	res := hm.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	assert.True(t, res)
+
+	res = hm.Put([]byte("iii"), &data.LogRecordPos{Fid: 2, Offset: 22})
+	assert.True(t, res)
+}
+
+func TestHashMap_Get(t *testing.T) {
+	hm := NewHashMap()
+
+	hm.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 11}, hm.Get([]byte("aaa")))
+	assert.Nil(t, hm.Get([]byte("bbb")))
+
+	hm.Put([]byte("aaa"), &data.LogRecordPos{Fid: 2, Offset: 22})
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 22}, hm.Get([]byte("aaa")))
+}
+
+func TestHashMap_Delete(t *testing.T) {
+	hm := NewHashMap()
+
+	hm.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	assert.True(t, hm.Delete([]byte("aaa")))
+	assert.False(t, hm.Delete([]byte("aaa")))
+	assert.False(t, hm.IsExist([]byte("aaa")))
+}
+
+func TestHashMap_Iterator(t *testing.T) {
+	hm := NewHashMap()
+	iter1 := hm.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	hm.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	hm.Put([]byte("acee"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	hm.Put([]byte("eede"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	hm.Put([]byte("bbcd"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	// 迭代器按 key 排序
+	var keys []string
+	iter2 := hm.Iterator(false)
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		keys = append(keys, string(iter2.Key()))
+	}
+	assert.Equal(t, []string{"acee", "bbcd", "ccde", "eede"}, keys)
+
+	keys = nil
+	iter3 := hm.Iterator(true)
+	for iter3.Seek([]byte("cc")); iter3.Valid(); iter3.Next() {
+		keys = append(keys, string(iter3.Key()))
+	}
+	assert.Equal(t, []string{"bbcd", "acee"}, keys)
+
+	iter4 := hm.Iterator(false)
+	iter4.Seek([]byte("cc"))
+	assert.Equal(t, []byte("ccde"), iter4.Key())
+}
+
+func TestHashMap_Concurrent(t *testing.T) {
+	hm := NewHashMap()
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := utils.GetTestKey(w*1000 + i)
+				hm.Put(key, &data.LogRecordPos{Fid: uint32(w), Offset: uint64(i)})
+				assert.NotNil(t, hm.Get(key))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	count := 0
+	iter := hm.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 8000, count)
+}
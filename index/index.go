@@ -7,7 +7,12 @@ import (
 type DBIndexType byte
 
 const (
-	BTree DBIndexType = iota
+	BTree    DBIndexType = iota
+	ART                  // 自适应基数树，适合 key 有较长公共前缀的场景
+	SkipList             // 并发跳表，读操作不加锁，适合读写混合的负载
+	Hash                 // 分片哈希表，点查 O(1)，迭代时需要排序，适合只有点查的场景
+	BPTree               // 磁盘 B+树，key 的数量不受内存限制，启动时不需要重建，需要通过 NewBPlusTree 创建
+	CowBTree             // 写时复制 BTree，读操作和创建迭代器不加锁，适合读多写少的场景
 )
 
 type Indexer interface {
@@ -16,6 +21,19 @@ type Indexer interface {
 	Delete(key []byte) bool
 	IsExist(key []byte) bool
 	Iterator(reverse bool) Iterator
+	// Size 索引中 key 的数量
+	Size() int
+}
+
+// PersistentIndexer 持久化在磁盘上的索引，启动时只需要从检查点之后的数据文件加载
+type PersistentIndexer interface {
+	Indexer
+	// Checkpoint 最近一次提交时索引对应的数据文件位置，该位置之前的记录都已经反映在索引中，从未提交过时返回 nil
+	Checkpoint() *data.LogRecordPos
+	// Commit 持久化索引的当前状态，checkpoint 之前的数据文件必须已经刷盘
+	Commit(checkpoint *data.LogRecordPos) error
+	// Close 关闭索引，未提交的修改会丢失
+	Close() error
 }
 
 // NewIndexer 工厂方法，根据类型，创建对应的内存索引
@@ -23,6 +41,14 @@ func NewIndexer(indexType DBIndexType) Indexer {
 	switch indexType {
 	case BTree:
 		return NewBTree()
+	case ART:
+		return NewART()
+	case SkipList:
+		return NewSkipList()
+	case Hash:
+		return NewHashMap()
+	case CowBTree:
+		return NewCowBTree()
 	default:
 		return NewBTree()
 	}
@@ -0,0 +1,37 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIndexer_Size(t *testing.T) {
+	bpt := openTestBPlusTree(t, fio.NewMemFS())
+	defer bpt.Close()
+	indexers := map[string]Indexer{
+		"BTree":    NewBTree(),
+		"ART":      NewART(),
+		"SkipList": NewSkipList(),
+		"Hash":     NewHashMap(),
+		"CowBTree": NewCowBTree(),
+		"Sharded":  NewShardedIndex(4, func() Indexer { return NewBTree() }),
+		"BPTree":   bpt,
+	}
+	for name, indexer := range indexers {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, 0, indexer.Size())
+			for i := 0; i < 100; i++ {
+				indexer.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+			}
+			// 覆盖写入不改变数量
+			indexer.Put(utils.GetTestKey(0), &data.LogRecordPos{Fid: 2, Offset: 0})
+			assert.Equal(t, 100, indexer.Size())
+			indexer.Delete(utils.GetTestKey(1))
+			indexer.Delete(utils.GetTestKey(1000))
+			assert.Equal(t, 99, indexer.Size())
+		})
+	}
+}
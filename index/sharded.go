@@ -0,0 +1,175 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"container/heap"
+	"hash/fnv"
+)
+
+// ShardedIndex 分片索引，按 key 的哈希值将 key 分布到多个内部索引中，
+// 每个内部索引有自己的锁，点查和写入可以随 CPU 核数扩展。
+// 迭代时对所有分片的迭代器做多路归并，结果仍然按 key 全局有序，
+// 但各分片的迭代器是分别创建的，迭代器看到的不是所有分片同一时刻的快照
+type ShardedIndex struct {
+	shards []Indexer
+}
+
+// NewShardedIndex 创建 shardNum 个分片，每个分片通过 newShard 创建
+func NewShardedIndex(shardNum int, newShard func() Indexer) *ShardedIndex {
+	if shardNum <= 0 {
+		shardNum = 1
+	}
+	shards := make([]Indexer, shardNum)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+	return &ShardedIndex{shards: shards}
+}
+
+func (s *ShardedIndex) shard(key []byte) Indexer {
+	hash := fnv.New32a()
+	_, _ = hash.Write(key)
+	// HashMap 内部同样按 fnv32a 取模分片，直接取模时同一个分片中的 key 只会落到 HashMap 的一部分分片中，
+	// 先打散哈希值，两层分片互不相关
+	return s.shards[mixHash(hash.Sum32())%uint32(len(s.shards))]
+}
+
+// mixHash murmur3 的 fmix32，结果的每一位都依赖输入的所有位
+func mixHash(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func (s *ShardedIndex) Put(key []byte, pos *data.LogRecordPos) bool {
+	return s.shard(key).Put(key, pos)
+}
+
+func (s *ShardedIndex) Get(key []byte) *data.LogRecordPos {
+	return s.shard(key).Get(key)
+}
+
+func (s *ShardedIndex) Delete(key []byte) bool {
+	return s.shard(key).Delete(key)
+}
+
+// IsExist 判断key是否已经存在
+func (s *ShardedIndex) IsExist(key []byte) bool {
+	return s.shard(key).IsExist(key)
+}
+
+func (s *ShardedIndex) Size() int {
+	size := 0
+	for _, shard := range s.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+func (s *ShardedIndex) Iterator(reverse bool) Iterator {
+	if s == nil {
+		return nil
+	}
+	it := &ShardedIterator{
+		iterators: make([]Iterator, len(s.shards)),
+		heap:      &iteratorHeap{reverse: reverse},
+	}
+	for i, shard := range s.shards {
+		it.iterators[i] = shard.Iterator(reverse)
+	}
+	it.Rewind()
+	return it
+}
+
+// ShardedIterator 分片索引迭代器，用堆对各分片的迭代器做多路归并，
+// 堆顶始终是所有分片中当前最小(反向时为最大)的 key
+type ShardedIterator struct {
+	iterators []Iterator
+	heap      *iteratorHeap
+}
+
+func (it *ShardedIterator) Rewind() {
+	for _, iterator := range it.iterators {
+		iterator.Rewind()
+	}
+	it.rebuild()
+}
+
+// Seek 所有分片同时 Seek，时间复杂度取决于分片索引的 Seek
+func (it *ShardedIterator) Seek(key []byte) {
+	for _, iterator := range it.iterators {
+		iterator.Seek(key)
+	}
+	it.rebuild()
+}
+
+func (it *ShardedIterator) rebuild() {
+	it.heap.items = it.heap.items[:0]
+	for _, iterator := range it.iterators {
+		if iterator.Valid() {
+			it.heap.items = append(it.heap.items, iterator)
+		}
+	}
+	heap.Init(it.heap)
+}
+
+// Next 堆顶的迭代器前进一步，遍历完时从堆中移除。不同分片中的 key 不会重复
+func (it *ShardedIterator) Next() {
+	if len(it.heap.items) == 0 {
+		return
+	}
+	top := it.heap.items[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(it.heap, 0)
+	} else {
+		heap.Pop(it.heap)
+	}
+}
+
+func (it *ShardedIterator) Valid() bool {
+	return len(it.heap.items) > 0
+}
+
+func (it *ShardedIterator) Key() []byte {
+	return it.heap.items[0].Key()
+}
+
+func (it *ShardedIterator) Value() *data.LogRecordPos {
+	return it.heap.items[0].Value()
+}
+
+func (it *ShardedIterator) Close() {
+	for _, iterator := range it.iterators {
+		iterator.Close()
+	}
+	it.heap.items = nil
+}
+
+// iteratorHeap 按各迭代器当前的 key 排序的堆
+type iteratorHeap struct {
+	reverse bool
+	items   []Iterator
+}
+
+func (h *iteratorHeap) Len() int { return len(h.items) }
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.items[i].Key(), h.items[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+func (h *iteratorHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *iteratorHeap) Push(x interface{}) { h.items = append(h.items, x.(Iterator)) }
+func (h *iteratorHeap) Pop() interface{} {
+	old := h.items
+	n := len(old)
+	x := old[n-1]
+	h.items = old[0 : n-1]
+	return x
+}
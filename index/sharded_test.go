@@ -0,0 +1,124 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// 分片内部使用的索引，SkipList 和 BTree 的迭代器实现不同，两者都需要覆盖
+var shardedTestIndexers = []struct {
+	name       string
+	newIndexer func() Indexer
+}{
+	{"skiplist", func() Indexer { return NewSkipList() }},
+	{"btree", func() Indexer { return NewBTree() }},
+}
+
+func TestShardedIndex_PutGetDelete(t *testing.T) {
+	for _, tt := range shardedTestIndexers {
+		t.Run(tt.name, func(t *testing.T) {
+			testShardedIndexPutGetDelete(t, NewShardedIndex(8, tt.newIndexer))
+		})
+	}
+}
+
+func testShardedIndexPutGetDelete(t *testing.T, s *ShardedIndex) {
+	assert.True(t, s.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11}))
+	assert.True(t, s.Put([]byte("bbb"), &data.LogRecordPos{Fid: 1, Offset: 22}))
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 11}, s.Get([]byte("aaa")))
+	assert.True(t, s.IsExist([]byte("bbb")))
+	assert.Nil(t, s.Get([]byte("ccc")))
+
+	assert.True(t, s.Delete([]byte("aaa")))
+	assert.False(t, s.Delete([]byte("aaa")))
+	assert.Nil(t, s.Get([]byte("aaa")))
+}
+
+func TestShardedIndex_Iterator(t *testing.T) {
+	for _, tt := range shardedTestIndexers {
+		t.Run(tt.name, func(t *testing.T) {
+			testShardedIndexIterator(t, NewShardedIndex(8, tt.newIndexer))
+		})
+	}
+}
+
+func testShardedIndexIterator(t *testing.T, s *ShardedIndex) {
+	iter1 := s.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	for i := 0; i < 1000; i++ {
+		s.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+
+	// 多路归并后 key 全局有序
+	i := 0
+	iter2 := s.Iterator(false)
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, utils.GetTestKey(i), iter2.Key())
+		assert.Equal(t, uint64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 1000, i)
+
+	i = 999
+	iter3 := s.Iterator(true)
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		assert.Equal(t, utils.GetTestKey(i), iter3.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+
+	iter4 := s.Iterator(false)
+	iter4.Seek([]byte("bitcask-go-0000005000"))
+	assert.Equal(t, utils.GetTestKey(501), iter4.Key())
+
+	iter5 := s.Iterator(true)
+	iter5.Seek([]byte("bitcask-go-0000005000"))
+	assert.Equal(t, utils.GetTestKey(500), iter5.Key())
+	iter5.Close()
+	assert.False(t, iter5.Valid())
+}
+
+func TestShardedIndex_Concurrent(t *testing.T) {
+	s := NewShardedIndex(16, func() Indexer { return NewART() })
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := utils.GetTestKey(w*1000 + i)
+				s.Put(key, &data.LogRecordPos{Fid: uint32(w), Offset: uint64(i)})
+				assert.NotNil(t, s.Get(key))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	count := 0
+	iter := s.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 8000, count)
+}
+
+// 内部为哈希索引时，每个分片中的 key 仍然分布到哈希索引的所有分片
+func TestShardedIndex_HashMap(t *testing.T) {
+	s := NewShardedIndex(8, func() Indexer { return NewHashMap() })
+	for i := 0; i < 8*hashShardNum*20; i++ {
+		s.Put(utils.GetTestKey(i), &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	for _, shard := range s.shards {
+		used := 0
+		for _, inner := range shard.(*HashMap).shards {
+			if len(inner.items) > 0 {
+				used++
+			}
+		}
+		assert.Equal(t, hashShardNum, used)
+	}
+}
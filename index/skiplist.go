@@ -0,0 +1,258 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	skipListMaxLevel = 20 // 跳表的最大层数
+	skipListP        = 4  // 每升高一层的概率为 1/skipListP
+)
+
+// Skiplist 并发跳表索引
+// 写操作之间通过互斥锁串行执行，节点的指针通过原子操作发布，读操作和迭代器完全不加锁，
+// 因此读写混合的负载下读不会被写阻塞
+type Skiplist struct {
+	head  *skipListNode
+	level int32 // 当前最高层数，原子读写
+	size  int64 // key 的数量，原子读写
+	lock  *sync.Mutex
+	rand  *rand.Rand // 只在持有写锁时使用
+}
+
+type skipListNode struct {
+	key  []byte
+	pos  unsafe.Pointer   // *data.LogRecordPos，为 nil 表示节点已被删除
+	next []unsafe.Pointer // *skipListNode，每一层的后继节点
+}
+
+func NewSkipList() *Skiplist {
+	return &Skiplist{
+		head:  newSkipListNode(nil, nil, skipListMaxLevel),
+		level: 1,
+		lock:  new(sync.Mutex),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func newSkipListNode(key []byte, pos *data.LogRecordPos, level int) *skipListNode {
+	return &skipListNode{
+		key:  key,
+		pos:  unsafe.Pointer(pos),
+		next: make([]unsafe.Pointer, level),
+	}
+}
+
+func (n *skipListNode) loadNext(level int) *skipListNode {
+	return (*skipListNode)(atomic.LoadPointer(&n.next[level]))
+}
+
+func (n *skipListNode) storeNext(level int, next *skipListNode) {
+	atomic.StorePointer(&n.next[level], unsafe.Pointer(next))
+}
+
+func (n *skipListNode) loadPos() *data.LogRecordPos {
+	return (*data.LogRecordPos)(atomic.LoadPointer(&n.pos))
+}
+
+func (s *Skiplist) Put(key []byte, pos *data.LogRecordPos) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var prev [skipListMaxLevel]*skipListNode
+	node := s.findGreaterOrEqual(key, &prev)
+	if node != nil && bytes.Equal(node.key, key) {
+		atomic.StorePointer(&node.pos, unsafe.Pointer(pos))
+		return true
+	}
+
+	level := s.randomLevel()
+	currentLevel := int(atomic.LoadInt32(&s.level))
+	if level > currentLevel {
+		for i := currentLevel; i < level; i++ {
+			prev[i] = s.head
+		}
+		atomic.StoreInt32(&s.level, int32(level))
+	}
+
+	// 先设置新节点的后继，再从底层开始逐层发布，读者看到的始终是合法的链表
+	node = newSkipListNode(key, pos, level)
+	for i := 0; i < level; i++ {
+		node.next[i] = prev[i].next[i]
+	}
+	for i := 0; i < level; i++ {
+		prev[i].storeNext(i, node)
+	}
+	atomic.AddInt64(&s.size, 1)
+	return true
+}
+
+func (s *Skiplist) Get(key []byte) *data.LogRecordPos {
+	node := s.findGreaterOrEqual(key, nil)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.loadPos()
+}
+
+func (s *Skiplist) Delete(key []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var prev [skipListMaxLevel]*skipListNode
+	node := s.findGreaterOrEqual(key, &prev)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return false
+	}
+
+	// 先标记删除，再从高层到底层摘除节点，正在访问该节点的读者仍然可以沿着它的后继继续遍历
+	atomic.StorePointer(&node.pos, nil)
+	for i := len(node.next) - 1; i >= 0; i-- {
+		prev[i].storeNext(i, node.loadNext(i))
+	}
+	atomic.AddInt64(&s.size, -1)
+	return true
+}
+
+// IsExist 判断key是否已经存在
+func (s *Skiplist) IsExist(key []byte) bool {
+	return s.Get(key) != nil
+}
+
+func (s *Skiplist) Size() int {
+	return int(atomic.LoadInt64(&s.size))
+}
+
+func (s *Skiplist) Iterator(reverse bool) Iterator {
+	if s == nil {
+		return nil
+	}
+	it := &SkiplistIterator{list: s, reverse: reverse}
+	it.Rewind()
+	return it
+}
+
+func (s *Skiplist) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && s.rand.Intn(skipListP) == 0 {
+		level++
+	}
+	return level
+}
+
+// 查找第一个大于等于 key 的节点，prev 不为空时记录每一层的前驱节点
+func (s *Skiplist) findGreaterOrEqual(key []byte, prev *[skipListMaxLevel]*skipListNode) *skipListNode {
+	node := s.head
+	for i := int(atomic.LoadInt32(&s.level)) - 1; i >= 0; i-- {
+		next := node.loadNext(i)
+		for next != nil && bytes.Compare(next.key, key) < 0 {
+			node = next
+			next = node.loadNext(i)
+		}
+		if prev != nil {
+			prev[i] = node
+		}
+		if i == 0 {
+			return next
+		}
+	}
+	return nil
+}
+
+// 查找最后一个小于 key 的节点，inclusive 为 true 时查找最后一个小于等于 key 的节点
+// key 为 nil 时查找最后一个节点，不存在时返回 nil
+func (s *Skiplist) findLess(key []byte, inclusive bool) *skipListNode {
+	node := s.head
+	for i := int(atomic.LoadInt32(&s.level)) - 1; i >= 0; i-- {
+		next := node.loadNext(i)
+		for next != nil {
+			cmp := 0
+			if key != nil {
+				cmp = bytes.Compare(next.key, key)
+			}
+			if key != nil && (cmp > 0 || (cmp == 0 && !inclusive)) {
+				break
+			}
+			node = next
+			next = node.loadNext(i)
+		}
+	}
+	if node == s.head {
+		return nil
+	}
+	return node
+}
+
+// SkiplistIterator 跳表迭代器，不加锁、不拷贝数据，遍历期间的并发修改可能可见
+type SkiplistIterator struct {
+	list    *Skiplist
+	reverse bool               // 是否反向遍历
+	node    *skipListNode      // 当前节点，为 nil 表示遍历结束
+	pos     *data.LogRecordPos // 移动到当前节点时读到的位置
+}
+
+func (it *SkiplistIterator) Rewind() {
+	if !it.reverse {
+		it.moveTo(it.list.head.loadNext(0))
+	} else {
+		it.moveTo(it.list.findLess(nil, true))
+	}
+}
+
+// Seek 从第一个大于(反向时为小于)等于 key 的位置开始遍历，时间复杂度 O(log n)
+func (it *SkiplistIterator) Seek(key []byte) {
+	if !it.reverse {
+		it.moveTo(it.list.findGreaterOrEqual(key, nil))
+	} else {
+		it.moveTo(it.list.findLess(key, true))
+	}
+}
+
+func (it *SkiplistIterator) Next() {
+	if it.node == nil {
+		return
+	}
+	if !it.reverse {
+		it.moveTo(it.node.loadNext(0))
+	} else {
+		it.moveTo(it.list.findLess(it.node.key, false))
+	}
+}
+
+// 移动到 node，跳过已经被删除的节点
+func (it *SkiplistIterator) moveTo(node *skipListNode) {
+	for node != nil {
+		if pos := node.loadPos(); pos != nil {
+			it.node, it.pos = node, pos
+			return
+		}
+		if !it.reverse {
+			node = node.loadNext(0)
+		} else {
+			node = it.list.findLess(node.key, false)
+		}
+	}
+	it.node, it.pos = nil, nil
+}
+
+func (it *SkiplistIterator) Valid() bool {
+	return it.node != nil
+}
+
+func (it *SkiplistIterator) Key() []byte {
+	return it.node.key
+}
+
+func (it *SkiplistIterator) Value() *data.LogRecordPos {
+	return it.pos
+}
+
+func (it *SkiplistIterator) Close() {
+	it.node, it.pos = nil, nil
+}
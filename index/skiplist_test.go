@@ -0,0 +1,172 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestSkiplist_Put(t *testing.T) {
+	sl := NewSkipList()
+
+	res := sl.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	assert.True(t, res)
+
+	res = sl.Put([]byte("iii"), &data.LogRecordPos{Fid: 2, Offset: 22})
+	assert.True(t, res)
+	assert.Equal(t, int64(2), sl.size)
+}
+
+func TestSkiplist_Get(t *testing.T) {
+	sl := NewSkipList()
+
+	sl.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	sl.Put([]byte("iii"), &data.LogRecordPos{Fid: 2, Offset: 22})
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 11}, sl.Get([]byte("aaa")))
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 22}, sl.Get([]byte("iii")))
+	assert.Nil(t, sl.Get([]byte("bbb")))
+
+	sl.Put([]byte("iii"), &data.LogRecordPos{Fid: 3, Offset: 33})
+	assert.Equal(t, &data.LogRecordPos{Fid: 3, Offset: 33}, sl.Get([]byte("iii")))
+	assert.Equal(t, int64(2), sl.size)
+}
+
+func TestSkiplist_Delete(t *testing.T) {
+	sl := NewSkipList()
+
+	sl.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 11})
+	sl.Put([]byte("iii"), &data.LogRecordPos{Fid: 2, Offset: 22})
+
+	assert.True(t, sl.Delete([]byte("aaa")))
+	assert.False(t, sl.Delete([]byte("aaa")))
+	assert.Nil(t, sl.Get([]byte("aaa")))
+	assert.False(t, sl.IsExist([]byte("aaa")))
+	assert.True(t, sl.IsExist([]byte("iii")))
+	assert.Equal(t, int64(1), sl.size)
+}
+
+func TestSkiplist_Iterator(t *testing.T) {
+	sl := NewSkipList()
+	// 1.跳表为空的情况
+	iter1 := sl.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1 = sl.Iterator(true)
+	assert.False(t, iter1.Valid())
+
+	// 2.有多条数据
+	sl.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	sl.Put([]byte("acee"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	sl.Put([]byte("eede"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	sl.Put([]byte("bbcd"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	var keys []string
+	iter2 := sl.Iterator(false)
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		keys = append(keys, string(iter2.Key()))
+	}
+	assert.Equal(t, []string{"acee", "bbcd", "ccde", "eede"}, keys)
+
+	keys = nil
+	iter3 := sl.Iterator(true)
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		keys = append(keys, string(iter3.Key()))
+	}
+	assert.Equal(t, []string{"eede", "ccde", "bbcd", "acee"}, keys)
+
+	// 3.测试 seek
+	iter4 := sl.Iterator(false)
+	iter4.Seek([]byte("cc"))
+	assert.Equal(t, []byte("ccde"), iter4.Key())
+	iter4.Seek([]byte("zz"))
+	assert.False(t, iter4.Valid())
+
+	// 4.反向遍历的 seek
+	iter5 := sl.Iterator(true)
+	iter5.Seek([]byte("cc"))
+	assert.Equal(t, []byte("bbcd"), iter5.Key())
+	iter5.Seek([]byte("ccde"))
+	assert.Equal(t, []byte("ccde"), iter5.Key())
+	iter5.Seek([]byte("a"))
+	assert.False(t, iter5.Valid())
+}
+
+func TestSkiplist_Random(t *testing.T) {
+	sl := NewSkipList()
+	expected := make(map[string]*data.LogRecordPos)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := utils.GetTestKey(rnd.Intn(5000))
+		if rnd.Intn(4) == 0 {
+			_, ok := expected[string(key)]
+			assert.Equal(t, ok, sl.Delete(key))
+			delete(expected, string(key))
+		} else {
+			pos := &data.LogRecordPos{Fid: uint32(i), Offset: uint64(i)}
+			sl.Put(key, pos)
+			expected[string(key)] = pos
+		}
+	}
+	assert.Equal(t, int64(len(expected)), sl.size)
+
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	iter := sl.Iterator(false)
+	i := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, keys[i], string(iter.Key()))
+		assert.Equal(t, expected[keys[i]], iter.Value())
+		i++
+	}
+	assert.Equal(t, len(keys), i)
+}
+
+// 并发读写，配合 go test -race 检查数据竞争
+func TestSkiplist_Concurrent(t *testing.T) {
+	sl := NewSkipList()
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := utils.GetTestKey(w*2000 + i)
+				sl.Put(key, &data.LogRecordPos{Fid: uint32(w), Offset: uint64(i)})
+				if i%3 == 0 {
+					sl.Delete(key)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				sl.Get(utils.GetTestKey(i))
+				it := sl.Iterator(i%2 == 0)
+				for j := 0; it.Valid() && j < 10; j++ {
+					it.Next()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	count := 0
+	iter := sl.Iterator(false)
+	var prev []byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.True(t, prev == nil || string(prev) < string(iter.Key()))
+		prev = iter.Key()
+		count++
+	}
+	assert.Equal(t, int64(count), sl.size)
+	assert.Equal(t, 4*(2000-667), count)
+}
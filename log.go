@@ -0,0 +1,222 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"sync/atomic"
+)
+
+// LogPosition 日志中的位置，即数据文件编号和文件中的偏移量，零值表示日志的开头。
+// 合并会重写之前的数据文件，Generation 记录位置属于第几次合并之后的日志，用来识别已经失效的位置
+type LogPosition struct {
+	Generation uint32
+	Fid        uint32
+	Offset     uint64
+}
+
+func (p LogPosition) String() string {
+	return fmt.Sprintf("%d:%d:%d", p.Generation, p.Fid, p.Offset)
+}
+
+// LogEntry 日志中的一条记录
+type LogEntry struct {
+	Pos    LogPosition // 记录的起始位置
+	Key    []byte
+	Value  []byte
+	Delete bool // 是否为删除记录
+}
+
+// LogEnd 返回日志的结束位置，即下一条记录写入的位置
+func (db *DB) LogEnd() LogPosition {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.logEnd()
+}
+
+// 该方法必须在加锁的条件下调用
+func (db *DB) logEnd() LogPosition {
+	if db.activityDataFile == nil {
+		return LogPosition{}
+	}
+	return LogPosition{Generation: db.logGeneration(), Fid: db.activityDataFile.FileId, Offset: db.activityDataFile.WriteOff}
+}
+
+// MergeGeneration 返回当前的合并代数，从未合并过时为 0，每次合并之后加一。
+// 合并会复用文件编号，记录的位置只在同一代中唯一，位置和合并代数一起才能标识 key 的一个版本。
+// 先读取合并代数再读取位置时，两者不一致只会让之后的比较失败，不会误判为相同。
+// 该方法不加锁，可以在 PutIf 和 DeleteIf 的 cond 中调用
+func (db *DB) MergeGeneration() uint32 {
+	return atomic.LoadUint32(&db.generation)
+}
+
+// 当前日志的合并代数，从未合并过时为 0
+// 该方法必须在加锁的条件下调用
+func (db *DB) logGeneration() uint32 {
+	if db.mergeEnd == (LogPosition{}) {
+		return 0
+	}
+	return db.mergeEnd.Generation + 1
+}
+
+// SetLogRetention 设置合并时需要保留的日志位置，fn 为空时取消。
+// 合并开始时调用 fn，返回的位置所在的文件及之后的文件不会被重写，从这些位置继续 ReadLog 不会返回 ErrLogPositionUnavailable。
+// fn 在持有 DB 的锁时调用，不能调用 DB 的方法
+func (db *DB) SetLogRetention(fn func() []LogPosition) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.logRetention = fn
+}
+
+// 合并的文件编号上限不能超过的值，没有需要保留的位置时返回 boundary
+// 该方法必须在加锁的条件下调用
+func (db *DB) retainBoundary(boundary uint32) uint32 {
+	if db.logRetention == nil {
+		return boundary
+	}
+	generation := db.logGeneration()
+	for _, pos := range db.logRetention() {
+		switch {
+		case pos == (LogPosition{}):
+			// 从日志的开头读取不受合并影响
+		case pos.Fid > db.mergeEnd.Fid || pos.Generation == generation:
+			if pos.Fid < boundary {
+				boundary = pos.Fid
+			}
+		case pos == db.mergeEnd:
+			// 上次合并的结束位置，再次合并之后无法继续，这次不合并
+			return 0
+		}
+	}
+	return boundary
+}
+
+// ReadLog 从 pos 开始按写入顺序读取日志中的记录，每条记录调用一次 fn，fn 返回 false 时结束读取。
+// 返回最后一条读取的记录之后的位置，下次从这里继续读取。
+// 读取期间阻塞写入，fn 中不能调用 DB 的写入方法。
+// 合并重写了 pos 所在的文件时返回 ErrLogPositionUnavailable，只能从日志的开头或者备份重新开始
+func (db *DB) ReadLog(pos LogPosition, fn func(entry *LogEntry) bool) (LogPosition, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dataFiles, i, pos, err := db.locateLog(pos)
+	if err != nil || len(dataFiles) == 0 {
+		return pos, err
+	}
+	for {
+		dataFile := dataFiles[i]
+		end, err := db.dataFileSize(dataFile)
+		if err != nil {
+			return pos, err
+		}
+		if pos.Offset > end {
+			return pos, ErrLogPositionUnavailable
+		}
+		for pos.Offset < end {
+			logRecord, size, err := dataFile.ReadLogRecord(int64(pos.Offset))
+			if err != nil {
+				return pos, err
+			}
+			entry := &LogEntry{
+				Pos:    pos,
+				Key:    logRecord.Key,
+				Value:  logRecord.Value,
+				Delete: logRecord.Type == data.LogRecordDelete,
+			}
+			pos.Offset += uint64(size)
+			if !fn(entry) {
+				return pos, nil
+			}
+		}
+		// 旧文件读完之后从下一个文件的开头继续
+		if i == len(dataFiles)-1 {
+			return pos, nil
+		}
+		i++
+		pos = LogPosition{Generation: pos.Generation, Fid: dataFiles[i].FileId}
+	}
+}
+
+// WaitLog 返回一个 channel，日志中 pos 之后有新的记录时关闭。pos 不是日志的结束位置时立即关闭
+func (db *DB) WaitLog(pos LogPosition) <-chan struct{} {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if pos != db.logEnd() {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if db.logWaiter == nil {
+		db.logWaiter = make(chan struct{})
+	}
+	return db.logWaiter
+}
+
+// 唤醒等待新记录的调用方
+// 该方法必须在加锁的条件下调用
+func (db *DB) notifyLog() {
+	if db.logWaiter != nil {
+		close(db.logWaiter)
+		db.logWaiter = nil
+	}
+}
+
+// LogDistance 返回日志中 pos 之后的字节数
+func (db *DB) LogDistance(pos LogPosition) (uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dataFiles, i, pos, err := db.locateLog(pos)
+	if err != nil {
+		return 0, err
+	}
+	var distance uint64
+	for ; i < len(dataFiles); i++ {
+		size, err := db.dataFileSize(dataFiles[i])
+		if err != nil {
+			return 0, err
+		}
+		if dataFiles[i].FileId == pos.Fid {
+			if pos.Offset > size {
+				return 0, ErrLogPositionUnavailable
+			}
+			size -= pos.Offset
+		}
+		distance += size
+	}
+	return distance, nil
+}
+
+// 找到 pos 所在的数据文件，返回所有数据文件、pos 所在文件的下标和调整后的位置
+// 该方法必须在加锁的条件下调用
+func (db *DB) locateLog(pos LogPosition) ([]*data.DataFile, int, LogPosition, error) {
+	dataFiles := db.dataFiles()
+	if len(dataFiles) == 0 {
+		if pos != (LogPosition{}) {
+			return nil, 0, pos, ErrLogPositionUnavailable
+		}
+		return nil, 0, pos, nil
+	}
+
+	// 合并重写了 mergeEnd 所在的文件及之前的文件，这些文件中之前的位置全部失效，
+	// 只有恰好位于合并开始时的结束位置时可以从之后的文件继续
+	generation := db.logGeneration()
+	afterMerge := false
+	if pos == (LogPosition{}) {
+		pos = LogPosition{Fid: dataFiles[0].FileId}
+	} else if pos.Fid <= db.mergeEnd.Fid && pos.Generation != generation {
+		if pos != db.mergeEnd {
+			return nil, 0, pos, ErrLogPositionUnavailable
+		}
+		afterMerge = true
+	}
+	pos.Generation = generation
+	for i, dataFile := range dataFiles {
+		if afterMerge && dataFile.FileId > pos.Fid {
+			return dataFiles, i, LogPosition{Generation: generation, Fid: dataFile.FileId}, nil
+		}
+		if !afterMerge && dataFile.FileId == pos.Fid {
+			return dataFiles, i, pos, nil
+		}
+	}
+	return nil, 0, pos, ErrLogPositionUnavailable
+}
@@ -0,0 +1,147 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// 从 pos 开始读取日志，每次最多读取 limit 条记录，把记录写入 target，返回读完之后的位置
+func replayLog(t *testing.T, db *DB, target *DB, pos LogPosition, limit int) LogPosition {
+	for {
+		batch := &WriteBatch{}
+		next, err := db.ReadLog(pos, func(entry *LogEntry) bool {
+			if entry.Delete {
+				batch.Delete(entry.Key)
+			} else {
+				batch.Put(entry.Key, entry.Value)
+			}
+			return batch.Len() < limit
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, target.Write(batch))
+		if next == pos {
+			return pos
+		}
+		pos = next
+	}
+}
+
+func TestDB_ReadLog(t *testing.T) {
+	opts := newMergeTestOptions("/bitcask-go-log")
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Equal(t, LogPosition{}, db.LogEnd())
+	pos, err := db.ReadLog(LogPosition{}, func(entry *LogEntry) bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, LogPosition{}, pos)
+
+	// 记录带有所在的位置，按写入的顺序读出
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.Delete([]byte("k1")))
+	var entries []*LogEntry
+	pos, err = db.ReadLog(LogPosition{}, func(entry *LogEntry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, db.LogEnd(), pos)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, LogPosition{Fid: 1}, entries[0].Pos)
+	assert.Equal(t, []byte("v1"), entries[0].Value)
+	assert.True(t, entries[1].Delete)
+	assert.Equal(t, []byte("k1"), entries[1].Key)
+	distance, err := db.LogDistance(entries[1].Pos)
+	assert.Nil(t, err)
+	assert.Equal(t, pos.Offset-entries[1].Pos.Offset, distance)
+
+	// 跨越多个文件分批重放，得到相同的数据
+	expected := writeMergeTestData(t, db)
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DataFileNum > 1)
+	distance, err = db.LogDistance(LogPosition{})
+	assert.Nil(t, err)
+	assert.Equal(t, stat.DiskSize, distance)
+
+	replicaOpts := newMergeTestOptions("/bitcask-go-log-replica")
+	replica, err := Start(&replicaOpts)
+	assert.Nil(t, err)
+	pos = replayLog(t, db, replica, LogPosition{}, 7)
+	assert.Equal(t, db.LogEnd(), pos)
+	verifyMergeTestData(t, replica, expected)
+
+	// 等待新的记录
+	wait := db.WaitLog(pos)
+	select {
+	case <-wait:
+		t.Fatal("no new log records")
+	default:
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = db.Put([]byte("new"), []byte("value"))
+	}()
+	select {
+	case <-wait:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait log timeout")
+	}
+	pos = replayLog(t, db, replica, pos, 7)
+	value, err := replica.Get([]byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	<-db.WaitLog(LogPosition{Fid: 1})
+
+	_, err = db.ReadLog(LogPosition{Fid: pos.Fid + 1}, func(entry *LogEntry) bool { return true })
+	assert.Equal(t, ErrLogPositionUnavailable, err)
+	_, err = db.ReadLog(LogPosition{Fid: pos.Fid, Offset: pos.Offset + 1}, func(entry *LogEntry) bool { return true })
+	assert.Equal(t, ErrLogPositionUnavailable, err)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, replica.Close())
+}
+
+func TestDB_ReadLogMerge(t *testing.T) {
+	opts := newMergeTestOptions("/bitcask-go-log-merge")
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	expected := writeMergeTestData(t, db)
+	lagging := LogPosition{Fid: 1}
+	caughtUp := db.LogEnd()
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("after-merge")))
+	expected[string(utils.GetTestKey(1))] = []byte("after-merge")
+
+	check := func(db *DB) {
+		// 合并重写了之前的文件，只能从合并开始时的位置或者日志开头继续
+		_, err := db.ReadLog(lagging, func(entry *LogEntry) bool { return true })
+		assert.Equal(t, ErrLogPositionUnavailable, err)
+		_, err = db.LogDistance(lagging)
+		assert.Equal(t, ErrLogPositionUnavailable, err)
+
+		var keys [][]byte
+		_, err = db.ReadLog(caughtUp, func(entry *LogEntry) bool {
+			keys = append(keys, entry.Key)
+			return true
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{utils.GetTestKey(1)}, keys)
+
+		replicaOpts := newMergeTestOptions("/bitcask-go-log-merge-replica")
+		replica, err := Start(&replicaOpts)
+		assert.Nil(t, err)
+		replayLog(t, db, replica, LogPosition{}, 100)
+		verifyMergeTestData(t, replica, expected)
+		assert.Nil(t, replica.Close())
+	}
+	check(db)
+
+	// 重启之后仍然拒绝被重写的位置
+	assert.Nil(t, db.Close())
+	db, err = Start(&opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Close())
+}
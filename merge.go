@@ -0,0 +1,420 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// 合并时新数据文件所在的目录，位于数据目录下
+	mergeDirName = "merge"
+	// 合并完成的标记文件，保存参与合并的文件编号上限和合并后的文件数量
+	mergeFinishedFileName = "merge.finished"
+	// 保存最近一次合并开始时的日志结束位置，位于数据目录下
+	mergeEndFileName = "merge.end"
+)
+
+// 合并时重写的记录，合并完成时索引仍然指向 oldPos 才更新为 newPos
+type mergedRecord struct {
+	key    []byte
+	oldPos *data.LogRecordPos
+	newPos *data.LogRecordPos
+}
+
+// Merge 合并旧的数据文件，只保留其中仍然有效的记录，回收被覆盖和删除的数据占用的空间
+//
+// 合并开始时活跃文件转为旧文件，之后的写入进入新的活跃文件，不受合并影响。
+// 有效的记录先写入 merge 目录，全部写完并刷盘后写入完成标记，再替换掉原来的数据文件。
+// 合并后的文件编号从 1 开始，仍然小于合并期间写入的文件，启动时按文件编号加载，后写入的记录依然覆盖先写入的记录。
+// 替换过程中崩溃时，下次启动会根据完成标记继续替换，没有完成标记则丢弃合并的结果。
+// 设置了 SetLogRetention 时，需要保留的位置所在的文件及之后的文件不参与合并。
+// 合并期间不能关闭数据库
+func (db *DB) Merge() error {
+	if db.options.ReadOnly {
+		return ErrDBReadOnly
+	}
+	mergeFiles, boundary, err := db.prepareMerge()
+	if err != nil || len(mergeFiles) == 0 {
+		return err
+	}
+	defer func() {
+		db.mu.Lock()
+		db.merging = false
+		db.mu.Unlock()
+	}()
+
+	mergeDir := path.Join(db.options.DBFileDir, mergeDirName)
+	if err := db.removeMergeDir(mergeDir); err != nil {
+		return err
+	}
+	if err := db.fs.MkdirAll(mergeDir); err != nil {
+		return err
+	}
+	records, count, err := db.rewriteDataFiles(mergeDir, mergeFiles)
+	var end LogPosition
+	if err == nil {
+		end, err = db.writeMergeEnd(mergeFiles[len(mergeFiles)-1])
+	}
+	if err == nil {
+		err = db.writeMergeFinished(mergeDir, boundary, count)
+	}
+	if err != nil {
+		_ = db.removeMergeDir(mergeDir)
+		return err
+	}
+	return db.finishMerge(mergeDir, boundary, count, records, end)
+}
+
+// 活跃文件转为旧文件，返回参与合并的旧文件和合并的文件编号上限
+func (db *DB) prepareMerge() ([]*data.DataFile, uint32, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.merging {
+		return nil, 0, ErrMergeIsProgress
+	}
+	if db.activityDataFile == nil {
+		return nil, 0, nil
+	}
+	if db.activityDataFile.WriteOff > 0 {
+		if err := db.rotateActivityDataFile(); err != nil {
+			return nil, 0, err
+		}
+	}
+	// 只合并需要保留的日志位置之前的文件
+	boundary := db.retainBoundary(db.activityDataFile.FileId)
+	var mergeFiles []*data.DataFile
+	for _, dataFile := range db.dataFiles() {
+		if dataFile.FileId < boundary {
+			mergeFiles = append(mergeFiles, dataFile)
+		}
+	}
+	if len(mergeFiles) > 0 {
+		db.merging = true
+	}
+	return mergeFiles, boundary, nil
+}
+
+// 将旧文件中索引仍然指向的记录写入 mergeDir，返回重写的记录和合并后的文件数量
+// 旧文件不会再被修改，这里不需要加锁
+func (db *DB) rewriteDataFiles(mergeDir string, mergeFiles []*data.DataFile) ([]*mergedRecord, uint32, error) {
+	var records []*mergedRecord
+	var output *data.DataFile
+	var fid uint32
+	var writeErr error
+
+	closeOutput := func() error {
+		if output == nil {
+			return nil
+		}
+		if err := output.Sync(); err != nil {
+			_ = output.Close()
+			return err
+		}
+		return output.Close()
+	}
+
+	for _, dataFile := range mergeFiles {
+		err := dataFile.Scan(func(logRecord *data.LogRecord, pos *data.LogRecordPos) bool {
+			if logRecord.Type != data.LogRecordNormal {
+				return true
+			}
+			current := db.index.Get(logRecord.Key)
+			if current == nil || current.Fid != pos.Fid || current.Offset != pos.Offset {
+				return true
+			}
+
+			size := uint64(dataFile.EncodeLogRecordSize(logRecord))
+			if output == nil || (output.WriteOff > 0 && output.WriteOff+size >= db.options.FileMaxSize) {
+				if writeErr = closeOutput(); writeErr != nil {
+					return false
+				}
+				fid++
+				if output, writeErr = data.OpenDataFile(db.fs, mergeDir, fid); writeErr != nil {
+					return false
+				}
+			}
+			n, err := output.WriteLogRecord(logRecord)
+			if err != nil {
+				writeErr = err
+				return false
+			}
+			records = append(records, &mergedRecord{
+				// key 与 value 共用同一块内存，拷贝 key 之后 value 可以被回收
+				key:    append([]byte(nil), logRecord.Key...),
+				oldPos: pos,
+				newPos: &data.LogRecordPos{Fid: fid, Offset: output.WriteOff - uint64(n), Size: uint32(n)},
+			})
+			return true
+		})
+		if err == nil {
+			err = writeErr
+		}
+		if err != nil {
+			_ = closeOutput()
+			return nil, 0, err
+		}
+	}
+	if err := closeOutput(); err != nil {
+		return nil, 0, err
+	}
+	return records, fid, nil
+}
+
+// 更新索引，用合并后的文件替换旧文件，end 为合并开始时的日志结束位置
+func (db *DB) finishMerge(mergeDir string, boundary, count uint32, records []*mergedRecord, end LogPosition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 合并期间被覆盖或删除的 key 不更新
+	for _, record := range records {
+		current := db.index.Get(record.key)
+		if current != nil && current.Fid == record.oldPos.Fid && current.Offset == record.oldPos.Offset {
+			db.index.Put(record.key, record.newPos)
+		}
+	}
+
+	for fid, dataFile := range db.oldDataFiles {
+		if fid < boundary {
+			if err := dataFile.Close(); err != nil {
+				return err
+			}
+			delete(db.oldDataFiles, fid)
+		}
+	}
+	if err := db.replaceDataFiles(mergeDir, boundary, count); err != nil {
+		return err
+	}
+	for fid := uint32(1); fid <= count; fid++ {
+		dataFile, err := data.OpenDataFile(db.fs, db.options.DBFileDir, fid)
+		if err != nil {
+			return err
+		}
+		db.oldDataFiles[fid] = dataFile
+	}
+
+	// 文件编号被复用，缓存中的位置全部失效
+	if db.cache != nil {
+		db.cache.Clear()
+	}
+	db.mergeEnd = end
+	atomic.StoreUint32(&db.generation, db.logGeneration())
+	// 持久化索引先提交新的位置，再删除完成标记
+	if err := db.commitIndex(); err != nil {
+		return err
+	}
+	return db.removeMergeDir(mergeDir)
+}
+
+// 用 mergeDir 中的文件替换编号小于 boundary 的数据文件，中途失败后可以重复执行
+func (db *DB) replaceDataFiles(mergeDir string, boundary, count uint32) error {
+	names, err := db.fs.ReadDir(mergeDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, data.DataFileSubffix) {
+			if err := db.fs.Rename(path.Join(mergeDir, name), path.Join(db.options.DBFileDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 合并后的文件编号为 1 到 count，其余参与合并的文件直接删除
+	names, err = db.fs.ReadDir(db.options.DBFileDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, data.DataFileSubffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.Split(name, ".")[0])
+		if err != nil {
+			return ErrDataFileDamaged
+		}
+		if uint32(fid) > count && uint32(fid) < boundary {
+			if err := db.fs.Remove(path.Join(db.options.DBFileDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 启动时处理上次未完成的合并，有完成标记则继续替换数据文件，否则丢弃合并的结果
+func (db *DB) recoverMerge() error {
+	mergeDir := path.Join(db.options.DBFileDir, mergeDirName)
+	names, err := db.fs.ReadDir(mergeDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	finished := false
+	for _, name := range names {
+		if name == mergeFinishedFileName {
+			finished = true
+		}
+	}
+	if db.options.ReadOnly {
+		if finished {
+			return ErrMergeUnfinished
+		}
+		return nil
+	}
+
+	if finished {
+		boundary, count, err := db.readMergeFinished(mergeDir)
+		if err == nil {
+			if err := db.replaceDataFiles(mergeDir, boundary, count); err != nil {
+				return err
+			}
+			// 持久化索引可能还指向旧文件中的位置，删除后从数据文件重建
+			indexFile := path.Join(db.options.DBFileDir, index.BPlusTreeFileName)
+			if err := db.fs.Remove(indexFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return db.removeMergeDir(mergeDir)
+}
+
+func (db *DB) writeMergeFinished(mergeDir string, boundary, count uint32) error {
+	file, err := db.fs.OpenFile(path.Join(mergeDir, mergeFinishedFileName))
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write([]byte(fmt.Sprintf("%d %d\n", boundary, count))); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// 读取完成标记，标记不完整时返回错误
+func (db *DB) readMergeFinished(mergeDir string) (uint32, uint32, error) {
+	file, err := db.fs.OpenReadOnly(path.Join(mergeDir, mergeFinishedFileName))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	size, err := file.Size()
+	if err != nil {
+		return 0, 0, err
+	}
+	buf := make([]byte, size)
+	if _, err := file.Read(buf, 0); err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	var boundary, count uint32
+	if _, err := fmt.Sscanf(string(buf), "%d %d\n", &boundary, &count); err != nil {
+		return 0, 0, err
+	}
+	return boundary, count, nil
+}
+
+// 记录合并开始时的日志结束位置，即参与合并的最后一个文件的末尾，ReadLog 据此拒绝已经被重写的位置。
+// 在替换数据文件之前写入，合并没有完成时只会多拒绝一些仍然有效的位置
+func (db *DB) writeMergeEnd(lastFile *data.DataFile) (LogPosition, error) {
+	size, err := lastFile.Size()
+	if err != nil {
+		return LogPosition{}, err
+	}
+	// 合并不会同时执行，合并代数只在 finishMerge 中修改
+	db.mu.RLock()
+	end := LogPosition{Generation: db.logGeneration(), Fid: lastFile.FileId, Offset: size}
+	db.mu.RUnlock()
+
+	// 先写入临时文件再重命名，崩溃时不会留下不完整的内容
+	name := path.Join(db.options.DBFileDir, mergeEndFileName)
+	tmpName := name + ".tmp"
+	if err := db.fs.Remove(tmpName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return LogPosition{}, err
+	}
+	file, err := db.fs.OpenFile(tmpName)
+	if err != nil {
+		return LogPosition{}, err
+	}
+	if _, err := file.Write([]byte(fmt.Sprintf("%d %d %d\n", end.Generation, end.Fid, end.Offset))); err != nil {
+		_ = file.Close()
+		return LogPosition{}, err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return LogPosition{}, err
+	}
+	if err := file.Close(); err != nil {
+		return LogPosition{}, err
+	}
+	return end, db.fs.Rename(tmpName, name)
+}
+
+// 启动时读取最近一次合并开始时的日志结束位置，从未合并过时为零值
+func (db *DB) loadMergeEnd() error {
+	// 打开文件时会创建不存在的文件，先确认文件存在
+	names, err := db.fs.ReadDir(db.options.DBFileDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	found := false
+	for _, name := range names {
+		if name == mergeEndFileName {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	file, err := db.fs.OpenReadOnly(path.Join(db.options.DBFileDir, mergeEndFileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	size, err := file.Size()
+	if err != nil || size == 0 {
+		return err
+	}
+	buf := make([]byte, size)
+	if _, err := file.Read(buf, 0); err != nil && err != io.EOF {
+		return err
+	}
+	end := &db.mergeEnd
+	if _, err := fmt.Sscanf(string(buf), "%d %d %d\n", &end.Generation, &end.Fid, &end.Offset); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&db.generation, db.logGeneration())
+	return nil
+}
+
+// 删除 merge 目录及其中的文件，目录不存在时不做任何操作
+func (db *DB) removeMergeDir(mergeDir string) error {
+	names, err := db.fs.ReadDir(mergeDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, name := range names {
+		if err := db.fs.Remove(path.Join(mergeDir, name)); err != nil {
+			return err
+		}
+	}
+	return db.fs.Remove(mergeDir)
+}
@@ -0,0 +1,201 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"path"
+	"sync"
+	"testing"
+)
+
+func newMergeTestOptions(dir string) Options {
+	opts := *DefaultOptions
+	opts.DBFileDir = dir
+	opts.FileMaxSize = 4 * 1024
+	opts.FS = fio.NewMemFS()
+	return opts
+}
+
+// 写入 1000 个 key，覆盖写入前 500 个，删除其中编号能被 3 整除的 key
+func writeMergeTestData(t *testing.T, db *DB) map[string][]byte {
+	expected := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+		expected[string(utils.GetTestKey(i))] = utils.GetTestKey(i)
+	}
+	for i := 0; i < 500; i++ {
+		value := []byte(fmt.Sprintf("new-value-%d", i))
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	for i := 0; i < 1000; i += 3 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	return expected
+}
+
+func verifyMergeTestData(t *testing.T, db *DB, expected map[string][]byte) {
+	assert.Equal(t, len(expected), len(db.ListKeys()))
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_Merge(t *testing.T) {
+	for _, indexType := range []index.DBIndexType{index.BTree, index.BPTree} {
+		opts := newMergeTestOptions("/bitcask-go-merge")
+		opts.DBIndex = indexType
+		opts.CacheSize = 64 * 1024
+		db, err := Start(&opts)
+		assert.Nil(t, err)
+		expected := writeMergeTestData(t, db)
+		verifyMergeTestData(t, db, expected)
+
+		before, err := db.Stat()
+		assert.Nil(t, err)
+		assert.Nil(t, db.Merge())
+		after, err := db.Stat()
+		assert.Nil(t, err)
+		assert.Equal(t, before.KeyNum, after.KeyNum)
+		assert.True(t, after.DiskSize < before.DiskSize/2)
+		assert.True(t, after.DataFileNum < before.DataFileNum)
+		verifyMergeTestData(t, db, expected)
+
+		// 合并目录已经删除
+		_, err = opts.FS.ReadDir(path.Join(opts.DBFileDir, mergeDirName))
+		assert.NotNil(t, err)
+
+		// 合并之后的写入和重启
+		assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("after-merge")))
+		expected[string(utils.GetTestKey(1))] = []byte("after-merge")
+		assert.Nil(t, db.Merge())
+		assert.Nil(t, db.Close())
+
+		db2, err := Start(&opts)
+		assert.Nil(t, err)
+		verifyMergeTestData(t, db2, expected)
+		assert.Nil(t, db2.Close())
+	}
+}
+
+func TestDB_MergeEmpty(t *testing.T) {
+	opts := newMergeTestOptions("/bitcask-go-merge-empty")
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put([]byte("hello"), []byte("world")))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Merge())
+	val, err := db.Get([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("world"), val)
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_MergeConcurrentWrite(t *testing.T) {
+	opts := newMergeTestOptions("/bitcask-go-merge-concurrent")
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	expected := writeMergeTestData(t, db)
+
+	// 合并期间覆盖写入和删除的 key 保持最新的状态
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, db.Merge())
+	}()
+	written := make(map[string][]byte)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i += 2 {
+			key := utils.GetTestKey(i)
+			if i%4 == 0 {
+				assert.Nil(t, db.Delete(key))
+				written[string(key)] = nil
+			} else {
+				value := []byte(fmt.Sprintf("concurrent-%d", i))
+				assert.Nil(t, db.Put(key, value))
+				written[string(key)] = value
+			}
+			val, err := db.Get(utils.GetTestKey(i + 1))
+			if err != ErrReadKeyNotFound {
+				assert.Nil(t, err)
+				assert.Equal(t, expected[string(utils.GetTestKey(i+1))], val)
+			}
+		}
+	}()
+	wg.Wait()
+	for key, value := range written {
+		if value == nil {
+			delete(expected, key)
+		} else {
+			expected[key] = value
+		}
+	}
+	verifyMergeTestData(t, db, expected)
+	assert.Nil(t, db.Close())
+
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	verifyMergeTestData(t, db2, expected)
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_MergeRecover(t *testing.T) {
+	opts := newMergeTestOptions("/bitcask-go-merge-recover")
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	expected := writeMergeTestData(t, db)
+
+	// 模拟写完合并文件之后、替换数据文件之前崩溃
+	mergeFiles, boundary, err := db.prepareMerge()
+	assert.Nil(t, err)
+	mergeDir := path.Join(opts.DBFileDir, mergeDirName)
+	assert.Nil(t, opts.FS.MkdirAll(mergeDir))
+	_, count, err := db.rewriteDataFiles(mergeDir, mergeFiles)
+	assert.Nil(t, err)
+	fs := opts.FS
+
+	// 没有完成标记时丢弃合并的结果
+	db.merging = false
+	assert.Nil(t, db.Close())
+	db2, err := Start(&opts)
+	assert.Nil(t, err)
+	verifyMergeTestData(t, db2, expected)
+	_, err = fs.ReadDir(mergeDir)
+	assert.NotNil(t, err)
+
+	// 有完成标记时继续替换，替换了一部分文件后崩溃
+	mergeFiles, boundary, err = db2.prepareMerge()
+	assert.Nil(t, err)
+	assert.Nil(t, fs.MkdirAll(mergeDir))
+	_, count, err = db2.rewriteDataFiles(mergeDir, mergeFiles)
+	assert.Nil(t, err)
+	assert.True(t, count > 1)
+	assert.Nil(t, db2.writeMergeFinished(mergeDir, boundary, count))
+	db2.merging = false
+	assert.Nil(t, db2.Close())
+	assert.Nil(t, fs.Rename(data.DataFileName(mergeDir, 1), data.DataFileName(opts.DBFileDir, 1)))
+
+	// 只读模式不能继续替换
+	opts.ReadOnly = true
+	_, err = Start(&opts)
+	assert.Equal(t, ErrMergeUnfinished, err)
+
+	opts.ReadOnly = false
+	db3, err := Start(&opts)
+	assert.Nil(t, err)
+	verifyMergeTestData(t, db3, expected)
+	stat, err := db3.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int(count)+1, stat.DataFileNum)
+	assert.Nil(t, db3.Close())
+}
@@ -1,11 +1,15 @@
 package bitcask_go
 
-import "bitcask-go/index"
+import (
+	"bitcask-go/fio"
+	"bitcask-go/index"
+)
 
 type DBSyncType byte
 
 const (
-	Always DBSyncType = iota
+	Always DBSyncType = iota // 每次写入后立即刷盘
+	Never                    // 写入时不主动刷盘，只在 Sync、文件轮转和 Close 时刷盘
 )
 
 type Options struct {
@@ -13,6 +17,34 @@ type Options struct {
 	FileMaxSize uint64            // 当个DB文件最大长度
 	DBSync      DBSyncType        // 刷盘策略
 	DBIndex     index.DBIndexType // 索引类型
+	FS          fio.VFS           // 文件系统，为空时使用 fio.DefaultVFS
+
+	// 写缓冲区大小，0 表示不启用。启用后追加写入先收集在用户态缓冲区中，
+	// 缓冲区写满、Flush、Sync、文件轮转或 Close 时才写入文件
+	WriteBufferSize int
+
+	// 数据文件预分配磁盘空间的块大小，0 表示不预分配。
+	// 活跃文件会按块提前分配空间（不改变文件长度），以减少文件碎片
+	PreallocateSize uint64
+
+	// 内存索引的分片数量，大于 1 时按 key 的哈希值将索引拆分为多个分片，各分片有自己的锁，
+	// 减少并发读写时的锁竞争。B+树索引不支持分片
+	IndexShards int
+
+	// B+树索引缓存的节点数量，只在 DBIndex 为 index.BPTree 时生效，0 表示使用默认值
+	IndexCacheSize int
+
+	// value 缓存的容量（字节），0 表示不启用。启用后 Get 读到的 value 按记录位置缓存在 LRU 中，
+	// 热点数据的读取不需要访问数据文件
+	CacheSize int64
+
+	// 启动时并行解码数据文件的数量，0 表示使用 CPU 核数，1 表示逐个文件解码。
+	// 解码结果仍然按文件顺序更新索引
+	LoadConcurrency int
+
+	// 只读模式，不锁定数据目录，不修改任何文件，写入返回 ErrDBReadOnly。
+	// 可以在其他实例正在写入时打开，只能看到打开时已经写入文件的数据
+	ReadOnly bool
 }
 
 var DefaultOptions = &Options{
@@ -20,4 +52,5 @@ var DefaultOptions = &Options{
 	FileMaxSize: 256 * 1024 * 1024, //256MB
 	DBSync:      Always,
 	DBIndex:     index.BTree,
+	FS:          fio.DefaultVFS,
 }
@@ -0,0 +1,209 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// 修复时新数据文件所在的目录，位于数据目录下
+	repairDirName = "repair"
+	// 被替换的损坏文件和无法解析编号的数据文件重命名时添加的后缀，保留原始数据。
+	// 同名的文件已经存在时（之前的修复留下的）在后缀之后再加上序号，如 .corrupt.1
+	CorruptFileSuffix = ".corrupt"
+)
+
+// RepairReport 修复的结果
+type RepairReport struct {
+	Files        []*VerifyFileReport // 被重写的数据文件，Records 为保留下来的记录数量，Damaged 为丢失的区间
+	LostBytes    uint64              // 丢失的字节数
+	InvalidNames []string            // 无法解析编号、被重命名的数据文件
+	Renamed      map[string]string   // 被重命名保留的原文件，原文件名到新文件名
+	IndexRemoved bool                // 是否删除了持久化索引，启动时从数据文件重建
+}
+
+// Repair 离线修复数据目录，修复期间数据目录不能被其他实例使用
+//
+// 对每个包含损坏区间的数据文件，跳过损坏的区间，把其余的有效记录按原来的顺序写入新文件，
+// 新文件保持原来的文件编号，原文件添加 CorruptFileSuffix 后缀保留。无法解析编号的数据文件同样添加后缀。
+// 重写了数据文件时，持久化索引中的位置失效，索引文件会被删除。修复之后数据库可以正常启动
+func Repair(dir string) (*RepairReport, error) {
+	return repair(fio.DefaultVFS, dir)
+}
+
+func repair(fs fio.VFS, dir string) (*RepairReport, error) {
+	fileLock, err := fs.Lock(path.Join(dir, fio.FileLockName))
+	if err != nil {
+		if err == fio.ErrFileLocked {
+			return nil, ErrDatabaseIsUsing
+		}
+		return nil, err
+	}
+	defer fileLock.Close()
+
+	repairDir := path.Join(dir, repairDirName)
+	if err := finishRepair(fs, dir, repairDir); err != nil {
+		return nil, err
+	}
+
+	verifyReport, err := verify(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	report := &RepairReport{InvalidNames: verifyReport.InvalidNames, Renamed: make(map[string]string)}
+	for _, name := range verifyReport.InvalidNames {
+		newName, err := renameCorruptFile(fs, dir, name)
+		if err != nil {
+			return nil, err
+		}
+		report.Renamed[name] = newName
+	}
+
+	for _, fileReport := range verifyReport.Files {
+		if len(fileReport.Damaged) == 0 {
+			continue
+		}
+		if err := fs.MkdirAll(repairDir); err != nil {
+			return nil, err
+		}
+		newName, err := rewriteDamagedFile(fs, dir, repairDir, fileReport)
+		if err != nil {
+			return nil, err
+		}
+		report.Renamed[path.Base(data.DataFileName(dir, fileReport.Fid))] = newName
+		report.Files = append(report.Files, fileReport)
+		for _, damaged := range fileReport.Damaged {
+			report.LostBytes += damaged.Size
+		}
+	}
+
+	if len(report.Files) > 0 {
+		indexFile := path.Join(dir, index.BPlusTreeFileName)
+		if err := fs.Remove(indexFile); err == nil {
+			report.IndexRemoved = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if err := finishRepair(fs, dir, repairDir); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// 将损坏区间之外的记录写入 repairDir 中的新文件，再替换原文件，返回原文件重命名后的文件名
+func rewriteDamagedFile(fs fio.VFS, dir, repairDir string, fileReport *VerifyFileReport) (string, error) {
+	src, err := data.OpenDataFileReadOnly(fs, dir, fileReport.Fid)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	fileName := data.DataFileName(repairDir, fileReport.Fid)
+	if err := fs.Remove(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	dst, err := data.OpenDataFile(fs, repairDir, fileReport.Fid)
+	if err != nil {
+		return "", err
+	}
+
+	var offset uint64
+	end := fileReport.Size - fileReport.Padding
+	damaged := fileReport.Damaged
+	for offset < end {
+		// 跳过损坏的区间
+		if len(damaged) > 0 && damaged[0].Offset == offset {
+			offset += damaged[0].Size
+			damaged = damaged[1:]
+			continue
+		}
+		logRecord, n, err := src.ReadLogRecord(int64(offset))
+		if err != nil {
+			_ = dst.Close()
+			return "", err
+		}
+		if _, err := dst.WriteLogRecord(logRecord); err != nil {
+			_ = dst.Close()
+			return "", err
+		}
+		offset += uint64(n)
+	}
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+
+	// 先保留原文件，再把新文件移入数据目录，中途崩溃时由 finishRepair 完成
+	original := data.DataFileName(dir, fileReport.Fid)
+	newName, err := renameCorruptFile(fs, dir, path.Base(original))
+	if err != nil {
+		return "", err
+	}
+	return newName, fs.Rename(fileName, original)
+}
+
+// 给 dir 中的 name 添加 CorruptFileSuffix 后缀，不覆盖已经存在的文件，返回新的文件名
+func renameCorruptFile(fs fio.VFS, dir, name string) (string, error) {
+	names, err := fs.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	exists := make(map[string]bool, len(names))
+	for _, n := range names {
+		exists[n] = true
+	}
+	newName := name + CorruptFileSuffix
+	for i := 1; exists[newName]; i++ {
+		newName = fmt.Sprintf("%s%s.%d", name, CorruptFileSuffix, i)
+	}
+	return newName, fs.Rename(path.Join(dir, name), path.Join(dir, newName))
+}
+
+// 处理 repairDir 中剩余的文件：数据目录中缺少对应的文件说明替换中途崩溃，移入数据目录，否则删除
+func finishRepair(fs fio.VFS, dir, repairDir string) error {
+	names, err := fs.ReadDir(repairDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	dirNames, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(dirNames))
+	for _, name := range dirNames {
+		exists[name] = true
+	}
+	// 原文件已经重命名，可能带有序号
+	renamed := func(name string) bool {
+		for _, dirName := range dirNames {
+			if strings.HasPrefix(dirName, name+CorruptFileSuffix) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range names {
+		fileName := path.Join(repairDir, name)
+		if strings.HasSuffix(name, data.DataFileSubffix) && !exists[name] && renamed(name) {
+			err = fs.Rename(fileName, path.Join(dir, name))
+		} else {
+			err = fs.Remove(fileName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return fs.Remove(repairDir)
+}
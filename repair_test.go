@@ -0,0 +1,128 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"path"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	fs := fio.NewMemFS()
+	dir := "/bitcask-go-repair"
+	db := newVerifyTestDB(t, fs, dir)
+	pos := db.index.Get(utils.GetTestKey(10))
+	next := db.index.Get(utils.GetTestKey(11))
+	lastPos := db.index.Get(utils.GetTestKey(199))
+
+	// 数据库正在使用时不能修复
+	_, err := repair(fs, dir)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	assert.Nil(t, db.Close())
+
+	corruptFile(t, fs, data.DataFileName(dir, pos.Fid), int64(pos.Offset)+8)
+	file, err := fs.OpenFile(data.DataFileName(dir, lastPos.Fid))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Truncate(int64(lastPos.Offset)+5))
+	assert.Nil(t, file.Close())
+	file, err = fs.OpenFile(dir + "/abc.data")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	report, err := repair(fs, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc.data"}, report.InvalidNames)
+	assert.Equal(t, "abc.data"+CorruptFileSuffix, report.Renamed["abc.data"])
+	assert.Equal(t, 2, len(report.Files))
+	assert.Equal(t, uint64(next.Offset-pos.Offset+5), report.LostBytes)
+	assert.True(t, report.IndexRemoved)
+
+	// 原文件被保留，修复之后校验通过
+	names, err := fs.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Contains(t, names, "abc.data"+CorruptFileSuffix)
+	assert.Contains(t, names, path.Base(data.DataFileName(dir, pos.Fid))+CorruptFileSuffix)
+	assert.NotContains(t, names, repairDirName)
+	verifyReport, err := verify(fs, dir)
+	assert.Nil(t, err)
+	assert.True(t, verifyReport.OK())
+
+	opts := *DefaultOptions
+	opts.DBFileDir = dir
+	opts.FileMaxSize = 4 * 1024
+	opts.DBIndex = index.BPTree
+	opts.FS = fs
+	db, err = Start(&opts)
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		if i == 10 || i == 199 {
+			assert.Equal(t, ErrReadKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+	assert.Nil(t, db.Put(utils.GetTestKey(10), utils.GetTestKey(10)))
+	assert.Nil(t, db.Close())
+
+	// 没有损坏时不做任何修改
+	report, err = repair(fs, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.Files))
+	assert.False(t, report.IndexRemoved)
+
+	// 再次修复同一个文件时不覆盖之前保留的原文件
+	name := path.Base(data.DataFileName(dir, pos.Fid))
+	corruptFile(t, fs, data.DataFileName(dir, pos.Fid), 8)
+	report, err = repair(fs, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Files))
+	assert.Equal(t, map[string]string{name: name + CorruptFileSuffix + ".1"}, report.Renamed)
+	names, err = fs.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Contains(t, names, name+CorruptFileSuffix)
+	assert.Contains(t, names, name+CorruptFileSuffix+".1")
+}
+
+func TestRepair_Recover(t *testing.T) {
+	fs := fio.NewMemFS()
+	dir := "/bitcask-go-repair-recover"
+	db := newVerifyTestDB(t, fs, dir)
+	pos := db.index.Get(utils.GetTestKey(10))
+	assert.Nil(t, db.Close())
+	corruptFile(t, fs, data.DataFileName(dir, pos.Fid), int64(pos.Offset)+8)
+
+	// 模拟替换原文件的过程中崩溃：原文件已经重命名，新文件还在修复目录中
+	repairDir := dir + "/" + repairDirName
+	assert.Nil(t, fs.MkdirAll(repairDir))
+	verifyReport, err := verify(fs, dir)
+	assert.Nil(t, err)
+	for _, fileReport := range verifyReport.Files {
+		if len(fileReport.Damaged) > 0 {
+			_, err := rewriteDamagedFile(fs, dir, repairDir, fileReport)
+			assert.Nil(t, err)
+		}
+	}
+	fileName := data.DataFileName(dir, pos.Fid)
+	assert.Nil(t, fs.Rename(fileName, data.DataFileName(repairDir, pos.Fid)))
+	file, err := fs.OpenFile(repairDir + "/stale.tmp")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	report, err := repair(fs, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.Files))
+	names, err := fs.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.NotContains(t, names, repairDirName)
+	verifyReport, err = verify(fs, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(verifyReport.InvalidNames))
+	for _, fileReport := range verifyReport.Files {
+		assert.Equal(t, 0, len(fileReport.Damaged))
+	}
+}
@@ -0,0 +1,278 @@
+package replication
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/wire"
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFollowerClosed = errors.New("replication: follower closed")
+	ErrStateFile      = errors.New("replication: the state file is invalid")
+)
+
+type FollowerOptions struct {
+	// 连接 primary 的超时时间，0 表示不限制
+	DialTimeout time.Duration
+
+	// 等待 primary 消息的超时时间，0 表示不限制。需要大于 primary 的心跳间隔
+	Timeout time.Duration
+
+	// 连接断开后重新连接的间隔
+	RetryInterval time.Duration
+}
+
+var DefaultFollowerOptions = &FollowerOptions{
+	DialTimeout:   5 * time.Second,
+	Timeout:       10 * time.Second,
+	RetryInterval: time.Second,
+}
+
+// Follower 从 primary 接收日志记录并写入 DB，DB 的打开和关闭由调用方负责。
+// 复制期间不能通过其他途径写入 DB，否则和 primary 的数据不一致
+type Follower struct {
+	db        *bitcask_go.DB
+	address   string
+	stateFile string
+	options   *FollowerOptions
+
+	mu          sync.Mutex
+	applied     bitcask_go.LogPosition
+	lag         uint64
+	connected   bool
+	lastContact time.Time
+	netConn     net.Conn
+	closed      bool
+	done        chan struct{}
+}
+
+// FollowerStatus follower 的复制状态
+type FollowerStatus struct {
+	Applied     bitcask_go.LogPosition // 已经应用到的 primary 日志位置
+	Lag         uint64                 // 最近一批记录发送时 primary 上之后还有的字节数
+	Connected   bool                   // 是否连接着 primary
+	LastContact time.Time              // 最近一次收到 primary 消息的时间
+}
+
+// NewFollower 创建 follower，从 address 上的 primary 复制日志，已经应用的位置保存在 stateFile 中。
+//
+// stateFile 不存在时，从 DB 当前的日志末尾开始复制：DB 为空时从 primary 日志的开头开始，
+// DB 由 primary 的备份打开时从备份的位置继续。其他情况下 DB 和 primary 的日志无关，不能作为 follower。
+// options 为空时使用 DefaultFollowerOptions
+func NewFollower(db *bitcask_go.DB, address string, stateFile string, options *FollowerOptions) (*Follower, error) {
+	if options == nil {
+		options = DefaultFollowerOptions
+	}
+	if options.DialTimeout < 0 || options.Timeout < 0 || options.RetryInterval < 0 {
+		return nil, ErrTimeout
+	}
+	f := &Follower{
+		db:        db,
+		address:   address,
+		stateFile: stateFile,
+		options:   options,
+		done:      make(chan struct{}),
+	}
+	applied, err := loadState(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		// 之后写入的记录会改变 DB 的日志末尾，先保存开始的位置
+		applied = db.LogEnd()
+		err = saveState(stateFile, applied)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.applied = applied
+	return f, nil
+}
+
+// Run 连接 primary 并持续复制，连接断开后按 RetryInterval 重新连接。
+// 一直阻塞到 Close 被调用，此时返回 ErrFollowerClosed。
+// 无法继续复制时返回对应的错误，primary 已经合并了还没有复制的日志时返回 bitcask_go.ErrLogPositionUnavailable，
+// 需要从 primary 新的备份重新开始
+func (f *Follower) Run() error {
+	for {
+		retry, err := f.replicate()
+		f.mu.Lock()
+		f.connected = false
+		closed := f.closed
+		f.mu.Unlock()
+		if closed {
+			return ErrFollowerClosed
+		}
+		if !retry {
+			return err
+		}
+		select {
+		case <-time.After(f.options.RetryInterval):
+		case <-f.done:
+			return ErrFollowerClosed
+		}
+	}
+}
+
+// Close 断开和 primary 的连接并结束 Run
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFollowerClosed
+	}
+	f.closed = true
+	close(f.done)
+	if f.netConn != nil {
+		_ = f.netConn.Close()
+	}
+	return nil
+}
+
+// Status 返回复制状态
+func (f *Follower) Status() *FollowerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &FollowerStatus{
+		Applied:     f.applied,
+		Lag:         f.lag,
+		Connected:   f.connected,
+		LastContact: f.lastContact,
+	}
+}
+
+// 建立一个连接并复制，直到连接断开。返回的 retry 表示是否可以重新连接
+func (f *Follower) replicate() (bool, error) {
+	netConn, err := net.DialTimeout("tcp", f.address, f.options.DialTimeout)
+	if err != nil {
+		return true, err
+	}
+	defer netConn.Close()
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return false, ErrFollowerClosed
+	}
+	f.netConn, f.connected = netConn, true
+	applied := f.applied
+	f.mu.Unlock()
+
+	reader := bufio.NewReader(netConn)
+	writer := bufio.NewWriter(netConn)
+	if err := f.writeFrame(netConn, writer, opSubscribe, applied); err != nil {
+		return true, err
+	}
+	for {
+		if f.options.Timeout > 0 {
+			_ = netConn.SetReadDeadline(time.Now().Add(f.options.Timeout))
+		}
+		frame, err := wire.ReadFrame(reader)
+		if err != nil {
+			return true, err
+		}
+		switch frame.Code {
+		case wire.StatusError:
+			err := wire.DecodeError(frame.Body)
+			return err != bitcask_go.ErrLogPositionUnavailable, err
+		case wire.StatusItem:
+		default:
+			return false, ErrUnexpectedOp
+		}
+
+		end, lag, err := f.apply(frame.Body)
+		if err != nil {
+			return false, err
+		}
+		f.mu.Lock()
+		f.applied, f.lag, f.lastContact = end, lag, time.Now()
+		f.mu.Unlock()
+		if err := f.writeFrame(netConn, writer, opAck, end); err != nil {
+			return true, err
+		}
+	}
+}
+
+// 把一批记录写入 DB 并保存新的位置，返回批次结束的位置和 primary 上落后的字节数
+func (f *Follower) apply(body []byte) (bitcask_go.LogPosition, uint64, error) {
+	d := wire.NewDecoder(body)
+	end := decodePosition(d)
+	lag := d.Uvarint()
+	batch := &bitcask_go.WriteBatch{}
+	for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+		flags := d.Byte()
+		_, _ = d.Uvarint(), d.Uvarint() // 记录在 primary 上的位置
+		// 索引直接引用 key，拷贝之后整个消息体可以被回收
+		key := append([]byte(nil), d.Bytes()...)
+		value := d.Bytes()
+		if flags&entryDelete != 0 {
+			batch.Delete(key)
+		} else {
+			batch.Put(key, value)
+		}
+	}
+	if err := d.Finish(); err != nil {
+		return end, 0, err
+	}
+	if err := f.db.Write(batch); err != nil {
+		return end, 0, err
+	}
+	// 写入之后、保存位置之前崩溃时，重启后会重复应用这一批记录，按顺序重放的结果不变。
+	// 保存位置之前先刷盘，否则刷盘策略不是每次写入都刷盘时，崩溃后位置已经保存的记录可能丢失且不会重新拉取
+	if end != f.applied {
+		if err := f.db.Sync(); err != nil {
+			return end, 0, err
+		}
+		if err := saveState(f.stateFile, end); err != nil {
+			return end, 0, err
+		}
+	}
+	return end, lag, nil
+}
+
+func (f *Follower) writeFrame(netConn net.Conn, writer *bufio.Writer, code byte, pos bitcask_go.LogPosition) error {
+	if f.options.Timeout > 0 {
+		_ = netConn.SetWriteDeadline(time.Now().Add(f.options.Timeout))
+	}
+	body := encodePosition(&wire.Encoder{}, pos).Body()
+	if err := wire.WriteFrame(writer, &wire.Frame{Code: code, Body: body}); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// 读取保存的位置，文件不存在时返回 os.ErrNotExist
+func loadState(name string) (bitcask_go.LogPosition, error) {
+	var pos bitcask_go.LogPosition
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return pos, err
+	}
+	if _, err := fmt.Sscanf(string(buf), "%d %d %d\n", &pos.Generation, &pos.Fid, &pos.Offset); err != nil {
+		return pos, ErrStateFile
+	}
+	return pos, nil
+}
+
+// 先写入临时文件再重命名，崩溃时不会留下不完整的内容
+func saveState(name string, pos bitcask_go.LogPosition) error {
+	tmpName := name + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(file, "%d %d %d\n", pos.Generation, pos.Fid, pos.Offset); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
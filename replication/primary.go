@@ -0,0 +1,343 @@
+package replication
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/wire"
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPrimaryClosed = errors.New("replication: primary closed")
+	ErrBatchSize     = errors.New("replication: illegal batch size")
+	ErrTimeout       = errors.New("replication: illegal timeout")
+	ErrUnexpectedOp  = errors.New("replication: unexpected op")
+)
+
+type PrimaryOptions struct {
+	// 每个批次中记录的最大字节数，单条记录超过时单独发送
+	BatchSize int
+
+	// 没有新记录时发送心跳的间隔，0 表示不发送
+	HeartbeatInterval time.Duration
+
+	// 等待 follower 确认和写入的超时时间，0 表示不限制。需要大于心跳间隔，否则空闲的连接会被断开
+	Timeout time.Duration
+}
+
+var DefaultPrimaryOptions = &PrimaryOptions{
+	BatchSize:         1024 * 1024,
+	HeartbeatInterval: time.Second,
+	Timeout:           10 * time.Second,
+}
+
+// Primary 把 DB 的日志推送给连接上来的 follower，DB 的打开和关闭由调用方负责。
+// Primary 通过 DB.SetLogRetention 让合并保留连接着的 follower 还没有确认的日志，一个 DB 只能创建一个 Primary。
+// 断开连接的 follower 不受保护，重新连接之前 primary 合并了它没有复制的日志时，
+// follower 会收到 bitcask_go.ErrLogPositionUnavailable，需要从新的备份重新开始
+type Primary struct {
+	db      *bitcask_go.DB
+	options *PrimaryOptions
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	followers map[*followerConn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// FollowerStat 一个 follower 的复制状态
+type FollowerStat struct {
+	Addr    string                 // follower 的地址
+	Sent    bitcask_go.LogPosition // 已经发送到的位置
+	Acked   bitcask_go.LogPosition // follower 确认已经应用到的位置
+	Lag     uint64                 // 日志中 Acked 之后的字节数
+	LastAck time.Time              // 最近一次收到确认的时间
+}
+
+// 一个 follower 的连接
+type followerConn struct {
+	netConn net.Conn
+	writer  *bufio.Writer
+	stop    chan struct{} // 连接断开或者 primary 关闭时关闭
+	once    sync.Once
+
+	mu      sync.Mutex
+	sent    bitcask_go.LogPosition
+	acked   bitcask_go.LogPosition
+	lastAck time.Time
+}
+
+// NewPrimary 创建 primary，options 为空时使用 DefaultPrimaryOptions
+func NewPrimary(db *bitcask_go.DB, options *PrimaryOptions) (*Primary, error) {
+	if options == nil {
+		options = DefaultPrimaryOptions
+	}
+	if options.BatchSize <= 0 {
+		return nil, ErrBatchSize
+	}
+	if options.HeartbeatInterval < 0 || options.Timeout < 0 ||
+		(options.Timeout > 0 && options.Timeout <= options.HeartbeatInterval) {
+		return nil, ErrTimeout
+	}
+	p := &Primary{
+		db:        db,
+		options:   options,
+		listeners: make(map[net.Listener]struct{}),
+		followers: make(map[*followerConn]struct{}),
+	}
+	db.SetLogRetention(p.ackedPositions)
+	return p, nil
+}
+
+// 所有连接着的 follower 确认的位置，在 DB 合并时调用
+func (p *Primary) ackedPositions() []bitcask_go.LogPosition {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	positions := make([]bitcask_go.LogPosition, 0, len(p.followers))
+	for f := range p.followers {
+		f.mu.Lock()
+		positions = append(positions, f.acked)
+		f.mu.Unlock()
+	}
+	return positions
+}
+
+// ListenAndServe 监听 tcp 地址 address 并处理 follower 的连接
+func (p *Primary) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve 接受 l 上的连接，每个 follower 由单独的协程推送日志。一直阻塞到 Close 被调用，此时返回 ErrPrimaryClosed
+func (p *Primary) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_ = l.Close()
+		return ErrPrimaryClosed
+	}
+	p.listeners[l] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.listeners, l)
+		p.mu.Unlock()
+	}()
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrPrimaryClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		f := &followerConn{netConn: netConn, writer: bufio.NewWriter(netConn), stop: make(chan struct{})}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			_ = netConn.Close()
+			return ErrPrimaryClosed
+		}
+		p.followers[f] = struct{}{}
+		p.wg.Add(1)
+		p.mu.Unlock()
+		go func() {
+			defer func() {
+				f.close()
+				p.mu.Lock()
+				delete(p.followers, f)
+				p.mu.Unlock()
+				p.wg.Done()
+			}()
+			p.serveFollower(f)
+		}()
+	}
+}
+
+// Close 停止接受新连接并断开所有 follower，follower 之后可以从确认的位置重新连接
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPrimaryClosed
+	}
+	p.closed = true
+	var err error
+	for l := range p.listeners {
+		if closeErr := l.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	for f := range p.followers {
+		f.close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	p.db.SetLogRetention(nil)
+	return err
+}
+
+// Followers 返回当前连接的 follower 的复制状态
+func (p *Primary) Followers() []*FollowerStat {
+	p.mu.Lock()
+	stats := make([]*FollowerStat, 0, len(p.followers))
+	for f := range p.followers {
+		f.mu.Lock()
+		stats = append(stats, &FollowerStat{
+			Addr:    f.netConn.RemoteAddr().String(),
+			Sent:    f.sent,
+			Acked:   f.acked,
+			LastAck: f.lastAck,
+		})
+		f.mu.Unlock()
+	}
+	p.mu.Unlock()
+
+	// 确认的位置已经被合并重写时无法计算，Lag 保持为 0
+	for _, stat := range stats {
+		stat.Lag, _ = p.db.LogDistance(stat.Acked)
+	}
+	return stats
+}
+
+func (f *followerConn) close() {
+	f.once.Do(func() {
+		close(f.stop)
+		_ = f.netConn.Close()
+	})
+}
+
+func (p *Primary) serveFollower(f *followerConn) {
+	if p.options.Timeout > 0 {
+		_ = f.netConn.SetReadDeadline(time.Now().Add(p.options.Timeout))
+	}
+	reader := bufio.NewReader(f.netConn)
+	req, err := wire.ReadFrame(reader)
+	if err != nil {
+		return
+	}
+	d := wire.NewDecoder(req.Body)
+	pos := decodePosition(d)
+	if err := d.Finish(); err != nil || req.Code != opSubscribe {
+		if err == nil {
+			err = ErrUnexpectedOp
+		}
+		_ = p.writeFrame(f, wire.StatusError, wire.EncodeError(err))
+		return
+	}
+	f.mu.Lock()
+	f.sent, f.acked, f.lastAck = pos, pos, time.Now()
+	f.mu.Unlock()
+
+	go p.readAcks(f, reader)
+	p.sendEntries(f, pos)
+}
+
+// 读取 follower 的确认，出错时断开连接
+func (p *Primary) readAcks(f *followerConn, reader *bufio.Reader) {
+	defer f.close()
+	for {
+		if p.options.Timeout > 0 {
+			_ = f.netConn.SetReadDeadline(time.Now().Add(p.options.Timeout))
+		}
+		frame, err := wire.ReadFrame(reader)
+		if err != nil || frame.Code != opAck {
+			return
+		}
+		d := wire.NewDecoder(frame.Body)
+		pos := decodePosition(d)
+		if d.Finish() != nil {
+			return
+		}
+		f.mu.Lock()
+		f.acked, f.lastAck = pos, time.Now()
+		f.mu.Unlock()
+	}
+}
+
+// 从 pos 开始推送日志，读到日志末尾后等待新的记录或者发送心跳
+func (p *Primary) sendEntries(f *followerConn, pos bitcask_go.LogPosition) {
+	var heartbeat <-chan time.Time
+	if p.options.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(p.options.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		var entries wire.Encoder
+		count := 0
+		next, err := p.db.ReadLog(pos, func(entry *bitcask_go.LogEntry) bool {
+			var flags byte
+			if entry.Delete {
+				flags = entryDelete
+			}
+			entries.Byte(flags).Uvarint(uint64(entry.Pos.Fid)).Uvarint(entry.Pos.Offset).Bytes(entry.Key).Bytes(entry.Value)
+			count++
+			return len(entries.Body()) < p.options.BatchSize
+		})
+		if err != nil {
+			_ = p.writeFrame(f, wire.StatusError, wire.EncodeError(err))
+			return
+		}
+		if count > 0 || next != pos {
+			if p.writeEntries(f, next, count, entries.Body()) != nil {
+				return
+			}
+			pos = next
+			continue
+		}
+
+		select {
+		case <-p.db.WaitLog(pos):
+		case <-heartbeat:
+			if p.writeEntries(f, pos, 0, nil) != nil {
+				return
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (p *Primary) writeEntries(f *followerConn, end bitcask_go.LogPosition, count int, entries []byte) error {
+	select {
+	case <-f.stop:
+		return ErrPrimaryClosed
+	default:
+	}
+	lag, _ := p.db.LogDistance(end)
+	e := encodePosition(&wire.Encoder{}, end).Uvarint(lag).Uvarint(uint64(count))
+	if err := p.writeFrame(f, wire.StatusItem, append(e.Body(), entries...)); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.sent = end
+	f.mu.Unlock()
+	return nil
+}
+
+func (p *Primary) writeFrame(f *followerConn, code byte, body []byte) error {
+	if p.options.Timeout > 0 {
+		_ = f.netConn.SetWriteDeadline(time.Now().Add(p.options.Timeout))
+	}
+	if err := wire.WriteFrame(f.writer, &wire.Frame{Code: code, Body: body}); err != nil {
+		return err
+	}
+	return f.writer.Flush()
+}
@@ -0,0 +1,40 @@
+// Package replication 实现主从异步复制：primary 把 DB 追加的日志记录连同所在的位置通过 TCP 推送给 follower，
+// follower 将记录写入自己的 DB 并确认已经应用的位置。
+//
+// 连接使用 wire 包的帧格式，帧的 id 不使用：
+//
+//	follower -> primary  opSubscribe  开始复制的位置
+//	primary -> follower  StatusItem   一批记录：批次结束的位置、primary 上落后的字节数、记录数量，
+//	                                  每条记录为标志(entryDelete)、文件编号、偏移量、key、value
+//	follower -> primary  opAck        已经应用的位置
+//	primary -> follower  StatusError  错误码、错误信息，之后关闭连接
+//
+// 没有新记录时 primary 按心跳间隔发送不含记录的批次，follower 对每个批次都回复 opAck，双方据此发现断开的连接。
+// 位置由合并代数、文件编号和偏移量组成，见 bitcask_go.LogPosition
+package replication
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/wire"
+)
+
+// follower 发送的操作码，primary 的应答使用 wire 的状态码
+const (
+	opSubscribe byte = iota + 1
+	opAck
+)
+
+// 日志记录的标志
+const entryDelete byte = 1
+
+func encodePosition(e *wire.Encoder, pos bitcask_go.LogPosition) *wire.Encoder {
+	return e.Uvarint(uint64(pos.Generation)).Uvarint(uint64(pos.Fid)).Uvarint(pos.Offset)
+}
+
+func decodePosition(d *wire.Decoder) bitcask_go.LogPosition {
+	return bitcask_go.LogPosition{
+		Generation: uint32(d.Uvarint()),
+		Fid:        uint32(d.Uvarint()),
+		Offset:     d.Uvarint(),
+	}
+}
@@ -0,0 +1,285 @@
+package replication
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/fio"
+	"bitcask-go/wire"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPrimaryOptions = &PrimaryOptions{
+	BatchSize:         4 * 1024,
+	HeartbeatInterval: 20 * time.Millisecond,
+	Timeout:           5 * time.Second,
+}
+
+var testFollowerOptions = &FollowerOptions{
+	DialTimeout:   time.Second,
+	Timeout:       5 * time.Second,
+	RetryInterval: 10 * time.Millisecond,
+}
+
+func openDB(t *testing.T, fs fio.VFS, dir string) *bitcask_go.DB {
+	opts := *bitcask_go.DefaultOptions
+	opts.DBFileDir = dir
+	opts.FileMaxSize = 16 * 1024
+	opts.FS = fs
+	db, err := bitcask_go.Start(&opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func startPrimary(t *testing.T, db *bitcask_go.DB) (*Primary, string) {
+	p, err := NewPrimary(db, testPrimaryOptions)
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() { _ = p.Serve(l) }()
+	t.Cleanup(func() { _ = p.Close() })
+	return p, l.Addr().String()
+}
+
+// 启动 follower，返回 Run 的结果
+func startFollower(t *testing.T, db *bitcask_go.DB, addr string, stateFile string) (*Follower, chan error) {
+	f, err := NewFollower(db, addr, stateFile, testFollowerOptions)
+	assert.Nil(t, err)
+	result := make(chan error, 1)
+	go func() { result <- f.Run() }()
+	return f, result
+}
+
+func writeData(t *testing.T, db *bitcask_go.DB, from, to int) {
+	for i := from; i < to; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte(fmt.Sprintf("value-%d", i))))
+		if i%7 == 0 {
+			assert.Nil(t, db.Delete([]byte(fmt.Sprintf("key-%05d", i/2))))
+		}
+	}
+}
+
+// 等待 follower 应用到 primary 的日志末尾，然后比较两边的数据
+func waitCaughtUp(t *testing.T, primary *bitcask_go.DB, f *Follower, follower *bitcask_go.DB) {
+	end := primary.LogEnd()
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Status().Applied != end {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower applied %v, primary log end %v", f.Status().Applied, end)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, primary.ListKeys(), follower.ListKeys())
+	assert.Nil(t, primary.Fold(func(key []byte, value []byte) bool {
+		got, err := follower.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, value, got)
+		return true
+	}))
+}
+
+func TestReplication(t *testing.T) {
+	primaryDB := openDB(t, fio.NewMemFS(), "/bitcask-go-primary")
+	defer primaryDB.Close()
+	writeData(t, primaryDB, 0, 500)
+	p, addr := startPrimary(t, primaryDB)
+
+	// 空的 follower 从日志开头开始复制
+	followerDB := openDB(t, fio.NewMemFS(), "/bitcask-go-follower")
+	defer followerDB.Close()
+	f, result := startFollower(t, followerDB, addr, filepath.Join(t.TempDir(), "state"))
+	waitCaughtUp(t, primaryDB, f, followerDB)
+
+	// 之后的写入持续复制
+	writeData(t, primaryDB, 500, 1000)
+	waitCaughtUp(t, primaryDB, f, followerDB)
+	status := f.Status()
+	assert.True(t, status.Connected)
+	assert.Equal(t, uint64(0), status.Lag)
+
+	// primary 上的复制状态，确认在下一个心跳之后更新
+	time.Sleep(5 * testPrimaryOptions.HeartbeatInterval)
+	stats := p.Followers()
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, primaryDB.LogEnd(), stats[0].Acked)
+	assert.Equal(t, primaryDB.LogEnd(), stats[0].Sent)
+	assert.Equal(t, uint64(0), stats[0].Lag)
+	assert.False(t, stats[0].LastAck.IsZero())
+
+	assert.Nil(t, f.Close())
+	assert.Equal(t, ErrFollowerClosed, <-result)
+	assert.Equal(t, ErrFollowerClosed, f.Close())
+}
+
+// follower 的 DB 不是每次写入都刷盘时，崩溃后已经保存位置的记录仍然存在
+func TestReplication_FollowerCrash(t *testing.T) {
+	primaryDB := openDB(t, fio.NewMemFS(), "/bitcask-go-primary")
+	defer primaryDB.Close()
+	writeData(t, primaryDB, 0, 500)
+	_, addr := startPrimary(t, primaryDB)
+
+	fs := fio.NewFaultFS(fio.NewMemFS(), fio.FaultConfig{})
+	opts := *bitcask_go.DefaultOptions
+	opts.DBFileDir = "/bitcask-go-follower"
+	opts.FS = fs
+	opts.DBSync = bitcask_go.Never
+	followerDB, err := bitcask_go.Start(&opts)
+	assert.Nil(t, err)
+	stateFile := filepath.Join(t.TempDir(), "state")
+	f, result := startFollower(t, followerDB, addr, stateFile)
+	waitCaughtUp(t, primaryDB, f, followerDB)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, ErrFollowerClosed, <-result)
+
+	assert.Nil(t, fs.Crash())
+	followerDB, err = bitcask_go.Start(&opts)
+	assert.Nil(t, err)
+	defer followerDB.Close()
+	f, result = startFollower(t, followerDB, addr, stateFile)
+	writeData(t, primaryDB, 500, 600)
+	waitCaughtUp(t, primaryDB, f, followerDB)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, ErrFollowerClosed, <-result)
+}
+
+func TestReplication_Bootstrap(t *testing.T) {
+	fs := fio.NewMemFS()
+	primaryDB := openDB(t, fs, "/bitcask-go-primary")
+	defer primaryDB.Close()
+	writeData(t, primaryDB, 0, 500)
+	assert.Nil(t, primaryDB.Backup("/bitcask-go-backup"))
+	writeData(t, primaryDB, 500, 800)
+	_, addr := startPrimary(t, primaryDB)
+
+	// 从备份启动的 follower 只复制备份之后的写入
+	followerDB := openDB(t, fs, "/bitcask-go-backup")
+	stateFile := filepath.Join(t.TempDir(), "state")
+	f, result := startFollower(t, followerDB, addr, stateFile)
+	waitCaughtUp(t, primaryDB, f, followerDB)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, ErrFollowerClosed, <-result)
+
+	// 重启 follower 之后从保存的位置继续
+	writeData(t, primaryDB, 800, 1000)
+	assert.Nil(t, followerDB.Close())
+	followerDB = openDB(t, fs, "/bitcask-go-backup")
+	defer followerDB.Close()
+	f, result = startFollower(t, followerDB, addr, stateFile)
+	waitCaughtUp(t, primaryDB, f, followerDB)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, ErrFollowerClosed, <-result)
+}
+
+func TestReplication_Merge(t *testing.T) {
+	primaryDB := openDB(t, fio.NewMemFS(), "/bitcask-go-primary")
+	defer primaryDB.Close()
+	writeData(t, primaryDB, 0, 500)
+	p, addr := startPrimary(t, primaryDB)
+
+	followerDB := openDB(t, fio.NewMemFS(), "/bitcask-go-follower")
+	defer followerDB.Close()
+	stateFile := filepath.Join(t.TempDir(), "state")
+	f, result := startFollower(t, followerDB, addr, stateFile)
+	waitCaughtUp(t, primaryDB, f, followerDB)
+
+	// 已经追上的 follower 不受合并影响
+	assert.Nil(t, primaryDB.Merge())
+	writeData(t, primaryDB, 500, 600)
+	waitCaughtUp(t, primaryDB, f, followerDB)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, ErrFollowerClosed, <-result)
+
+	// 断开连接的 follower 需要的日志已经被合并重写，无法继续复制
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.Followers()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	writeData(t, primaryDB, 600, 1000)
+	assert.Nil(t, primaryDB.Merge())
+	f, result = startFollower(t, followerDB, addr, stateFile)
+	select {
+	case err := <-result:
+		assert.Equal(t, bitcask_go.ErrLogPositionUnavailable, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower is still running")
+	}
+	assert.Nil(t, f.Close())
+
+	// 合并之后新的 follower 仍然可以从日志开头复制
+	followerDB2 := openDB(t, fio.NewMemFS(), "/bitcask-go-follower")
+	defer followerDB2.Close()
+	f, result = startFollower(t, followerDB2, addr, filepath.Join(t.TempDir(), "state"))
+	waitCaughtUp(t, primaryDB, f, followerDB2)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, ErrFollowerClosed, <-result)
+}
+
+// 连接着的 follower 还没有确认的日志在合并时保留
+func TestReplication_MergeRetention(t *testing.T) {
+	primaryDB := openDB(t, fio.NewMemFS(), "/bitcask-go-primary")
+	defer primaryDB.Close()
+	writeData(t, primaryDB, 0, 500)
+	pos := primaryDB.LogEnd()
+	writeData(t, primaryDB, 500, 1000)
+	p, addr := startPrimary(t, primaryDB)
+
+	// 订阅之后不确认，确认的位置一直停留在 pos
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	body := encodePosition(&wire.Encoder{}, pos).Body()
+	assert.Nil(t, wire.WriteFrame(conn, &wire.Frame{Code: opSubscribe, Body: body}))
+	deadline := time.Now().Add(5 * time.Second)
+	for (len(p.Followers()) == 0 || p.Followers()[0].Acked != pos) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Nil(t, primaryDB.Merge())
+	_, err = primaryDB.ReadLog(pos, func(*bitcask_go.LogEntry) bool { return true })
+	assert.Nil(t, err)
+
+	// primary 关闭之后不再保留
+	assert.Nil(t, p.Close())
+	writeData(t, primaryDB, 1000, 1100)
+	assert.Nil(t, primaryDB.Merge())
+	_, err = primaryDB.ReadLog(pos, func(*bitcask_go.LogEntry) bool { return true })
+	assert.Equal(t, bitcask_go.ErrLogPositionUnavailable, err)
+}
+
+func TestReplication_Reconnect(t *testing.T) {
+	primaryDB := openDB(t, fio.NewMemFS(), "/bitcask-go-primary")
+	defer primaryDB.Close()
+	writeData(t, primaryDB, 0, 200)
+	p, addr := startPrimary(t, primaryDB)
+
+	followerDB := openDB(t, fio.NewMemFS(), "/bitcask-go-follower")
+	defer followerDB.Close()
+	f, result := startFollower(t, followerDB, addr, filepath.Join(t.TempDir(), "state"))
+	waitCaughtUp(t, primaryDB, f, followerDB)
+
+	// primary 重启之后 follower 自动重新连接
+	assert.Nil(t, p.Close())
+	writeData(t, primaryDB, 200, 400)
+	p2, err := NewPrimary(primaryDB, testPrimaryOptions)
+	assert.Nil(t, err)
+	defer p2.Close()
+	l, err := net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	go func() { _ = p2.Serve(l) }()
+	waitCaughtUp(t, primaryDB, f, followerDB)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, ErrFollowerClosed, <-result)
+}
+
+func TestNewPrimary(t *testing.T) {
+	_, err := NewPrimary(nil, &PrimaryOptions{})
+	assert.Equal(t, ErrBatchSize, err)
+	_, err = NewPrimary(nil, &PrimaryOptions{BatchSize: 1, HeartbeatInterval: time.Second, Timeout: time.Second})
+	assert.Equal(t, ErrTimeout, err)
+	_, err = NewFollower(nil, "", "", &FollowerOptions{Timeout: -1})
+	assert.Equal(t, ErrTimeout, err)
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/data"
+	"bitcask-go/wire"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 二进制协议的每个连接上同时执行的请求数量，超过时暂停读取新的请求
+const binaryMaxInflight = 64
+
+// 二进制协议直接访问 DB，不处理 RESP 和 memcached 的过期时间等元数据。
+// 请求并发执行，应答由单独的协程按完成的顺序写回
+func (c *conn) serveBinary() {
+	out := make(chan *wire.Frame, binaryMaxInflight)
+	writerDone := make(chan struct{})
+	go c.writeFrames(out, writerDone)
+
+	var inflight sync.WaitGroup
+	sem := make(chan struct{}, binaryMaxInflight)
+	var mu sync.Mutex
+	scans := make(map[uint32]*binaryScan) // 正在执行的扫描
+	for {
+		if c.reader.Buffered() == 0 && c.server.shuttingDown() {
+			break
+		}
+		req, err := wire.ReadFrame(c.reader)
+		if err != nil {
+			break
+		}
+		atomic.AddInt64(&c.server.totalCommands, 1)
+		if req.Code == wire.OpCancel || req.Code == wire.OpAck {
+			mu.Lock()
+			if scan := scans[req.ID]; scan != nil {
+				if req.Code == wire.OpCancel {
+					scan.cancel()
+				} else {
+					// 确认的数量无效时忽略
+					d := wire.NewDecoder(req.Body)
+					if n := d.Uvarint(); d.Finish() == nil {
+						scan.ack(n)
+					}
+				}
+			}
+			mu.Unlock()
+			continue
+		}
+
+		var scan *binaryScan
+		if req.Code == wire.OpScan {
+			scan = newBinaryScan()
+			mu.Lock()
+			scans[req.ID] = scan
+			mu.Unlock()
+		}
+		sem <- struct{}{}
+		inflight.Add(1)
+		go func() {
+			defer func() {
+				if scan != nil {
+					mu.Lock()
+					delete(scans, req.ID)
+					mu.Unlock()
+				}
+				<-sem
+				inflight.Done()
+			}()
+			c.server.executeBinary(req, out, scan)
+		}()
+	}
+	// 连接已经断开，不会再收到确认，结束等待确认的扫描
+	mu.Lock()
+	for _, scan := range scans {
+		scan.cancel()
+	}
+	mu.Unlock()
+	inflight.Wait()
+	close(out)
+	<-writerDone
+}
+
+// binaryScan 正在执行的扫描的流量控制，发送的数据用完窗口之后等待客户端确认
+type binaryScan struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	window   uint64 // 还可以发送的数据条数
+	canceled bool
+}
+
+func newBinaryScan() *binaryScan {
+	scan := &binaryScan{window: wire.ScanWindow}
+	scan.cond = sync.NewCond(&scan.mu)
+	return scan
+}
+
+// 等待窗口中有空位，占用一个空位，扫描被取消时返回 false
+func (s *binaryScan) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.window == 0 && !s.canceled {
+		s.cond.Wait()
+	}
+	if s.canceled {
+		return false
+	}
+	s.window--
+	return true
+}
+
+// 客户端确认了 n 条数据，窗口不超过 ScanWindow
+func (s *binaryScan) ack(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window += n
+	if s.window > wire.ScanWindow {
+		s.window = wire.ScanWindow
+	}
+	s.cond.Broadcast()
+}
+
+func (s *binaryScan) cancel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.canceled = true
+	s.cond.Broadcast()
+}
+
+// 写回应答，队列中没有待写的应答时再刷新缓冲区。写入失败后丢弃剩余的应答，并唤醒读取协程结束连接
+func (c *conn) writeFrames(out <-chan *wire.Frame, done chan<- struct{}) {
+	defer close(done)
+	var err error
+	for frame := range out {
+		if err != nil {
+			continue
+		}
+		err = wire.WriteFrame(c.writer, frame)
+		if err == nil && len(out) == 0 {
+			err = c.writer.Flush()
+		}
+		if err != nil {
+			_ = c.netConn.SetReadDeadline(time.Now())
+		}
+	}
+}
+
+func (s *Server) executeBinary(req *wire.Frame, out chan<- *wire.Frame, scan *binaryScan) {
+	reply := func(code byte, body []byte) {
+		out <- &wire.Frame{ID: req.ID, Code: code, Body: body}
+	}
+	body, err := s.handleBinary(req, func(body []byte) bool {
+		if !scan.acquire() {
+			return false
+		}
+		reply(wire.StatusItem, body)
+		return true
+	})
+	if err != nil {
+		reply(wire.StatusError, wire.EncodeError(err))
+		return
+	}
+	reply(wire.StatusOK, body)
+}
+
+// 执行一个请求，返回 StatusOK 应答的消息体。扫描时每条数据调用一次 item，返回 false 时结束扫描
+func (s *Server) handleBinary(req *wire.Frame, item func(body []byte) bool) ([]byte, error) {
+	d := wire.NewDecoder(req.Body)
+	switch req.Code {
+	case wire.OpPing:
+		return nil, d.Finish()
+	case wire.OpGet:
+		key := d.Bytes()
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		return s.db.Get(key)
+	case wire.OpPut:
+		key, value := d.Bytes(), d.Bytes()
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		return nil, s.db.Put(key, value)
+	case wire.OpDelete:
+		key := d.Bytes()
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		return nil, s.db.Delete(key)
+	case wire.OpWrite:
+		batch := &bitcask_go.WriteBatch{}
+		for n := d.Uvarint(); n > 0 && d.Err() == nil; n-- {
+			switch typ, key := d.Byte(), d.Bytes(); typ {
+			case wire.BatchPut:
+				batch.Put(key, d.Bytes())
+			case wire.BatchDelete:
+				batch.Delete(key)
+			default:
+				return nil, wire.ErrInvalidBody
+			}
+		}
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		return nil, s.db.Write(batch)
+	case wire.OpScan:
+		flags, prefix := d.Byte(), d.Bytes()
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		if flags&wire.ScanKeysOnly != 0 {
+			s.db.ScanKeys(prefix, nil, func(key []byte, _ *data.LogRecordPos) bool {
+				return item((&wire.Encoder{}).Bytes(key).Body())
+			})
+			return nil, nil
+		}
+		return nil, s.db.Scan(prefix, func(key []byte, value []byte) bool {
+			return item((&wire.Encoder{}).Bytes(key).Bytes(value).Body())
+		})
+	case wire.OpStat:
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		stat, err := s.db.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return (&wire.Encoder{}).Uvarint(uint64(stat.KeyNum)).Uvarint(uint64(stat.DataFileNum)).Uvarint(stat.DiskSize).Body(), nil
+	case wire.OpSync:
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		return nil, s.db.Sync()
+	case wire.OpMerge:
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		return nil, s.db.Merge()
+	case wire.OpBackup:
+		name := d.Bytes()
+		if err := d.Finish(); err != nil {
+			return nil, err
+		}
+		dir, err := s.backup(string(name))
+		if err != nil {
+			return nil, err
+		}
+		return (&wire.Encoder{}).Bytes([]byte(dir)).Body(), nil
+	}
+	return nil, wire.ErrUnknownOp
+}
+
+// 备份到 BackupDir 下的 name 目录，name 为空时使用当前时间，返回备份目录。
+// name 只能是一级目录名，客户端不能把数据写到 BackupDir 之外
+func (s *Server) backup(name string) (string, error) {
+	if s.options.BackupDir == "" {
+		return "", ErrBackupDisabled
+	}
+	if name == "" {
+		name = "backup-" + time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", ErrBackupName
+	}
+	dir := filepath.Join(s.options.BackupDir, name)
+	return dir, s.db.Backup(dir)
+}
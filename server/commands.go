@@ -0,0 +1,359 @@
+package server
+
+import (
+	bitcask_go "bitcask-go"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// 默认每次 SCAN 返回的 key 数量
+const defaultScanCount = 10
+
+type command struct {
+	// 参数数量（包括命令名），为负数时表示至少 -arity 个参数
+	arity int
+	fn    func(s *Server, w *respWriter, args [][]byte)
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"get":    {arity: 2, fn: (*Server).get},
+		"set":    {arity: -3, fn: (*Server).set},
+		"del":    {arity: -2, fn: (*Server).del},
+		"exists": {arity: -2, fn: (*Server).exists},
+		"keys":   {arity: 2, fn: (*Server).keys},
+		"scan":   {arity: -2, fn: (*Server).scan},
+		"incr":   {arity: 2, fn: (*Server).incr},
+		"ping":   {arity: -1, fn: (*Server).ping},
+		"info":   {arity: -1, fn: (*Server).info},
+	}
+}
+
+// 执行一条命令，将回复写入 w，返回是否关闭连接
+func (s *Server) execute(w *respWriter, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	if name == "quit" {
+		w.WriteSimple("OK")
+		return true
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		w.WriteError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		w.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+	for _, key := range commandKeys(name, args) {
+		if isReservedKey(key) {
+			w.WriteError("ERR key uses a reserved prefix")
+			return false
+		}
+	}
+	cmd.fn(s, w, args)
+	return false
+}
+
+// 命令中作为 key 的参数
+func commandKeys(name string, args [][]byte) [][]byte {
+	switch name {
+	case "get", "set", "incr":
+		return args[1:2]
+	case "del", "exists":
+		return args[1:]
+	}
+	return nil
+}
+
+func writeDBError(w *respWriter, err error) {
+	w.WriteError("ERR " + err.Error())
+}
+
+// GET key
+func (s *Server) get(w *respWriter, args [][]byte) {
+	mu := s.lockKey(args[1])
+	mu.Lock()
+	value, _, err := s.getLocked(args[1])
+	mu.Unlock()
+	switch err {
+	case nil:
+		atomic.AddInt64(&s.getHits, 1)
+		w.WriteBulk(value)
+	case bitcask_go.ErrReadKeyNotFound:
+		atomic.AddInt64(&s.getMisses, 1)
+		w.WriteNil()
+	default:
+		writeDBError(w, err)
+	}
+}
+
+// SET key value [EX seconds | PX milliseconds]
+func (s *Server) set(w *respWriter, args [][]byte) {
+	var expireAt int64
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if (opt != "EX" && opt != "PX") || expireAt != 0 || i+1 == len(args) {
+			w.WriteError("ERR syntax error")
+			return
+		}
+		i++
+		ttl, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil {
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		unit := int64(1)
+		if opt == "EX" {
+			unit = 1000
+		}
+		now := nowMs()
+		if ttl <= 0 || ttl > (math.MaxInt64-now)/unit {
+			w.WriteError("ERR invalid expire time in 'set' command")
+			return
+		}
+		expireAt = now + ttl*unit
+	}
+
+	mu := s.lockKey(args[1])
+	mu.Lock()
+	_, err := s.setLocked(args[1], args[2], expireAt, 0, nil)
+	mu.Unlock()
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.WriteSimple("OK")
+}
+
+// DEL key [key ...]，返回删除的 key 的数量
+func (s *Server) del(w *respWriter, args [][]byte) {
+	var deleted int64
+	for _, key := range args[1:] {
+		mu := s.lockKey(key)
+		mu.Lock()
+		_, _, err := s.getLocked(key)
+		if err == nil {
+			err = s.deleteLocked(key)
+			if err == nil {
+				deleted++
+			}
+		}
+		mu.Unlock()
+		if err != nil && err != bitcask_go.ErrReadKeyNotFound {
+			writeDBError(w, err)
+			return
+		}
+	}
+	w.WriteInt(deleted)
+}
+
+// EXISTS key [key ...]，返回存在的 key 的数量，重复的 key 重复计数
+func (s *Server) exists(w *respWriter, args [][]byte) {
+	var count int64
+	for _, key := range args[1:] {
+		mu := s.lockKey(key)
+		mu.Lock()
+		_, _, err := s.getLocked(key)
+		mu.Unlock()
+		if err == nil {
+			count++
+		} else if err != bitcask_go.ErrReadKeyNotFound {
+			writeDBError(w, err)
+			return
+		}
+	}
+	w.WriteInt(count)
+}
+
+// KEYS pattern
+func (s *Server) keys(w *respWriter, args [][]byte) {
+	var matched [][]byte
+	err := s.scanLiveKeys(nil, func(key []byte) bool {
+		if globMatch(args[1], key) {
+			matched = append(matched, key)
+		}
+		return true
+	})
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.WriteBulks(matched)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+//
+// 游标是上一页最后检查的 key 的十六进制编码，0 表示从头开始。
+// 下一页从游标之后的 key 继续，遍历期间增删其他 key 不会导致已经存在的 key 被跳过
+func (s *Server) scan(w *respWriter, args [][]byte) {
+	var start []byte
+	if cursor := string(args[1]); cursor != "0" {
+		last, err := hex.DecodeString(cursor)
+		if err != nil || len(last) == 0 {
+			w.WriteError("ERR invalid cursor")
+			return
+		}
+		start = append(last, 0)
+	}
+	var pattern []byte
+	var err error
+	count := uint64(defaultScanCount)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			w.WriteError("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.ParseUint(string(args[i+1]), 10, 64)
+			if err != nil || count == 0 {
+				w.WriteError("ERR syntax error")
+				return
+			}
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	// COUNT 是检查的 key 的数量，多检查一个判断是否还有下一页
+	var last []byte
+	var matched [][]byte
+	checked := uint64(0)
+	more := false
+	err = s.scanLiveKeys(start, func(key []byte) bool {
+		if checked == count {
+			more = true
+			return false
+		}
+		checked++
+		last = key
+		if pattern == nil || globMatch(pattern, key) {
+			matched = append(matched, key)
+		}
+		return true
+	})
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	next := []byte("0")
+	if more {
+		next = []byte(hex.EncodeToString(last))
+	}
+	w.WriteArrayLen(2)
+	w.WriteBulk(next)
+	w.WriteBulks(matched)
+}
+
+// INCR key，key 不存在时视为 0，保留 key 的过期时间
+func (s *Server) incr(w *respWriter, args [][]byte) {
+	mu := s.lockKey(args[1])
+	mu.Lock()
+	defer mu.Unlock()
+
+	value, _, err := s.getLocked(args[1])
+	var n int64
+	switch err {
+	case nil:
+		n, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+	case bitcask_go.ErrReadKeyNotFound:
+	default:
+		writeDBError(w, err)
+		return
+	}
+	if n == math.MaxInt64 {
+		w.WriteError("ERR increment or decrement would overflow")
+		return
+	}
+	n++
+	if err := s.db.Put(args[1], []byte(strconv.FormatInt(n, 10))); err != nil {
+		writeDBError(w, err)
+		return
+	}
+	w.WriteInt(n)
+}
+
+// PING [message]
+func (s *Server) ping(w *respWriter, args [][]byte) {
+	switch len(args) {
+	case 1:
+		w.WriteSimple("PONG")
+	case 2:
+		w.WriteBulk(args[1])
+	default:
+		w.WriteError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// INFO [section]
+func (s *Server) info(w *respWriter, args [][]byte) {
+	if len(args) > 2 {
+		w.WriteError("ERR syntax error")
+		return
+	}
+	section := "all"
+	if len(args) == 2 {
+		section = strings.ToLower(string(args[1]))
+	}
+	stat, err := s.db.Stat()
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	times, err := s.expireTimes()
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+	keys, err := s.keyCount()
+	if err != nil {
+		writeDBError(w, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	write := func(name string, lines ...string) {
+		if section != "all" && section != "default" && section != "everything" && section != name {
+			return
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString("# " + strings.ToUpper(name[:1]) + name[1:] + "\r\n")
+		for _, line := range lines {
+			buf.WriteString(line + "\r\n")
+		}
+	}
+	write("server", "server_name:bitcask-go", "redis_mode:standalone")
+	write("clients", fmt.Sprintf("connected_clients:%d", s.connectedClients()))
+	write("persistence",
+		fmt.Sprintf("data_files:%d", stat.DataFileNum),
+		fmt.Sprintf("disk_size:%d", stat.DiskSize))
+	write("stats",
+		fmt.Sprintf("total_connections_received:%d", atomic.LoadInt64(&s.totalConnections)),
+		fmt.Sprintf("total_commands_processed:%d", atomic.LoadInt64(&s.totalCommands)),
+		fmt.Sprintf("expired_keys:%d", atomic.LoadInt64(&s.expiredKeys)),
+		fmt.Sprintf("keyspace_hits:%d", atomic.LoadInt64(&s.getHits)),
+		fmt.Sprintf("keyspace_misses:%d", atomic.LoadInt64(&s.getMisses)))
+	if keys > 0 {
+		write("keyspace", fmt.Sprintf("db0:keys=%d,expires=%d", keys, len(times)))
+	} else {
+		write("keyspace")
+	}
+	w.WriteBulk(buf.Bytes())
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/data"
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// 数据本身保持原样写入，其他方式打开数据库时看到的仍然是原始的 value。附加的信息保存在以 metaPrefix 开头的元数据 key 里：
+// 过期时间的 key 以 expirePrefix 开头，value 为 8 字节大端序的毫秒时间戳；
+// memcached 的 flags 的 key 以 flagsPrefix 开头，value 为 4 字节大端序的整数，flags 为 0 时不保存
+var (
+	metaPrefix   = []byte("\x00bitcask-server:")
+	expirePrefix = []byte("\x00bitcask-server:expire:")
+	flagsPrefix  = []byte("\x00bitcask-server:flags:")
+	// 所有元数据 key 之后的第一个 key
+	metaEnd = []byte("\x00bitcask-server;")
+)
+
+func metaKey(prefix, key []byte) []byte {
+	return append(append(make([]byte, 0, len(prefix)+len(key)), prefix...), key...)
+}
+
+func expireKey(key []byte) []byte {
+	return metaKey(expirePrefix, key)
+}
+
+func flagsKey(key []byte) []byte {
+	return metaKey(flagsPrefix, key)
+}
+
+// 客户端不能读写元数据 key
+func isReservedKey(key []byte) bool {
+	return bytes.HasPrefix(key, metaPrefix)
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func encodeExpireAt(expireAt int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(expireAt))
+	return buf
+}
+
+// 元数据损坏时视为没有过期时间
+func decodeExpireAt(value []byte) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(value))
+}
+
+// 读取 key 的过期时间，没有设置过期时间时返回 0
+func (s *Server) expireAt(key []byte) (int64, error) {
+	value, err := s.db.Get(expireKey(key))
+	if err == bitcask_go.ErrReadKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return decodeExpireAt(value), nil
+}
+
+// 读取 key 的值和记录的位置，key 已经过期时删除并返回 ErrReadKeyNotFound，调用方需要持有 key 的锁
+func (s *Server) getLocked(key []byte) ([]byte, *data.LogRecordPos, error) {
+	if _, err := s.expireIfNeeded(key); err != nil {
+		return nil, nil, err
+	}
+	return s.db.GetWithPosition(key)
+}
+
+// 读取 key 的 flags，没有保存时返回 0
+func (s *Server) flags(key []byte) (uint32, error) {
+	value, err := s.db.Get(flagsKey(key))
+	if err == bitcask_go.ErrReadKeyNotFound || (err == nil && len(value) != 4) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(value), nil
+}
+
+// key 已经过期时删除 key，返回是否删除
+func (s *Server) expireIfNeeded(key []byte) (bool, error) {
+	expireAt, err := s.expireAt(key)
+	if err != nil || expireAt == 0 || expireAt > nowMs() {
+		return false, err
+	}
+	if err := s.deleteLocked(key); err != nil {
+		return false, err
+	}
+	atomic.AddInt64(&s.expiredKeys, 1)
+	return true, nil
+}
+
+// 在 cond 满足时写入 key，cond 的含义和 DB.PutIf 相同。expireAt 为 0 时清除之前设置的过期时间，
+// flags 为 0 时清除之前保存的 flags。调用方需要持有 key 的锁。
+// 先删除旧的过期时间，再写数据，最后写新的过期时间，中途崩溃时 key 最多保留更久，
+// 不会带着旧的过期时间提前过期。删除之前先检查 cond，条件不满足时不修改旧的过期时间
+func (s *Server) setLocked(key, value []byte, expireAt int64, flags uint32,
+	cond func(pos *data.LogRecordPos) bool) (*data.LogRecordPos, error) {
+	if cond != nil {
+		_, current, err := s.db.GetWithPosition(key)
+		if err != nil && err != bitcask_go.ErrReadKeyNotFound {
+			return nil, err
+		}
+		if !cond(current) {
+			return nil, bitcask_go.ErrConditionFailed
+		}
+	}
+	if err := s.db.Delete(expireKey(key)); err != nil {
+		return nil, err
+	}
+	pos, err := s.db.PutIf(key, value, cond)
+	if err != nil {
+		return nil, err
+	}
+	if expireAt > 0 {
+		if err := s.db.Put(expireKey(key), encodeExpireAt(expireAt)); err != nil {
+			return nil, err
+		}
+	}
+	if flags != 0 {
+		buf := make([]byte, 4)
+		binary.BigEndian.PutUint32(buf, flags)
+		err = s.db.Put(flagsKey(key), buf)
+	} else {
+		err = s.db.Delete(flagsKey(key))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+// 修改 key 的过期时间，expireAt 为 0 时清除过期时间，调用方需要持有 key 的锁
+func (s *Server) setExpireLocked(key []byte, expireAt int64) error {
+	if expireAt > 0 {
+		return s.db.Put(expireKey(key), encodeExpireAt(expireAt))
+	}
+	return s.db.Delete(expireKey(key))
+}
+
+// 删除 key 和它的元数据，调用方需要持有 key 的锁。
+// 先删除数据，中途崩溃时只会留下没有数据的元数据，之后写入 key 时会先清除过期时间，已经删除的 key 不会重新出现
+func (s *Server) deleteLocked(key []byte) error {
+	if err := s.db.Delete(key); err != nil {
+		return err
+	}
+	if err := s.db.Delete(expireKey(key)); err != nil {
+		return err
+	}
+	return s.db.Delete(flagsKey(key))
+}
+
+// 读取所有设置了过期时间的 key 及其过期时间
+func (s *Server) expireTimes() (map[string]int64, error) {
+	times := make(map[string]int64)
+	err := s.db.Scan(expirePrefix, func(key []byte, value []byte) bool {
+		times[string(key[len(expirePrefix):])] = decodeExpireAt(value)
+		return true
+	})
+	return times, err
+}
+
+// 删除所有已经过期的 key，返回删除的数量
+func (s *Server) sweepExpired() (int, error) {
+	times, err := s.expireTimes()
+	if err != nil {
+		return 0, err
+	}
+	now := nowMs()
+	deleted := 0
+	for key, expireAt := range times {
+		if expireAt == 0 || expireAt > now {
+			continue
+		}
+		// 加锁后重新检查，期间 key 可能被重新写入
+		mu := s.lockKey([]byte(key))
+		mu.Lock()
+		expired, err := s.expireIfNeeded([]byte(key))
+		mu.Unlock()
+		if err != nil {
+			return deleted, err
+		}
+		if expired {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// 返回数据库中 key 的数量，不包括元数据 key
+func (s *Server) keyCount() (int, error) {
+	stat, err := s.db.Stat()
+	if err != nil {
+		return 0, err
+	}
+	meta := 0
+	s.db.ScanKeys(metaPrefix, nil, func([]byte, *data.LogRecordPos) bool {
+		meta++
+		return true
+	})
+	return stat.KeyNum - meta, nil
+}
+
+// 从 start 开始按 key 的顺序遍历未过期的 key，不包括元数据 key，fn 返回 false 时结束遍历。
+// 元数据 key 排在一起，遍历到它们时直接跳到元数据之后继续
+func (s *Server) scanLiveKeys(start []byte, fn func(key []byte) bool) error {
+	now := nowMs()
+	var err error
+	stopped, reachedMeta := false, false
+	visit := func(key []byte, _ *data.LogRecordPos) bool {
+		if isReservedKey(key) {
+			reachedMeta = true
+			return false
+		}
+		var expireAt int64
+		if expireAt, err = s.expireAt(key); err != nil {
+			return false
+		}
+		if expireAt != 0 && expireAt <= now {
+			return true
+		}
+		stopped = !fn(key)
+		return !stopped
+	}
+	s.db.ScanKeys(nil, start, visit)
+	if err != nil || stopped || !reachedMeta {
+		return err
+	}
+	if bytes.Compare(start, metaEnd) < 0 {
+		start = metaEnd
+	}
+	s.db.ScanKeys(nil, start, visit)
+	return err
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// 覆盖写入并清除过期时间的过程中崩溃，依次在删除过期时间和写入 value 时失败，
+// 重启后新的 value 不会带着旧的过期时间
+func TestServer_SetCrash(t *testing.T) {
+	key := []byte("k")
+	for failAt := 1; failAt <= 2; failAt++ {
+		fs := fio.NewFaultFS(fio.NewMemFS(), fio.FaultConfig{})
+		opts := *bitcask_go.DefaultOptions
+		opts.DBFileDir = "/bitcask-go-server-crash"
+		opts.FS = fs
+		db, err := bitcask_go.Start(&opts)
+		assert.Nil(t, err)
+		s, err := New(db, nil)
+		assert.Nil(t, err)
+		expireAt := nowMs() + 3600*1000
+		_, err = s.setLocked(key, []byte("v1"), expireAt, 0, nil)
+		assert.Nil(t, err)
+
+		fs.SetConfig(fio.FaultConfig{FailWriteAt: failAt})
+		_, err = s.setLocked(key, []byte("v2"), 0, 0, nil)
+		assert.NotNil(t, err, failAt)
+		assert.Nil(t, fs.Crash())
+
+		fs.SetConfig(fio.FaultConfig{})
+		db, err = bitcask_go.Start(&opts)
+		assert.Nil(t, err)
+		s, err = New(db, nil)
+		assert.Nil(t, err)
+		value, err := db.Get(key)
+		assert.Nil(t, err)
+		got, err := s.expireAt(key)
+		assert.Nil(t, err)
+		if string(value) == "v2" {
+			assert.Equal(t, int64(0), got, failAt)
+		} else {
+			assert.Equal(t, []byte("v1"), value)
+			assert.Contains(t, []int64{0, expireAt}, got, failAt)
+		}
+		assert.Nil(t, db.Close())
+	}
+}
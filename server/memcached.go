@@ -0,0 +1,408 @@
+package server
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/data"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// memcached 文本协议：https://github.com/memcached/memcached/blob/master/doc/protocol.txt
+const (
+	memcachedMaxKeyLen   = 250
+	memcachedMaxItemSize = 1024 * 1024
+	// exptime 不超过 30 天时是相对当前时间的秒数，否则是 Unix 时间戳
+	memcachedRelativeExpireLimit = 60 * 60 * 24 * 30
+)
+
+// CAS 值由合并代数和记录的位置生成，key 每次写入都会改变。
+// 合并会复用文件编号，只有位置时合并之后的记录可能和之前的值相同，因此带上合并代数。
+// 三者超过 64 位，用哈希压缩，不同的版本生成相同的值的概率可以忽略
+func casToken(generation uint32, pos *data.LogRecordPos) uint64 {
+	var buf [16]byte
+	binary.BigEndian.PutUint32(buf[:4], generation)
+	binary.BigEndian.PutUint32(buf[4:8], pos.Fid)
+	binary.BigEndian.PutUint64(buf[8:], pos.Offset)
+	h := fnv.New64a()
+	_, _ = h.Write(buf[:])
+	return h.Sum64()
+}
+
+// 将 exptime 转换为毫秒时间戳，0 表示不过期，负数表示已经过期
+func memcachedExpireAt(exptime int64) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return 1
+	case exptime > math.MaxInt64/1000:
+		return math.MaxInt64
+	case exptime > memcachedRelativeExpireLimit:
+		return exptime * 1000
+	}
+	return nowMs() + exptime*1000
+}
+
+func (c *conn) serveMemcached() {
+	reader := &respReader{reader: c.reader}
+	for c.flushIfIdle() {
+		line, err := reader.readLine()
+		if err != nil {
+			var protoErr protocolError
+			if errors.As(err, &protoErr) {
+				c.reply(false, "CLIENT_ERROR line too long")
+			}
+			return
+		}
+		// 行指向读缓冲区，之后读取数据块会覆盖它，而且索引会直接保存传入的 key，因此复制一份
+		fields := bytes.Fields(append([]byte(nil), line...))
+		if len(fields) == 0 {
+			c.reply(false, "ERROR")
+			continue
+		}
+		atomic.AddInt64(&c.server.totalCommands, 1)
+		if quit := c.executeMemcached(fields); quit {
+			return
+		}
+	}
+}
+
+// 写入一行回复，客户端指定了 noreply 时不回复
+func (c *conn) reply(noreply bool, line string) {
+	if noreply {
+		return
+	}
+	c.writer.WriteString(line)
+	c.writer.WriteString("\r\n")
+}
+
+// 最后一个参数为 noreply 时去掉该参数并返回 true
+func parseNoreply(fields [][]byte) ([][]byte, bool) {
+	if len(fields) > 0 && string(fields[len(fields)-1]) == "noreply" {
+		return fields[:len(fields)-1], true
+	}
+	return fields, false
+}
+
+func validMemcachedKey(key []byte) bool {
+	return len(key) <= memcachedMaxKeyLen && !isReservedKey(key)
+}
+
+// 执行一条命令，返回是否关闭连接
+func (c *conn) executeMemcached(fields [][]byte) bool {
+	switch name := string(fields[0]); name {
+	case "get", "gets":
+		c.memcachedGet(fields[1:], name == "gets")
+	case "set", "add", "replace", "cas":
+		return c.memcachedStore(name, fields[1:])
+	case "delete":
+		c.memcachedDelete(fields[1:])
+	case "incr", "decr":
+		c.memcachedIncr(fields[1:], name == "incr")
+	case "touch":
+		c.memcachedTouch(fields[1:])
+	case "stats":
+		c.memcachedStats(fields[1:])
+	case "version":
+		c.reply(false, "VERSION bitcask-go")
+	case "quit":
+		return true
+	default:
+		c.reply(false, "ERROR")
+	}
+	return false
+}
+
+// get <key>*、gets <key>*，gets 额外返回 CAS 值
+func (c *conn) memcachedGet(keys [][]byte, withCas bool) {
+	if len(keys) == 0 {
+		c.reply(false, "ERROR")
+		return
+	}
+	s := c.server
+	for _, key := range keys {
+		if !validMemcachedKey(key) {
+			c.reply(false, "CLIENT_ERROR bad command line format")
+			return
+		}
+	}
+	for _, key := range keys {
+		mu := s.lockKey(key)
+		mu.Lock()
+		// 先读取合并代数，读取期间发生合并时 CAS 值只会失效
+		generation := s.db.MergeGeneration()
+		value, pos, err := s.getLocked(key)
+		var flags uint32
+		if err == nil {
+			flags, err = s.flags(key)
+		}
+		mu.Unlock()
+		if err == bitcask_go.ErrReadKeyNotFound {
+			atomic.AddInt64(&s.getMisses, 1)
+			continue
+		}
+		if err != nil {
+			c.reply(false, "SERVER_ERROR "+err.Error())
+			return
+		}
+		atomic.AddInt64(&s.getHits, 1)
+		if withCas {
+			fmt.Fprintf(c.writer, "VALUE %s %d %d %d\r\n", key, flags, len(value), casToken(generation, pos))
+		} else {
+			fmt.Fprintf(c.writer, "VALUE %s %d %d\r\n", key, flags, len(value))
+		}
+		c.writer.Write(value)
+		c.writer.WriteString("\r\n")
+	}
+	c.reply(false, "END")
+}
+
+// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]，之后是 bytes 字节的数据块。
+// 数据块格式错误时返回 true 关闭连接
+func (c *conn) memcachedStore(name string, args [][]byte) bool {
+	args, noreply := parseNoreply(args)
+	n := 4
+	if name == "cas" {
+		n = 5
+	}
+	if len(args) != n {
+		c.reply(false, "ERROR")
+		return false
+	}
+	flags, err1 := strconv.ParseUint(string(args[1]), 10, 32)
+	exptime, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	size, err3 := strconv.Atoi(string(args[3]))
+	var cas uint64
+	var err4 error
+	if name == "cas" {
+		cas, err4 = strconv.ParseUint(string(args[4]), 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+		c.reply(false, "CLIENT_ERROR bad command line format")
+		return false
+	}
+
+	key := args[0]
+
+	// 先读出数据块，参数有误时也不会把数据当成命令执行
+	if size > memcachedMaxItemSize {
+		if _, err := io.CopyN(io.Discard, c.reader, int64(size)+2); err != nil {
+			return true
+		}
+		c.reply(false, "SERVER_ERROR object too large for cache")
+		return false
+	}
+	value := make([]byte, size+2)
+	if _, err := io.ReadFull(c.reader, value); err != nil {
+		return true
+	}
+	if value[size] != '\r' || value[size+1] != '\n' {
+		c.reply(false, "CLIENT_ERROR bad data chunk")
+		return true
+	}
+	value = value[:size]
+	if !validMemcachedKey(key) {
+		c.reply(false, "CLIENT_ERROR bad command line format")
+		return false
+	}
+
+	s := c.server
+	mu := s.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// set 之外的命令需要先确认 key 的状态，过期的 key 在这里删除，写入时再在写锁内确认
+	var cond func(pos *data.LogRecordPos) bool
+	if name != "set" {
+		_, current, err := s.getLocked(key)
+		if err != nil && err != bitcask_go.ErrReadKeyNotFound {
+			c.reply(noreply, "SERVER_ERROR "+err.Error())
+			return false
+		}
+		switch {
+		case name == "add" && current != nil:
+			c.reply(noreply, "NOT_STORED")
+			return false
+		case name == "replace" && current == nil:
+			c.reply(noreply, "NOT_STORED")
+			return false
+		case name == "cas" && current == nil:
+			c.reply(noreply, "NOT_FOUND")
+			return false
+		}
+		cond = func(pos *data.LogRecordPos) bool {
+			switch name {
+			case "add":
+				return pos == nil
+			case "replace":
+				return pos != nil
+			}
+			// cond 在写锁内执行，合并代数和位置一致
+			return pos != nil && casToken(s.db.MergeGeneration(), pos) == cas
+		}
+	}
+	_, err := s.setLocked(key, value, memcachedExpireAt(exptime), uint32(flags), cond)
+	switch {
+	case err == nil:
+		c.reply(noreply, "STORED")
+	case err == bitcask_go.ErrConditionFailed && name == "cas":
+		c.reply(noreply, "EXISTS")
+	case err == bitcask_go.ErrConditionFailed:
+		c.reply(noreply, "NOT_STORED")
+	default:
+		c.reply(noreply, "SERVER_ERROR "+err.Error())
+	}
+	return false
+}
+
+// delete <key> [noreply]
+func (c *conn) memcachedDelete(args [][]byte) {
+	args, noreply := parseNoreply(args)
+	if len(args) != 1 || !validMemcachedKey(args[0]) {
+		c.reply(false, "CLIENT_ERROR bad command line format")
+		return
+	}
+	s := c.server
+	mu := s.lockKey(args[0])
+	mu.Lock()
+	defer mu.Unlock()
+	_, _, err := s.getLocked(args[0])
+	if err == nil {
+		err = s.deleteLocked(args[0])
+	}
+	switch err {
+	case nil:
+		c.reply(noreply, "DELETED")
+	case bitcask_go.ErrReadKeyNotFound:
+		c.reply(noreply, "NOT_FOUND")
+	default:
+		c.reply(noreply, "SERVER_ERROR "+err.Error())
+	}
+}
+
+// incr/decr <key> <value> [noreply]，value 为 64 位无符号整数，incr 溢出时回绕，decr 最小减到 0。
+// 保留 key 的过期时间和 flags
+func (c *conn) memcachedIncr(args [][]byte, incr bool) {
+	args, noreply := parseNoreply(args)
+	if len(args) != 2 || !validMemcachedKey(args[0]) {
+		c.reply(false, "ERROR")
+		return
+	}
+	delta, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.reply(false, "CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+	s := c.server
+	mu := s.lockKey(args[0])
+	mu.Lock()
+	defer mu.Unlock()
+	value, _, err := s.getLocked(args[0])
+	if err == bitcask_go.ErrReadKeyNotFound {
+		c.reply(noreply, "NOT_FOUND")
+		return
+	}
+	if err != nil {
+		c.reply(noreply, "SERVER_ERROR "+err.Error())
+		return
+	}
+	n, err := strconv.ParseUint(string(bytes.TrimSpace(value)), 10, 64)
+	if err != nil {
+		c.reply(false, "CLIENT_ERROR cannot increment or decrement non-numeric value")
+		return
+	}
+	switch {
+	case incr:
+		n += delta
+	case delta > n:
+		n = 0
+	default:
+		n -= delta
+	}
+	result := strconv.FormatUint(n, 10)
+	if err := s.db.Put(args[0], []byte(result)); err != nil {
+		c.reply(noreply, "SERVER_ERROR "+err.Error())
+		return
+	}
+	c.reply(noreply, result)
+}
+
+// touch <key> <exptime> [noreply]
+func (c *conn) memcachedTouch(args [][]byte) {
+	args, noreply := parseNoreply(args)
+	if len(args) != 2 || !validMemcachedKey(args[0]) {
+		c.reply(false, "ERROR")
+		return
+	}
+	exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		c.reply(false, "CLIENT_ERROR invalid exptime argument")
+		return
+	}
+	s := c.server
+	mu := s.lockKey(args[0])
+	mu.Lock()
+	defer mu.Unlock()
+	_, _, err = s.getLocked(args[0])
+	if err == nil {
+		err = s.setExpireLocked(args[0], memcachedExpireAt(exptime))
+	}
+	switch err {
+	case nil:
+		c.reply(noreply, "TOUCHED")
+	case bitcask_go.ErrReadKeyNotFound:
+		c.reply(noreply, "NOT_FOUND")
+	default:
+		c.reply(noreply, "SERVER_ERROR "+err.Error())
+	}
+}
+
+// stats，只支持通用统计信息
+func (c *conn) memcachedStats(args [][]byte) {
+	if len(args) > 0 {
+		c.reply(false, "ERROR")
+		return
+	}
+	s := c.server
+	stat, err := s.db.Stat()
+	if err != nil {
+		c.reply(false, "SERVER_ERROR "+err.Error())
+		return
+	}
+	items, err := s.keyCount()
+	if err != nil {
+		c.reply(false, "SERVER_ERROR "+err.Error())
+		return
+	}
+	now := time.Now()
+	stats := []struct {
+		name  string
+		value interface{}
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(s.startTime) / time.Second)},
+		{"time", now.Unix()},
+		{"version", "bitcask-go"},
+		{"curr_connections", s.connectedClients()},
+		{"total_connections", atomic.LoadInt64(&s.totalConnections)},
+		{"cmd_total", atomic.LoadInt64(&s.totalCommands)},
+		{"get_hits", atomic.LoadInt64(&s.getHits)},
+		{"get_misses", atomic.LoadInt64(&s.getMisses)},
+		{"expired_items", atomic.LoadInt64(&s.expiredKeys)},
+		{"curr_items", items},
+		{"bytes", stat.DiskSize},
+	}
+	for _, stat := range stats {
+		fmt.Fprintf(c.writer, "STAT %s %v\r\n", stat.name, stat.value)
+	}
+	c.reply(false, "END")
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mcClient 测试用的 memcached 文本协议客户端
+type mcClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialMemcached(t *testing.T, address string) *mcClient {
+	conn, err := net.Dial("tcp", address)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return &mcClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *mcClient) send(t *testing.T, data string) {
+	_, err := c.conn.Write([]byte(data))
+	assert.Nil(t, err)
+}
+
+func (c *mcClient) readLine(t *testing.T) string {
+	line, err := c.reader.ReadString('\n')
+	assert.Nil(t, err)
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// 发送一行命令或一条存储命令，返回一行回复
+func (c *mcClient) do(t *testing.T, data string) string {
+	c.send(t, data)
+	return c.readLine(t)
+}
+
+type mcItem struct {
+	key   string
+	flags uint32
+	value string
+	cas   uint64
+}
+
+// 发送 get 或 gets 命令，读取到 END 为止
+func (c *mcClient) get(t *testing.T, command string) []mcItem {
+	c.send(t, command+"\r\n")
+	var items []mcItem
+	for {
+		line := c.readLine(t)
+		if line == "END" {
+			return items
+		}
+		fields := strings.Fields(line)
+		if !assert.True(t, len(fields) >= 4 && fields[0] == "VALUE", line) {
+			return items
+		}
+		flags, _ := strconv.ParseUint(fields[2], 10, 32)
+		size, _ := strconv.Atoi(fields[3])
+		item := mcItem{key: fields[1], flags: uint32(flags)}
+		if len(fields) == 5 {
+			item.cas, _ = strconv.ParseUint(fields[4], 10, 64)
+		}
+		buf := make([]byte, size+2)
+		_, err := io.ReadFull(c.reader, buf)
+		assert.Nil(t, err)
+		item.value = string(buf[:size])
+		items = append(items, item)
+	}
+}
+
+func TestMemcached_Storage(t *testing.T) {
+	ts := startServer(t, &Options{Protocol: Memcached})
+	c := dialMemcached(t, ts.addr)
+
+	assert.Equal(t, "STORED", c.do(t, "set a 5 0 3\r\nabc\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "set b 0 0 4\r\na\r\nb\r\n"))
+	assert.Equal(t, []mcItem{{key: "a", flags: 5, value: "abc"}, {key: "b", value: "a\r\nb"}}, c.get(t, "get a missing b"))
+	value, err := ts.db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), value)
+
+	assert.Equal(t, "NOT_STORED", c.do(t, "add a 0 0 1\r\nx\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "add c 0 0 1\r\nx\r\n"))
+	assert.Equal(t, "NOT_STORED", c.do(t, "replace d 0 0 1\r\nx\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "replace c 0 0 1\r\ny\r\n"))
+
+	// CAS 值在每次写入之后改变
+	items := c.get(t, "gets a")
+	assert.Equal(t, 1, len(items))
+	token := items[0].cas
+	assert.Equal(t, "STORED", c.do(t, fmt.Sprintf("cas a 0 0 3 %d\r\nnew\r\n", token)))
+	assert.Equal(t, "EXISTS", c.do(t, fmt.Sprintf("cas a 0 0 3 %d\r\nold\r\n", token)))
+	assert.Equal(t, "NOT_FOUND", c.do(t, "cas d 0 0 1 1\r\nx\r\n"))
+	items = c.get(t, "gets a")
+	assert.Equal(t, "new", items[0].value)
+	assert.Equal(t, uint32(0), items[0].flags)
+	assert.NotEqual(t, token, items[0].cas)
+
+	// 读取之后 key 被覆盖，合并把新的记录写到了旧记录原来的位置，之前的 CAS 值仍然失效
+	assert.Nil(t, ts.db.Merge())
+	token = c.get(t, "gets a")[0].cas
+	_, before, err := ts.db.GetWithPosition([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, ts.db.Put([]byte("a"), []byte("put")))
+	assert.Nil(t, ts.db.Merge())
+	_, after, err := ts.db.GetWithPosition([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, before.Fid, after.Fid)
+	assert.Equal(t, before.Offset, after.Offset)
+	assert.Equal(t, "EXISTS", c.do(t, fmt.Sprintf("cas a 0 0 3 %d\r\nold\r\n", token)))
+	assert.Equal(t, "put", c.get(t, "get a")[0].value)
+
+	assert.Equal(t, "DELETED", c.do(t, "delete a\r\n"))
+	assert.Equal(t, "NOT_FOUND", c.do(t, "delete a\r\n"))
+
+	// noreply 的命令没有回复
+	c.send(t, "set n 0 0 1 noreply\r\n1\r\nincr n 5 noreply\r\ndelete missing noreply\r\n")
+	assert.Equal(t, []mcItem{{key: "n", value: "6"}}, c.get(t, "get n"))
+
+	assert.Equal(t, "ERROR", c.do(t, "unknown\r\n"))
+	assert.Equal(t, "ERROR", c.do(t, "set a 0 0\r\n"))
+	assert.Equal(t, "CLIENT_ERROR bad command line format", c.do(t, "set a x 0 1\r\n"))
+	assert.Equal(t, "CLIENT_ERROR bad command line format", c.do(t, "set "+strings.Repeat("k", 251)+" 0 0 1\r\nx\r\n"))
+	assert.Equal(t, "SERVER_ERROR object too large for cache",
+		c.do(t, fmt.Sprintf("set big 0 0 %d\r\n%s\r\n", memcachedMaxItemSize+1, strings.Repeat("x", memcachedMaxItemSize+1))))
+	assert.Equal(t, "VERSION bitcask-go", c.do(t, "version\r\n"))
+
+	// 数据块长度不对时关闭连接
+	assert.Equal(t, "CLIENT_ERROR bad data chunk", c.do(t, "set a 0 0 1\r\nabc\r\n"))
+	_, err = c.reader.ReadString('\n')
+	assert.Equal(t, io.EOF, err)
+
+	_, err = New(ts.db, &Options{Protocol: 9})
+	assert.Equal(t, ErrProtocol, err)
+}
+
+func TestMemcached_IncrTouch(t *testing.T) {
+	ts := startServer(t, &Options{Protocol: Memcached})
+	c := dialMemcached(t, ts.addr)
+
+	assert.Equal(t, "NOT_FOUND", c.do(t, "incr n 1\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "set n 3 0 2\r\n10\r\n"))
+	assert.Equal(t, "15", c.do(t, "incr n 5\r\n"))
+	assert.Equal(t, "0", c.do(t, "decr n 100\r\n"))
+	assert.Equal(t, "18446744073709551615", c.do(t, "incr n 18446744073709551615\r\n"))
+	assert.Equal(t, "1", c.do(t, "incr n 2\r\n"))
+	assert.Equal(t, []mcItem{{key: "n", flags: 3, value: "1"}}, c.get(t, "get n"))
+	assert.Equal(t, "CLIENT_ERROR invalid numeric delta argument", c.do(t, "incr n -1\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "set s 0 0 3\r\nabc\r\n"))
+	assert.Equal(t, "CLIENT_ERROR cannot increment or decrement non-numeric value", c.do(t, "incr s 1\r\n"))
+
+	// 负数的 exptime 表示已经过期，超过 30 天的 exptime 是 Unix 时间戳
+	assert.Equal(t, "STORED", c.do(t, "set e1 0 -1 1\r\nx\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "set e2 0 2592001 1\r\nx\r\n"))
+	assert.Equal(t, "STORED", c.do(t, fmt.Sprintf("set e3 0 %d 1\r\nx\r\n", time.Now().Unix()+100)))
+	assert.Equal(t, []mcItem{{key: "e3", value: "x"}}, c.get(t, "get e1 e2 e3"))
+
+	assert.Equal(t, "TOUCHED", c.do(t, "touch n -1\r\n"))
+	assert.Equal(t, "NOT_FOUND", c.do(t, "touch n 0\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "set t 0 1 1\r\nx\r\n"))
+	assert.Equal(t, "TOUCHED", c.do(t, "touch t 0\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "set u 0 1 1\r\nx\r\n"))
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, []mcItem{{key: "t", value: "x"}}, c.get(t, "get t u"))
+
+	c.send(t, "stats\r\n")
+	stats := make(map[string]string)
+	for line := c.readLine(t); line != "END"; line = c.readLine(t) {
+		fields := strings.Fields(line)
+		assert.Equal(t, 3, len(fields))
+		stats[fields[1]] = fields[2]
+	}
+	assert.Equal(t, "3", stats["curr_items"])
+	assert.Equal(t, "4", stats["expired_items"])
+	assert.Equal(t, "1", stats["curr_connections"])
+}
+
+func TestMemcached_RESPInterop(t *testing.T) {
+	ts := startServer(t, &Options{Protocol: Memcached})
+	c := dialMemcached(t, ts.addr)
+
+	// 同一个数据库上的 RESP 服务看到相同的数据和过期时间
+	respServer, err := New(ts.db, nil)
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() { _ = respServer.Serve(l) }()
+	defer respServer.Shutdown(context.Background())
+	r := dial(t, "tcp", l.Addr().String())
+
+	assert.Equal(t, "STORED", c.do(t, "set k 7 100 1\r\nv\r\n"))
+	assert.Equal(t, "STORED", c.do(t, "set gone 0 -1 1\r\nv\r\n"))
+	assert.Equal(t, []byte("v"), r.mustDo(t, "GET", "k"))
+	assert.Nil(t, r.mustDo(t, "GET", "gone"))
+	assert.Equal(t, bulks("k"), r.mustDo(t, "KEYS", "*"))
+
+	// RESP 的 SET 清除 flags 和过期时间
+	assert.Equal(t, "OK", r.mustDo(t, "SET", "k", "w"))
+	assert.Equal(t, []mcItem{{key: "k", value: "w"}}, c.get(t, "get k"))
+	_, err = ts.db.Get(expireKey([]byte("k")))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	// 一条命令最多的参数数量
+	maxArgs = 1024 * 1024
+	// 单个参数的最大长度
+	maxBulkLen = 512 * 1024 * 1024
+	// 内联命令一行的最大长度
+	maxInlineLen = 64 * 1024
+	// 参数数量和长度都由客户端声明，预先分配的参数数量和单个参数的长度不超过以下大小，
+	// 超出的部分随着数据的到达逐步分配
+	preallocArgs    = 1024
+	preallocBulkLen = 64 * 1024
+)
+
+// protocolError 客户端发送的数据不符合 RESP 协议，回复错误后关闭连接
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// respReader 从连接中读取命令，支持 RESP 数组和内联命令两种格式
+type respReader struct {
+	reader *bufio.Reader
+}
+
+// ReadCommand 读取一条命令，空命令返回长度为 0 的参数列表
+func (r *respReader) ReadCommand() ([][]byte, error) {
+	b, err := r.reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		return r.readInline()
+	}
+
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([][]byte, 0, minInt(n, preallocArgs))
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError("expected '$', got '" + string(line[:minInt(len(line), 1)]) + "'")
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, protocolError("invalid bulk length")
+		}
+		arg, err := r.readBulk(size + 2)
+		if err != nil {
+			return nil, err
+		}
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, protocolError("invalid bulk terminator")
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// 读取 n 个字节，较长的数据逐步读取
+func (r *respReader) readBulk(n int) ([]byte, error) {
+	if n <= preallocBulkLen {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r.reader, buf); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return buf, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(preallocBulkLen)
+	if _, err := io.CopyN(&buf, r.reader, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// 内联命令：一行以空白分隔的参数，便于 telnet 等工具直接使用
+func (r *respReader) readInline() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	fields := bytes.Fields(line)
+	args := make([][]byte, len(fields))
+	for i, field := range fields {
+		args[i] = append([]byte(nil), field...)
+	}
+	return args, nil
+}
+
+// 读取一行，去掉结尾的 \r\n
+func (r *respReader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// 行的长度超过缓冲区，只有内联命令可能出现
+		buf := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull && len(buf) <= maxInlineLen {
+			line, err = r.reader.ReadSlice('\n')
+			buf = append(buf, line...)
+		}
+		if err == bufio.ErrBufferFull {
+			return nil, protocolError("too big inline request")
+		}
+		line = buf
+	}
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// 命令读到一半时连接断开
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// respWriter 将回复写入缓冲区，由连接决定何时写回，写入错误在写回时返回
+type respWriter struct {
+	writer *bufio.Writer
+}
+
+// WriteSimple 写入简单字符串，如 +OK
+func (w *respWriter) WriteSimple(s string) {
+	w.writer.WriteByte('+')
+	w.writer.WriteString(s)
+	w.writer.WriteString("\r\n")
+}
+
+// WriteError 写入错误，msg 以错误类型开头，如 ERR、WRONGTYPE
+func (w *respWriter) WriteError(msg string) {
+	w.writer.WriteByte('-')
+	w.writer.WriteString(msg)
+	w.writer.WriteString("\r\n")
+}
+
+func (w *respWriter) WriteInt(n int64) {
+	w.writer.WriteByte(':')
+	w.writer.WriteString(strconv.FormatInt(n, 10))
+	w.writer.WriteString("\r\n")
+}
+
+func (w *respWriter) WriteBulk(b []byte) {
+	w.writer.WriteByte('$')
+	w.writer.WriteString(strconv.Itoa(len(b)))
+	w.writer.WriteString("\r\n")
+	w.writer.Write(b)
+	w.writer.WriteString("\r\n")
+}
+
+// WriteNil 写入空的批量字符串，表示 key 不存在
+func (w *respWriter) WriteNil() {
+	w.writer.WriteString("$-1\r\n")
+}
+
+// WriteArrayLen 写入数组的长度，之后需要再写入 n 个元素
+func (w *respWriter) WriteArrayLen(n int) {
+	w.writer.WriteByte('*')
+	w.writer.WriteString(strconv.Itoa(n))
+	w.writer.WriteString("\r\n")
+}
+
+func (w *respWriter) WriteBulks(items [][]byte) {
+	w.WriteArrayLen(len(items))
+	for _, item := range items {
+		w.WriteBulk(item)
+	}
+}
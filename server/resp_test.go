@@ -0,0 +1,33 @@
+package server
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// 声明了很多参数和很长的参数时，内存随着数据的到达分配，不按声明的大小预先分配
+func TestRespReader_LargeDeclaredSize(t *testing.T) {
+	for _, input := range []string{
+		"*1048576\r\n$3\r\nabc\r\n",
+		"*1\r\n$536870912\r\nabc",
+	} {
+		reader := &respReader{reader: bufio.NewReader(strings.NewReader(input))}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := reader.ReadCommand()
+		runtime.ReadMemStats(&after)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+		assert.True(t, after.TotalAlloc-before.TotalAlloc < 1024*1024)
+	}
+
+	// 超过预分配大小的参数仍然可以完整读出
+	value := strings.Repeat("v", 3*preallocBulkLen)
+	reader := &respReader{reader: bufio.NewReader(strings.NewReader("*2\r\n$3\r\nSET\r\n$196608\r\n" + value + "\r\n"))}
+	args, err := reader.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("SET"), []byte(value)}, args)
+}
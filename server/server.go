@@ -0,0 +1,327 @@
+// Package server 通过 RESP2 协议（Redis 协议）、memcached 文本协议或 bitcask 二进制协议对外提供 bitcask 数据库的读写服务
+package server
+
+import (
+	bitcask_go "bitcask-go"
+	"bufio"
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrServerClosed   = errors.New("server: server closed")
+	ErrExpireInterval = errors.New("server: illegal expire interval")
+	ErrProtocol       = errors.New("server: unknown protocol")
+	ErrBackupDisabled = errors.New("server: backup is disabled")
+	ErrBackupName     = errors.New("server: invalid backup name")
+)
+
+// 关闭连接前丢弃客户端未读数据的最长时间
+const closeDrainTimeout = time.Second
+
+// 按 key 的哈希值分段加锁，保证同一个 key 的读改写和过期检查不会交错执行
+const lockStripes = 256
+
+type Protocol byte
+
+const (
+	RESP      Protocol = iota // Redis 协议 RESP2
+	Memcached                 // memcached 文本协议
+	Binary                    // bitcask 二进制协议，格式见 wire 包
+)
+
+type Options struct {
+	// 客户端使用的协议
+	Protocol Protocol
+
+	// 后台清理过期 key 的间隔，0 表示不在后台清理，过期的 key 只在被访问时删除
+	ExpireInterval time.Duration
+
+	// 备份的根目录，二进制协议的备份请求在其下创建备份目录，为空时不允许备份
+	BackupDir string
+}
+
+var DefaultOptions = &Options{
+	ExpireInterval: time.Second,
+}
+
+// Server 将 RESP 命令映射到一个 DB 上，DB 的打开和关闭由调用方负责
+type Server struct {
+	db      *bitcask_go.DB
+	options *Options
+	locks   [lockStripes]sync.Mutex
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	closing   int32 // 是否已经开始关闭，原子访问
+	connWg    sync.WaitGroup
+	sweepOnce sync.Once
+	done      chan struct{}
+	sweepWg   sync.WaitGroup
+
+	// 统计信息，原子访问
+	startTime        time.Time
+	totalConnections int64
+	totalCommands    int64
+	expiredKeys      int64
+	getHits          int64
+	getMisses        int64
+}
+
+// New 创建服务，options 为空时使用 DefaultOptions
+func New(db *bitcask_go.DB, options *Options) (*Server, error) {
+	if options == nil {
+		options = DefaultOptions
+	}
+	if options.ExpireInterval < 0 {
+		return nil, ErrExpireInterval
+	}
+	if options.Protocol > Binary {
+		return nil, ErrProtocol
+	}
+	return &Server{
+		db:        db,
+		options:   options,
+		startTime: time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// ListenAndServe 监听 network 上的 address 并处理连接，network 为 tcp 或 unix
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 接受 l 上的连接，每个连接由一个协程处理。可以同时在多个 listener 上调用，
+// 一直阻塞到 Shutdown 被调用，此时返回 ErrServerClosed
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l) {
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l)
+	s.sweepOnce.Do(s.startSweeper)
+
+	for {
+		netConn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		c := newConn(s, netConn)
+		if !s.trackConn(c) {
+			_ = netConn.Close()
+			return ErrServerClosed
+		}
+		atomic.AddInt64(&s.totalConnections, 1)
+		go func() {
+			defer s.untrackConn(c)
+			c.serve()
+		}()
+	}
+}
+
+// Shutdown 优雅关闭：停止接受新连接，等待每个连接执行完已经收到的命令并写回回复后关闭。
+// ctx 结束时强制关闭剩余的连接并返回 ctx 的错误
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !atomic.CompareAndSwapInt32(&s.closing, 0, 1) {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	var err error
+	for l := range s.listeners {
+		if closeErr := l.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	// 唤醒阻塞在读取上的连接，正在执行命令的连接处理完缓冲区中的命令后退出
+	for c := range s.conns {
+		_ = c.netConn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+	close(s.done)
+	s.sweepWg.Wait()
+
+	finished := make(chan struct{})
+	go func() {
+		s.connWg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return err
+	case <-ctx.Done():
+		s.mu.Lock()
+		for c := range s.conns {
+			_ = c.netConn.Close()
+		}
+		s.mu.Unlock()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.closing) == 1
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown() {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+}
+
+func (s *Server) trackConn(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown() {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.connWg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(c *conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	s.connWg.Done()
+}
+
+func (s *Server) connectedClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// 返回 key 所在分段的锁
+func (s *Server) lockKey(key []byte) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return &s.locks[h.Sum32()%lockStripes]
+}
+
+func (s *Server) startSweeper() {
+	if s.options.ExpireInterval == 0 {
+		return
+	}
+	s.sweepWg.Add(1)
+	go func() {
+		defer s.sweepWg.Done()
+		ticker := time.NewTicker(s.options.ExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				_, _ = s.sweepExpired()
+			}
+		}
+	}()
+}
+
+// conn 一个客户端连接。命令按顺序执行，客户端流水线发送的命令全部执行完之后才一次性写回回复
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+}
+
+func newConn(s *Server, netConn net.Conn) *conn {
+	return &conn{
+		server:  s,
+		netConn: netConn,
+		reader:  bufio.NewReader(netConn),
+		writer:  bufio.NewWriter(netConn),
+	}
+}
+
+func (c *conn) serve() {
+	defer c.close()
+	switch c.server.options.Protocol {
+	case Memcached:
+		c.serveMemcached()
+	case Binary:
+		c.serveBinary()
+	default:
+		c.serveRESP()
+	}
+	_ = c.writer.Flush()
+}
+
+// 缓冲区中没有待执行的命令时写回回复，减少流水线下的系统调用。
+// 关闭时已经收到的命令仍然执行完，返回 false 表示不再读取命令
+func (c *conn) flushIfIdle() bool {
+	if c.reader.Buffered() > 0 {
+		return true
+	}
+	return c.writer.Flush() == nil && !c.server.shuttingDown()
+}
+
+func (c *conn) serveRESP() {
+	reader := &respReader{reader: c.reader}
+	writer := &respWriter{writer: c.writer}
+	for c.flushIfIdle() {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			var protoErr protocolError
+			if errors.As(err, &protoErr) {
+				writer.WriteError("ERR " + protoErr.Error())
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		atomic.AddInt64(&c.server.totalCommands, 1)
+		if quit := c.server.execute(writer, args); quit {
+			return
+		}
+	}
+}
+
+// 关闭连接。接收缓冲区中还有未读数据时直接关闭会发送 RST，客户端可能收不到已经写回的回复，
+// 因此先关闭写端，丢弃客户端发来的数据直到对方关闭或超时
+func (c *conn) close() {
+	if cw, ok := c.netConn.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err == nil {
+			_ = c.netConn.SetReadDeadline(time.Now().Add(closeDrainTimeout))
+			_, _ = io.Copy(io.Discard, c.netConn)
+		}
+	}
+	_ = c.netConn.Close()
+}
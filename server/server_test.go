@@ -0,0 +1,439 @@
+package server
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/fio"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// respClient 测试用的 Redis 协议客户端，按 RESP2 编码命令并解析回复
+type respClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// respError 服务端返回的错误回复
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+func dial(t *testing.T, network, address string) *respClient {
+	conn, err := net.Dial(network, address)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return &respClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func encodeCommand(args ...string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"+arg+"\r\n"...)
+	}
+	return buf
+}
+
+func (c *respClient) Send(args ...string) error {
+	_, err := c.conn.Write(encodeCommand(args...))
+	return err
+}
+
+// Receive 读取一条回复：简单字符串为 string，错误为 respError，整数为 int64，
+// 批量字符串为 []byte，空值为 nil，数组为 []interface{}
+func (c *respClient) Receive() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(line, "\r\n") || len(line) < 3 {
+		return nil, fmt.Errorf("invalid reply line %q", line)
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return respError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.Receive(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", line[0])
+}
+
+func (c *respClient) Do(args ...string) (interface{}, error) {
+	if err := c.Send(args...); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+func (c *respClient) mustDo(t *testing.T, args ...string) interface{} {
+	reply, err := c.Do(args...)
+	assert.Nil(t, err)
+	return reply
+}
+
+func bulks(items ...string) []interface{} {
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		result[i] = []byte(item)
+	}
+	return result
+}
+
+type testServer struct {
+	db     *bitcask_go.DB
+	server *Server
+	addr   string
+	served chan error
+}
+
+func startServer(t *testing.T, options *Options) *testServer {
+	opts := *bitcask_go.DefaultOptions
+	opts.DBFileDir = "/bitcask-go-server"
+	opts.FS = fio.NewMemFS()
+	db, err := bitcask_go.Start(&opts)
+	assert.Nil(t, err)
+	srv, err := New(db, options)
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	ts := &testServer{db: db, server: srv, addr: l.Addr().String(), served: make(chan error, 1)}
+	go func() { ts.served <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		_ = srv.Shutdown(context.Background())
+		_ = db.Close()
+	})
+	return ts
+}
+
+func TestServer_Commands(t *testing.T) {
+	ts := startServer(t, nil)
+	c := dial(t, "tcp", ts.addr)
+
+	assert.Equal(t, "PONG", c.mustDo(t, "PING"))
+	assert.Equal(t, []byte("hello"), c.mustDo(t, "ping", "hello"))
+	assert.Nil(t, c.mustDo(t, "GET", "k1"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "k1", "v1"))
+	assert.Equal(t, []byte("v1"), c.mustDo(t, "GET", "k1"))
+	assert.Equal(t, "OK", c.mustDo(t, "set", "k2", ""))
+	assert.Equal(t, []byte{}, c.mustDo(t, "GET", "k2"))
+	assert.Equal(t, int64(3), c.mustDo(t, "EXISTS", "k1", "k2", "k1", "k3"))
+	assert.Equal(t, int64(1), c.mustDo(t, "DEL", "k1", "k3"))
+	assert.Equal(t, int64(0), c.mustDo(t, "EXISTS", "k1"))
+
+	// 写入的数据可以直接从数据库读到
+	value, err := ts.db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(value))
+
+	assert.Equal(t, int64(1), c.mustDo(t, "INCR", "counter"))
+	assert.Equal(t, int64(2), c.mustDo(t, "INCR", "counter"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "counter", "-10"))
+	assert.Equal(t, int64(-9), c.mustDo(t, "INCR", "counter"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "text", "abc"))
+	assert.Equal(t, respError("ERR value is not an integer or out of range"), c.mustDo(t, "INCR", "text"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "max", strconv.FormatInt(1<<63-1, 10)))
+	assert.Equal(t, respError("ERR increment or decrement would overflow"), c.mustDo(t, "INCR", "max"))
+
+	assert.Equal(t, respError("ERR unknown command 'FOO'"), c.mustDo(t, "FOO"))
+	assert.Equal(t, respError("ERR wrong number of arguments for 'get' command"), c.mustDo(t, "GET"))
+	assert.Equal(t, respError("ERR wrong number of arguments for 'set' command"), c.mustDo(t, "SET", "k"))
+	assert.Equal(t, respError("ERR syntax error"), c.mustDo(t, "SET", "k", "v", "NX"))
+	assert.Equal(t, respError("ERR syntax error"), c.mustDo(t, "SET", "k", "v", "EX", "1", "PX", "1"))
+	assert.Equal(t, respError("ERR invalid expire time in 'set' command"), c.mustDo(t, "SET", "k", "v", "EX", "0"))
+	assert.Equal(t, respError("ERR key uses a reserved prefix"), c.mustDo(t, "GET", string(expireKey([]byte("k2")))))
+
+	info, ok := c.mustDo(t, "INFO").([]byte)
+	assert.True(t, ok)
+	assert.Contains(t, string(info), "# Keyspace\r\ndb0:keys=4,expires=0\r\n")
+	assert.Contains(t, string(info), "connected_clients:1\r\n")
+	info, _ = c.mustDo(t, "INFO", "clients").([]byte)
+	assert.Equal(t, "# Clients\r\nconnected_clients:1\r\n", string(info))
+
+	assert.Equal(t, "OK", c.mustDo(t, "QUIT"))
+	_, err = c.Receive()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServer_Expire(t *testing.T) {
+	ts := startServer(t, &Options{})
+	c := dial(t, "tcp", ts.addr)
+
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "a", "1", "PX", "50"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "b", "1", "px", "50"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "c", "v", "EX", "100"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "d", "v", "PX", "50"))
+	// INCR 保留过期时间，不带 EX/PX 的 SET 清除过期时间
+	assert.Equal(t, int64(2), c.mustDo(t, "INCR", "a"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "b", "2"))
+	assert.Equal(t, []byte("2"), c.mustDo(t, "GET", "a"))
+	info, _ := c.mustDo(t, "INFO", "keyspace").([]byte)
+	assert.Equal(t, "# Keyspace\r\ndb0:keys=4,expires=3\r\n", string(info))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, c.mustDo(t, "GET", "a"))
+	assert.Equal(t, []byte("2"), c.mustDo(t, "GET", "b"))
+	assert.Equal(t, []byte("v"), c.mustDo(t, "GET", "c"))
+	assert.Equal(t, bulks("b", "c"), c.mustDo(t, "KEYS", "*"))
+	assert.Equal(t, int64(0), c.mustDo(t, "EXISTS", "d"))
+	assert.Equal(t, int64(1), c.mustDo(t, "INCR", "d"))
+
+	// 过期的 key 和元数据都已经从数据库删除
+	_, err := ts.db.Get([]byte("a"))
+	assert.Equal(t, bitcask_go.ErrReadKeyNotFound, err)
+	_, err = ts.db.Get(expireKey([]byte("a")))
+	assert.Equal(t, bitcask_go.ErrReadKeyNotFound, err)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&ts.server.expiredKeys))
+}
+
+func TestServer_ExpireSweep(t *testing.T) {
+	ts := startServer(t, &Options{ExpireInterval: 10 * time.Millisecond})
+	c := dial(t, "tcp", ts.addr)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "OK", c.mustDo(t, "SET", fmt.Sprintf("key-%d", i), "v", "PX", "20"))
+	}
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "keep", "v"))
+
+	// 后台清理不需要访问 key
+	deadline := time.Now().Add(2 * time.Second)
+	for len(ts.db.ListKeys()) > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, [][]byte{[]byte("keep")}, ts.db.ListKeys())
+
+	_, err := New(ts.db, &Options{ExpireInterval: -1})
+	assert.Equal(t, ErrExpireInterval, err)
+}
+
+func TestServer_KeysScan(t *testing.T) {
+	ts := startServer(t, nil)
+	c := dial(t, "tcp", ts.addr)
+	for i := 0; i < 25; i++ {
+		assert.Equal(t, "OK", c.mustDo(t, "SET", fmt.Sprintf("user:%02d", i), "v"))
+	}
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "other", "v", "EX", "100"))
+
+	assert.Equal(t, bulks("user:01", "user:11", "user:21"), c.mustDo(t, "KEYS", "user:?1"))
+	assert.Equal(t, bulks("other"), c.mustDo(t, "KEYS", "o*"))
+	assert.Equal(t, bulks("user:20", "user:21", "user:22"), c.mustDo(t, "KEYS", "user:2[0-2]"))
+	assert.Equal(t, bulks(), c.mustDo(t, "KEYS", "nothing*"))
+
+	var keys []interface{}
+	cursor := "0"
+	for rounds := 0; ; rounds++ {
+		reply, ok := c.mustDo(t, "SCAN", cursor, "COUNT", "7").([]interface{})
+		assert.True(t, ok)
+		cursor = string(reply[0].([]byte))
+		keys = append(keys, reply[1].([]interface{})...)
+		if cursor == "0" {
+			assert.Equal(t, 3, rounds)
+			break
+		}
+	}
+	assert.Equal(t, 26, len(keys))
+	assert.Equal(t, []byte("other"), keys[0])
+
+	reply, _ := c.mustDo(t, "SCAN", "0", "MATCH", "user:1*", "COUNT", "100").([]interface{})
+	assert.Equal(t, []byte("0"), reply[0])
+	assert.Equal(t, 10, len(reply[1].([]interface{})))
+	assert.Equal(t, respError("ERR invalid cursor"), c.mustDo(t, "SCAN", "abc"))
+
+	// 遍历期间删除已经返回的 key，之后的 key 不会被跳过。排在元数据 key 前后的 key 都能遍历到
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "\x00a", "v"))
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "\x01", "v"))
+	seen := make(map[string]bool)
+	cursor = "0"
+	for {
+		reply, ok := c.mustDo(t, "SCAN", cursor, "COUNT", "5").([]interface{})
+		assert.True(t, ok)
+		for _, key := range reply[1].([]interface{}) {
+			seen[string(key.([]byte))] = true
+			assert.Equal(t, int64(1), c.mustDo(t, "DEL", string(key.([]byte))))
+		}
+		cursor = string(reply[0].([]byte))
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, 28, len(seen))
+	assert.True(t, seen["\x00a"] && seen["\x01"] && seen["user:24"])
+	assert.Equal(t, bulks(), c.mustDo(t, "KEYS", "*"))
+	assert.Equal(t, respError("ERR syntax error"), c.mustDo(t, "SCAN", "0", "COUNT", "0"))
+}
+
+func TestServer_Backup(t *testing.T) {
+	ts := startServer(t, nil)
+	_, err := ts.server.backup("b1")
+	assert.Equal(t, ErrBackupDisabled, err)
+
+	s, err := New(ts.db, &Options{BackupDir: "/bitcask-go-server-backup"})
+	assert.Nil(t, err)
+	dir, err := s.backup("b1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/bitcask-go-server-backup/b1", dir)
+	for _, name := range []string{".", "..", "a/b", `a\b`} {
+		_, err = s.backup(name)
+		assert.Equal(t, ErrBackupName, err, name)
+	}
+}
+
+func TestServer_Pipeline(t *testing.T) {
+	ts := startServer(t, nil)
+	c := dial(t, "tcp", ts.addr)
+
+	// 一次写入多条命令，包括内联命令
+	var buf []byte
+	for i := 0; i < 100; i++ {
+		buf = append(buf, encodeCommand("SET", fmt.Sprintf("key-%d", i), strconv.Itoa(i))...)
+	}
+	buf = append(buf, "GET key-42\r\nPING\r\n\r\n"...)
+	buf = append(buf, encodeCommand("INCR", "key-99")...)
+	_, err := c.conn.Write(buf)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		reply, err := c.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, "OK", reply)
+	}
+	assert.Equal(t, []byte("42"), c.mustReceive(t))
+	assert.Equal(t, "PONG", c.mustReceive(t))
+	assert.Equal(t, int64(100), c.mustReceive(t))
+
+	// 协议错误时回复错误并关闭连接
+	_, err = c.conn.Write([]byte("*1\r\n+PING\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, respError("ERR Protocol error: expected '$', got '+'"), c.mustReceive(t))
+	_, err = c.Receive()
+	assert.Equal(t, io.EOF, err)
+}
+
+func (c *respClient) mustReceive(t *testing.T) interface{} {
+	reply, err := c.Receive()
+	assert.Nil(t, err)
+	return reply
+}
+
+func TestServer_Concurrent(t *testing.T) {
+	ts := startServer(t, nil)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := dial(t, "tcp", ts.addr)
+			for n := 0; n < 100; n++ {
+				assert.IsType(t, int64(0), c.mustDo(t, "INCR", "counter"))
+				assert.Equal(t, "OK", c.mustDo(t, "SET", fmt.Sprintf("client-%d", i), strconv.Itoa(n)))
+			}
+		}(i)
+	}
+	wg.Wait()
+	c := dial(t, "tcp", ts.addr)
+	assert.Equal(t, []byte("800"), c.mustDo(t, "GET", "counter"))
+	assert.Equal(t, []byte("99"), c.mustDo(t, "GET", "client-7"))
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	ts := startServer(t, nil)
+	idle := dial(t, "tcp", ts.addr)
+	assert.Equal(t, "PONG", idle.mustDo(t, "PING"))
+
+	// 关闭前已经读入的命令执行完并收到回复，之后的命令不再执行
+	busy := dial(t, "tcp", ts.addr)
+	var buf []byte
+	for i := 0; i < 1000; i++ {
+		buf = append(buf, encodeCommand("SET", fmt.Sprintf("key-%d", i), "v")...)
+	}
+	_, err := busy.conn.Write(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", busy.mustReceive(t))
+
+	assert.Nil(t, ts.server.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-ts.served)
+	replies := 1
+	for {
+		reply, err := busy.Receive()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		assert.Equal(t, "OK", reply)
+		replies++
+	}
+	_, err = ts.db.Get([]byte(fmt.Sprintf("key-%d", replies-1)))
+	assert.Nil(t, err)
+	_, err = ts.db.Get([]byte(fmt.Sprintf("key-%d", replies)))
+	assert.Equal(t, bitcask_go.ErrReadKeyNotFound, err)
+	_, err = idle.Receive()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = net.Dial("tcp", ts.addr)
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrServerClosed, ts.server.Shutdown(context.Background()))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrServerClosed, ts.server.Serve(l))
+}
+
+func TestServer_Unix(t *testing.T) {
+	ts := startServer(t, nil)
+	sock := filepath.Join(t.TempDir(), "bitcask.sock")
+	served := make(chan error, 1)
+	go func() { served <- ts.server.ListenAndServe("unix", sock) }()
+
+	var c *respClient
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			c = &respClient{conn: conn, reader: bufio.NewReader(conn)}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !assert.NotNil(t, c) {
+		return
+	}
+	defer c.conn.Close()
+	assert.Equal(t, "OK", c.mustDo(t, "SET", "k", "v"))
+	tcp := dial(t, "tcp", ts.addr)
+	assert.Equal(t, []byte("v"), tcp.mustDo(t, "GET", "k"))
+
+	assert.Nil(t, ts.server.Shutdown(context.Background()))
+	assert.True(t, errors.Is(<-served, ErrServerClosed))
+}
@@ -0,0 +1,237 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VerifyReport 数据目录的校验结果
+type VerifyReport struct {
+	Files        []*VerifyFileReport // 所有数据文件的校验结果，按文件编号排序
+	InvalidNames []string            // 无法解析出文件编号的数据文件
+	Index        *VerifyIndexReport  // 持久化索引的校验结果，目录下没有索引文件时为 nil
+}
+
+// VerifyFileReport 单个数据文件的校验结果
+type VerifyFileReport struct {
+	Fid     uint32
+	Size    uint64          // 文件长度
+	Records int             // 校验通过的记录数量
+	Padding uint64          // 文件末尾全零的预分配空间的长度
+	Damaged []*DamagedRange // 损坏的区间，按偏移量排序
+}
+
+// DamagedRange 数据文件中无法解析出有效记录的区间
+type DamagedRange struct {
+	Fid    uint32
+	Offset uint64
+	Size   uint64
+	Err    error // 区间开头解析记录时的错误，ErrInvalidCRC 或 io.ErrUnexpectedEOF
+}
+
+// VerifyIndexReport 持久化索引的校验结果
+type VerifyIndexReport struct {
+	Err               error              // 索引文件无法打开或读取时的错误
+	Keys              int                // 索引中 key 的数量
+	Checkpoint        *data.LogRecordPos // 最近一次提交的检查点
+	InvalidCheckpoint bool               // 检查点超出了数据文件的范围，启动时会重建索引
+	Mismatches        []*IndexMismatch   // 与数据文件不一致的索引项
+}
+
+// IndexMismatch 索引项指向的位置上没有该 key 的有效记录
+type IndexMismatch struct {
+	Key []byte
+	Pos *data.LogRecordPos
+	Err error
+}
+
+// OK 所有文件都没有发现问题
+func (r *VerifyReport) OK() bool {
+	if len(r.InvalidNames) > 0 {
+		return false
+	}
+	for _, file := range r.Files {
+		if len(file.Damaged) > 0 {
+			return false
+		}
+	}
+	return r.Index == nil || (r.Index.Err == nil && !r.Index.InvalidCheckpoint && len(r.Index.Mismatches) == 0)
+}
+
+func (r *DamagedRange) String() string {
+	return fmt.Sprintf("fid %d offset %d size %d: %v", r.Fid, r.Offset, r.Size, r.Err)
+}
+
+func (m *IndexMismatch) String() string {
+	return fmt.Sprintf("key %q at fid %d offset %d: %v", m.Key, m.Pos.Fid, m.Pos.Offset, m.Err)
+}
+
+// Verify 离线校验数据目录，不修改任何文件，数据库可以正在被其他进程使用
+// 逐条校验所有数据文件中记录的 CRC，记录损坏时向后查找下一条有效记录，报告其间的损坏区间；
+// 目录下有 B+树索引文件时，校验每个索引项都指向该 key 的有效记录。
+// 只有读取文件出错时才返回错误，发现的问题都记录在报告中
+func Verify(dir string) (*VerifyReport, error) {
+	return verify(fio.DefaultVFS, dir)
+}
+
+func verify(fs fio.VFS, dir string) (*VerifyReport, error) {
+	names, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{}
+	hasIndex := false
+	var fids []uint32
+	for _, name := range names {
+		if name == index.BPlusTreeFileName {
+			hasIndex = true
+		}
+		if !strings.HasSuffix(name, data.DataFileSubffix) {
+			continue
+		}
+		fid, err := strconv.ParseUint(strings.TrimSuffix(name, data.DataFileSubffix), 10, 32)
+		if err != nil {
+			report.InvalidNames = append(report.InvalidNames, name)
+			continue
+		}
+		fids = append(fids, uint32(fid))
+	}
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+
+	dataFiles := make(map[uint32]*data.DataFile)
+	defer func() {
+		for _, dataFile := range dataFiles {
+			_ = dataFile.Close()
+		}
+	}()
+	for _, fid := range fids {
+		dataFile, err := data.OpenDataFileReadOnly(fs, dir, fid)
+		if err != nil {
+			return nil, err
+		}
+		dataFiles[fid] = dataFile
+		fileReport, err := verifyDataFile(dataFile)
+		if err != nil {
+			return nil, err
+		}
+		report.Files = append(report.Files, fileReport)
+	}
+
+	if hasIndex {
+		report.Index = verifyIndex(fs, dir, dataFiles)
+	}
+	return report, nil
+}
+
+// 顺序校验数据文件中的所有记录
+func verifyDataFile(dataFile *data.DataFile) (*VerifyFileReport, error) {
+	size, err := dataFile.Size()
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyFileReport{Fid: dataFile.FileId, Size: size}
+	var offset uint64
+	for offset < size {
+		_, n, err := dataFile.ReadLogRecord(int64(offset))
+		if err == nil {
+			report.Records++
+			offset += uint64(n)
+			continue
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF && err != data.ErrInvalidCRC {
+			return nil, err
+		}
+		// 文件末尾预分配的空间
+		zero, err2 := dataFile.IsZeroTail(offset)
+		if err2 != nil {
+			return nil, err2
+		}
+		if zero {
+			report.Padding = size - offset
+			break
+		}
+		// 全零的头部后面还有数据，同样视为损坏
+		if err == io.EOF {
+			err = data.ErrInvalidCRC
+		}
+		next, err2 := dataFile.NextValidRecord(offset+1, size)
+		if err2 != nil {
+			return nil, err2
+		}
+		report.Damaged = append(report.Damaged, &DamagedRange{Fid: dataFile.FileId, Offset: offset, Size: next - offset, Err: err})
+		offset = next
+	}
+	return report, nil
+}
+
+// 以只读模式打开 B+树索引，校验检查点和每个索引项
+func verifyIndex(fs fio.VFS, dir string, dataFiles map[uint32]*data.DataFile) *VerifyIndexReport {
+	report := &VerifyIndexReport{}
+	tree, err := index.NewBPlusTreeReadOnly(fs, dir, 0)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	defer tree.Close()
+	report.Keys = tree.Size()
+	report.Checkpoint = tree.Checkpoint()
+	if report.Checkpoint != nil {
+		dataFile := dataFiles[report.Checkpoint.Fid]
+		size := uint64(0)
+		if dataFile != nil {
+			if size, err = dataFile.Size(); err != nil {
+				report.Err = err
+				return report
+			}
+		}
+		report.InvalidCheckpoint = dataFile == nil || size < report.Checkpoint.Offset
+	}
+
+	count := 0
+	iter := tree.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+		key, pos := iter.Key(), iter.Value()
+		if err := verifyIndexEntry(dataFiles, key, pos); err != nil {
+			report.Mismatches = append(report.Mismatches, &IndexMismatch{
+				Key: append([]byte(nil), key...),
+				Pos: pos,
+				Err: err,
+			})
+		}
+	}
+	// 迭代器读取文件出错时会提前结束
+	if count != report.Keys {
+		report.Err = index.ErrBPlusTreeCorrupted
+	}
+	return report
+}
+
+func verifyIndexEntry(dataFiles map[uint32]*data.DataFile, key []byte, pos *data.LogRecordPos) error {
+	dataFile := dataFiles[pos.Fid]
+	if dataFile == nil {
+		return ErrDataFileNotFound
+	}
+	var logRecord *data.LogRecord
+	var err error
+	if pos.Size > 0 {
+		logRecord, err = dataFile.ReadLogRecordAt(int64(pos.Offset), pos.Size)
+	} else {
+		logRecord, _, err = dataFile.ReadLogRecord(int64(pos.Offset))
+	}
+	if err != nil {
+		return err
+	}
+	if logRecord.Type != data.LogRecordNormal || !bytes.Equal(logRecord.Key, key) {
+		return ErrIndexMismatch
+	}
+	return nil
+}
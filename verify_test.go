@@ -0,0 +1,153 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func newVerifyTestDB(t *testing.T, fs fio.VFS, dir string) *DB {
+	opts := *DefaultOptions
+	opts.DBFileDir = dir
+	opts.FileMaxSize = 4 * 1024
+	opts.PreallocateSize = 1024
+	opts.DBIndex = index.BPTree
+	opts.FS = fs
+	db, err := Start(&opts)
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	return db
+}
+
+// 修改文件 offset 处的一个字节
+func corruptFile(t *testing.T, fs fio.VFS, fileName string, offset int64) {
+	file, err := fs.OpenFile(fileName)
+	assert.Nil(t, err)
+	buf := make([]byte, 1)
+	_, err = file.Read(buf, offset)
+	assert.Nil(t, err)
+	size, err := file.Size()
+	assert.Nil(t, err)
+	tail := make([]byte, size-offset)
+	_, err = file.Read(tail, offset)
+	assert.Nil(t, err)
+	tail[0] ^= 0xff
+	assert.Nil(t, file.Truncate(offset))
+	_, err = file.Write(tail)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+}
+
+func TestVerify(t *testing.T) {
+	fs := fio.NewMemFS()
+	dir := "/bitcask-go-verify"
+	db := newVerifyTestDB(t, fs, dir)
+	assert.Nil(t, db.Sync())
+
+	// 数据库正在使用时也可以校验
+	report, err := verify(fs, dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	records := 0
+	for _, file := range report.Files {
+		records += file.Records
+	}
+	assert.Equal(t, 200, records)
+	assert.Equal(t, 200, report.Index.Keys)
+	assert.Equal(t, db.activityDataFile.FileId, report.Index.Checkpoint.Fid)
+	fid := db.activityDataFile.FileId
+	assert.Nil(t, db.Close())
+
+	// 活跃文件末尾预分配的空间
+	file, err := fs.OpenFile(data.DataFileName(dir, fid))
+	assert.Nil(t, err)
+	_, err = file.Write(make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	report, err = verify(fs, dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	last := report.Files[len(report.Files)-1]
+	assert.Equal(t, fid, last.Fid)
+	assert.Equal(t, uint64(100), last.Padding)
+
+	_, err = verify(fs, "/not-exist")
+	assert.NotNil(t, err)
+}
+
+func TestVerify_Damaged(t *testing.T) {
+	fs := fio.NewMemFS()
+	dir := "/bitcask-go-verify-damaged"
+	db := newVerifyTestDB(t, fs, dir)
+	pos := db.index.Get(utils.GetTestKey(10))
+	next := db.index.Get(utils.GetTestKey(11))
+	assert.Equal(t, pos.Fid, next.Fid)
+	lastPos := db.index.Get(utils.GetTestKey(199))
+	assert.Nil(t, db.Close())
+
+	// 中间的一条记录损坏，之后的记录仍然可以找到
+	corruptFile(t, fs, data.DataFileName(dir, pos.Fid), int64(pos.Offset)+8)
+	// 最后一条记录不完整
+	file, err := fs.OpenFile(data.DataFileName(dir, lastPos.Fid))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Truncate(int64(lastPos.Offset)+5))
+	assert.Nil(t, file.Close())
+	// 无法解析编号的数据文件
+	file, err = fs.OpenFile(dir + "/abc.data")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	report, err := verify(fs, dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, []string{"abc.data"}, report.InvalidNames)
+
+	var damaged []*DamagedRange
+	records := 0
+	for _, file := range report.Files {
+		damaged = append(damaged, file.Damaged...)
+		records += file.Records
+	}
+	assert.Equal(t, 198, records)
+	assert.Equal(t, []*DamagedRange{
+		{Fid: pos.Fid, Offset: pos.Offset, Size: next.Offset - pos.Offset, Err: data.ErrInvalidCRC},
+		{Fid: lastPos.Fid, Offset: lastPos.Offset, Size: 5, Err: io.ErrUnexpectedEOF},
+	}, damaged)
+
+	// 索引中指向损坏记录的 key，截断之后活跃文件的长度小于检查点
+	assert.Nil(t, report.Index.Err)
+	assert.True(t, report.Index.InvalidCheckpoint)
+	assert.Equal(t, 2, len(report.Index.Mismatches))
+	assert.Equal(t, utils.GetTestKey(10), report.Index.Mismatches[0].Key)
+	assert.Equal(t, data.ErrInvalidCRC, report.Index.Mismatches[0].Err)
+	assert.Equal(t, utils.GetTestKey(199), report.Index.Mismatches[1].Key)
+}
+
+func TestVerify_Index(t *testing.T) {
+	fs := fio.NewMemFS()
+	dir := "/bitcask-go-verify-index"
+	db := newVerifyTestDB(t, fs, dir)
+	checkpointFid := db.activityDataFile.FileId
+	assert.Nil(t, db.Close())
+
+	// 检查点所在的数据文件丢失
+	assert.Nil(t, fs.Remove(data.DataFileName(dir, checkpointFid)))
+	report, err := verify(fs, dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.True(t, report.Index.InvalidCheckpoint)
+	assert.True(t, len(report.Index.Mismatches) > 0)
+	assert.Equal(t, ErrDataFileNotFound, report.Index.Mismatches[0].Err)
+
+	// 索引文件损坏
+	corruptFile(t, fs, dir+"/"+index.BPlusTreeFileName, 0)
+	report, err = verify(fs, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, index.ErrBPlusTreeCorrupted, report.Index.Err)
+}
@@ -0,0 +1,278 @@
+// Package wire 定义 bitcask 二进制协议的帧格式，由 server 和 client 共用。
+//
+// 每个帧由 9 字节的头部和消息体组成，整数按小端序编码：
+//
+//	| body length uint32 | request id uint32 | code uint8 | body |
+//
+// 请求的 code 为操作码，应答的 code 为状态码。应答带有对应请求的 id，同一个连接上可以同时发送多个请求，
+// 应答的顺序和请求的顺序不一定相同。扫描操作的每条数据是一个 StatusItem 帧，最后以 StatusOK 帧结束，
+// 客户端可以发送带有相同 id 的 OpCancel 提前结束扫描。
+// 服务端最多发送 ScanWindow 条客户端没有确认的数据，客户端处理数据之后发送带有相同 id 的 OpAck 确认，
+// 处理得慢的扫描只会暂停自己，不会阻塞同一个连接上的其他应答。
+//
+// 消息体由若干字段依次拼接而成：整数按 uvarint 编码，字节串为 uvarint 长度加内容
+package wire
+
+import (
+	bitcask_go "bitcask-go"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// HeaderSize 帧头部的长度
+	HeaderSize = 9
+	// MaxBodySize 消息体的最大长度
+	MaxBodySize = 256 * 1024 * 1024
+	// 不超过该长度的消息体按头部声明的长度一次分配，更长的随着数据的到达分块增长，
+	// 避免只发送头部的连接让读取方分配大量内存
+	bodyChunkSize = 64 * 1024
+)
+
+// 操作码
+const (
+	OpPing   byte = iota + 1 // 空消息体
+	OpGet                    // key，应答为 value
+	OpPut                    // key, value
+	OpDelete                 // key
+	OpWrite                  // 操作数量，每个操作为类型(BatchPut/BatchDelete)、key，写入操作还有 value
+	OpScan                   // 标志(ScanKeysOnly)、prefix，每条数据应答 key、value（只扫描 key 时没有 value）
+	OpCancel                 // 空消息体，结束 id 相同的扫描，没有应答
+	OpStat                   // 应答为 key 数量、数据文件数量、磁盘占用
+	OpSync
+	OpMerge
+	OpBackup // 备份名称，应答为服务端上的备份目录
+	OpAck    // 已经处理的数据条数，确认 id 相同的扫描的数据，没有应答
+)
+
+// ScanWindow 扫描时服务端最多发送的没有确认的数据条数
+const ScanWindow = 256
+
+// 状态码
+const (
+	StatusOK    byte = iota // 请求成功，扫描结束
+	StatusItem              // 扫描的一条数据
+	StatusError             // 错误码、错误信息
+)
+
+// OpWrite 中操作的类型
+const (
+	BatchPut byte = iota
+	BatchDelete
+)
+
+// ScanKeysOnly 只扫描 key，不读取 value
+const ScanKeysOnly byte = 1
+
+var (
+	ErrBodyTooLarge = errors.New("wire: frame body too large")
+	ErrInvalidBody  = errors.New("wire: invalid frame body")
+	ErrUnknownOp    = errors.New("wire: unknown op")
+)
+
+// Frame 一个请求或应答
+type Frame struct {
+	ID   uint32
+	Code byte
+	Body []byte
+}
+
+// ReadFrame 读取一个帧，消息体是新分配的，可以在之后继续引用
+func ReadFrame(r io.Reader) (*Frame, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > MaxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	body, err := readBody(r, int64(size))
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{
+		ID:   binary.LittleEndian.Uint32(header[4:8]),
+		Code: header[8],
+		Body: body,
+	}, nil
+}
+
+func readBody(r io.Reader, n int64) ([]byte, error) {
+	if n <= bodyChunkSize {
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return body, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(bodyChunkSize)
+	copied, err := io.CopyN(&buf, r, n)
+	if copied < n {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFrame 写入一个帧
+func WriteFrame(w io.Writer, frame *Frame) error {
+	if len(frame.Body) > MaxBodySize {
+		return ErrBodyTooLarge
+	}
+	var header [HeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(frame.Body)))
+	binary.LittleEndian.PutUint32(header[4:8], frame.ID)
+	header[8] = frame.Code
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame.Body)
+	return err
+}
+
+// Encoder 依次编码消息体的字段
+type Encoder struct {
+	buf []byte
+}
+
+func (e *Encoder) Byte(b byte) *Encoder {
+	e.buf = append(e.buf, b)
+	return e
+}
+
+func (e *Encoder) Uvarint(n uint64) *Encoder {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, tmp[:binary.PutUvarint(tmp[:], n)]...)
+	return e
+}
+
+func (e *Encoder) Bytes(b []byte) *Encoder {
+	e.Uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+	return e
+}
+
+// Body 返回编码后的消息体
+func (e *Encoder) Body() []byte {
+	return e.buf
+}
+
+// Decoder 依次解码消息体的字段，出错之后的字段都返回零值，最后通过 Finish 检查
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+func NewDecoder(body []byte) *Decoder {
+	return &Decoder{buf: body}
+}
+
+func (d *Decoder) Byte() byte {
+	if d.err != nil || len(d.buf) < 1 {
+		d.err = ErrInvalidBody
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *Decoder) Uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.buf)
+	if size <= 0 {
+		d.err = ErrInvalidBody
+		return 0
+	}
+	d.buf = d.buf[size:]
+	return n
+}
+
+// Bytes 返回的切片引用消息体
+func (d *Decoder) Bytes() []byte {
+	n := d.Uvarint()
+	if d.err != nil || n > uint64(len(d.buf)) {
+		d.err = ErrInvalidBody
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// Err 返回解码过程中的错误
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Finish 返回解码过程中的错误，消息体有多余的数据时也返回错误
+func (d *Decoder) Finish() error {
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = ErrInvalidBody
+	}
+	return d.err
+}
+
+// 错误码，常见的错误在客户端还原为 bitcask_go 中对应的错误
+const (
+	errCodeUnknown byte = iota
+	errCodeKeyNotFound
+	errCodeKeyIsNilOrEmpty
+	errCodeReadOnly
+	errCodeMergeIsProgress
+	errCodeBadRequest
+	errCodeUnknownOp
+	errCodeLogPositionUnavailable
+)
+
+var errorCodes = map[error]byte{
+	bitcask_go.ErrReadKeyNotFound: errCodeKeyNotFound,
+	bitcask_go.ErrKeyIsNilOrEmpty: errCodeKeyIsNilOrEmpty,
+	bitcask_go.ErrDBReadOnly:      errCodeReadOnly,
+	bitcask_go.ErrMergeIsProgress: errCodeMergeIsProgress,
+	ErrInvalidBody:                errCodeBadRequest,
+	ErrUnknownOp:                  errCodeUnknownOp,
+
+	bitcask_go.ErrLogPositionUnavailable: errCodeLogPositionUnavailable,
+}
+
+// RemoteError 服务端返回的其他错误
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// EncodeError 将错误编码为 StatusError 应答的消息体
+func EncodeError(err error) []byte {
+	return (&Encoder{}).Byte(errorCodes[err]).Bytes([]byte(err.Error())).Body()
+}
+
+// DecodeError 解码 StatusError 应答的消息体，返回对应的错误
+func DecodeError(body []byte) error {
+	d := NewDecoder(body)
+	code := d.Byte()
+	message := d.Bytes()
+	if err := d.Finish(); err != nil {
+		return err
+	}
+	for err, c := range errorCodes {
+		if c == code {
+			return err
+		}
+	}
+	return &RemoteError{Message: string(message)}
+}
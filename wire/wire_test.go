@@ -0,0 +1,96 @@
+package wire
+
+import (
+	bitcask_go "bitcask-go"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	buf := new(bytes.Buffer)
+	frames := []*Frame{
+		{ID: 1, Code: OpPing, Body: []byte{}},
+		{ID: 1<<32 - 1, Code: OpPut, Body: (&Encoder{}).Bytes([]byte("key")).Bytes([]byte("value")).Body()},
+	}
+	for _, frame := range frames {
+		assert.Nil(t, WriteFrame(buf, frame))
+	}
+	assert.Equal(t, HeaderSize*2+len(frames[1].Body), buf.Len())
+	for _, frame := range frames {
+		read, err := ReadFrame(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, frame, read)
+	}
+	_, err := ReadFrame(buf)
+	assert.Equal(t, io.EOF, err)
+
+	// 消息体不完整
+	assert.Nil(t, WriteFrame(buf, frames[1]))
+	_, err = ReadFrame(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	// 长度超过限制
+	_, err = ReadFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0}))
+	assert.Equal(t, ErrBodyTooLarge, err)
+}
+
+func TestFrame_HugeBody(t *testing.T) {
+	allocated := func(fn func()) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		fn()
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+
+	// 头部声明最大长度的消息体，之后只有几个字节，随着数据的到达分配内存
+	header := []byte{0, 0, 0, 0, 1, 0, 0, 0, OpPut}
+	binary.LittleEndian.PutUint32(header, MaxBodySize)
+	var err error
+	assert.True(t, allocated(func() {
+		_, err = ReadFrame(bytes.NewReader(append(header, "body"...)))
+	}) < 1024*1024)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// 长度超过分块大小的消息体仍然可以完整读出
+	buf := new(bytes.Buffer)
+	frame := &Frame{ID: 1, Code: OpPut, Body: bytes.Repeat([]byte("v"), 3*bodyChunkSize+1)}
+	assert.Nil(t, WriteFrame(buf, frame))
+	read, err := ReadFrame(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, frame, read)
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	body := (&Encoder{}).Byte(7).Uvarint(300).Bytes(nil).Bytes([]byte("abc")).Body()
+	d := NewDecoder(body)
+	assert.Equal(t, byte(7), d.Byte())
+	assert.Equal(t, uint64(300), d.Uvarint())
+	assert.Equal(t, []byte{}, d.Bytes())
+	assert.Equal(t, []byte("abc"), d.Bytes())
+	assert.Nil(t, d.Finish())
+
+	// 多余的数据和截断的数据都是错误
+	d = NewDecoder(body)
+	d.Byte()
+	assert.Equal(t, ErrInvalidBody, d.Finish())
+	d = NewDecoder(body[:len(body)-1])
+	d.Byte()
+	d.Uvarint()
+	d.Bytes()
+	assert.Nil(t, d.Bytes())
+	assert.Equal(t, ErrInvalidBody, d.Finish())
+}
+
+func TestError(t *testing.T) {
+	for _, err := range []error{bitcask_go.ErrReadKeyNotFound, bitcask_go.ErrDBReadOnly, ErrUnknownOp} {
+		assert.Equal(t, err, DecodeError(EncodeError(err)))
+	}
+	err := DecodeError(EncodeError(errors.New("disk full")))
+	assert.Equal(t, &RemoteError{Message: "disk full"}, err)
+	assert.Equal(t, ErrInvalidBody, DecodeError([]byte{1}))
+}